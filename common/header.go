@@ -4,7 +4,10 @@ package common
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 	"sync/atomic"
 
 	"github.com/serngawy/libOpenflow/util"
@@ -63,6 +66,43 @@ func (h *Header) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+var (
+	typeNamesLock sync.RWMutex
+	typeNames     = make(map[uint8]func(uint8) string)
+)
+
+// Register the names of the message types of an OpenFlow version. Called
+// by the package implementing the version
+func RegisterTypeNames(version uint8, names func(uint8) string) {
+	typeNamesLock.Lock()
+	defer typeNamesLock.Unlock()
+	typeNames[version] = names
+}
+
+// Returns the name of the message type, e.g. "echo_request"
+func (h *Header) TypeName() string {
+	typeNamesLock.RLock()
+	names := typeNames[h.Version]
+	typeNamesLock.RUnlock()
+	if names == nil {
+		return fmt.Sprintf("type_%d", h.Type)
+	}
+	return names(h.Type)
+}
+
+// Returns the message type and xid, e.g. "echo_request xid=5"
+func (h *Header) String() string {
+	return fmt.Sprintf("%s xid=%d", h.TypeName(), h.Xid)
+}
+
+func (h *Header) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"version": h.Version,
+		"type":    h.TypeName(),
+		"xid":     h.Xid,
+	})
+}
+
 const (
 	reserved = iota
 	HelloElemType_VersionBitmap
@@ -0,0 +1,384 @@
+package datapath
+
+// This file implements the OpenFlow channel of the datapath to its
+// controller
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/internal/switchutil"
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+const (
+	// Messages queued for the controller before dropping
+	outboundQueueLen = 4096
+	// How long the handshake waits for the controller
+	handshakeTimeout = 5 * time.Second
+)
+
+// The connection to the controller
+type channel struct {
+	conn      net.Conn
+	outbound  chan []byte
+	connected chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Connect to a controller listening on a TCP address, e.g. "127.0.0.1:6633"
+func (dp *Datapath) Connect(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return dp.Serve(conn)
+}
+
+// Connect to a controller over a net.Pipe, the controller doesn't need to
+// listen
+func (dp *Datapath) ConnectController(ctrler *ofctrl.Controller) error {
+	conn, ctrlerConn := net.Pipe()
+	go ctrler.ServeConn(ctrlerConn)
+	return dp.Serve(conn)
+}
+
+// Serve the controller at the other end of conn. Returns once the features
+// reply is sent or the handshake failed
+func (dp *Datapath) Serve(conn net.Conn) error {
+	ch := &channel{
+		conn:      conn,
+		outbound:  make(chan []byte, outboundQueueLen),
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	dp.lock.Lock()
+	if dp.ctrl != nil {
+		dp.lock.Unlock()
+		return errors.New("Datapath is already connected")
+	}
+	dp.ctrl = ch
+	dp.lock.Unlock()
+
+	go ch.send()
+	go dp.readMessages(ch)
+	go dp.expire(ch)
+
+	h, err := common.NewHello(openflow13.VERSION)
+	if err != nil {
+		return err
+	}
+	data, _ := h.MarshalBinary()
+	ch.write(data)
+
+	select {
+	case <-ch.connected:
+		return nil
+	case <-ch.done:
+		return errors.New("Connection closed during the handshake")
+	case <-time.After(handshakeTimeout):
+		dp.Close()
+		return errors.New("Timeout waiting for the features request")
+	}
+}
+
+// Disconnect from the controller. The datapath keeps forwarding with the
+// flows installed
+func (dp *Datapath) Close() {
+	dp.lock.Lock()
+	ch := dp.ctrl
+	dp.ctrl = nil
+	dp.lock.Unlock()
+	if ch != nil {
+		ch.close()
+	}
+}
+
+func (ch *channel) close() {
+	ch.closeOnce.Do(func() {
+		close(ch.done)
+		ch.conn.Close()
+	})
+}
+
+// Queue a marshaled message for the writer. Never blocks, messages are
+// dropped when the controller doesn't keep up
+func (ch *channel) write(data []byte) {
+	select {
+	case ch.outbound <- data:
+	case <-ch.done:
+	default:
+		log.Warnf("Datapath dropping a message of type %d to the controller", data[1])
+	}
+}
+
+// Write the outbound messages to the connection
+func (ch *channel) send() {
+	for {
+		select {
+		case data := <-ch.outbound:
+			if _, err := ch.conn.Write(data); err != nil {
+				ch.close()
+				return
+			}
+		case <-ch.done:
+			return
+		}
+	}
+}
+
+// Send an asynchronous message to the controller, if connected. Must be
+// called with the lock held
+func (dp *Datapath) sendToController(msg util.Message) {
+	if dp.ctrl == nil {
+		return
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		log.Errorf("Error marshaling message to the controller. Err: %v", err)
+		return
+	}
+	dp.ctrl.write(data)
+}
+
+// Must be called with the lock held
+func (dp *Datapath) sendPortStatus(reason uint8, port *Port) {
+	status := openflow13.NewPortStatus()
+	status.Reason = reason
+	status.Desc = *port.desc()
+	dp.sendToController(status)
+}
+
+// Send a reply to the request with xid
+func (ch *channel) reply(msg util.Message, xid uint32) {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		log.Errorf("Error marshaling reply. Err: %v", err)
+		return
+	}
+	binary.BigEndian.PutUint32(data[4:], xid)
+	ch.write(data)
+}
+
+// Send the error caused by a request, with the start of the request as data
+func (ch *channel) replyError(request []byte, errType, code uint16) {
+	if len(request) > 64 {
+		request = request[:64]
+	}
+	errMsg := openflow13.NewErrorMsg()
+	errMsg.Type = errType
+	errMsg.Code = code
+	errMsg.Data = *util.NewBuffer(append([]byte(nil), request...))
+	ch.reply(errMsg, binary.BigEndian.Uint32(request[4:]))
+}
+
+// Expire the flows that timed out while no packet was received
+func (dp *Datapath) expire(ch *channel) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			dp.lock.Lock()
+			dp.expireFlows(now)
+			dp.lock.Unlock()
+		case <-ch.done:
+			return
+		}
+	}
+}
+
+// Read the messages of the controller and handle them in order
+func (dp *Datapath) readMessages(ch *channel) {
+	defer func() {
+		dp.lock.Lock()
+		if dp.ctrl == ch {
+			dp.ctrl = nil
+		}
+		dp.lock.Unlock()
+		ch.close()
+	}()
+	for {
+		data, err := switchutil.ReadMessage(ch.conn)
+		if err != nil {
+			return
+		}
+		msg, err := openflow13.Parse(data)
+		if err != nil || msg == nil {
+			log.Warnf("Datapath received unsupported message type %d", data[1])
+			ch.replyError(data, openflow13.ET_BAD_REQUEST, openflow13.BRC_BAD_TYPE)
+			continue
+		}
+		dp.handleMessage(ch, msg, data)
+	}
+}
+
+func (dp *Datapath) handleMessage(ch *channel, msg util.Message, data []byte) {
+	xid := binary.BigEndian.Uint32(data[4:])
+
+	switch m := msg.(type) {
+	case *common.Hello:
+		// Our hello was sent when connecting
+	case *common.Header:
+		switch m.Type {
+		case openflow13.Type_EchoRequest:
+			ch.reply(openflow13.NewEchoReply(), xid)
+		case openflow13.Type_FeaturesRequest:
+			ch.reply(dp.features(), xid)
+			select {
+			case <-ch.connected:
+			default:
+				close(ch.connected)
+			}
+		case openflow13.Type_BarrierRequest:
+			// Messages are handled in order, all previous ones are done
+			res := openflow13.NewOfp13Header()
+			res.Type = openflow13.Type_BarrierReply
+			ch.reply(&res, xid)
+		case openflow13.Type_GetConfigRequest:
+			res := openflow13.NewSetConfig()
+			res.Header.Type = openflow13.Type_GetConfigReply
+			dp.lock.Lock()
+			res.Flags = dp.configFlags
+			res.MissSendLen = dp.missSendLen
+			dp.lock.Unlock()
+			ch.reply(res, xid)
+		}
+	case *openflow13.SwitchConfig:
+		dp.lock.Lock()
+		dp.configFlags = m.Flags
+		dp.missSendLen = m.MissSendLen
+		dp.lock.Unlock()
+	case *openflow13.RoleRequest:
+		ch.reply(openflow13.NewRoleReply(m.Role, m.GenerationId), xid)
+	case *openflow13.FlowMod:
+		dp.replyErr(ch, dp.ApplyFlowMod(m), data)
+	case *openflow13.GroupMod:
+		dp.replyErr(ch, dp.ApplyGroupMod(m), data)
+	case *openflow13.MeterMod:
+		dp.replyErr(ch, dp.ApplyMeterMod(m), data)
+	case *openflow13.PacketOut:
+		dp.replyErr(ch, dp.packetOut(m), data)
+	case *openflow13.MultipartRequest:
+		dp.multipartRequest(ch, m, data)
+	}
+}
+
+// Answer a request that failed with its OpenFlow error
+func (dp *Datapath) replyErr(ch *channel, err error, data []byte) {
+	if err == nil {
+		return
+	}
+	if ofErr, ok := err.(*Error); ok {
+		ch.replyError(data, ofErr.Type, ofErr.Code)
+		return
+	}
+	log.Errorf("Error handling message of type %d. Err: %v", data[1], err)
+}
+
+func (dp *Datapath) features() *openflow13.SwitchFeatures {
+	res := openflow13.NewFeaturesReply()
+	copy(res.DPID, dp.DPID)
+	res.Buffers = 0
+	res.NumTables = dp.NumTables
+	res.Capabilities = openflow13.C_FLOW_STATS | openflow13.C_TABLE_STATS |
+		openflow13.C_PORT_STATS | openflow13.C_GROUP_STATS
+	return res
+}
+
+// Execute the actions of a packet out. The datapath has no buffers, packet
+// outs must carry the packet
+func (dp *Datapath) packetOut(pktOut *openflow13.PacketOut) error {
+	if pktOut.Data == nil {
+		return newError(openflow13.ET_BAD_REQUEST, openflow13.BRC_BUFFER_UNKNOWN)
+	}
+	data, err := pktOut.Data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	pkt, err := newPacket(data, pktOut.InPort)
+	if err != nil {
+		return newError(openflow13.ET_BAD_REQUEST, openflow13.BRC_BAD_PACKET)
+	}
+
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	ctx := actionContext{reason: openflow13.R_ACTION, packetOut: true}
+	dp.newExecution().applyActions(pkt, pktOut.Actions, ctx)
+	return nil
+}
+
+func (dp *Datapath) multipartRequest(ch *channel, req *openflow13.MultipartRequest, data []byte) {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+
+	var bodies []util.Message
+	switch req.Type {
+	case openflow13.MultipartType_Desc:
+		desc := openflow13.NewDescStats()
+		copy(desc.MfrDesc, "libOpenflow")
+		copy(desc.HWDesc, "datapath")
+		copy(desc.SWDesc, "datapath")
+		copy(desc.DPDesc, dp.DPID.String())
+		bodies = append(bodies, desc)
+	case openflow13.MultipartType_Flow:
+		for _, entry := range dp.selectFlows(req.Body.(*openflow13.FlowStatsRequest)) {
+			bodies = append(bodies, entry.flowStats())
+		}
+	case openflow13.MultipartType_Aggregate:
+		flowReq := switchutil.AggregateFilter(req.Body.(*openflow13.AggregateStatsRequest))
+		stats := openflow13.NewAggregateStats()
+		for _, entry := range dp.selectFlows(flowReq) {
+			stats.PacketCount += entry.packetCount
+			stats.ByteCount += entry.byteCount
+			stats.FlowCount++
+		}
+		bodies = append(bodies, stats)
+	case openflow13.MultipartType_Table:
+		for tableId := 0; tableId < int(dp.NumTables); tableId++ {
+			stats := openflow13.NewTableStats()
+			stats.TableId = uint8(tableId)
+			if t, ok := dp.tables[uint8(tableId)]; ok {
+				stats.ActiveCount = uint32(len(t.entries))
+				stats.LookupCount = t.lookupCount
+				stats.MatchedCount = t.matchedCount
+			}
+			bodies = append(bodies, stats)
+		}
+	case openflow13.MultipartType_Port:
+		portNo := req.Body.(*openflow13.PortStatsRequest).PortNo
+		for _, port := range dp.portNumbers() {
+			if portNo == openflow13.P_ANY || port == portNo {
+				bodies = append(bodies, dp.ports[port].portStats())
+			}
+		}
+	default:
+		ch.replyError(data, openflow13.ET_BAD_REQUEST, openflow13.BRC_BAD_MULTIPART)
+		return
+	}
+	for _, rep := range switchutil.MultipartReplies(req.Type, bodies) {
+		ch.reply(rep, req.Xid)
+	}
+}
+
+// Returns the flows a flow stats request selects, sorted. Must be called
+// with the lock held
+func (dp *Datapath) selectFlows(req *openflow13.FlowStatsRequest) []*flowEntry {
+	filter := switchutil.StatsFilter(req)
+	var entries []*flowEntry
+	for _, entry := range dp.sortedFlows() {
+		if switchutil.Selects(filter, entry.flowMod) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
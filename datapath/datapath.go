@@ -0,0 +1,300 @@
+// Package datapath implements a userspace OpenFlow 1.3 switch in pure Go.
+//
+// A Datapath keeps real multi-table flow tables, groups and meters, and
+// forwards the frames injected on its in-memory ports through them. It
+// connects to a controller as a client, so ofctrl pipelines can be tested
+// end to end without OVS or veth pairs:
+//
+//	dp := datapath.NewDatapath(dpid)
+//	p1, p2 := dp.AddPort(1, "p1"), dp.AddPort(2, "p2")
+//	err := dp.ConnectController(ctrler) // or dp.Connect("127.0.0.1:6633")
+//	err = p1.Inject(frame)
+//	out, err := p2.Receive(time.Second)
+//
+// Frames are handled as protocol.Ethernet, so only the headers that
+// package parses (one vlan tag, IPv4, ARP, TCP, UDP and ICMP) can be
+// matched and rewritten.
+//
+// TracePacket runs a packet through the flows of a flow dump, like
+// ovs-appctl ofproto/trace.
+package datapath
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+// Frames a port queues before dropping
+const portQueueLen = 1024
+
+// A software OpenFlow 1.3 switch
+type Datapath struct {
+	DPID      net.HardwareAddr
+	NumTables uint8
+
+	lock        sync.Mutex
+	tables      map[uint8]*flowTable
+	groups      map[uint32]*group
+	meters      map[uint32]*meter
+	ports       map[uint32]*Port
+	missSendLen uint16
+	configFlags uint16
+	ctrl        *channel
+}
+
+// An in-memory port of a datapath
+type Port struct {
+	PortNo uint32
+	Name   string
+	HWAddr net.HardwareAddr
+
+	dp       *Datapath
+	out      chan *protocol.Ethernet
+	linkDown bool
+	peer     *Port
+	created  time.Time
+	stats    openflow13.PortStats
+}
+
+// Create a datapath with 254 tables and no ports
+func NewDatapath(dpid net.HardwareAddr) *Datapath {
+	dp := new(Datapath)
+	dp.DPID = dpid
+	dp.NumTables = openflow13.OFPTT_MAX
+	dp.tables = make(map[uint8]*flowTable)
+	dp.groups = make(map[uint32]*group)
+	dp.meters = make(map[uint32]*meter)
+	dp.ports = make(map[uint32]*Port)
+	dp.missSendLen = 128
+	return dp
+}
+
+// Add a port, its mac address is derived from the dpid and port number
+func (dp *Datapath) AddPort(portNo uint32, name string) *Port {
+	port := &Port{
+		PortNo:  portNo,
+		Name:    name,
+		HWAddr:  net.HardwareAddr{0x02, dp.dpidByte(6), dp.dpidByte(7), byte(portNo >> 16), byte(portNo >> 8), byte(portNo)},
+		dp:      dp,
+		out:     make(chan *protocol.Ethernet, portQueueLen),
+		created: time.Now(),
+	}
+	port.stats = *openflow13.NewPortStats()
+	port.stats.PortNo = portNo
+
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	dp.ports[portNo] = port
+	dp.sendPortStatus(openflow13.PR_ADD, port)
+	return port
+}
+
+func (dp *Datapath) dpidByte(i int) byte {
+	if i < len(dp.DPID) {
+		return dp.DPID[i]
+	}
+	return 0
+}
+
+// Remove a port
+func (dp *Datapath) DeletePort(portNo uint32) {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	if port, ok := dp.ports[portNo]; ok {
+		delete(dp.ports, portNo)
+		dp.sendPortStatus(openflow13.PR_DELETE, port)
+	}
+}
+
+// Returns a port, nil if there is none
+func (dp *Datapath) Port(portNo uint32) *Port {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	return dp.ports[portNo]
+}
+
+// Returns the port numbers, sorted. Must be called with the lock held
+func (dp *Datapath) portNumbers() []uint32 {
+	ports := make([]uint32, 0, len(dp.ports))
+	for portNo := range dp.ports {
+		ports = append(ports, portNo)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// Inject a frame as received on the port. It goes through the pipeline
+// before Inject returns
+func (p *Port) Inject(pkt *protocol.Ethernet) error {
+	data, err := pkt.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return p.dp.receive(p, data)
+}
+
+// Returns the next frame the datapath sent out of the port
+func (p *Port) Receive(timeout time.Duration) (*protocol.Ethernet, error) {
+	select {
+	case pkt := <-p.out:
+		return pkt, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("Timeout waiting for a packet on port %d", p.PortNo)
+	}
+}
+
+// Returns the frames the datapath sends out of the port. Unread frames are
+// dropped once the queue is full
+func (p *Port) Packets() <-chan *protocol.Ethernet {
+	return p.out
+}
+
+// Set the link of the port down or up. Fast failover groups watch it
+func (p *Port) SetLinkDown(down bool) {
+	p.dp.lock.Lock()
+	defer p.dp.lock.Unlock()
+	if p.linkDown != down {
+		p.linkDown = down
+		p.dp.sendPortStatus(openflow13.PR_MODIFY, p)
+	}
+}
+
+// Returns the port description sent to the controller. Must be called with
+// the datapath lock held
+func (p *Port) desc() *openflow13.PhyPort {
+	desc := openflow13.NewPhyPort()
+	desc.PortNo = p.PortNo
+	copy(desc.HWAddr, p.HWAddr)
+	copy(desc.Name, p.Name)
+	if p.linkDown {
+		desc.State = openflow13.PS_LINK_DOWN
+	}
+	return desc
+}
+
+// Connect two ports like a cable: the frames sent out of one are injected
+// on the other. The ports may belong to different datapaths
+func Link(a, b *Port) error {
+	if a.peer != nil || b.peer != nil {
+		return errors.New("Port is already linked")
+	}
+	a.peer, b.peer = b, a
+	go a.forward()
+	go b.forward()
+	return nil
+}
+
+func (p *Port) forward() {
+	for pkt := range p.out {
+		if err := p.peer.Inject(pkt); err != nil {
+			log.Debugf("Error forwarding a packet to port %d. Err: %v", p.peer.PortNo, err)
+		}
+	}
+}
+
+// Queue a frame sent out of the port. Must be called with the datapath
+// lock held
+func (p *Port) transmit(pkt *packet) {
+	data := pkt.frame()
+	if p.linkDown {
+		p.stats.TxDropped++
+		return
+	}
+	eth := new(protocol.Ethernet)
+	if err := eth.UnmarshalBinary(data); err != nil {
+		p.stats.TxErrors++
+		return
+	}
+	select {
+	case p.out <- eth:
+		p.stats.TxPackets++
+		p.stats.TxBytes += uint64(len(data))
+	default:
+		p.stats.TxDropped++
+	}
+}
+
+// Returns the counters of the port. Must be called with the datapath lock
+// held
+func (p *Port) portStats() *openflow13.PortStats {
+	stats := p.stats
+	duration := time.Since(p.created)
+	stats.DurationSec = uint32(duration / time.Second)
+	stats.DurationNSec = uint32(duration % time.Second)
+	return &stats
+}
+
+// Run a frame received on a port through the pipeline
+func (dp *Datapath) receive(port *Port, data []byte) error {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+
+	if port.linkDown || dp.ports[port.PortNo] != port {
+		port.stats.RxDropped++
+		return nil
+	}
+	port.stats.RxPackets++
+	port.stats.RxBytes += uint64(len(data))
+
+	pkt, err := newPacket(data, port.PortNo)
+	if err != nil {
+		port.stats.RxErrors++
+		return err
+	}
+	dp.expireFlows(time.Now())
+	dp.newExecution().runPipeline(pkt, 0)
+	return nil
+}
+
+// Returns a flow table, creating it on first use. Must be called with the
+// lock held
+func (dp *Datapath) table(tableId uint8) *flowTable {
+	t, ok := dp.tables[tableId]
+	if !ok {
+		t = new(flowTable)
+		dp.tables[tableId] = t
+	}
+	return t
+}
+
+// Returns the installed flows in the ovs-ofctl syntax, sorted by table,
+// priority and match
+func (dp *Datapath) DumpFlows() []string {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+
+	var flows []string
+	for _, entry := range dp.sortedFlows() {
+		flows = append(flows, entry.flowStats().String())
+	}
+	return flows
+}
+
+// Must be called with the lock held
+func (dp *Datapath) sortedFlows() []*flowEntry {
+	var keys []ofctrl.FlowKey
+	entries := make(map[ofctrl.FlowKey]*flowEntry)
+	for _, t := range dp.tables {
+		for _, entry := range t.entries {
+			keys = append(keys, entry.key)
+			entries[entry.key] = entry
+		}
+	}
+	ofctrl.SortFlowKeys(keys)
+
+	sorted := make([]*flowEntry, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, entries[key])
+	}
+	return sorted
+}
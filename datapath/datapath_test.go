@@ -0,0 +1,256 @@
+package datapath_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/datapath"
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+var testDPID = net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+
+// App handing its switch and PacketIns over channels
+type testApp struct {
+	connected chan *ofctrl.OFSwitch
+	packets   chan *openflow13.PacketIn
+}
+
+func (app *testApp) SwitchConnected(sw *ofctrl.OFSwitch)                                     { app.connected <- sw }
+func (app *testApp) SwitchDisconnected(sw *ofctrl.OFSwitch)                                  {}
+func (app *testApp) PacketRcvd(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn)                { app.packets <- pkt }
+func (app *testApp) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply)      {}
+func (app *testApp) PortStatusChange(sw *ofctrl.OFSwitch, portStatus *openflow13.PortStatus) {}
+func (app *testApp) FlowRemoved(sw *ofctrl.OFSwitch, flowRemoved *openflow13.FlowRemoved)    {}
+
+// Connect a datapath with ports 1 to 3 to a new controller
+func connect(t *testing.T) (*datapath.Datapath, *ofctrl.OFSwitch, *testApp) {
+	app := &testApp{connected: make(chan *ofctrl.OFSwitch, 1), packets: make(chan *openflow13.PacketIn, 16)}
+	dp := datapath.NewDatapath(testDPID)
+	for portNo := uint32(1); portNo <= 3; portNo++ {
+		dp.AddPort(portNo, "")
+	}
+	if err := dp.ConnectController(ofctrl.NewController(app)); err != nil {
+		t.Fatalf("Error connecting the datapath. Err: %v", err)
+	}
+	select {
+	case sw := <-app.connected:
+		return dp, sw, app
+	case <-time.After(2 * time.Second):
+		dp.Close()
+		t.Fatalf("Switch connected is not notified")
+	}
+	return nil, nil, nil
+}
+
+// Send flows in the ovs-ofctl syntax and wait for the datapath to have
+// them all
+func installFlows(t *testing.T, dp *datapath.Datapath, sw *ofctrl.OFSwitch, flows ...string) {
+	t.Helper()
+	for _, flow := range flows {
+		flowMod, err := openflow13.ParseFlowMod(flow)
+		if err != nil {
+			t.Fatalf("Error parsing flow %s. Err: %v", flow, err)
+		}
+		sw.Send(flowMod)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(dp.DumpFlows()) < len(flows) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Flows are not installed: %v", dp.DumpFlows())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newUDPPacket(nwDst string) *protocol.Ethernet {
+	eth := protocol.NewEthernet()
+	eth.HWSrc = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	eth.HWDst = net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	ip := protocol.NewIPv4()
+	ip.NWSrc = net.ParseIP("10.0.0.1").To4()
+	ip.NWDst = net.ParseIP(nwDst).To4()
+	ip.Protocol = 17
+	ip.TTL = 64
+	ip.Data = protocol.NewUDP()
+	ip.Length = ip.Len()
+	eth.Data = ip
+	return eth
+}
+
+func receive(t *testing.T, port *datapath.Port) *protocol.Ethernet {
+	t.Helper()
+	pkt, err := port.Receive(time.Second)
+	if err != nil {
+		t.Fatalf("Error receiving packet. Err: %v", err)
+	}
+	return pkt
+}
+
+func expectNoPacket(t *testing.T, port *datapath.Port) {
+	t.Helper()
+	select {
+	case pkt := <-port.Packets():
+		t.Errorf("Unexpected packet on port %d: %+v", port.PortNo, pkt)
+	default:
+	}
+}
+
+// Packets go through the tables, are rewritten and output, or sent to the
+// controller on a miss. The flow and port counters count them
+func TestForwarding(t *testing.T) {
+	dp, sw, app := connect(t)
+	defer dp.Close()
+
+	installFlows(t, dp, sw,
+		"table=0,priority=0,actions=controller",
+		"table=0,priority=10,ip,actions=goto_table:1",
+		"table=1,priority=10,ip,nw_dst=10.0.0.2,actions=mod_dl_dst:00:00:00:00:00:22,dec_ttl,output:2",
+		"table=1,priority=10,ip,nw_dst=10.0.0.3,actions=in_port",
+	)
+
+	if err := dp.Port(1).Inject(newUDPPacket("10.0.0.2")); err != nil {
+		t.Fatalf("Error injecting packet. Err: %v", err)
+	}
+	pkt := receive(t, dp.Port(2))
+	if pkt.HWDst.String() != "00:00:00:00:00:22" {
+		t.Errorf("Wrong eth_dst: got %s, want 00:00:00:00:00:22", pkt.HWDst)
+	}
+	if ip, ok := pkt.Data.(*protocol.IPv4); !ok || ip.TTL != 63 {
+		t.Errorf("Wrong packet, want TTL 63: %+v", pkt.Data)
+	}
+	expectNoPacket(t, dp.Port(3))
+
+	if err := dp.Port(1).Inject(newUDPPacket("10.0.0.3")); err != nil {
+		t.Fatalf("Error injecting packet. Err: %v", err)
+	}
+	receive(t, dp.Port(1))
+
+	// No flow of table 1 matches, the packet is dropped
+	if err := dp.Port(1).Inject(newUDPPacket("10.0.0.4")); err != nil {
+		t.Fatalf("Error injecting packet. Err: %v", err)
+	}
+
+	arp := protocol.NewEthernet()
+	arp.HWSrc = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	arp.HWDst = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	arp.Ethertype = 0x0806
+	arp.Data, _ = protocol.NewARP(protocol.Type_Request)
+	if err := dp.Port(3).Inject(arp); err != nil {
+		t.Fatalf("Error injecting packet. Err: %v", err)
+	}
+	select {
+	case pktIn := <-app.packets:
+		if pktIn.Reason != openflow13.R_NO_MATCH || pktIn.Data.Ethertype != 0x0806 {
+			t.Errorf("Wrong packet in: reason %d, ethertype 0x%04x", pktIn.Reason, pktIn.Data.Ethertype)
+		}
+		if pktIn.Match.String() != "in_port=3" {
+			t.Errorf("Wrong packet in match: got %s, want in_port=3", pktIn.Match.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Packet in is not received")
+	}
+	for _, port := range []uint32{1, 2, 3} {
+		expectNoPacket(t, dp.Port(port))
+	}
+
+	flowReq := openflow13.NewFlowStatsRequest()
+	flowReq.TableId = openflow13.OFPTT_ALL
+	stats, err := sw.DumpFlowStats(flowReq)
+	if err != nil {
+		t.Fatalf("Error dumping flows. Err: %v", err)
+	}
+	type flowId struct {
+		tableId  uint8
+		priority uint16
+		match    string
+	}
+	wantPackets := map[flowId]uint64{
+		{0, 0, ""}:                    1,
+		{0, 10, "ip"}:                 3,
+		{1, 10, "ip,nw_dst=10.0.0.2"}: 1,
+		{1, 10, "ip,nw_dst=10.0.0.3"}: 1,
+	}
+	if len(stats) != len(wantPackets) {
+		t.Fatalf("Wrong flows: %v", stats)
+	}
+	for _, s := range stats {
+		id := flowId{s.TableId, s.Priority, s.Match.String()}
+		if want, ok := wantPackets[id]; !ok || s.PacketCount != want {
+			t.Errorf("Wrong packet count of %+v: got %d, want %d", id, s.PacketCount, want)
+		}
+	}
+
+	wantPorts := map[uint32][2]uint64{1: {3, 1}, 2: {0, 1}, 3: {1, 0}}
+	for portNo, want := range wantPorts {
+		portStats, err := sw.DumpPortStats(portNo)
+		if err != nil || len(portStats) != 1 {
+			t.Fatalf("Error dumping port %d. Err: %v", portNo, err)
+		}
+		if portStats[0].RxPackets != want[0] || portStats[0].TxPackets != want[1] {
+			t.Errorf("Wrong counters of port %d: got rx %d tx %d, want rx %d tx %d",
+				portNo, portStats[0].RxPackets, portStats[0].TxPackets, want[0], want[1])
+		}
+	}
+}
+
+// An all group outputs to every bucket, a meter drops the packets above its
+// rate
+func TestGroupsAndMeters(t *testing.T) {
+	dp, sw, _ := connect(t)
+	defer dp.Close()
+
+	groupMod := openflow13.NewGroupMod()
+	groupMod.GroupId = 1
+	groupMod.Type = openflow13.OFPGT_ALL
+	for _, port := range []uint32{2, 3} {
+		bkt := openflow13.NewBucket()
+		bkt.AddAction(openflow13.NewActionOutput(port))
+		groupMod.AddBucket(*bkt)
+	}
+	sw.InstallGroup(groupMod)
+
+	meterMod := openflow13.NewMeterMod()
+	meterMod.MeterId = 1
+	meterMod.Flags = openflow13.OFPMF_PKTPS
+	meterMod.AddBand(openflow13.NewMeterBandDrop(2, 0))
+	sw.InstallMeter(meterMod)
+
+	installFlows(t, dp, sw,
+		"table=0,priority=10,ip,nw_dst=10.0.0.2,actions=group:1",
+		"table=0,priority=10,ip,nw_dst=10.0.0.3,actions=meter:1,output:2",
+	)
+
+	if err := dp.Port(1).Inject(newUDPPacket("10.0.0.2")); err != nil {
+		t.Fatalf("Error injecting packet. Err: %v", err)
+	}
+	receive(t, dp.Port(2))
+	receive(t, dp.Port(3))
+
+	// The bucket of the meter holds one second at its rate
+	const sent = 5
+	for i := 0; i < sent; i++ {
+		if err := dp.Port(1).Inject(newUDPPacket("10.0.0.3")); err != nil {
+			t.Fatalf("Error injecting packet. Err: %v", err)
+		}
+	}
+	received := 0
+	for len(dp.Port(2).Packets()) > 0 {
+		<-dp.Port(2).Packets()
+		received++
+	}
+	if received < 2 || received >= sent {
+		t.Errorf("Wrong packets through meter: got %d of %d, want 2 or 3", received, sent)
+	}
+
+	portStats, err := sw.DumpPortStats(2)
+	if err != nil || len(portStats) != 1 {
+		t.Fatalf("Error dumping port 2. Err: %v", err)
+	}
+	if portStats[0].TxPackets != uint64(1+received) {
+		t.Errorf("Wrong tx packets of port 2: got %d, want %d", portStats[0].TxPackets, 1+received)
+	}
+}
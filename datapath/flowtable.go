@@ -0,0 +1,465 @@
+package datapath
+
+// This file implements the flow tables, groups and meters and the flow,
+// group and meter mods changing them
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/serngawy/libOpenflow/internal/switchutil"
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// An OpenFlow error a request failed with
+type Error struct {
+	Type uint16
+	Code uint16
+}
+
+func newError(errType, code uint16) *Error {
+	return &Error{Type: errType, Code: code}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("OpenFlow error type %d code %d", e.Type, e.Code)
+}
+
+// A flow table, entries are sorted by descending priority
+type flowTable struct {
+	entries      []*flowEntry
+	lookupCount  uint64
+	matchedCount uint64
+}
+
+// An installed flow
+type flowEntry struct {
+	flowMod     *openflow13.FlowMod
+	key         ofctrl.FlowKey
+	match       []matchField
+	matchable   bool // False for a match on fields of other classes
+	installed   time.Time
+	lastUsed    time.Time
+	packetCount uint64
+	byteCount   uint64
+}
+
+// A group
+type group struct {
+	groupMod    *openflow13.GroupMod
+	packetCount uint64
+	byteCount   uint64
+}
+
+// A meter, each band is a token bucket
+type meter struct {
+	meterMod    *openflow13.MeterMod
+	bands       []*meterBand
+	packetCount uint64
+	byteCount   uint64
+}
+
+type meterBand struct {
+	band   openflow13.MeterBand
+	tokens float64
+	last   time.Time
+}
+
+func newFlowEntry(flowMod *openflow13.FlowMod, now time.Time) *flowEntry {
+	entry := &flowEntry{
+		flowMod:   flowMod,
+		key:       ofctrl.NewFlowKey(flowMod.TableId, flowMod.Priority, flowMod.Match),
+		installed: now,
+		lastUsed:  now,
+	}
+	entry.match, entry.matchable = compileMatch(flowMod.Match)
+	return entry
+}
+
+// Returns the highest priority flow matching the packet, nil on a table
+// miss
+func (t *flowTable) lookup(pkt *packet) *flowEntry {
+	for _, entry := range t.entries {
+		if entry.matchable && pkt.matches(entry.match) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Add a flow, replacing the flow with the same priority and match. Returns
+// the replaced flow
+func (t *flowTable) insert(entry *flowEntry) *flowEntry {
+	for i, old := range t.entries {
+		if old.key == entry.key {
+			t.entries[i] = entry
+			return old
+		}
+	}
+	i := sort.Search(len(t.entries), func(i int) bool {
+		return t.entries[i].flowMod.Priority < entry.flowMod.Priority
+	})
+	t.entries = append(t.entries, nil)
+	copy(t.entries[i+1:], t.entries[i:])
+	t.entries[i] = entry
+	return nil
+}
+
+// Remove the flows selected by a function and return them
+func (t *flowTable) remove(selects func(*flowEntry) bool) []*flowEntry {
+	var removed []*flowEntry
+	kept := t.entries[:0]
+	for _, entry := range t.entries {
+		if selects(entry) {
+			removed = append(removed, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	for i := len(kept); i < len(t.entries); i++ {
+		t.entries[i] = nil
+	}
+	t.entries = kept
+	return removed
+}
+
+// Returns true for the table-miss flow, whose packet ins have the
+// no_match reason
+func (entry *flowEntry) isTableMiss() bool {
+	return entry.flowMod.Priority == 0 && len(entry.flowMod.Match.Fields) == 0
+}
+
+// Returns true if the flow goes through a meter, OFPM_ALL matches all
+// metered flows
+func (entry *flowEntry) usesMeter(meterId uint32) bool {
+	for _, instr := range entry.flowMod.Instructions {
+		if m, ok := instr.(*openflow13.InstrMeter); ok && (m.MeterId == meterId || meterId == openflow13.OFPM_ALL) {
+			return true
+		}
+	}
+	return false
+}
+
+func (entry *flowEntry) duration(now time.Time) (uint32, uint32) {
+	d := now.Sub(entry.installed)
+	return uint32(d / time.Second), uint32(d % time.Second)
+}
+
+func (entry *flowEntry) flowStats() *openflow13.FlowStats {
+	stats := openflow13.NewFlowStats()
+	stats.TableId = entry.flowMod.TableId
+	stats.DurationSec, stats.DurationNSec = entry.duration(time.Now())
+	stats.Priority = entry.flowMod.Priority
+	stats.IdleTimeout = entry.flowMod.IdleTimeout
+	stats.HardTimeout = entry.flowMod.HardTimeout
+	stats.Flags = entry.flowMod.Flags
+	stats.Cookie = entry.flowMod.Cookie
+	stats.PacketCount = entry.packetCount
+	stats.ByteCount = entry.byteCount
+	stats.Match = entry.flowMod.Match
+	stats.Instructions = entry.flowMod.Instructions
+	stats.Length = stats.Len()
+	return stats
+}
+
+func (entry *flowEntry) flowRemoved(reason uint8, now time.Time) *openflow13.FlowRemoved {
+	msg := openflow13.NewFlowRemoved()
+	msg.Cookie = entry.flowMod.Cookie
+	msg.Priority = entry.flowMod.Priority
+	msg.Reason = reason
+	msg.TableId = entry.flowMod.TableId
+	msg.DurationSec, msg.DurationNSec = entry.duration(now)
+	msg.IdleTimeout = entry.flowMod.IdleTimeout
+	msg.HardTimeout = entry.flowMod.HardTimeout
+	msg.PacketCount = entry.packetCount
+	msg.ByteCount = entry.byteCount
+	msg.Match = entry.flowMod.Match
+	return msg
+}
+
+// Apply a flow mod as if it was sent by the controller
+func (dp *Datapath) ApplyFlowMod(flowMod *openflow13.FlowMod) error {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	return dp.flowMod(flowMod)
+}
+
+// Must be called with the lock held
+func (dp *Datapath) flowMod(flowMod *openflow13.FlowMod) error {
+	now := time.Now()
+	switch flowMod.Command {
+	case openflow13.FC_ADD, openflow13.FC_MODIFY, openflow13.FC_MODIFY_STRICT:
+		if flowMod.TableId >= dp.NumTables {
+			return newError(openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_BAD_TABLE_ID)
+		}
+		if err := dp.checkInstructions(flowMod); err != nil {
+			return err
+		}
+	}
+
+	switch flowMod.Command {
+	case openflow13.FC_ADD:
+		t := dp.table(flowMod.TableId)
+		entry := newFlowEntry(flowMod, now)
+		if flowMod.Flags&openflow13.FF_CHECK_OVERLAP != 0 && t.overlaps(flowMod) {
+			return newError(openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_OVERLAP)
+		}
+		if old := t.insert(entry); old != nil && flowMod.Flags&openflow13.FF_RESET_COUNTS == 0 {
+			entry.packetCount = old.packetCount
+			entry.byteCount = old.byteCount
+		}
+	case openflow13.FC_MODIFY, openflow13.FC_MODIFY_STRICT:
+		for _, t := range dp.tables {
+			for i, entry := range t.entries {
+				if !switchutil.Selects(flowMod, entry.flowMod) {
+					continue
+				}
+				modified := *entry.flowMod
+				modified.Instructions = flowMod.Instructions
+				replaced := *entry
+				replaced.flowMod = &modified
+				if flowMod.Flags&openflow13.FF_RESET_COUNTS != 0 {
+					replaced.packetCount = 0
+					replaced.byteCount = 0
+				}
+				t.entries[i] = &replaced
+			}
+		}
+	case openflow13.FC_DELETE, openflow13.FC_DELETE_STRICT:
+		dp.removeFlows(func(entry *flowEntry) bool {
+			return switchutil.Selects(flowMod, entry.flowMod)
+		}, openflow13.RR_DELETE, now)
+	default:
+		return newError(openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_BAD_COMMAND)
+	}
+	return nil
+}
+
+// Returns true if a flow of the table overlaps the flow added by flowMod.
+// Must be called with the lock held
+func (t *flowTable) overlaps(flowMod *openflow13.FlowMod) bool {
+	for _, other := range t.entries {
+		if switchutil.Overlaps(flowMod, other.flowMod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the flows selected by a function from all tables, sending flow
+// removed messages. Must be called with the lock held
+func (dp *Datapath) removeFlows(selects func(*flowEntry) bool, reason uint8, now time.Time) {
+	for _, t := range dp.tables {
+		for _, entry := range t.remove(selects) {
+			if entry.flowMod.Flags&openflow13.FF_SEND_FLOW_REM != 0 {
+				dp.sendToController(entry.flowRemoved(reason, now))
+			}
+		}
+	}
+}
+
+// Remove the flows whose idle or hard timeout expired. Must be called with
+// the lock held
+func (dp *Datapath) expireFlows(now time.Time) {
+	dp.removeFlows(func(entry *flowEntry) bool {
+		timeout := time.Duration(entry.flowMod.HardTimeout) * time.Second
+		return timeout > 0 && now.Sub(entry.installed) >= timeout
+	}, openflow13.RR_HARD_TIMEOUT, now)
+	dp.removeFlows(func(entry *flowEntry) bool {
+		timeout := time.Duration(entry.flowMod.IdleTimeout) * time.Second
+		return timeout > 0 && now.Sub(entry.lastUsed) >= timeout
+	}, openflow13.RR_IDLE_TIMEOUT, now)
+}
+
+// Check the tables, groups and meters a flow mod refers to exist. Must be
+// called with the lock held
+func (dp *Datapath) checkInstructions(flowMod *openflow13.FlowMod) error {
+	for _, instr := range flowMod.Instructions {
+		switch instr := instr.(type) {
+		case *openflow13.InstrGotoTable:
+			if instr.TableId <= flowMod.TableId || instr.TableId >= dp.NumTables {
+				return newError(openflow13.ET_BAD_INSTRUCTION, openflow13.BIC_BAD_TABLE_ID)
+			}
+		case *openflow13.InstrMeter:
+			if _, ok := dp.meters[instr.MeterId]; !ok {
+				return newError(openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_UNKNOWN_METER)
+			}
+		case *openflow13.InstrActions:
+			if !dp.groupsExist(instr.Actions) {
+				return newError(openflow13.ET_BAD_ACTION, openflow13.BAC_BAD_OUT_GROUP)
+			}
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held
+func (dp *Datapath) groupsExist(actions []openflow13.Action) bool {
+	for _, act := range actions {
+		if g, ok := act.(*openflow13.ActionGroup); ok {
+			if _, ok := dp.groups[g.GroupId]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply a group mod as if it was sent by the controller
+func (dp *Datapath) ApplyGroupMod(groupMod *openflow13.GroupMod) error {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	return dp.groupMod(groupMod)
+}
+
+// Must be called with the lock held
+func (dp *Datapath) groupMod(groupMod *openflow13.GroupMod) error {
+	old, exists := dp.groups[groupMod.GroupId]
+	switch groupMod.Command {
+	case openflow13.OFPGC_ADD, openflow13.OFPGC_MODIFY:
+		if groupMod.Command == openflow13.OFPGC_ADD && exists {
+			return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_GROUP_EXISTS)
+		}
+		if groupMod.Command == openflow13.OFPGC_MODIFY && !exists {
+			return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_UNKNOWN_GROUP)
+		}
+		if groupMod.GroupId > openflow13.OFPG_MAX {
+			return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_INVALID_GROUP)
+		}
+		if groupMod.Type > openflow13.OFPGT_FF {
+			return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_BAD_TYPE)
+		}
+		if groupMod.Type == openflow13.OFPGT_INDIRECT && len(groupMod.Buckets) != 1 {
+			return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_INVALID_GROUP)
+		}
+		for _, bucket := range groupMod.Buckets {
+			if !dp.groupsExist(bucket.Actions) {
+				return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_BAD_BUCKET)
+			}
+		}
+		g := &group{groupMod: groupMod}
+		if exists {
+			g.packetCount = old.packetCount
+			g.byteCount = old.byteCount
+		}
+		dp.groups[groupMod.GroupId] = g
+	case openflow13.OFPGC_DELETE:
+		if groupMod.GroupId == openflow13.OFPG_ALL {
+			dp.groups = make(map[uint32]*group)
+		} else {
+			delete(dp.groups, groupMod.GroupId)
+		}
+		dp.removeFlows(func(entry *flowEntry) bool {
+			return switchutil.UsesGroup(entry.flowMod, groupMod.GroupId)
+		}, openflow13.RR_GROUP_DELETE, time.Now())
+	default:
+		return newError(openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_BAD_COMMAND)
+	}
+	return nil
+}
+
+// Apply a meter mod as if it was sent by the controller
+func (dp *Datapath) ApplyMeterMod(meterMod *openflow13.MeterMod) error {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	return dp.meterMod(meterMod)
+}
+
+// Must be called with the lock held
+func (dp *Datapath) meterMod(meterMod *openflow13.MeterMod) error {
+	_, exists := dp.meters[meterMod.MeterId]
+	switch meterMod.Command {
+	case openflow13.OFPMC_ADD, openflow13.OFPMC_MODIFY:
+		if meterMod.Command == openflow13.OFPMC_ADD && exists {
+			return newError(openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_METER_EXISTS)
+		}
+		if meterMod.Command == openflow13.OFPMC_MODIFY && !exists {
+			return newError(openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_UNKNOWN_METER)
+		}
+		if meterMod.MeterId == 0 || meterMod.MeterId > openflow13.OFPM_MAX {
+			return newError(openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_INVALID_METER)
+		}
+		if meterMod.Flags&(openflow13.OFPMF_KBPS|openflow13.OFPMF_PKTPS) == 0 {
+			return newError(openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_BAD_FLAGS)
+		}
+		dp.meters[meterMod.MeterId] = newMeter(meterMod, time.Now())
+	case openflow13.OFPMC_DELETE:
+		if meterMod.MeterId == openflow13.OFPM_ALL {
+			dp.meters = make(map[uint32]*meter)
+		} else {
+			delete(dp.meters, meterMod.MeterId)
+		}
+		dp.removeFlows(func(entry *flowEntry) bool {
+			return entry.usesMeter(meterMod.MeterId)
+		}, openflow13.RR_DELETE, time.Now())
+	default:
+		return newError(openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_BAD_COMMAND)
+	}
+	return nil
+}
+
+// Create a meter, its buckets start full
+func newMeter(meterMod *openflow13.MeterMod, now time.Time) *meter {
+	m := &meter{meterMod: meterMod}
+	for _, band := range meterMod.Bands {
+		b := &meterBand{band: band, last: now}
+		b.tokens = m.burst(band)
+		m.bands = append(m.bands, b)
+	}
+	return m
+}
+
+// Size of the bucket of a band, one second at the band rate without the
+// burst flag
+func (m *meter) burst(band openflow13.MeterBand) float64 {
+	hdr := band.Header()
+	if m.meterMod.Flags&openflow13.OFPMF_BURST != 0 && hdr.BurstSize > 0 {
+		return float64(hdr.BurstSize)
+	}
+	return float64(hdr.Rate)
+}
+
+// Meter a packet of size bytes. The band with the highest rate the packet
+// exceeds applies. Returns false if the packet is dropped
+func (m *meter) apply(pkt *packet, size int, now time.Time) bool {
+	m.packetCount++
+	m.byteCount += uint64(size)
+
+	// Packets or kilobits
+	cost := 1.0
+	if m.meterMod.Flags&openflow13.OFPMF_PKTPS == 0 {
+		cost = float64(size*8) / 1000
+	}
+
+	var exceeded *meterBand
+	for _, b := range m.bands {
+		hdr := b.band.Header()
+		b.tokens += now.Sub(b.last).Seconds() * float64(hdr.Rate)
+		if max := m.burst(b.band); b.tokens > max {
+			b.tokens = max
+		}
+		b.last = now
+
+		if b.tokens >= cost {
+			b.tokens -= cost
+		} else if exceeded == nil || hdr.Rate > exceeded.band.Header().Rate {
+			exceeded = b
+		}
+	}
+	if exceeded == nil {
+		return true
+	}
+
+	switch band := exceeded.band.(type) {
+	case *openflow13.MeterBandDrop:
+		return false
+	case *openflow13.MeterBandDSCP:
+		// Raise the drop precedence encoded in the dscp
+		if ip := pkt.ipv4(); ip != nil {
+			ip.DSCP = (ip.DSCP + band.PrecLevel<<1) & 0x3f
+		}
+	}
+	return true
+}
@@ -0,0 +1,354 @@
+package datapath
+
+// This file implements reading and writing the OXM fields of the packets
+// going through the pipeline
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// A packet going through the pipeline with its metadata
+type packet struct {
+	eth      *protocol.Ethernet
+	tagged   bool // Has a vlan header, set by push_vlan even with vid 0
+	inPort   uint32
+	metadata uint64
+	tunnelId uint64
+	queueId  uint32
+}
+
+// Parse a frame received on a port
+func newPacket(data []byte, inPort uint32) (*packet, error) {
+	eth := new(protocol.Ethernet)
+	if err := eth.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &packet{eth: eth, tagged: eth.VLANID.VID != 0, inPort: inPort}, nil
+}
+
+// Returns the frame. A vlan header with vid 0 can't be written and is lost
+func (p *packet) frame() []byte {
+	data, _ := p.eth.MarshalBinary()
+	return data
+}
+
+// Copy the packet, e.g. for each bucket of a group
+func (p *packet) clone() *packet {
+	c := *p
+	c.eth = new(protocol.Ethernet)
+	if err := c.eth.UnmarshalBinary(p.frame()); err != nil {
+		c.eth = p.eth
+	}
+	return &c
+}
+
+func (p *packet) ipv4() *protocol.IPv4 {
+	ip, _ := p.eth.Data.(*protocol.IPv4)
+	return ip
+}
+
+func (p *packet) arp() *protocol.ARP {
+	arp, _ := p.eth.Data.(*protocol.ARP)
+	return arp
+}
+
+// Returns the TCP header, decoded from the raw payload the protocol
+// package leaves TCP in
+func (p *packet) tcp() *protocol.TCP {
+	ip := p.ipv4()
+	if ip == nil || ip.Protocol != protocol.Type_TCP {
+		return nil
+	}
+	switch data := ip.Data.(type) {
+	case *protocol.TCP:
+		return data
+	case *util.Buffer:
+		tcp := protocol.NewTCP()
+		if err := tcp.UnmarshalBinary(data.Bytes()); err != nil {
+			return nil
+		}
+		ip.Data = tcp
+		return tcp
+	}
+	return nil
+}
+
+func (p *packet) udp() *protocol.UDP {
+	if ip := p.ipv4(); ip != nil && ip.Protocol == protocol.Type_UDP {
+		udp, _ := ip.Data.(*protocol.UDP)
+		return udp
+	}
+	return nil
+}
+
+func (p *packet) icmp() *protocol.ICMP {
+	if ip := p.ipv4(); ip != nil && ip.Protocol == protocol.Type_ICMP {
+		icmp, _ := ip.Data.(*protocol.ICMP)
+		return icmp
+	}
+	return nil
+}
+
+func uint8Bytes(v uint8) []byte {
+	return []byte{v}
+}
+
+func uint16Bytes(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// Returns the value of an OpenFlow basic field of the packet, nil if the
+// packet doesn't have the field
+func (p *packet) field(field uint8) []byte {
+	eth := p.eth
+	switch field {
+	case openflow13.OXM_FIELD_IN_PORT, openflow13.OXM_FIELD_IN_PHY_PORT:
+		return uint32Bytes(p.inPort)
+	case openflow13.OXM_FIELD_METADATA:
+		return uint64Bytes(p.metadata)
+	case openflow13.OXM_FIELD_TUNNEL_ID:
+		return uint64Bytes(p.tunnelId)
+	case openflow13.OXM_FIELD_ETH_DST:
+		return eth.HWDst
+	case openflow13.OXM_FIELD_ETH_SRC:
+		return eth.HWSrc
+	case openflow13.OXM_FIELD_ETH_TYPE:
+		return uint16Bytes(eth.Ethertype)
+	case openflow13.OXM_FIELD_VLAN_VID:
+		if !p.tagged {
+			return uint16Bytes(0)
+		}
+		return uint16Bytes(eth.VLANID.VID | openflow13.OFPVID_PRESENT)
+	case openflow13.OXM_FIELD_VLAN_PCP:
+		if p.tagged {
+			return uint8Bytes(eth.VLANID.PCP)
+		}
+	case openflow13.OXM_FIELD_IP_DSCP, openflow13.OXM_FIELD_IP_ECN, openflow13.OXM_FIELD_IP_PROTO,
+		openflow13.OXM_FIELD_IPV4_SRC, openflow13.OXM_FIELD_IPV4_DST:
+		ip := p.ipv4()
+		if ip == nil {
+			return nil
+		}
+		switch field {
+		case openflow13.OXM_FIELD_IP_DSCP:
+			return uint8Bytes(ip.DSCP)
+		case openflow13.OXM_FIELD_IP_ECN:
+			return uint8Bytes(ip.ECN)
+		case openflow13.OXM_FIELD_IP_PROTO:
+			return uint8Bytes(ip.Protocol)
+		case openflow13.OXM_FIELD_IPV4_SRC:
+			return ip.NWSrc.To4()
+		case openflow13.OXM_FIELD_IPV4_DST:
+			return ip.NWDst.To4()
+		}
+	case openflow13.OXM_FIELD_TCP_SRC:
+		if tcp := p.tcp(); tcp != nil {
+			return uint16Bytes(tcp.PortSrc)
+		}
+	case openflow13.OXM_FIELD_TCP_DST:
+		if tcp := p.tcp(); tcp != nil {
+			return uint16Bytes(tcp.PortDst)
+		}
+	case openflow13.OXM_FIELD_TCP_FLAGS:
+		if tcp := p.tcp(); tcp != nil {
+			return uint16Bytes(uint16(tcp.Code))
+		}
+	case openflow13.OXM_FIELD_UDP_SRC:
+		if udp := p.udp(); udp != nil {
+			return uint16Bytes(udp.PortSrc)
+		}
+	case openflow13.OXM_FIELD_UDP_DST:
+		if udp := p.udp(); udp != nil {
+			return uint16Bytes(udp.PortDst)
+		}
+	case openflow13.OXM_FIELD_ICMPV4_TYPE:
+		if icmp := p.icmp(); icmp != nil {
+			return uint8Bytes(icmp.Type)
+		}
+	case openflow13.OXM_FIELD_ICMPV4_CODE:
+		if icmp := p.icmp(); icmp != nil {
+			return uint8Bytes(icmp.Code)
+		}
+	case openflow13.OXM_FIELD_ARP_OP, openflow13.OXM_FIELD_ARP_SPA, openflow13.OXM_FIELD_ARP_TPA,
+		openflow13.OXM_FIELD_ARP_SHA, openflow13.OXM_FIELD_ARP_THA:
+		arp := p.arp()
+		if arp == nil {
+			return nil
+		}
+		switch field {
+		case openflow13.OXM_FIELD_ARP_OP:
+			return uint16Bytes(arp.Operation)
+		case openflow13.OXM_FIELD_ARP_SPA:
+			return arp.IPSrc.To4()
+		case openflow13.OXM_FIELD_ARP_TPA:
+			return arp.IPDst.To4()
+		case openflow13.OXM_FIELD_ARP_SHA:
+			return arp.HWSrc
+		case openflow13.OXM_FIELD_ARP_THA:
+			return arp.HWDst
+		}
+	}
+	return nil
+}
+
+// Set an OpenFlow basic field of the packet. Returns false if the packet
+// doesn't have the field or the field can't be set
+func (p *packet) setField(field uint8, value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
+	v := bytesUint(value)
+	eth := p.eth
+	mac := func() net.HardwareAddr { return net.HardwareAddr(append([]byte(nil), value...)) }
+	ip := func() net.IP { return net.IP(append([]byte(nil), value...)) }
+
+	switch field {
+	case openflow13.OXM_FIELD_TUNNEL_ID:
+		p.tunnelId = v
+	case openflow13.OXM_FIELD_ETH_DST:
+		eth.HWDst = mac()
+	case openflow13.OXM_FIELD_ETH_SRC:
+		eth.HWSrc = mac()
+	case openflow13.OXM_FIELD_ETH_TYPE:
+		eth.Ethertype = uint16(v)
+	case openflow13.OXM_FIELD_VLAN_VID:
+		if !p.tagged {
+			return false
+		}
+		eth.VLANID.VID = uint16(v) & protocol.VID_MASK
+	case openflow13.OXM_FIELD_VLAN_PCP:
+		if !p.tagged {
+			return false
+		}
+		eth.VLANID.PCP = uint8(v) & 0x7
+	case openflow13.OXM_FIELD_IP_DSCP, openflow13.OXM_FIELD_IP_ECN,
+		openflow13.OXM_FIELD_IPV4_SRC, openflow13.OXM_FIELD_IPV4_DST:
+		ipv4 := p.ipv4()
+		if ipv4 == nil {
+			return false
+		}
+		switch field {
+		case openflow13.OXM_FIELD_IP_DSCP:
+			ipv4.DSCP = uint8(v) & 0x3f
+		case openflow13.OXM_FIELD_IP_ECN:
+			ipv4.ECN = uint8(v) & 0x3
+		case openflow13.OXM_FIELD_IPV4_SRC:
+			ipv4.NWSrc = ip()
+		case openflow13.OXM_FIELD_IPV4_DST:
+			ipv4.NWDst = ip()
+		}
+	case openflow13.OXM_FIELD_TCP_SRC, openflow13.OXM_FIELD_TCP_DST:
+		tcp := p.tcp()
+		if tcp == nil {
+			return false
+		}
+		if field == openflow13.OXM_FIELD_TCP_SRC {
+			tcp.PortSrc = uint16(v)
+		} else {
+			tcp.PortDst = uint16(v)
+		}
+	case openflow13.OXM_FIELD_UDP_SRC, openflow13.OXM_FIELD_UDP_DST:
+		udp := p.udp()
+		if udp == nil {
+			return false
+		}
+		if field == openflow13.OXM_FIELD_UDP_SRC {
+			udp.PortSrc = uint16(v)
+		} else {
+			udp.PortDst = uint16(v)
+		}
+	case openflow13.OXM_FIELD_ARP_OP, openflow13.OXM_FIELD_ARP_SPA, openflow13.OXM_FIELD_ARP_TPA,
+		openflow13.OXM_FIELD_ARP_SHA, openflow13.OXM_FIELD_ARP_THA:
+		arp := p.arp()
+		if arp == nil {
+			return false
+		}
+		switch field {
+		case openflow13.OXM_FIELD_ARP_OP:
+			arp.Operation = uint16(v)
+		case openflow13.OXM_FIELD_ARP_SPA:
+			arp.IPSrc = ip()
+		case openflow13.OXM_FIELD_ARP_TPA:
+			arp.IPDst = ip()
+		case openflow13.OXM_FIELD_ARP_SHA:
+			arp.HWSrc = mac()
+		case openflow13.OXM_FIELD_ARP_THA:
+			arp.HWDst = mac()
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func bytesUint(b []byte) uint64 {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+// An OpenFlow basic match field compiled for matching packets
+type matchField struct {
+	field uint8
+	value []byte
+	mask  []byte // nil for an exact match
+}
+
+// Compile a match. Returns false for matches on fields of other classes,
+// they never match
+func compileMatch(match openflow13.Match) ([]matchField, bool) {
+	fields := make([]matchField, 0, len(match.Fields))
+	for _, f := range match.Fields {
+		if f.Class != openflow13.OXM_CLASS_OPENFLOW_BASIC || f.Value == nil {
+			return nil, false
+		}
+		value, _ := f.Value.MarshalBinary()
+		mf := matchField{field: f.Field, value: value}
+		if f.HasMask && f.Mask != nil {
+			mf.mask, _ = f.Mask.MarshalBinary()
+		}
+		fields = append(fields, mf)
+	}
+	return fields, true
+}
+
+// Returns true if the packet matches all fields
+func (p *packet) matches(fields []matchField) bool {
+	for _, f := range fields {
+		value := p.field(f.field)
+		if len(value) != len(f.value) {
+			return false
+		}
+		for i := range value {
+			mask := byte(0xff)
+			if f.mask != nil && i < len(f.mask) {
+				mask = f.mask[i]
+			}
+			if value[i]&mask != f.value[i]&mask {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,421 @@
+package datapath
+
+// This file implements running packets through the flow tables and
+// executing the instructions, actions and groups of the flows they match
+
+import (
+	"hash/fnv"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Maximum depth of chained groups and packet out resubmits
+const maxDepth = 16
+
+// The processing of one packet. Runs with the datapath lock held
+type execution struct {
+	dp    *Datapath
+	now   time.Time
+	depth int
+	trace *Trace // Set when tracing, outputs are recorded instead of sent
+}
+
+// Where the actions being executed come from. Packet ins carry the table
+// and cookie of the flow
+type actionContext struct {
+	tableId   uint8
+	cookie    uint64
+	reason    uint8
+	packetOut bool
+}
+
+func (dp *Datapath) newExecution() *execution {
+	return &execution{dp: dp, now: time.Now()}
+}
+
+// Rank of an instruction, the instructions of a flow execute in the order
+// of the spec whatever their order in the flow
+func instrRank(instr openflow13.Instruction) int {
+	switch instr := instr.(type) {
+	case *openflow13.InstrMeter:
+		return 0
+	case *openflow13.InstrActions:
+		switch instr.Type {
+		case openflow13.InstrType_APPLY_ACTIONS:
+			return 1
+		case openflow13.InstrType_CLEAR_ACTIONS:
+			return 2
+		}
+		return 3
+	case *openflow13.InstrWriteMetadata:
+		return 4
+	}
+	return 5
+}
+
+func sortInstructions(instrs []openflow13.Instruction) []openflow13.Instruction {
+	sorted := append([]openflow13.Instruction(nil), instrs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return instrRank(sorted[i]) < instrRank(sorted[j])
+	})
+	return sorted
+}
+
+// Run a packet through the tables from tableId, then execute its action
+// set. A table miss without a table-miss flow drops the packet
+func (x *execution) runPipeline(pkt *packet, tableId uint8) {
+	size := uint64(len(pkt.frame()))
+	actionSet := newActionSet()
+	var ctx actionContext
+
+	for {
+		t := x.dp.table(tableId)
+		t.lookupCount++
+		entry := t.lookup(pkt)
+		x.trace.lookup(x.depth, tableId, entry)
+		if entry == nil {
+			x.trace.drop(x.depth, "table miss, dropped")
+			return
+		}
+		t.matchedCount++
+		entry.packetCount++
+		entry.byteCount += size
+		entry.lastUsed = x.now
+
+		ctx = actionContext{tableId: tableId, cookie: entry.flowMod.Cookie, reason: openflow13.R_ACTION}
+		if entry.isTableMiss() {
+			ctx.reason = openflow13.R_NO_MATCH
+		}
+
+		next := -1
+		for _, instr := range sortInstructions(entry.flowMod.Instructions) {
+			if actions, ok := instr.(*openflow13.InstrActions); !ok || actions.Type != openflow13.InstrType_APPLY_ACTIONS {
+				x.trace.action(x.depth, "%s", instr)
+			}
+			switch instr := instr.(type) {
+			case *openflow13.InstrMeter:
+				if m, ok := x.dp.meters[instr.MeterId]; ok && !m.apply(pkt, int(size), x.now) {
+					x.trace.drop(x.depth, "dropped by meter %d", instr.MeterId)
+					return
+				}
+			case *openflow13.InstrActions:
+				switch instr.Type {
+				case openflow13.InstrType_APPLY_ACTIONS:
+					if !x.applyActions(pkt, instr.Actions, ctx) {
+						return
+					}
+				case openflow13.InstrType_CLEAR_ACTIONS:
+					actionSet = newActionSet()
+				case openflow13.InstrType_WRITE_ACTIONS:
+					actionSet.write(instr.Actions)
+				}
+			case *openflow13.InstrWriteMetadata:
+				pkt.metadata = pkt.metadata&^instr.MetadataMask | instr.Metadata&instr.MetadataMask
+			case *openflow13.InstrGotoTable:
+				next = int(instr.TableId)
+			}
+		}
+		if next < 0 {
+			break
+		}
+		tableId = uint8(next)
+	}
+	actions := actionSet.list()
+	if len(actions) > 0 {
+		x.trace.action(x.depth, "action set: %s", openflow13.FormatActions(actions))
+	}
+	x.applyActions(pkt, actions, ctx)
+}
+
+// Execute a list of actions on the packet. Returns false if the packet was
+// dropped, e.g. on an invalid ttl
+func (x *execution) applyActions(pkt *packet, actions []openflow13.Action, ctx actionContext) bool {
+	for _, act := range actions {
+		x.trace.action(x.depth, "%s", openflow13.FormatActions([]openflow13.Action{act}))
+		switch act := act.(type) {
+		case *openflow13.ActionOutput:
+			x.output(pkt, act.Port, ctx)
+		case *openflow13.ActionGroup:
+			x.group(pkt, act.GroupId, ctx)
+		case *openflow13.ActionSetField:
+			value, _ := act.Field.Value.MarshalBinary()
+			if act.Field.Class != openflow13.OXM_CLASS_OPENFLOW_BASIC || !pkt.setField(act.Field.Field, value) {
+				log.Debugf("Datapath can't set field %s", openflow13.OxmFieldName(act.Field.Class, act.Field.Field))
+			}
+		case *openflow13.ActionPush:
+			if act.Type != openflow13.ActionType_PushVlan || pkt.tagged {
+				log.Debugf("Datapath doesn't support action %s", act)
+				continue
+			}
+			pkt.tagged = true
+			pkt.eth.VLANID.TPID = act.EtherType
+			pkt.eth.VLANID.PCP = 0
+			pkt.eth.VLANID.VID = 0
+		case *openflow13.ActionPopVlan:
+			pkt.tagged = false
+			pkt.eth.VLANID.VID = 0
+		case *openflow13.ActionNwTtl:
+			if ip := pkt.ipv4(); ip != nil {
+				ip.TTL = act.NwTtl
+			}
+		case *openflow13.ActionSetqueue:
+			pkt.queueId = act.QueueId
+		case *openflow13.ActionGeneric:
+			switch act.Type {
+			case openflow13.ActionType_DecNwTtl:
+				if ip := pkt.ipv4(); ip != nil {
+					if ip.TTL <= 1 {
+						x.trace.drop(x.depth, "ttl expired, dropped")
+						return false
+					}
+					ip.TTL--
+				}
+			case openflow13.ActionType_CopyTtlIn, openflow13.ActionType_CopyTtlOut:
+				// A single layer of ttl, nothing to copy
+			default:
+				log.Debugf("Datapath doesn't support action %s", act)
+			}
+		default:
+			log.Debugf("Datapath doesn't support action %s", act)
+		}
+	}
+	return true
+}
+
+// Send the packet out of a port. NORMAL floods, there is no learning
+func (x *execution) output(pkt *packet, portNo uint32, ctx actionContext) {
+	if x.trace != nil {
+		x.trace.output(x.depth, pkt, portNo)
+		return
+	}
+	dp := x.dp
+	switch portNo {
+	case openflow13.P_CONTROLLER:
+		x.packetIn(pkt, ctx)
+	case openflow13.P_IN_PORT:
+		if port, ok := dp.ports[pkt.inPort]; ok {
+			port.transmit(pkt)
+		}
+	case openflow13.P_ALL, openflow13.P_FLOOD, openflow13.P_NORMAL:
+		for _, portNo := range dp.portNumbers() {
+			if portNo != pkt.inPort {
+				dp.ports[portNo].transmit(pkt)
+			}
+		}
+	case openflow13.P_TABLE:
+		// Only packet outs may resubmit to the tables
+		if ctx.packetOut && x.depth < maxDepth {
+			x.depth++
+			x.runPipeline(pkt.clone(), 0)
+			x.depth--
+		}
+	default:
+		// Packets are sent back to the in port only by IN_PORT
+		if port, ok := dp.ports[portNo]; ok && portNo != pkt.inPort {
+			port.transmit(pkt)
+		}
+	}
+}
+
+// Send the packet to the controller. Packets are never buffered nor
+// truncated to the max_len of the output
+func (x *execution) packetIn(pkt *packet, ctx actionContext) {
+	pktIn := openflow13.NewPacketIn()
+	pktIn.Reason = ctx.reason
+	pktIn.TableId = ctx.tableId
+	pktIn.Cookie = ctx.cookie
+	pktIn.Match.AddField(*openflow13.NewInPortField(pkt.inPort))
+	if pkt.metadata != 0 {
+		pktIn.Match.AddField(*openflow13.NewMetadataField(pkt.metadata, nil))
+	}
+	if pkt.tunnelId != 0 {
+		pktIn.Match.AddField(*openflow13.NewTunnelIdField(pkt.tunnelId))
+	}
+	pktIn.Data = *pkt.clone().eth
+	pktIn.TotalLen = uint16(len(pkt.frame()))
+	x.dp.sendToController(pktIn)
+}
+
+// Execute a group on copies of the packet
+func (x *execution) group(pkt *packet, groupId uint32, ctx actionContext) {
+	g, ok := x.dp.groups[groupId]
+	if !ok {
+		x.trace.drop(x.depth, "unknown group %d", groupId)
+		return
+	}
+	if x.depth >= maxDepth {
+		return
+	}
+	x.depth++
+	defer func() { x.depth-- }()
+
+	g.packetCount++
+	g.byteCount += uint64(len(pkt.frame()))
+
+	var buckets []openflow13.Bucket
+	switch g.groupMod.Type {
+	case openflow13.OFPGT_ALL:
+		buckets = g.groupMod.Buckets
+	case openflow13.OFPGT_SELECT:
+		if b := x.selectBucket(g, pkt); b != nil {
+			buckets = append(buckets, *b)
+		}
+	case openflow13.OFPGT_INDIRECT:
+		buckets = g.groupMod.Buckets
+	case openflow13.OFPGT_FF:
+		for _, b := range g.groupMod.Buckets {
+			if x.bucketLive(&b) {
+				buckets = append(buckets, b)
+				break
+			}
+		}
+	}
+	for _, b := range buckets {
+		x.trace.action(x.depth-1, "bucket: %s", openflow13.FormatActions(b.Actions))
+		x.applyActions(pkt.clone(), b.Actions, ctx)
+	}
+}
+
+// Returns true if the port and group a bucket watches are up
+func (x *execution) bucketLive(b *openflow13.Bucket) bool {
+	if b.WatchPort != openflow13.P_ANY {
+		port, ok := x.dp.ports[b.WatchPort]
+		if !ok || port.linkDown {
+			return false
+		}
+	}
+	if b.WatchGroup != openflow13.OFPG_ANY {
+		g, ok := x.dp.groups[b.WatchGroup]
+		if !ok {
+			return false
+		}
+		if g.groupMod.Type == openflow13.OFPGT_FF && x.depth < maxDepth {
+			x.depth++
+			defer func() { x.depth-- }()
+			for i := range g.groupMod.Buckets {
+				if x.bucketLive(&g.groupMod.Buckets[i]) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Fields hashed to pick the bucket of a select group, packets of a
+// connection always take the same bucket
+var selectFields = []uint8{
+	openflow13.OXM_FIELD_ETH_SRC, openflow13.OXM_FIELD_ETH_DST, openflow13.OXM_FIELD_ETH_TYPE,
+	openflow13.OXM_FIELD_IPV4_SRC, openflow13.OXM_FIELD_IPV4_DST, openflow13.OXM_FIELD_IP_PROTO,
+	openflow13.OXM_FIELD_TCP_SRC, openflow13.OXM_FIELD_TCP_DST,
+	openflow13.OXM_FIELD_UDP_SRC, openflow13.OXM_FIELD_UDP_DST,
+}
+
+// Pick a live bucket of a select group by weight
+func (x *execution) selectBucket(g *group, pkt *packet) *openflow13.Bucket {
+	var live []*openflow13.Bucket
+	total := uint32(0)
+	for i := range g.groupMod.Buckets {
+		b := &g.groupMod.Buckets[i]
+		if b.Weight > 0 && x.bucketLive(b) {
+			live = append(live, b)
+			total += uint32(b.Weight)
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	for _, field := range selectFields {
+		h.Write(pkt.field(field))
+	}
+	n := h.Sum32() % total
+	for _, b := range live {
+		if n < uint32(b.Weight) {
+			return b
+		}
+		n -= uint32(b.Weight)
+	}
+	return nil
+}
+
+// The action set of a packet: at most one action of each type, a set field
+// per field, executed in the order of the spec
+type actionSet struct {
+	actions map[uint32]openflow13.Action
+}
+
+func newActionSet() *actionSet {
+	return &actionSet{actions: make(map[uint32]openflow13.Action)}
+}
+
+// Rank of the action types in the action set
+var actionSetOrder = map[uint16]uint32{
+	openflow13.ActionType_CopyTtlIn:  0,
+	openflow13.ActionType_PopVlan:    1,
+	openflow13.ActionType_PopMpls:    1,
+	openflow13.ActionType_PopPbb:     1,
+	openflow13.ActionType_PushMpls:   2,
+	openflow13.ActionType_PushPbb:    3,
+	openflow13.ActionType_PushVlan:   4,
+	openflow13.ActionType_CopyTtlOut: 5,
+	openflow13.ActionType_DecMplsTtl: 6,
+	openflow13.ActionType_DecNwTtl:   6,
+	openflow13.ActionType_SetField:   7,
+	openflow13.ActionType_SetNwTtl:   7,
+	openflow13.ActionType_SetMplsTtl: 7,
+	openflow13.ActionType_SetQueue:   8,
+	openflow13.ActionType_Group:      9,
+	openflow13.ActionType_Output:     10,
+}
+
+// Key of an action in the set: its rank, type and set field
+func actionSetKey(act openflow13.Action) uint32 {
+	actionType := act.Header().Type
+	rank, ok := actionSetOrder[actionType]
+	if !ok {
+		rank = 11
+	}
+	key := rank<<24 | uint32(actionType&0xff)<<8
+	if setField, ok := act.(*openflow13.ActionSetField); ok {
+		key |= uint32(setField.Field.Field)
+	}
+	return key
+}
+
+// Merge actions in the set, replacing the actions of the same type
+func (s *actionSet) write(actions []openflow13.Action) {
+	for _, act := range actions {
+		s.actions[actionSetKey(act)] = act
+	}
+}
+
+// Returns the actions in execution order. Output is dropped when there is
+// a group
+func (s *actionSet) list() []openflow13.Action {
+	keys := make([]uint32, 0, len(s.actions))
+	hasGroup := false
+	for key, act := range s.actions {
+		keys = append(keys, key)
+		if act.Header().Type == openflow13.ActionType_Group {
+			hasGroup = true
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	actions := make([]openflow13.Action, 0, len(keys))
+	for _, key := range keys {
+		act := s.actions[key]
+		if hasGroup && act.Header().Type == openflow13.ActionType_Output {
+			continue
+		}
+		actions = append(actions, act)
+	}
+	return actions
+}
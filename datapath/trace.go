@@ -0,0 +1,241 @@
+package datapath
+
+// This file implements tracing a packet through the flows of a flow dump,
+// like ovs-appctl ofproto/trace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+// The kind of a step of a trace
+type TraceStepType int
+
+const (
+	// A table lookup, Flow is the matched flow, nil on a table miss
+	TraceLookup TraceStepType = iota
+	// An instruction, action or group bucket executed
+	TraceAction
+	// The packet was sent out of Port, P_CONTROLLER for a packet in
+	TraceOutput
+	// The packet was dropped or an action had no effect
+	TraceDrop
+)
+
+// A step of a trace
+type TraceStep struct {
+	Type    TraceStepType
+	Depth   int // Nesting in group buckets
+	TableId uint8
+	Flow    *openflow13.FlowStats
+	Port    uint32
+	Packet  *protocol.Ethernet // The packet sent by an output
+	Text    string
+}
+
+// The trace of a packet through flow tables
+type Trace struct {
+	InPort    uint32
+	Flow      openflow13.Match // The fields of the packet received
+	FinalFlow openflow13.Match // The fields of the packet after the pipeline
+	Steps     []TraceStep
+
+	flows map[*flowEntry]*openflow13.FlowStats
+}
+
+// Trace a packet received on inPort through the flows of a flow dump, e.g.
+// from OFSwitch.DumpFlowStats. Groups and meters are not part of a flow
+// dump: group actions are traced as unknown groups and meters don't drop
+func TracePacket(flows []*openflow13.FlowStats, pkt *protocol.Ethernet, inPort uint32) (*Trace, error) {
+	data, err := pkt.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	p, err := newPacket(data, inPort)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &Trace{InPort: inPort, flows: make(map[*flowEntry]*openflow13.FlowStats)}
+	dp := NewDatapath(nil)
+	now := time.Now()
+	for _, stats := range flows {
+		flowMod := openflow13.NewFlowMod()
+		flowMod.TableId = stats.TableId
+		flowMod.Priority = stats.Priority
+		flowMod.Cookie = stats.Cookie
+		flowMod.Flags = stats.Flags
+		flowMod.Match = stats.Match
+		flowMod.Instructions = stats.Instructions
+		entry := newFlowEntry(flowMod, now)
+		dp.table(stats.TableId).insert(entry)
+		trace.flows[entry] = stats
+	}
+
+	trace.Flow = p.flowMatch()
+	x := dp.newExecution()
+	x.trace = trace
+	x.runPipeline(p, 0)
+	trace.FinalFlow = p.flowMatch()
+	return trace, nil
+}
+
+// Returns the output steps of the trace, in order
+func (t *Trace) Outputs() []TraceStep {
+	var outputs []TraceStep
+	for _, step := range t.Steps {
+		if step.Type == TraceOutput {
+			outputs = append(outputs, step)
+		}
+	}
+	return outputs
+}
+
+// Returns the trace in a format close to ovs-appctl ofproto/trace
+func (t *Trace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Flow: %s\n\n", t.Flow.String())
+	for _, step := range t.Steps {
+		indent := strings.Repeat("    ", step.Depth)
+		switch step.Type {
+		case TraceLookup:
+			fmt.Fprintf(&b, "%stable=%d: %s\n", indent, step.TableId, step.Text)
+		case TraceAction:
+			fmt.Fprintf(&b, "%s    %s\n", indent, step.Text)
+		default:
+			fmt.Fprintf(&b, "%s     -> %s\n", indent, step.Text)
+		}
+	}
+
+	var ports []string
+	for _, step := range t.Outputs() {
+		ports = append(ports, portName(step.Port))
+	}
+	if len(ports) == 0 {
+		ports = append(ports, "drop")
+	}
+	fmt.Fprintf(&b, "\nFinal flow: %s\n", t.FinalFlow.String())
+	fmt.Fprintf(&b, "Outputs: %s\n", strings.Join(ports, ","))
+	return b.String()
+}
+
+func portName(portNo uint32) string {
+	switch portNo {
+	case openflow13.P_IN_PORT:
+		return "IN_PORT"
+	case openflow13.P_TABLE:
+		return "TABLE"
+	case openflow13.P_NORMAL:
+		return "NORMAL"
+	case openflow13.P_FLOOD:
+		return "FLOOD"
+	case openflow13.P_ALL:
+		return "ALL"
+	case openflow13.P_CONTROLLER:
+		return "CONTROLLER"
+	case openflow13.P_LOCAL:
+		return "LOCAL"
+	}
+	return fmt.Sprint(portNo)
+}
+
+// The trace hooks of the pipeline do nothing on a nil trace
+
+func (t *Trace) lookup(depth int, tableId uint8, entry *flowEntry) {
+	if t == nil {
+		return
+	}
+	step := TraceStep{Type: TraceLookup, Depth: depth, TableId: tableId, Text: "no match"}
+	if entry != nil {
+		step.Flow = t.flows[entry]
+		flow := fmt.Sprintf("priority=%d", entry.flowMod.Priority)
+		if match := entry.flowMod.Match.String(); match != "" {
+			flow += "," + match
+		}
+		step.Text = fmt.Sprintf("%s, cookie=%#x", flow, entry.flowMod.Cookie)
+	}
+	t.Steps = append(t.Steps, step)
+}
+
+func (t *Trace) action(depth int, format string, args ...interface{}) {
+	if t != nil {
+		t.Steps = append(t.Steps, TraceStep{Type: TraceAction, Depth: depth, Text: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (t *Trace) drop(depth int, format string, args ...interface{}) {
+	if t != nil {
+		t.Steps = append(t.Steps, TraceStep{Type: TraceDrop, Depth: depth, Text: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Record an output instead of sending the packet
+func (t *Trace) output(depth int, pkt *packet, portNo uint32) {
+	switch portNo {
+	case openflow13.P_IN_PORT:
+		portNo = pkt.inPort
+	case openflow13.P_TABLE, openflow13.P_ANY:
+		t.drop(depth, "output to %s is ignored", portName(portNo))
+		return
+	case pkt.inPort:
+		t.drop(depth, "output to the in_port %d is skipped, use IN_PORT", portNo)
+		return
+	}
+	t.Steps = append(t.Steps, TraceStep{
+		Type:   TraceOutput,
+		Depth:  depth,
+		Port:   portNo,
+		Packet: pkt.clone().eth,
+		Text:   "output to " + portName(portNo),
+	})
+}
+
+// Fields of the packet shown in traces, in order
+var flowFields = []uint8{
+	openflow13.OXM_FIELD_IN_PORT, openflow13.OXM_FIELD_METADATA, openflow13.OXM_FIELD_TUNNEL_ID,
+	openflow13.OXM_FIELD_ETH_SRC, openflow13.OXM_FIELD_ETH_DST, openflow13.OXM_FIELD_ETH_TYPE,
+	openflow13.OXM_FIELD_VLAN_VID, openflow13.OXM_FIELD_VLAN_PCP,
+	openflow13.OXM_FIELD_IPV4_SRC, openflow13.OXM_FIELD_IPV4_DST, openflow13.OXM_FIELD_IP_PROTO,
+	openflow13.OXM_FIELD_IP_DSCP, openflow13.OXM_FIELD_IP_ECN,
+	openflow13.OXM_FIELD_TCP_SRC, openflow13.OXM_FIELD_TCP_DST,
+	openflow13.OXM_FIELD_UDP_SRC, openflow13.OXM_FIELD_UDP_DST,
+	openflow13.OXM_FIELD_ICMPV4_TYPE, openflow13.OXM_FIELD_ICMPV4_CODE,
+	openflow13.OXM_FIELD_ARP_OP, openflow13.OXM_FIELD_ARP_SPA, openflow13.OXM_FIELD_ARP_TPA,
+	openflow13.OXM_FIELD_ARP_SHA, openflow13.OXM_FIELD_ARP_THA,
+}
+
+// Returns the fields of the packet as an exact match. Metadata, tunnel id,
+// vlan and ip dscp and ecn are left out when not set
+func (p *packet) flowMatch() openflow13.Match {
+	match := *openflow13.NewMatch()
+	for _, field := range flowFields {
+		switch {
+		case field == openflow13.OXM_FIELD_METADATA && p.metadata == 0,
+			field == openflow13.OXM_FIELD_TUNNEL_ID && p.tunnelId == 0,
+			field == openflow13.OXM_FIELD_VLAN_VID && !p.tagged:
+			continue
+		}
+		value := p.field(field)
+		if value == nil {
+			continue
+		}
+		if (field == openflow13.OXM_FIELD_IP_DSCP || field == openflow13.OXM_FIELD_IP_ECN) && value[0] == 0 {
+			continue
+		}
+		v, err := openflow13.DecodeMatchField(openflow13.OXM_CLASS_OPENFLOW_BASIC, field, value)
+		if err != nil || v == nil {
+			continue
+		}
+		match.AddField(openflow13.MatchField{
+			Class:  openflow13.OXM_CLASS_OPENFLOW_BASIC,
+			Field:  field,
+			Length: uint8(len(value)),
+			Value:  v,
+		})
+	}
+	return match
+}
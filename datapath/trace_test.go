@@ -0,0 +1,151 @@
+package datapath_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/datapath"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Returns the flow stats of flows in the ovs-ofctl syntax, as dumped from a
+// switch
+func parseFlowStats(t *testing.T, flows []string) []*openflow13.FlowStats {
+	t.Helper()
+	var dump []*openflow13.FlowStats
+	for _, flow := range flows {
+		flowMod, err := openflow13.ParseFlowMod(flow)
+		if err != nil {
+			t.Fatalf("Error parsing flow %s. Err: %v", flow, err)
+		}
+		stats := openflow13.NewFlowStats()
+		stats.TableId = flowMod.TableId
+		stats.Priority = flowMod.Priority
+		stats.Cookie = flowMod.Cookie
+		stats.Match = flowMod.Match
+		stats.Instructions = flowMod.Instructions
+		dump = append(dump, stats)
+	}
+	return dump
+}
+
+func TestTracePacket(t *testing.T) {
+	tests := []struct {
+		name      string
+		flows     []string
+		outputs   []uint32
+		finalFlow []string // Fields of the final flow
+		drop      string   // Text of a drop step
+	}{
+		{
+			name: "goto chain",
+			flows: []string{
+				"table=0,priority=10,ip,actions=goto_table:1",
+				"table=1,priority=10,ip,nw_dst=10.0.0.2,actions=mod_dl_dst:00:00:00:00:00:22,goto_table:2",
+				"table=2,priority=0,actions=output:2",
+			},
+			outputs:   []uint32{2},
+			finalFlow: []string{"dl_dst=00:00:00:00:00:22", "nw_dst=10.0.0.2"},
+		},
+		{
+			name: "table miss",
+			flows: []string{
+				"table=0,priority=10,ip,actions=goto_table:1",
+				"table=1,priority=10,arp,actions=output:2",
+			},
+			finalFlow: []string{"dl_dst=00:00:00:00:00:02"},
+			drop:      "table miss",
+		},
+		{
+			name:      "output to in_port",
+			flows:     []string{"table=0,priority=10,ip,actions=output:1"},
+			finalFlow: []string{"in_port=1"},
+			drop:      "in_port 1 is skipped",
+		},
+		{
+			name:    "IN_PORT",
+			flows:   []string{"table=0,priority=10,ip,actions=in_port"},
+			outputs: []uint32{1},
+		},
+		{
+			name: "write actions",
+			flows: []string{
+				"table=0,priority=10,ip,actions=output:2,write_actions(mod_dl_src:00:00:00:00:00:33,output:3)",
+			},
+			outputs:   []uint32{2, 3},
+			finalFlow: []string{"dl_src=00:00:00:00:00:33"},
+		},
+		{
+			name:  "unknown group",
+			flows: []string{"table=0,priority=10,ip,actions=group:5"},
+			drop:  "unknown group 5",
+		},
+	}
+
+	for _, test := range tests {
+		trace, err := datapath.TracePacket(parseFlowStats(t, test.flows), newUDPPacket("10.0.0.2"), 1)
+		if err != nil {
+			t.Fatalf("Error tracing %s. Err: %v", test.name, err)
+		}
+
+		var outputs []uint32
+		for _, step := range trace.Outputs() {
+			outputs = append(outputs, step.Port)
+		}
+		if len(outputs) != len(test.outputs) {
+			t.Errorf("Wrong outputs of %s: got %v, want %v\n%s", test.name, outputs, test.outputs, trace)
+		} else {
+			for i := range outputs {
+				if outputs[i] != test.outputs[i] {
+					t.Errorf("Wrong outputs of %s: got %v, want %v\n%s", test.name, outputs, test.outputs, trace)
+					break
+				}
+			}
+		}
+
+		finalFlow := "," + trace.FinalFlow.String() + ","
+		for _, field := range test.finalFlow {
+			if !strings.Contains(finalFlow, ","+field+",") {
+				t.Errorf("Wrong final flow of %s: got %s, want %s", test.name, trace.FinalFlow.String(), field)
+			}
+		}
+
+		if test.drop != "" {
+			found := false
+			for _, step := range trace.Steps {
+				if step.Type == datapath.TraceDrop && strings.Contains(step.Text, test.drop) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Wrong trace of %s, want a %q drop:\n%s", test.name, test.drop, trace)
+			}
+		}
+	}
+}
+
+// The flow of a trace lookup is the flow stats it was dumped as, and the
+// packet of an output has the rewrites done before it
+func TestTraceFlowsAndPackets(t *testing.T) {
+	flows := parseFlowStats(t, []string{
+		"table=0,priority=10,ip,actions=output:2,mod_dl_src:00:00:00:00:00:33,output:3",
+	})
+	trace, err := datapath.TracePacket(flows, newUDPPacket("10.0.0.2"), 1)
+	if err != nil {
+		t.Fatalf("Error tracing packet. Err: %v", err)
+	}
+
+	if len(trace.Steps) == 0 || trace.Steps[0].Type != datapath.TraceLookup || trace.Steps[0].Flow != flows[0] {
+		t.Errorf("Wrong lookup step: %+v", trace.Steps)
+	}
+	outputs := trace.Outputs()
+	if len(outputs) != 2 {
+		t.Fatalf("Wrong outputs: %+v", outputs)
+	}
+	if src := outputs[0].Packet.HWSrc.String(); src != "00:00:00:00:00:01" {
+		t.Errorf("Wrong eth_src of output to port 2: got %s, want 00:00:00:00:00:01", src)
+	}
+	if src := outputs[1].Packet.HWSrc.String(); src != "00:00:00:00:00:33" {
+		t.Errorf("Wrong eth_src of output to port 3: got %s, want 00:00:00:00:00:33", src)
+	}
+}
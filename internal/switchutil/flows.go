@@ -0,0 +1,144 @@
+// Package switchutil has the helpers shared by the switches of the library:
+// the fake switch of ofswitchtest, the userspace datapath and the session
+// replayer.
+package switchutil
+
+// This file implements selecting flows the way a switch does for flow mods
+// and flow stats requests
+
+import (
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Returns true if a modify or delete request selects the flow added by
+// flowMod. Deletes also filter on out_port and out_group, modifies ignore
+// them as the spec says
+func Selects(req, flowMod *openflow13.FlowMod) bool {
+	if !ofctrl.FlowModSelects(req, flowMod) {
+		return false
+	}
+	switch req.Command {
+	case openflow13.FC_DELETE, openflow13.FC_DELETE_STRICT:
+		return OutputsTo(flowMod, req.OutPort) && UsesGroup(flowMod, req.OutGroup)
+	}
+	return true
+}
+
+// Returns the non-strict delete request selecting the same flows as a flow
+// stats request
+func StatsFilter(req *openflow13.FlowStatsRequest) *openflow13.FlowMod {
+	filter := openflow13.NewFlowMod()
+	filter.Command = openflow13.FC_DELETE
+	filter.TableId = req.TableId
+	filter.OutPort = req.OutPort
+	filter.OutGroup = req.OutGroup
+	filter.Cookie = req.Cookie
+	filter.CookieMask = req.CookieMask
+	filter.Match = req.Match
+	return filter
+}
+
+// Returns the flow stats request selecting the same flows as an aggregate
+// stats request
+func AggregateFilter(req *openflow13.AggregateStatsRequest) *openflow13.FlowStatsRequest {
+	flowReq := openflow13.NewFlowStatsRequest()
+	flowReq.TableId = req.TableId
+	flowReq.OutPort = req.OutPort
+	flowReq.OutGroup = req.OutGroup
+	flowReq.Cookie = req.Cookie
+	flowReq.CookieMask = req.CookieMask
+	flowReq.Match = req.Match
+	return flowReq
+}
+
+// Returns the actions of the apply and write actions instructions
+func Actions(flowMod *openflow13.FlowMod) []openflow13.Action {
+	var actions []openflow13.Action
+	for _, instr := range flowMod.Instructions {
+		if instr, ok := instr.(*openflow13.InstrActions); ok {
+			actions = append(actions, instr.Actions...)
+		}
+	}
+	return actions
+}
+
+// Returns true if the flow outputs to a port, P_ANY matches all flows
+func OutputsTo(flowMod *openflow13.FlowMod, portNo uint32) bool {
+	if portNo == openflow13.P_ANY {
+		return true
+	}
+	for _, act := range Actions(flowMod) {
+		if output, ok := act.(*openflow13.ActionOutput); ok && output.Port == portNo {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the flow forwards to a group, OFPG_ANY matches all flows
+// and OFPG_ALL the flows using any group
+func UsesGroup(flowMod *openflow13.FlowMod, groupId uint32) bool {
+	if groupId == openflow13.OFPG_ANY {
+		return true
+	}
+	for _, act := range Actions(flowMod) {
+		if g, ok := act.(*openflow13.ActionGroup); ok && (g.GroupId == groupId || groupId == openflow13.OFPG_ALL) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if adding flowMod with FF_CHECK_OVERLAP fails because of
+// other: both flows are in the same table with the same priority, their
+// matches differ and some packet matches both
+func Overlaps(flowMod, other *openflow13.FlowMod) bool {
+	if flowMod.TableId != other.TableId || flowMod.Priority != other.Priority {
+		return false
+	}
+	if ofctrl.NewFlowKey(0, 0, flowMod.Match).SameMatch(ofctrl.NewFlowKey(0, 0, other.Match)) {
+		return false
+	}
+	return MatchesOverlap(flowMod.Match, other.Match)
+}
+
+// Returns true if some packet matches both matches, i.e. the fields both
+// match on agree on the bits both masks keep
+func MatchesOverlap(a, b openflow13.Match) bool {
+	for _, fa := range a.Fields {
+		for _, fb := range b.Fields {
+			if fa.Class != fb.Class || fa.Field != fb.Field {
+				continue
+			}
+			va, ma := fieldBytes(fa)
+			vb, mb := fieldBytes(fb)
+			if len(va) != len(vb) {
+				continue
+			}
+			for i := range va {
+				mask := ma[i] & mb[i]
+				if va[i]&mask != vb[i]&mask {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Returns the value and the mask of a match field, an all-ones mask for a
+// field without mask
+func fieldBytes(field openflow13.MatchField) ([]byte, []byte) {
+	value, _ := field.Value.MarshalBinary()
+	if field.HasMask && field.Mask != nil {
+		if mask, err := field.Mask.MarshalBinary(); err == nil && len(mask) == len(value) {
+			return value, mask
+		}
+	}
+	mask := make([]byte, len(value))
+	for i := range mask {
+		mask[i] = 0xff
+	}
+	return value, mask
+}
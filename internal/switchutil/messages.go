@@ -0,0 +1,54 @@
+package switchutil
+
+// This file implements reading the messages of a controller connection and
+// building multipart replies
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// Largest body of a multipart reply, bigger replies are split
+const MaxReplyLen = 0xff00
+
+// Read one OpenFlow message
+func ReadMessage(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(hdr[2:]))
+	if length < len(hdr) {
+		return nil, fmt.Errorf("Invalid message length %d", length)
+	}
+	data := make([]byte, length)
+	copy(data, hdr)
+	if _, err := io.ReadFull(r, data[len(hdr):]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Returns the multipart replies carrying bodies, split so that no body is
+// bigger than MaxReplyLen. All the replies but the last have the
+// OFPMPF_REPLY_MORE flag
+func MultipartReplies(mpType uint16, bodies []util.Message) []*openflow13.MultipartReply {
+	rep := openflow13.NewMpReply(mpType)
+	replies := []*openflow13.MultipartReply{rep}
+	size := 0
+	for _, body := range bodies {
+		if size > 0 && size+int(body.Len()) > MaxReplyLen {
+			rep.Flags = openflow13.OFPMPF_REPLY_MORE
+			rep = openflow13.NewMpReply(mpType)
+			replies = append(replies, rep)
+			size = 0
+		}
+		rep.Body = append(rep.Body, body)
+		size += int(body.Len())
+	}
+	return replies
+}
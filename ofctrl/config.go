@@ -0,0 +1,631 @@
+package ofctrl
+
+// This file implements a declarative configuration of the tables, flows,
+// groups and meters of a switch, read from and written to YAML or JSON
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"gopkg.in/yaml.v3"
+)
+
+// Declarative configuration of a switch. The matches and actions are
+// written in the ovs-ofctl syntax, tables are referred to by name or id,
+// goto_table actions included. e.g. in YAML
+//
+//	tables:
+//	- {name: classifier, id: 0, miss: next}
+//	- {name: acl, id: 10, miss: drop}
+//	flows:
+//	- {table: classifier, priority: 100, match: "tcp,tp_dst=80", actions: "meter:1,goto_table:acl"}
+//	- {table: acl, priority: 10, match: "ip,nw_src=10.0.0.0/8", actions: "group:1"}
+//	groups:
+//	- id: 1
+//	  type: select
+//	  buckets:
+//	  - {weight: 50, actions: "output:1"}
+//	  - {weight: 50, actions: "output:2"}
+//	meters:
+//	- {id: 1, flags: [kbps, burst], bands: [{type: drop, rate: 1000, burst_size: 100}]}
+type FlowConfig struct {
+	Tables []TableConfig     `json:"tables,omitempty" yaml:"tables,omitempty"`
+	Flows  []FlowConfigEntry `json:"flows,omitempty" yaml:"flows,omitempty"`
+	Groups []GroupConfig     `json:"groups,omitempty" yaml:"groups,omitempty"`
+	Meters []MeterConfig     `json:"meters,omitempty" yaml:"meters,omitempty"`
+}
+
+// A table of the pipeline, see PipelineTable
+type TableConfig struct {
+	Name  string `json:"name" yaml:"name"`
+	Id    uint8  `json:"id" yaml:"id"`
+	Miss  string `json:"miss,omitempty" yaml:"miss,omitempty"` // drop (default), controller, next or normal
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+}
+
+// A flow
+type FlowConfigEntry struct {
+	Table       string   `json:"table" yaml:"table"` // Table name or id
+	Priority    uint16   `json:"priority" yaml:"priority"`
+	Cookie      uint64   `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	IdleTimeout uint16   `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	HardTimeout uint16   `json:"hard_timeout,omitempty" yaml:"hard_timeout,omitempty"`
+	Flags       []string `json:"flags,omitempty" yaml:"flags,omitempty"` // send_flow_rem, check_overlap, reset_counts, no_packet_counts, no_byte_counts
+	Match       string   `json:"match,omitempty" yaml:"match,omitempty"` // e.g. "tcp,nw_dst=10.0.0.0/24,tp_dst=80"
+	Actions     string   `json:"actions" yaml:"actions"`                 // e.g. "set_field:00:11:22:33:44:55->dl_dst,output:2"
+}
+
+// A group
+type GroupConfig struct {
+	Id      uint32         `json:"id" yaml:"id"`
+	Type    string         `json:"type" yaml:"type"` // all, select, indirect or ff
+	Buckets []BucketConfig `json:"buckets" yaml:"buckets"`
+}
+
+// A bucket of a group
+type BucketConfig struct {
+	Weight     uint16  `json:"weight,omitempty" yaml:"weight,omitempty"`
+	WatchPort  *uint32 `json:"watch_port,omitempty" yaml:"watch_port,omitempty"`
+	WatchGroup *uint32 `json:"watch_group,omitempty" yaml:"watch_group,omitempty"`
+	Actions    string  `json:"actions" yaml:"actions"` // e.g. "output:1"
+}
+
+// A meter
+type MeterConfig struct {
+	Id    uint32            `json:"id" yaml:"id"`
+	Flags []string          `json:"flags,omitempty" yaml:"flags,omitempty"` // kbps, pktps, burst, stats
+	Bands []MeterBandConfig `json:"bands" yaml:"bands"`
+}
+
+// A band of a meter
+type MeterBandConfig struct {
+	Type      string `json:"type" yaml:"type"` // drop or dscp_remark
+	Rate      uint32 `json:"rate" yaml:"rate"`
+	BurstSize uint32 `json:"burst_size,omitempty" yaml:"burst_size,omitempty"`
+	PrecLevel uint8  `json:"prec_level,omitempty" yaml:"prec_level,omitempty"`
+}
+
+var missActionNames = map[string]MissAction{
+	"":           MissDrop,
+	"drop":       MissDrop,
+	"controller": MissController,
+	"next":       MissNext,
+	"normal":     MissNormal,
+}
+
+var groupTypes = map[string]uint8{
+	"all":      openflow13.OFPGT_ALL,
+	"select":   openflow13.OFPGT_SELECT,
+	"indirect": openflow13.OFPGT_INDIRECT,
+	"ff":       openflow13.OFPGT_FF,
+}
+
+var flowFlags = map[string]uint16{
+	"send_flow_rem":    openflow13.FF_SEND_FLOW_REM,
+	"check_overlap":    openflow13.FF_CHECK_OVERLAP,
+	"reset_counts":     openflow13.FF_RESET_COUNTS,
+	"no_packet_counts": openflow13.FF_NO_PKT_COUNTS,
+	"no_byte_counts":   openflow13.FF_NO_BYT_COUNTS,
+}
+
+var meterFlags = map[string]uint16{
+	"kbps":  openflow13.OFPMF_KBPS,
+	"pktps": openflow13.OFPMF_PKTPS,
+	"burst": openflow13.OFPMF_BURST,
+	"stats": openflow13.OFPMF_STATS,
+}
+
+// Parse a configuration in YAML or JSON and validate it
+func ParseFlowConfig(data []byte) (*FlowConfig, error) {
+	cfg := new(FlowConfig)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Error decoding flow config. Err: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Read a configuration from a YAML or JSON file and validate it
+func LoadFlowConfig(path string) (*FlowConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseFlowConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Write the configuration to a file, in JSON if its extension is .json
+// and in YAML otherwise
+func (cfg *FlowConfig) Save(path string) error {
+	var data []byte
+	var err error
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		data, err = json.MarshalIndent(cfg, "", "  ")
+	} else {
+		data, err = yaml.Marshal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// The configuration built into the messages installing it
+type builtConfig struct {
+	pipeline *Pipeline // nil without tables
+	flows    []*Flow
+	groups   []*openflow13.GroupMod
+	meters   []*openflow13.MeterMod
+}
+
+// Check the configuration: the tables form a valid pipeline, the flows,
+// buckets and bands parse, and the flows only refer to tables, groups and
+// meters of the configuration
+func (cfg *FlowConfig) Validate() error {
+	_, err := cfg.build()
+	return err
+}
+
+// Returns the pipeline of the tables, nil if the configuration has none
+func (cfg *FlowConfig) Pipeline() (*Pipeline, error) {
+	if len(cfg.Tables) == 0 {
+		return nil, nil
+	}
+	p := NewPipeline()
+	for _, t := range cfg.Tables {
+		miss, ok := missActionNames[t.Miss]
+		if !ok {
+			return nil, fmt.Errorf("Table %s: invalid miss action %s", t.Name, t.Miss)
+		}
+		if _, err := p.AddTable(t.Name, t.Id, miss, t.Owner); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.Validate(0); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (cfg *FlowConfig) build() (*builtConfig, error) {
+	b := new(builtConfig)
+	var err error
+	if b.pipeline, err = cfg.Pipeline(); err != nil {
+		return nil, err
+	}
+
+	meterIds := make(map[uint32]bool)
+	for _, m := range cfg.Meters {
+		meterMod, err := m.build()
+		if err != nil {
+			return nil, err
+		}
+		if meterIds[m.Id] {
+			return nil, fmt.Errorf("Duplicate meter %d", m.Id)
+		}
+		meterIds[m.Id] = true
+		b.meters = append(b.meters, meterMod)
+	}
+
+	groupIds := make(map[uint32]bool)
+	for _, g := range cfg.Groups {
+		if groupIds[g.Id] {
+			return nil, fmt.Errorf("Duplicate group %d", g.Id)
+		}
+		groupIds[g.Id] = true
+	}
+	for _, g := range cfg.Groups {
+		groupMod, err := g.build(groupIds)
+		if err != nil {
+			return nil, err
+		}
+		b.groups = append(b.groups, groupMod)
+	}
+
+	keys := make(map[FlowKey]bool)
+	for i := range cfg.Flows {
+		flow, err := cfg.buildFlow(&cfg.Flows[i], b.pipeline, groupIds, meterIds)
+		if err != nil {
+			return nil, fmt.Errorf("Flow %d: %v", i, err)
+		}
+		if keys[flow.FlowKey()] {
+			return nil, fmt.Errorf("Flow %d: duplicate of a previous flow", i)
+		}
+		keys[flow.FlowKey()] = true
+		b.flows = append(b.flows, flow)
+	}
+	return b, nil
+}
+
+// Returns the id of a table given by name or id
+func (cfg *FlowConfig) tableId(table string) (uint8, error) {
+	for _, t := range cfg.Tables {
+		if t.Name == table {
+			return t.Id, nil
+		}
+	}
+	id, err := strconv.ParseUint(table, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("Unknown table %s", table)
+	}
+	return uint8(id), nil
+}
+
+var gotoTableRegexp = regexp.MustCompile(`goto_table:([^,)\s]+)`)
+
+func (cfg *FlowConfig) buildFlow(entry *FlowConfigEntry, p *Pipeline, groupIds, meterIds map[uint32]bool) (*Flow, error) {
+	tableId, err := cfg.tableId(entry.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the table names of the goto_table actions by their ids
+	var gotoErr error
+	actions := gotoTableRegexp.ReplaceAllStringFunc(entry.Actions, func(s string) string {
+		id, err := cfg.tableId(s[len("goto_table:"):])
+		if err != nil {
+			gotoErr = err
+		}
+		return fmt.Sprintf("goto_table:%d", id)
+	})
+	if gotoErr != nil {
+		return nil, gotoErr
+	}
+
+	parts := []string{fmt.Sprintf("table=%d", tableId), fmt.Sprintf("priority=%d", entry.Priority)}
+	if entry.Cookie != 0 {
+		parts = append(parts, fmt.Sprintf("cookie=0x%x", entry.Cookie))
+	}
+	if entry.IdleTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("idle_timeout=%d", entry.IdleTimeout))
+	}
+	if entry.HardTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("hard_timeout=%d", entry.HardTimeout))
+	}
+	if entry.Match != "" {
+		parts = append(parts, entry.Match)
+	}
+	flowMod, err := openflow13.ParseFlowMod(strings.Join(parts, ",") + ",actions=" + actions)
+	if err != nil {
+		return nil, err
+	}
+	if err := flowMod.Match.Validate(); err != nil {
+		return nil, err
+	}
+	for _, name := range entry.Flags {
+		flag, ok := flowFlags[name]
+		if !ok {
+			return nil, fmt.Errorf("Invalid flag %s", name)
+		}
+		flowMod.Flags |= flag
+	}
+
+	for _, instr := range flowMod.Instructions {
+		switch instr := instr.(type) {
+		case *openflow13.InstrMeter:
+			if !meterIds[instr.MeterId] {
+				return nil, fmt.Errorf("Unknown meter %d", instr.MeterId)
+			}
+		case *openflow13.InstrActions:
+			if err := checkGroupRefs(instr.Actions, groupIds); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	flow, err := NewFlowFromFlowMod(flowMod)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		if err := p.ValidateFlow(flow); err != nil {
+			return nil, err
+		}
+	}
+	return flow, nil
+}
+
+func checkGroupRefs(actions []openflow13.Action, groupIds map[uint32]bool) error {
+	for _, act := range actions {
+		if g, ok := act.(*openflow13.ActionGroup); ok && !groupIds[g.GroupId] {
+			return fmt.Errorf("Unknown group %d", g.GroupId)
+		}
+	}
+	return nil
+}
+
+func (g *GroupConfig) build(groupIds map[uint32]bool) (*openflow13.GroupMod, error) {
+	groupType, ok := groupTypes[g.Type]
+	if !ok {
+		return nil, fmt.Errorf("Group %d: invalid type %s", g.Id, g.Type)
+	}
+	if g.Id > openflow13.OFPG_MAX {
+		return nil, fmt.Errorf("Invalid group id %d", g.Id)
+	}
+
+	groupMod := openflow13.NewGroupMod()
+	groupMod.GroupId = g.Id
+	groupMod.Type = groupType
+	for i, b := range g.Buckets {
+		actions, err := openflow13.ParseActions(b.Actions)
+		if err != nil {
+			return nil, fmt.Errorf("Group %d bucket %d: %v", g.Id, i, err)
+		}
+		if err := checkGroupRefs(actions, groupIds); err != nil {
+			return nil, fmt.Errorf("Group %d bucket %d: %v", g.Id, i, err)
+		}
+		bkt := openflow13.NewBucket()
+		bkt.Weight = b.Weight
+		if b.WatchPort != nil {
+			bkt.WatchPort = *b.WatchPort
+		}
+		if b.WatchGroup != nil {
+			bkt.WatchGroup = *b.WatchGroup
+		}
+		for _, act := range actions {
+			bkt.AddAction(act)
+		}
+		groupMod.AddBucket(*bkt)
+	}
+	return groupMod, nil
+}
+
+func (m *MeterConfig) build() (*openflow13.MeterMod, error) {
+	if m.Id == 0 || m.Id > openflow13.OFPM_MAX {
+		return nil, fmt.Errorf("Invalid meter id %d", m.Id)
+	}
+	meterMod := openflow13.NewMeterMod()
+	meterMod.MeterId = m.Id
+	if len(m.Flags) != 0 {
+		meterMod.Flags = 0
+	}
+	for _, name := range m.Flags {
+		flag, ok := meterFlags[name]
+		if !ok {
+			return nil, fmt.Errorf("Meter %d: invalid flag %s", m.Id, name)
+		}
+		meterMod.Flags |= flag
+	}
+	if len(m.Bands) == 0 {
+		return nil, fmt.Errorf("Meter %d has no band", m.Id)
+	}
+	for _, b := range m.Bands {
+		if b.Rate == 0 {
+			return nil, fmt.Errorf("Meter %d: band without rate", m.Id)
+		}
+		switch b.Type {
+		case "drop":
+			meterMod.AddBand(openflow13.NewMeterBandDrop(b.Rate, b.BurstSize))
+		case "dscp_remark":
+			meterMod.AddBand(openflow13.NewMeterBandDSCP(b.Rate, b.BurstSize, b.PrecLevel))
+		default:
+			return nil, fmt.Errorf("Meter %d: invalid band type %s", m.Id, b.Type)
+		}
+	}
+	return meterMod, nil
+}
+
+// Install the configuration on a switch: the table-miss flows of its
+// tables, then its meters, groups and flows
+func (cfg *FlowConfig) Install(sw *OFSwitch) error {
+	b, err := cfg.build()
+	if err != nil {
+		return err
+	}
+	if b.pipeline != nil {
+		if err := b.pipeline.Install(sw); err != nil {
+			return err
+		}
+	}
+	return b.installEntries(sw)
+}
+
+// Install the meters, groups and flows
+func (b *builtConfig) installEntries(sw *OFSwitch) error {
+	for _, meterMod := range b.meters {
+		sw.InstallMeter(meterMod)
+	}
+	for _, groupMod := range b.groups {
+		sw.InstallGroup(groupMod)
+	}
+	for _, flow := range b.flows {
+		if err := sw.InstallFlow(flow); err != nil {
+			return err
+		}
+	}
+	log.Infof("Installed flow config of %d flows, %d groups and %d meters on switch %s",
+		len(b.flows), len(b.groups), len(b.meters), sw.DPID())
+	return nil
+}
+
+// Record the meters, groups and flows of the configuration in the desired
+// state of a switch, the first time it connects. Replay then installs them
+// with the rest of the state. The entries the state already has, e.g.
+// loaded from a snapshot, are kept.
+func (b *builtConfig) load(st *SwitchState) error {
+	flowMods := make([]*openflow13.FlowMod, 0, len(b.flows))
+	for _, flow := range b.flows {
+		flowMod, err := flow.BuildFlowMod()
+		if err != nil {
+			return err
+		}
+		flowMods = append(flowMods, flowMod)
+	}
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if st.configLoaded {
+		return nil
+	}
+	st.configLoaded = true
+	for _, meterMod := range b.meters {
+		if _, ok := st.meters[meterMod.MeterId]; !ok {
+			st.meters[meterMod.MeterId] = copyMeterMod(meterMod)
+		}
+	}
+	for _, groupMod := range b.groups {
+		if _, ok := st.groups[groupMod.GroupId]; !ok {
+			st.groups[groupMod.GroupId] = copyGroupMod(groupMod)
+		}
+	}
+	for i, flow := range b.flows {
+		key := flow.FlowKey()
+		if _, ok := st.flows[key]; !ok {
+			st.flows[key] = &storedFlow{flow: flow, flowMod: flowMods[i]}
+		}
+	}
+	log.Infof("Loaded flow config of %d flows, %d groups and %d meters for switch %s",
+		len(b.flows), len(b.groups), len(b.meters), st.dpid)
+	return nil
+}
+
+// Export the flows installed on a switch in the configuration format. The
+// tables are those of the controller pipeline, whose table-miss flows are
+// left out. The groups and meters are those of the desired state of the
+// switch, kept by the controller.
+func ExportFlowConfig(sw *OFSwitch) (*FlowConfig, error) {
+	cfg := new(FlowConfig)
+
+	var pipeline *Pipeline
+	if sw.ctrler != nil {
+		pipeline = sw.ctrler.pipeline
+	}
+	tableNames := make(map[uint8]string)
+	missKeys := make(map[FlowKey]bool)
+	if pipeline != nil {
+		for _, t := range pipeline.Tables() {
+			miss := ""
+			for name, m := range missActionNames {
+				if m == t.Miss && name != "" {
+					miss = name
+				}
+			}
+			cfg.Tables = append(cfg.Tables, TableConfig{Name: t.Name, Id: t.Id, Miss: miss, Owner: t.Owner})
+			tableNames[t.Id] = t.Name
+		}
+		for _, flow := range pipeline.MissFlows() {
+			missKeys[flow.FlowKey()] = true
+		}
+	}
+	tableName := func(id uint8) string {
+		if name, ok := tableNames[id]; ok {
+			return name
+		}
+		return strconv.Itoa(int(id))
+	}
+
+	sw.lock.Lock()
+	keys := make([]FlowKey, 0, len(sw.flows))
+	flows := make(map[FlowKey]*Flow, len(sw.flows))
+	for key, flow := range sw.flows {
+		keys = append(keys, key)
+		flows[key] = flow
+	}
+	sw.lock.Unlock()
+	SortFlowKeys(keys)
+
+	for _, key := range keys {
+		if missKeys[key] {
+			continue
+		}
+		flowMod, err := flows[key].buildFlowMod()
+		if err != nil {
+			return nil, err
+		}
+		actions := openflow13.FormatInstructions(flowMod.Instructions)
+		actions = gotoTableRegexp.ReplaceAllStringFunc(actions, func(s string) string {
+			id, _ := strconv.ParseUint(s[len("goto_table:"):], 10, 8)
+			return "goto_table:" + tableName(uint8(id))
+		})
+		cfg.Flows = append(cfg.Flows, FlowConfigEntry{
+			Table:       tableName(flowMod.TableId),
+			Priority:    flowMod.Priority,
+			Cookie:      flowMod.Cookie,
+			IdleTimeout: flowMod.IdleTimeout,
+			HardTimeout: flowMod.HardTimeout,
+			Flags:       flagNames(flowFlags, flowMod.Flags),
+			Match:       flowMod.Match.String(),
+			Actions:     actions,
+		})
+	}
+
+	if st := sw.state(); st != nil {
+		for _, groupMod := range st.Groups() {
+			cfg.Groups = append(cfg.Groups, exportGroup(groupMod))
+		}
+		for _, meterMod := range st.Meters() {
+			cfg.Meters = append(cfg.Meters, exportMeter(meterMod))
+		}
+	}
+	return cfg, nil
+}
+
+func exportGroup(groupMod *openflow13.GroupMod) GroupConfig {
+	g := GroupConfig{Id: groupMod.GroupId, Buckets: []BucketConfig{}}
+	for name, t := range groupTypes {
+		if t == groupMod.Type {
+			g.Type = name
+		}
+	}
+	for _, bkt := range groupMod.Buckets {
+		b := BucketConfig{Weight: bkt.Weight, Actions: openflow13.FormatActions(bkt.Actions)}
+		if bkt.WatchPort != openflow13.P_ANY {
+			port := bkt.WatchPort
+			b.WatchPort = &port
+		}
+		if bkt.WatchGroup != openflow13.OFPG_ANY {
+			group := bkt.WatchGroup
+			b.WatchGroup = &group
+		}
+		g.Buckets = append(g.Buckets, b)
+	}
+	return g
+}
+
+func exportMeter(meterMod *openflow13.MeterMod) MeterConfig {
+	m := MeterConfig{Id: meterMod.MeterId, Flags: flagNames(meterFlags, meterMod.Flags), Bands: []MeterBandConfig{}}
+	for _, band := range meterMod.Bands {
+		h := band.Header()
+		b := MeterBandConfig{Rate: h.Rate, BurstSize: h.BurstSize}
+		switch band := band.(type) {
+		case *openflow13.MeterBandDrop:
+			b.Type = "drop"
+		case *openflow13.MeterBandDSCP:
+			b.Type = "dscp_remark"
+			b.PrecLevel = band.PrecLevel
+		default:
+			continue
+		}
+		m.Bands = append(m.Bands, b)
+	}
+	return m
+}
+
+// Sorted names of the flags set, nil if none is
+func flagNames(names map[string]uint16, flags uint16) []string {
+	var set []string
+	for name, flag := range names {
+		if flags&flag != 0 {
+			set = append(set, name)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
@@ -0,0 +1,202 @@
+package ofctrl_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+const testFlowConfig = `
+tables:
+- {name: classifier, id: 0, miss: next}
+- {name: acl, id: 10, miss: drop}
+flows:
+- {table: classifier, priority: 100, match: "tcp,tp_dst=80", actions: "meter:1,goto_table:acl"}
+- {table: acl, priority: 10, cookie: 0x10, idle_timeout: 30, flags: [send_flow_rem, check_overlap],
+   match: "ip,nw_src=10.0.0.0/8", actions: "group:1"}
+groups:
+- id: 1
+  type: select
+  buckets:
+  - {weight: 50, actions: "output:1"}
+  - {weight: 50, watch_port: 2, actions: "output:2"}
+meters:
+- {id: 1, flags: [burst, kbps], bands: [{type: drop, rate: 1000, burst_size: 100}]}
+`
+
+func parseTestFlowConfig(t *testing.T) *ofctrl.FlowConfig {
+	cfg, err := ofctrl.ParseFlowConfig([]byte(testFlowConfig))
+	if err != nil {
+		t.Fatalf("Error parsing flow config. Err: %v", err)
+	}
+	return cfg
+}
+
+func TestParseFlowConfig(t *testing.T) {
+	cfg := parseTestFlowConfig(t)
+	if len(cfg.Tables) != 2 || len(cfg.Flows) != 2 || len(cfg.Groups) != 1 || len(cfg.Meters) != 1 {
+		t.Fatalf("Wrong flow config: %+v", cfg)
+	}
+	flow := cfg.Flows[1]
+	if flow.Table != "acl" || flow.Cookie != 0x10 || flow.IdleTimeout != 30 ||
+		!reflect.DeepEqual(flow.Flags, []string{"send_flow_rem", "check_overlap"}) {
+		t.Errorf("Wrong flow: %+v", flow)
+	}
+	if bkt := cfg.Groups[0].Buckets[1]; bkt.WatchPort == nil || *bkt.WatchPort != 2 || bkt.WatchGroup != nil {
+		t.Errorf("Wrong bucket: %+v", bkt)
+	}
+
+	// The same configuration in JSON
+	json := `{"flows": [{"table": "0", "priority": 1, "match": "arp", "actions": "NORMAL"}]}`
+	cfg, err := ofctrl.ParseFlowConfig([]byte(json))
+	if err != nil {
+		t.Fatalf("Error parsing JSON flow config. Err: %v", err)
+	}
+	if len(cfg.Flows) != 1 || cfg.Flows[0].Match != "arp" {
+		t.Errorf("Wrong JSON flow config: %+v", cfg)
+	}
+
+	if _, err := ofctrl.ParseFlowConfig([]byte("flows: [{table: 0, prio: 1}]")); err == nil {
+		t.Errorf("Flow config with an unknown field parsed")
+	}
+}
+
+func TestFlowConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		err  string // Part of the error
+	}{
+		{"invalid miss", "tables: [{name: t, id: 0, miss: flood}]", "invalid miss action flood"},
+		{"unknown table", "flows: [{table: acl, priority: 1, actions: drop}]", "Unknown table acl"},
+		{"unknown goto table", "tables: [{name: t, id: 0}]\nflows: [{table: t, priority: 1, actions: goto_table:acl}]",
+			"Unknown table acl"},
+		{"backward goto", "tables: [{name: a, id: 0}, {name: b, id: 1}]\nflows: [{table: b, priority: 1, actions: goto_table:a}]",
+			"backward goto"},
+		{"invalid match", "flows: [{table: 0, priority: 1, match: bogus=1, actions: drop}]", "bogus"},
+		{"invalid flag", "flows: [{table: 0, priority: 1, flags: [tcp], actions: drop}]", "Invalid flag tcp"},
+		{"duplicate flow", "flows: [{table: 0, priority: 1, actions: drop}, {table: 0, priority: 1, actions: NORMAL}]",
+			"duplicate of a previous flow"},
+		{"unknown group", "flows: [{table: 0, priority: 1, actions: group:2}]", "Unknown group 2"},
+		{"unknown meter", "flows: [{table: 0, priority: 1, actions: 'meter:2,output:1'}]", "Unknown meter 2"},
+		{"duplicate group", "groups: [{id: 1, type: all, buckets: []}, {id: 1, type: all, buckets: []}]",
+			"Duplicate group 1"},
+		{"invalid group type", "groups: [{id: 1, type: any, buckets: []}]", "invalid type any"},
+		{"bucket to unknown group", "groups: [{id: 1, type: all, buckets: [{actions: group:2}]}]", "Unknown group 2"},
+		{"meter without band", "meters: [{id: 1, bands: []}]", "has no band"},
+		{"meter 0", "meters: [{id: 0, bands: [{type: drop, rate: 1}]}]", "Invalid meter id 0"},
+		{"band without rate", "meters: [{id: 1, bands: [{type: drop}]}]", "band without rate"},
+		{"invalid band type", "meters: [{id: 1, bands: [{type: remark, rate: 1}]}]", "invalid band type remark"},
+		{"invalid meter flag", "meters: [{id: 1, flags: [fast], bands: [{type: drop, rate: 1}]}]", "invalid flag fast"},
+	}
+
+	for _, test := range tests {
+		_, err := ofctrl.ParseFlowConfig([]byte(test.cfg))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Wrong error of %s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// The configuration is installed when a switch first connects and restored
+// with the rest of its desired state when it reconnects, its meters and
+// groups being added once
+func TestFlowConfigInstall(t *testing.T) {
+	app := newTestApp()
+	ctrler := ofctrl.NewController(app)
+	if err := ctrler.SetFlowConfig(parseTestFlowConfig(t)); err != nil {
+		t.Fatalf("Error setting flow config. Err: %v", err)
+	}
+
+	expectConfig := func(fake *ofswitchtest.Switch) {
+		fake.ExpectFlow(t, "table=0,priority=0,actions=goto_table:10")
+		fake.ExpectFlow(t, "table=10,priority=0,actions=drop")
+		fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=meter:1,goto_table:10")
+		fake.ExpectFlow(t, "table=10,priority=10,cookie=0x10,ip,nw_src=10.0.0.0/8,actions=group:1")
+		fake.ExpectFlowCount(t, 4)
+		fake.ExpectGroup(t, 1)
+		fake.ExpectMeter(t, 1)
+	}
+	fake := ofswitchtest.NewSwitch(testDPID)
+	connectController(t, fake, ctrler, app.connected)
+	expectConfig(fake)
+	fake.Close()
+
+	// The switch kept its meter and group
+	fake = ofswitchtest.NewSwitch(testDPID)
+	fake.AddGroup(newGroup(1, 3))
+	fake.AddMeter(newMeter(1, 500))
+	connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+	expectConfig(fake)
+	waitFor(t, "group 1 to be modified", func() bool { return groupPort(fake, 1) == 1 })
+	waitFor(t, "meter 1 to be modified", func() bool { return meterRate(fake, 1) == 1000 })
+
+	groups, meters := receivedMods(fake)
+	if len(groups[openflow13.OFPGC_ADD]) != 1 || len(meters[openflow13.OFPMC_ADD]) != 1 {
+		t.Errorf("Wrong group and meter adds: got %v and %v, want one each",
+			groups[openflow13.OFPGC_ADD], meters[openflow13.OFPMC_ADD])
+	}
+}
+
+// The exported configuration is the installed one, flow flags included,
+// and can be saved and loaded again
+func TestExportFlowConfig(t *testing.T) {
+	app := newTestApp()
+	ctrler := ofctrl.NewController(app)
+	cfg := parseTestFlowConfig(t)
+	if err := ctrler.SetFlowConfig(cfg); err != nil {
+		t.Fatalf("Error setting flow config. Err: %v", err)
+	}
+	fake := ofswitchtest.NewSwitch(testDPID)
+	sw := connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+
+	exported, err := ofctrl.ExportFlowConfig(sw)
+	if err != nil {
+		t.Fatalf("Error exporting flow config. Err: %v", err)
+	}
+	if err := exported.Validate(); err != nil {
+		t.Fatalf("Error validating exported flow config. Err: %v", err)
+	}
+
+	want := *cfg
+	want.Tables = []ofctrl.TableConfig{{Name: "classifier", Id: 0, Miss: "next"}, {Name: "acl", Id: 10, Miss: "drop"}}
+	want.Flows = []ofctrl.FlowConfigEntry{
+		{Table: "classifier", Priority: 100, Match: "tcp,tp_dst=80", Actions: "meter:1,goto_table:acl"},
+		{Table: "acl", Priority: 10, Cookie: 0x10, IdleTimeout: 30, Flags: []string{"check_overlap", "send_flow_rem"},
+			Match: "ip,nw_src=10.0.0.0/8", Actions: "group:1"},
+	}
+	if !reflect.DeepEqual(exported.Tables, want.Tables) {
+		t.Errorf("Wrong tables:\n got %+v\nwant %+v", exported.Tables, want.Tables)
+	}
+	if !reflect.DeepEqual(exported.Flows, want.Flows) {
+		t.Errorf("Wrong flows:\n got %+v\nwant %+v", exported.Flows, want.Flows)
+	}
+	if !reflect.DeepEqual(exported.Groups, want.Groups) {
+		t.Errorf("Wrong groups:\n got %+v\nwant %+v", exported.Groups, want.Groups)
+	}
+	if !reflect.DeepEqual(exported.Meters, want.Meters) {
+		t.Errorf("Wrong meters:\n got %+v\nwant %+v", exported.Meters, want.Meters)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"flows.yaml", "flows.json"} {
+		path := filepath.Join(dir, name)
+		if err := exported.Save(path); err != nil {
+			t.Fatalf("Error saving %s. Err: %v", name, err)
+		}
+		loaded, err := ofctrl.LoadFlowConfig(path)
+		if err != nil {
+			t.Fatalf("Error loading %s. Err: %v", name, err)
+		}
+		if !reflect.DeepEqual(loaded, exported) {
+			t.Errorf("Wrong %s:\n got %+v\nwant %+v", name, loaded, exported)
+		}
+	}
+}
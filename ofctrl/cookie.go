@@ -0,0 +1,193 @@
+package ofctrl
+
+// This file implements allocating the flow cookies of the apps so that the
+// flows of an app can be told apart from the flows of the others
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Default number of high cookie bits identifying the namespace of an app
+const DefaultCookieNamespaceBits = 16
+
+// Hands out cookie namespaces to the apps. The high bits of a cookie
+// identify the namespace of the app owning the flow, the low bits identify
+// the flow within the namespace.
+type CookieAllocator struct {
+	lock   sync.Mutex
+	bits   uint                        // Number of namespace bits
+	spaces map[string]*CookieNamespace // Namespaces by app
+	ids    map[uint64]*CookieNamespace // Namespaces by id
+	nextId uint64                      // Next id tried by Reserve
+}
+
+// A namespace of cookies reserved by an app. Every flow cookie of the
+// namespace matches Cookie/Mask
+type CookieNamespace struct {
+	App    string // App owning the namespace
+	Id     uint64 // Namespace id, the high bits of the cookies
+	Cookie uint64 // Cookie of the namespace, i.e. the id in the high bits
+	Mask   uint64 // Mask of the namespace bits
+
+	lock sync.Mutex
+	next uint64 // Next flow id
+	max  uint64 // Highest flow id
+}
+
+// Create an allocator of namespaces identified by the nsBits high bits of
+// the cookies. A nsBits of 0 uses DefaultCookieNamespaceBits
+func NewCookieAllocator(nsBits uint) (*CookieAllocator, error) {
+	if nsBits == 0 {
+		nsBits = DefaultCookieNamespaceBits
+	}
+	if nsBits > 32 {
+		return nil, fmt.Errorf("Invalid cookie namespace bits %d, at most 32", nsBits)
+	}
+	return &CookieAllocator{
+		bits:   nsBits,
+		spaces: make(map[string]*CookieNamespace),
+		ids:    make(map[uint64]*CookieNamespace),
+		nextId: 1,
+	}, nil
+}
+
+// Reserve a namespace for an app with the next free id. Namespace 0 is
+// left to the flows not using the allocator. Reserving again returns the
+// namespace already reserved by the app
+func (a *CookieAllocator) Reserve(app string) (*CookieNamespace, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if ns, ok := a.spaces[app]; ok {
+		return ns, nil
+	}
+	// Search from the id after the last one reserved, wrapping to 1 so that
+	// released ids are reused
+	maxId := uint64(1)<<a.bits - 1
+	id := a.nextId
+	for i := uint64(0); i < maxId; i++ {
+		if id > maxId {
+			id = 1
+		}
+		if _, ok := a.ids[id]; !ok {
+			a.nextId = id + 1
+			return a.reserve(app, id), nil
+		}
+		id++
+	}
+	return nil, fmt.Errorf("No cookie namespace left for app %s", app)
+}
+
+// Reserve a given namespace id for an app, so that its cookies stay the
+// same across restarts of the controller
+func (a *CookieAllocator) ReserveId(app string, id uint64) (*CookieNamespace, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if id == 0 || id >= uint64(1)<<a.bits {
+		return nil, fmt.Errorf("Invalid cookie namespace %d for app %s", id, app)
+	}
+	if ns, ok := a.spaces[app]; ok {
+		if ns.Id == id {
+			return ns, nil
+		}
+		return nil, fmt.Errorf("App %s already has cookie namespace %d", app, ns.Id)
+	}
+	if ns, ok := a.ids[id]; ok {
+		return nil, fmt.Errorf("Cookie namespace %d of app %s already used by %s", id, app, ns.App)
+	}
+	return a.reserve(app, id), nil
+}
+
+// Must be called with the allocator lock held
+func (a *CookieAllocator) reserve(app string, id uint64) *CookieNamespace {
+	shift := 64 - a.bits
+	ns := &CookieNamespace{
+		App:    app,
+		Id:     id,
+		Cookie: id << shift,
+		Mask:   ^uint64(0) << shift,
+		next:   1,
+		max:    uint64(1)<<shift - 1,
+	}
+	a.spaces[app] = ns
+	a.ids[id] = ns
+	return ns
+}
+
+// Release the namespace of an app. Its flows must be deleted first
+func (a *CookieAllocator) Release(app string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if ns, ok := a.spaces[app]; ok {
+		delete(a.spaces, app)
+		delete(a.ids, ns.Id)
+	}
+}
+
+// Returns the namespace of an app, nil if it has none
+func (a *CookieAllocator) Namespace(app string) *CookieNamespace {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.spaces[app]
+}
+
+// Returns the namespace a cookie belongs to, nil if none
+func (a *CookieAllocator) Owner(cookie uint64) *CookieNamespace {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.ids[cookie>>(64-a.bits)]
+}
+
+// Returns a cookie of the namespace no other flow of the namespace uses
+func (ns *CookieNamespace) NewCookie() (uint64, error) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	if ns.next > ns.max {
+		return 0, fmt.Errorf("Cookie namespace of app %s is exhausted", ns.App)
+	}
+	cookie := ns.Cookie | ns.next
+	ns.next++
+	return cookie, nil
+}
+
+// Returns true if a cookie belongs to the namespace
+func (ns *CookieNamespace) Contains(cookie uint64) bool {
+	return cookie&ns.Mask == ns.Cookie
+}
+
+// Create a flow with a new cookie of the namespace as FlowID
+func (ns *CookieNamespace) NewFlow(tableId uint8) (*Flow, error) {
+	cookie, err := ns.NewCookie()
+	if err != nil {
+		return nil, err
+	}
+	flow := NewFlow(tableId)
+	flow.FlowID = cookie
+	return flow, nil
+}
+
+// Delete all the flows of the namespace from a switch
+func (ns *CookieNamespace) DeleteFlows(sw *OFSwitch) {
+	sw.DeleteFlowsByCookie(ns.Cookie, ns.Mask)
+}
+
+// Dump the flows of the namespace on a switch
+func (ns *CookieNamespace) DumpFlows(sw *OFSwitch) ([]*openflow13.FlowStats, error) {
+	flowReq := openflow13.NewFlowStatsRequest()
+	flowReq.TableId = openflow13.OFPTT_ALL
+	flowReq.Cookie = ns.Cookie
+	flowReq.CookieMask = ns.Mask
+	return sw.DumpFlowStats(flowReq)
+}
+
+// Returns the PacketIn match of the PacketIns sent by the flows of the
+// namespace
+func (ns *CookieNamespace) PacketInMatch() PacketInMatch {
+	return PacketInMatch{Cookie: ns.Cookie, CookieMask: ns.Mask}
+}
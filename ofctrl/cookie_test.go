@@ -0,0 +1,34 @@
+package ofctrl
+
+import (
+	"testing"
+)
+
+// Released namespace ids are reused once the search reaches the last id
+func TestCookieReserveWraps(t *testing.T) {
+	a, err := NewCookieAllocator(2)
+	if err != nil {
+		t.Fatalf("Error creating allocator. Err: %v", err)
+	}
+	for i, app := range []string{"a", "b", "c"} {
+		ns, err := a.Reserve(app)
+		if err != nil {
+			t.Fatalf("Error reserving %s. Err: %v", app, err)
+		}
+		if ns.Id != uint64(i+1) {
+			t.Errorf("Wrong namespace of %s: got %d, want %d", app, ns.Id, i+1)
+		}
+	}
+	if _, err := a.Reserve("d"); err == nil {
+		t.Errorf("Reserved a namespace with all ids used")
+	}
+
+	a.Release("a")
+	ns, err := a.Reserve("d")
+	if err != nil {
+		t.Fatalf("Error reserving a released id. Err: %v", err)
+	}
+	if ns.Id != 1 {
+		t.Errorf("Wrong namespace of d: got %d, want 1", ns.Id)
+	}
+}
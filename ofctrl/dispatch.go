@@ -0,0 +1,220 @@
+package ofctrl
+
+// This file implements dispatching the PacketIns of the switches to the
+// handlers registered by the apps, on a pool of workers per switch
+
+import (
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// Handles a PacketIn selected by a PacketInMatch
+type PacketInHandler func(sw *OFSwitch, pkt *openflow13.PacketIn)
+
+// Selects PacketIns. Unset fields match any PacketIn
+type PacketInMatch struct {
+	TableId    *uint8 // Table the packet was sent from
+	Cookie     uint64 // Cookie of the flow that sent the packet
+	CookieMask uint64 // Cookie bits to compare, 0 matches any cookie
+	Reason     *uint8 // One of openflow13.R_*
+	Ethertype  uint16 // Ethertype of the packet, 0 matches any
+	IpProto    uint8  // IP protocol of the packet, 0 matches any
+}
+
+// Default size of the worker pool and queue of each switch
+const (
+	DefaultPacketInWorkers = 4
+	DefaultPacketInQueue   = 256
+)
+
+type packetInRoute struct {
+	id      int
+	match   PacketInMatch
+	handler PacketInHandler
+}
+
+type packetInJob struct {
+	sw  *OFSwitch
+	pkt *openflow13.PacketIn
+}
+
+// Workers of one switch
+type packetInPool struct {
+	jobs chan packetInJob
+	wg   sync.WaitGroup
+}
+
+// Dispatches the PacketIns of the switches to handlers. Handlers are tried
+// in the order they were registered and the first one whose match selects
+// a PacketIn gets it. PacketIns no handler selects go to the default
+// handler, which is the consumer's PacketRcvd when the dispatcher is set
+// on a controller.
+//
+// Handlers run on a bounded pool of workers per switch so that a slow
+// handler doesn't hold up the messages of the switch. PacketIns arriving
+// while the queue of the switch is full are dropped.
+type PacketInDispatcher struct {
+	dropped    uint64 // first for 64-bit alignment of atomic accesses
+	lock       sync.RWMutex
+	routes     []*packetInRoute
+	nextId     int
+	defHandler PacketInHandler
+	workers    int
+	queueLen   int
+	pools      map[*OFSwitch]*packetInPool
+}
+
+// Create a dispatcher running workers handlers per switch, with up to
+// queueLen PacketIns waiting per switch. Zero values use the defaults
+func NewPacketInDispatcher(workers, queueLen int) *PacketInDispatcher {
+	if workers <= 0 {
+		workers = DefaultPacketInWorkers
+	}
+	if queueLen <= 0 {
+		queueLen = DefaultPacketInQueue
+	}
+	return &PacketInDispatcher{
+		workers:  workers,
+		queueLen: queueLen,
+		pools:    make(map[*OFSwitch]*packetInPool),
+	}
+}
+
+// Register a handler for the PacketIns selected by match. Returns an id to
+// remove the handler
+func (d *PacketInDispatcher) Handle(match PacketInMatch, handler PacketInHandler) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.nextId++
+	d.routes = append(d.routes, &packetInRoute{id: d.nextId, match: match, handler: handler})
+	return d.nextId
+}
+
+// Remove a handler registered by Handle
+func (d *PacketInDispatcher) Remove(id int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, route := range d.routes {
+		if route.id == id {
+			d.routes = append(d.routes[:i:i], d.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Set the handler of the PacketIns no registered handler selects
+func (d *PacketInDispatcher) SetDefaultHandler(handler PacketInHandler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.defHandler = handler
+}
+
+// Number of PacketIns dropped because the queue of their switch was full
+func (d *PacketInDispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+// Queue a PacketIn for the workers of its switch
+func (d *PacketInDispatcher) Dispatch(sw *OFSwitch, pkt *openflow13.PacketIn) {
+	// The lock is held while queueing so that the queue isn't closed
+	// meanwhile, the send never blocks
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	pool, ok := d.pools[sw]
+	if !ok {
+		pool = &packetInPool{jobs: make(chan packetInJob, d.queueLen)}
+		for i := 0; i < d.workers; i++ {
+			pool.wg.Add(1)
+			go d.worker(pool)
+		}
+		d.pools[sw] = pool
+	}
+
+	select {
+	case pool.jobs <- packetInJob{sw: sw, pkt: pkt}:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+		log.Warnf("PacketIn queue of switch %s is full, dropping packet", sw.DPID())
+	}
+}
+
+// Stop the workers of a switch once they handled the queued PacketIns
+func (d *PacketInDispatcher) RemoveSwitch(sw *OFSwitch) {
+	d.lock.Lock()
+	pool, ok := d.pools[sw]
+	if ok {
+		delete(d.pools, sw)
+		close(pool.jobs)
+	}
+	d.lock.Unlock()
+
+	if ok {
+		pool.wg.Wait()
+	}
+}
+
+func (d *PacketInDispatcher) worker(pool *packetInPool) {
+	defer pool.wg.Done()
+	for job := range pool.jobs {
+		if handler := d.handler(job.pkt); handler != nil {
+			handler(job.sw, job.pkt)
+		}
+	}
+}
+
+// Returns the handler of a PacketIn
+func (d *PacketInDispatcher) handler(pkt *openflow13.PacketIn) PacketInHandler {
+	ethertype, ipProto := packetInProtocols(pkt)
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, route := range d.routes {
+		if route.match.selects(pkt, ethertype, ipProto) {
+			return route.handler
+		}
+	}
+	return d.defHandler
+}
+
+func (m *PacketInMatch) selects(pkt *openflow13.PacketIn, ethertype uint16, ipProto uint8) bool {
+	if m.TableId != nil && *m.TableId != pkt.TableId {
+		return false
+	}
+	if m.Reason != nil && *m.Reason != pkt.Reason {
+		return false
+	}
+	if pkt.Cookie&m.CookieMask != m.Cookie&m.CookieMask {
+		return false
+	}
+	if m.Ethertype != 0 && m.Ethertype != ethertype {
+		return false
+	}
+	if m.IpProto != 0 && m.IpProto != ipProto {
+		return false
+	}
+	return true
+}
+
+// Ethertype and IP protocol of the packet of a PacketIn, the IP protocol
+// is 0 for non IP packets
+func packetInProtocols(pkt *openflow13.PacketIn) (uint16, uint8) {
+	eth := &pkt.Data
+	switch data := eth.Data.(type) {
+	case *protocol.IPv4:
+		return eth.Ethertype, data.Protocol
+	case *util.Buffer:
+		// IPv6 packets aren't decoded, the next header is at offset 6
+		if b := data.Bytes(); eth.Ethertype == protocol.IPv6_MSG && len(b) > 6 {
+			return eth.Ethertype, b[6]
+		}
+	}
+	return eth.Ethertype, 0
+}
@@ -0,0 +1,102 @@
+package ofctrl_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+// App handing the PacketIns no handler selects over a channel
+type packetApp struct {
+	*testApp
+	packets chan *openflow13.PacketIn
+}
+
+func (app *packetApp) PacketRcvd(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn) {
+	app.packets <- pkt
+}
+
+func newPacket(ethertype uint16) *protocol.Ethernet {
+	eth := protocol.NewEthernet()
+	eth.HWSrc = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	eth.HWDst = net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	eth.Ethertype = ethertype
+	return eth
+}
+
+func newIPPacket(ipProto uint8) *protocol.Ethernet {
+	eth := newPacket(0x0800)
+	ip := protocol.NewIPv4()
+	ip.NWSrc = net.ParseIP("10.0.0.1").To4()
+	ip.NWDst = net.ParseIP("10.0.0.2").To4()
+	ip.Protocol = ipProto
+	ip.TTL = 64
+	if ipProto == 6 {
+		ip.Data = protocol.NewTCP()
+	} else {
+		ip.Data = protocol.NewUDP()
+	}
+	ip.Length = ip.Len()
+	eth.Data = ip
+	return eth
+}
+
+// PacketIns go to the first handler selecting them, the others to
+// PacketRcvd
+func TestPacketInDispatcher(t *testing.T) {
+	app := &packetApp{testApp: newTestApp(), packets: make(chan *openflow13.PacketIn, 4)}
+	ctrler := ofctrl.NewController(app)
+
+	arps := make(chan *openflow13.PacketIn, 4)
+	tcps := make(chan *openflow13.PacketIn, 4)
+	dispatcher := ofctrl.NewPacketInDispatcher(2, 16)
+	dispatcher.Handle(ofctrl.PacketInMatch{Ethertype: 0x0806}, func(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn) {
+		arps <- pkt
+	})
+	dispatcher.Handle(ofctrl.PacketInMatch{Ethertype: 0x0800, IpProto: 6}, func(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn) {
+		tcps <- pkt
+	})
+	ctrler.SetPacketInDispatcher(dispatcher)
+
+	fake := ofswitchtest.NewSwitch(testDPID)
+	connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+
+	arp := newPacket(0x0806)
+	arp.Data, _ = protocol.NewARP(protocol.Type_Request)
+	for _, eth := range []*protocol.Ethernet{arp, newIPPacket(6), newIPPacket(17)} {
+		if err := fake.SendPacketIn(1, eth); err != nil {
+			t.Fatalf("Error sending packet in. Err: %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		packets   chan *openflow13.PacketIn
+		ethertype uint16
+	}{
+		{"ARP handler", arps, 0x0806},
+		{"TCP handler", tcps, 0x0800},
+		{"PacketRcvd", app.packets, 0x0800},
+	} {
+		select {
+		case pkt := <-test.packets:
+			if pkt.Data.Ethertype != test.ethertype {
+				t.Errorf("Wrong packet for %s: got ethertype 0x%04x, want 0x%04x", test.name, pkt.Data.Ethertype, test.ethertype)
+			}
+			if ip, ok := pkt.Data.Data.(*protocol.IPv4); ok && test.name == "PacketRcvd" && ip.Protocol != 17 {
+				t.Errorf("Wrong packet for %s: got ip proto %d, want 17", test.name, ip.Protocol)
+			}
+		case <-time.After(fake.Timeout):
+			t.Fatalf("No packet for %s", test.name)
+		}
+	}
+	if dispatcher.Dropped() != 0 {
+		t.Errorf("Wrong dropped packets: got %d, want 0", dispatcher.Dropped())
+	}
+}
@@ -0,0 +1,233 @@
+package ofctrl
+
+// This file implements the event bus delivering the events of the switches
+// to any number of subscribers
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Subscribers implement the listener interfaces of the events they want,
+// any ConsumerInterface implements all of the first six.
+type SwitchConnectedListener interface {
+	SwitchConnected(sw *OFSwitch)
+}
+
+type SwitchDisconnectedListener interface {
+	SwitchDisconnected(sw *OFSwitch)
+}
+
+type PacketRcvdListener interface {
+	PacketRcvd(sw *OFSwitch, pkt *openflow13.PacketIn)
+}
+
+type PortStatusListener interface {
+	PortStatusChange(sw *OFSwitch, portStatus *openflow13.PortStatus)
+}
+
+type FlowRemovedListener interface {
+	FlowRemoved(sw *OFSwitch, flowRemoved *openflow13.FlowRemoved)
+}
+
+// Receives the multipart replies to requests not sent by the library
+type MultipartReplyListener interface {
+	MultipartReply(sw *OFSwitch, rep *openflow13.MultipartReply)
+}
+
+type ErrorListener interface {
+	ErrorRcvd(sw *OFSwitch, errMsg *openflow13.ErrorMsg)
+}
+
+// Receives the role replies of the switches, i.e. the role the controller
+// has on the switch after a role request
+type RoleChangeListener interface {
+	RoleChanged(sw *OFSwitch, role *openflow13.RoleRequest)
+}
+
+type subscriber struct {
+	id       int
+	listener interface{}
+}
+
+// Events of one switch waiting for delivery
+type eventQueue struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	events []func(listener interface{})
+	closed bool
+}
+
+// Delivers the events of the switches to the subscribers. The events of a
+// switch are delivered in the order the switch sent them, by a goroutine per
+// switch, so that a slow subscriber doesn't hold up the receive loop of the
+// switch. Events of different switches are delivered concurrently.
+type EventBus struct {
+	lock   sync.RWMutex
+	subs   []*subscriber
+	nextId int
+	queues map[*OFSwitch]*eventQueue
+}
+
+// Create a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{queues: make(map[*OFSwitch]*eventQueue)}
+}
+
+// Subscribe a listener implementing one or more of the listener
+// interfaces. Returns an id to unsubscribe
+func (b *EventBus) Subscribe(listener interface{}) (int, error) {
+	switch listener.(type) {
+	case SwitchConnectedListener, SwitchDisconnectedListener, PacketRcvdListener,
+		PortStatusListener, FlowRemovedListener, MultipartReplyListener,
+		ErrorListener, RoleChangeListener:
+	default:
+		return 0, errors.New("Listener implements no listener interface")
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nextId++
+	b.subs = append(b.subs, &subscriber{id: b.nextId, listener: listener})
+	return b.nextId, nil
+}
+
+// Unsubscribe a listener. Events already being delivered may still reach
+// it
+func (b *EventBus) Unsubscribe(id int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, sub := range b.subs {
+		if sub.id == id {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *EventBus) switchConnected(sw *OFSwitch) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(SwitchConnectedListener); ok {
+			l.SwitchConnected(sw)
+		}
+	})
+}
+
+// The last event of a switch, its queue is released once delivered
+func (b *EventBus) switchDisconnected(sw *OFSwitch) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(SwitchDisconnectedListener); ok {
+			l.SwitchDisconnected(sw)
+		}
+	})
+
+	b.lock.Lock()
+	q, ok := b.queues[sw]
+	delete(b.queues, sw)
+	b.lock.Unlock()
+	if ok {
+		q.close()
+	}
+}
+
+func (b *EventBus) packetRcvd(sw *OFSwitch, pkt *openflow13.PacketIn) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(PacketRcvdListener); ok {
+			l.PacketRcvd(sw, pkt)
+		}
+	})
+}
+
+func (b *EventBus) portStatusChange(sw *OFSwitch, portStatus *openflow13.PortStatus) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(PortStatusListener); ok {
+			l.PortStatusChange(sw, portStatus)
+		}
+	})
+}
+
+func (b *EventBus) flowRemoved(sw *OFSwitch, flowRemoved *openflow13.FlowRemoved) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(FlowRemovedListener); ok {
+			l.FlowRemoved(sw, flowRemoved)
+		}
+	})
+}
+
+func (b *EventBus) multipartReply(sw *OFSwitch, rep *openflow13.MultipartReply) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(MultipartReplyListener); ok {
+			l.MultipartReply(sw, rep)
+		}
+	})
+}
+
+func (b *EventBus) errorRcvd(sw *OFSwitch, errMsg *openflow13.ErrorMsg) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(ErrorListener); ok {
+			l.ErrorRcvd(sw, errMsg)
+		}
+	})
+}
+
+func (b *EventBus) roleChanged(sw *OFSwitch, role *openflow13.RoleRequest) {
+	b.publish(sw, func(l interface{}) {
+		if l, ok := l.(RoleChangeListener); ok {
+			l.RoleChanged(sw, role)
+		}
+	})
+}
+
+// Queue an event of a switch, starting the delivery goroutine of the
+// switch on its first event
+func (b *EventBus) publish(sw *OFSwitch, event func(listener interface{})) {
+	b.lock.Lock()
+	q, ok := b.queues[sw]
+	if !ok {
+		q = &eventQueue{}
+		q.cond = sync.NewCond(&q.lock)
+		b.queues[sw] = q
+		go b.deliver(q)
+	}
+	b.lock.Unlock()
+
+	q.lock.Lock()
+	q.events = append(q.events, event)
+	q.lock.Unlock()
+	q.cond.Signal()
+}
+
+// Deliver the events of a queue to the subscribers until it is closed and
+// empty
+func (b *EventBus) deliver(q *eventQueue) {
+	for {
+		q.lock.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			q.lock.Unlock()
+			return
+		}
+		event := q.events[0]
+		q.events[0] = nil
+		q.events = q.events[1:]
+		q.lock.Unlock()
+
+		b.lock.RLock()
+		subs := b.subs
+		b.lock.RUnlock()
+		for _, sub := range subs {
+			event(sub.listener)
+		}
+	}
+}
+
+func (q *eventQueue) close() {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.cond.Signal()
+}
@@ -0,0 +1,93 @@
+package ofctrl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Listener recording the events of each switch in the order delivered
+type orderListener struct {
+	lock   sync.Mutex
+	events map[*OFSwitch][]uint32
+	done   chan *OFSwitch
+}
+
+func (l *orderListener) PacketRcvd(sw *OFSwitch, pkt *openflow13.PacketIn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events[sw] = append(l.events[sw], pkt.BufferId)
+}
+
+func (l *orderListener) SwitchDisconnected(sw *OFSwitch) {
+	l.done <- sw
+}
+
+// The events of a switch are delivered in order, those of different
+// switches independently
+func TestEventBusOrder(t *testing.T) {
+	const numEvents = 1000
+	bus := NewEventBus()
+	listener := &orderListener{events: make(map[*OFSwitch][]uint32), done: make(chan *OFSwitch, 2)}
+	if _, err := bus.Subscribe(listener); err != nil {
+		t.Fatalf("Error subscribing. Err: %v", err)
+	}
+
+	switches := []*OFSwitch{{}, {}}
+	for i := 0; i < numEvents; i++ {
+		for _, sw := range switches {
+			pkt := openflow13.NewPacketIn()
+			pkt.BufferId = uint32(i)
+			bus.packetRcvd(sw, pkt)
+		}
+	}
+	for _, sw := range switches {
+		bus.switchDisconnected(sw)
+	}
+	for range switches {
+		select {
+		case <-listener.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Events are not delivered")
+		}
+	}
+
+	listener.lock.Lock()
+	defer listener.lock.Unlock()
+	for n, sw := range switches {
+		events := listener.events[sw]
+		if len(events) != numEvents {
+			t.Fatalf("Wrong number of events of switch %d: got %d, want %d", n, len(events), numEvents)
+		}
+		for i, id := range events {
+			if id != uint32(i) {
+				t.Fatalf("Wrong event %d of switch %d: got %d", i, n, id)
+			}
+		}
+	}
+}
+
+// Unsubscribed listeners get no more events
+func TestEventBusUnsubscribe(t *testing.T) {
+	bus := NewEventBus()
+	listener := &orderListener{events: make(map[*OFSwitch][]uint32), done: make(chan *OFSwitch, 1)}
+	id, err := bus.Subscribe(listener)
+	if err != nil {
+		t.Fatalf("Error subscribing. Err: %v", err)
+	}
+	if _, err := bus.Subscribe(struct{}{}); err == nil {
+		t.Errorf("Listener without events is subscribed")
+	}
+	bus.Unsubscribe(id)
+
+	sw := &OFSwitch{}
+	bus.packetRcvd(sw, openflow13.NewPacketIn())
+	bus.switchDisconnected(sw)
+	select {
+	case <-listener.done:
+		t.Errorf("Unsubscribed listener got an event")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -1,7 +1,6 @@
 package ofctrl
 
 import (
-	"encoding/json"
 	"net"
 	"sync"
 
@@ -12,56 +11,129 @@ import (
 
 const IP_PROTO_TCP = 6
 const IP_PROTO_UDP = 17
+const IP_PROTO_SCTP = 132
 
 type FlowMatch struct {
-	Priority     uint16            // Priority of the flow
-	InputPort    uint32            // Input port number
-	MacDa        *net.HardwareAddr // Mac dest
-	MacDaMask    *net.HardwareAddr // Mac dest mask
-	MacSa        *net.HardwareAddr // Mac source
-	MacSaMask    *net.HardwareAddr // Mac source mask
-	Ethertype    uint16            // Ethertype
-	VlanId       uint16            // vlan id
-	ArpOper      uint16            // ARP Oper type
-	IpSa         *net.IP           // IPv4 source addr
-	IpSaMask     *net.IP           // IPv4 source mask
-	IpDa         *net.IP           // IPv4 dest addr
-	IpDaMask     *net.IP           // IPv4 dest mask
-	Ipv6Sa       *net.IP           // IPv6 source addr
-	Ipv6SaMask   *net.IP           // IPv6 source mask
-	Ipv6Da       *net.IP           // IPv6 dest addr
-	Ipv6DaMask   *net.IP           // IPv6 dest mask
-	IpProto      uint8             // IP protocol
-	IpDscp       uint8             // DSCP/TOS field
-	TcpSrcPort   uint16            // TCP source port
-	TcpDstPort   uint16            // TCP dest port
-	UdpSrcPort   uint16            // UDP source port
-	UdpDstPort   uint16            // UDP dest port
-	Metadata     *uint64           // OVS metadata
-	MetadataMask *uint64           // Metadata mask
-	TunnelId     uint64            // Vxlan Tunnel id i.e. VNI
-	TcpFlags     *uint16           // TCP flags
-	TcpFlagsMask *uint16           // Mask for TCP flags
+	Priority          uint16            // Priority of the flow
+	InputPort         uint32            // Input port number
+	InPhyPort         uint32            // Physical input port number
+	MacDa             *net.HardwareAddr // Mac dest
+	MacDaMask         *net.HardwareAddr // Mac dest mask
+	MacSa             *net.HardwareAddr // Mac source
+	MacSaMask         *net.HardwareAddr // Mac source mask
+	Ethertype         uint16            // Ethertype
+	VlanId            uint16            // vlan id
+	VlanIdMask        *uint16           // vlan id mask
+	VlanPcp           *uint8            // vlan priority
+	ArpOper           uint16            // ARP Oper type
+	ArpSpa            *net.IP           // ARP source IPv4 addr
+	ArpSpaMask        *net.IP           // ARP source IPv4 mask
+	ArpTpa            *net.IP           // ARP target IPv4 addr
+	ArpTpaMask        *net.IP           // ARP target IPv4 mask
+	ArpSha            *net.HardwareAddr // ARP source hardware addr
+	ArpShaMask        *net.HardwareAddr // ARP source hardware mask
+	ArpTha            *net.HardwareAddr // ARP target hardware addr
+	ArpThaMask        *net.HardwareAddr // ARP target hardware mask
+	IpSa              *net.IP           // IPv4 source addr
+	IpSaMask          *net.IP           // IPv4 source mask
+	IpDa              *net.IP           // IPv4 dest addr
+	IpDaMask          *net.IP           // IPv4 dest mask
+	Ipv6Sa            *net.IP           // IPv6 source addr
+	Ipv6SaMask        *net.IP           // IPv6 source mask
+	Ipv6Da            *net.IP           // IPv6 dest addr
+	Ipv6DaMask        *net.IP           // IPv6 dest mask
+	Ipv6FlowLabel     *uint32           // IPv6 flow label
+	Ipv6FlowLabelMask *uint32           // IPv6 flow label mask
+	Ipv6ExtHdr        *uint16           // IPv6 extension header flags (OFPIEH_*)
+	Ipv6ExtHdrMask    *uint16           // IPv6 extension header flags mask
+	IpProto           uint8             // IP protocol
+	IpDscp            uint8             // DSCP/TOS field
+	IpEcn             *uint8            // ECN field
+	TcpSrcPort        uint16            // TCP source port
+	TcpDstPort        uint16            // TCP dest port
+	UdpSrcPort        uint16            // UDP source port
+	UdpDstPort        uint16            // UDP dest port
+	SctpSrcPort       uint16            // SCTP source port
+	SctpDstPort       uint16            // SCTP dest port
+	IcmpType          *uint8            // ICMPv4 type
+	IcmpCode          *uint8            // ICMPv4 code
+	Icmpv6Type        *uint8            // ICMPv6 type
+	Icmpv6Code        *uint8            // ICMPv6 code
+	NdTarget          *net.IP           // IPv6 ND target addr
+	NdSll             *net.HardwareAddr // IPv6 ND source link-layer addr
+	NdTll             *net.HardwareAddr // IPv6 ND target link-layer addr
+	MplsLabel         *uint32           // MPLS label
+	MplsTc            *uint8            // MPLS traffic class
+	MplsBos           *uint8            // MPLS bottom of stack bit
+	PbbIsid           *uint32           // PBB service instance id
+	PbbIsidMask       *uint32           // PBB service instance id mask
+	Metadata          *uint64           // OVS metadata
+	MetadataMask      *uint64           // Metadata mask
+	TunnelId          uint64            // Vxlan Tunnel id i.e. VNI
+	TunnelIdMask      *uint64           // Tunnel id mask
+	TcpFlags          *uint16           // TCP flags
+	TcpFlagsMask      *uint16           // Mask for TCP flags
 }
 
+// Type of a flow action
+type ActionType string
+
+const (
+	ActionSetVlan     ActionType = "setVlan"      // Push a vlan tag and set its id
+	ActionPopVlan     ActionType = "popVlan"      // Pop the outer vlan tag
+	ActionSetMacDa    ActionType = "setMacDa"     // Set the mac dest
+	ActionSetMacSa    ActionType = "setMacSa"     // Set the mac source
+	ActionSetTunnelId ActionType = "setTunnelId"  // Set the tunnel id
+	ActionSetIPSa     ActionType = "setIPSa"      // Set the IPv4 source
+	ActionSetIPDa     ActionType = "setIPDa"      // Set the IPv4 dest
+	ActionSetDscp     ActionType = "setDscp"      // Set the DSCP field
+	ActionSetTCPSrc   ActionType = "setTCPSrc"    // Set the TCP source port
+	ActionSetTCPDst   ActionType = "setTCPDst"    // Set the TCP dest port
+	ActionSetUDPSrc   ActionType = "setUDPSrc"    // Set the UDP source port
+	ActionSetUDPDst   ActionType = "setUDPDst"    // Set the UDP dest port
+	ActionSetField    ActionType = "setField"     // Set any match field
+	ActionApply       ActionType = "applyAction"  // Apply a raw openflow action
+	ActionWrite       ActionType = "writeAction"  // Write a raw openflow action to the action set
+	ActionClear       ActionType = "clearActions" // Clear the action set
+	ActionSetMetadata ActionType = "setMetadata"  // Write the metadata
+	ActionMeter       ActionType = "meter"        // Send the packets through a meter
+)
+
+// Type of a flow output
+type OutputType string
+
+const (
+	OutputController OutputType = "gotoCtrl" // Send to the controller
+	OutputGotoTable  OutputType = "gotoTbl"  // Continue in another table
+	OutputDrop       OutputType = "drop"     // Drop the packets
+	OutputFlood      OutputType = "flood"    // Flood on all ports
+	OutputNormal     OutputType = "normal"   // Switch normal processing
+	OutputPort       OutputType = "outPort"  // Send out of a port
+	OutputGroup      OutputType = "group"    // Process by a group
+)
+
 type FlowAction struct {
-	actionType   string           // Type of action "setVlan", "setMetadata", ..etc
-	vlanId       uint16           // Vlan Id in case of "setVlan"
-	macAddr      net.HardwareAddr // Mac address to set
-	ipAddr       net.IP           // IP address to be set
-	l4Port       uint16           // Transport port to be set
-	tunnelId     uint64           // Tunnel Id (used for setting VNI)
-	metadata     uint64           // Metadata in case of "setMetadata"
-	metadataMask uint64           // Metadata mask
-	dscp         uint8            // DSCP field
-	outPort      uint16           // traffic outport
-	gotoTblId    uint16           // goto tableId
+	actionType   ActionType             // Type of action ActionSetVlan, ActionSetMetadata, ..etc
+	vlanId       uint16                 // Vlan Id in case of "setVlan"
+	macAddr      net.HardwareAddr       // Mac address to set
+	ipAddr       net.IP                 // IP address to be set
+	l4Port       uint16                 // Transport port to be set
+	tunnelId     uint64                 // Tunnel Id (used for setting VNI)
+	metadata     uint64                 // Metadata in case of "setMetadata"
+	metadataMask uint64                 // Metadata mask
+	dscp         uint8                  // DSCP field
+	outPort      uint16                 // traffic outport
+	gotoTblId    uint16                 // goto tableId
+	meterId      uint32                 // Meter id in case of "meter"
+	action       openflow13.Action      // Raw action for "applyAction" and "writeAction"
+	field        *openflow13.MatchField // Field to set in case of "setField"
 }
 
 type FlowOutput struct {
-	OutputType string // Output type: "toController", "flood", "gotoTable" or "outPort"
-	OutPortNo     uint32 // Output port number
-	TblId      uint8 // goto table id
+	OutputType OutputType // Output type, one of Output*
+	OutPortNo  uint32     // Output port number
+	TblId      uint8      // goto table id
+	GroupId    uint32     // group id
 }
 
 type Flow struct {
@@ -73,6 +145,13 @@ type Flow struct {
 	lock        sync.RWMutex  // lock for modifying flow state
 	IdleTimeout uint16 /* Idle time before discarding (seconds). */
 	HardTimeout uint16 /* Max time before discarding (seconds). */
+
+	// Flow mod flags
+	SendFlowRem  bool // Send a FlowRemoved message when the flow expires or is deleted
+	CheckOverlap bool // Refuse to add the flow if it overlaps an existing one
+	ResetCounts  bool // Reset the counters of the flow it replaces
+	NoPktCounts  bool // Don't keep track of the packet count
+	NoBytCounts  bool // Don't keep track of the byte count
 }
 
 
@@ -86,19 +165,39 @@ func NewFlow(tblID uint8) *Flow {
 }
 
 
-// string key for the flow
-func (self *Flow) FlowKey() string {
-	jsonVal, err := json.Marshal(self.Match)
-	if err != nil {
-		log.Errorf("Error forming flowkey for %+v. Err: %v", self, err)
-		return ""
-	}
+// Replace the actions and outputs of the flow with those of another flow,
+// i.e. the instructions a modify request sets
+func (self *Flow) copyActions(from *Flow) {
+	from.lock.RLock()
+	actions := append([]*FlowAction(nil), from.FlowActions...)
+	outputs := append([]*FlowOutput(nil), from.FlowOutput...)
+	from.lock.RUnlock()
 
-	return string(jsonVal)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowActions = actions
+	self.FlowOutput = outputs
+}
+
+// Key identifying the flow on the switch
+func (self *Flow) FlowKey() FlowKey {
+	return NewFlowKey(self.TableId, self.Match.Priority, self.GetMatchFields())
 }
 
-// Get all defined match openflow match fields
+// Get all defined match openflow match fields, with the missing
+// prerequisites inserted. Errors are logged, use BuildMatch to get them
 func (self *Flow) GetMatchFields() openflow13.Match {
+	ofMatch, err := self.BuildMatch()
+	if err != nil {
+		log.Errorf("Invalid match for flow %+v. Err: %v", self.Match, err)
+	}
+	return ofMatch
+}
+
+// Build the openflow match of the flow. Missing prerequisites that can be
+// inferred, e.g. eth_type for IPv4 addresses, are inserted. An error is
+// returned for a match the switch would reject
+func (self *Flow) BuildMatch() (openflow13.Match, error) {
 	ofMatch := openflow13.NewMatch()
 
 	// Handle input poty
@@ -107,6 +206,12 @@ func (self *Flow) GetMatchFields() openflow13.Match {
 		ofMatch.AddField(*inportField)
 	}
 
+	// Handle physical input port
+	if self.Match.InPhyPort != 0 {
+		inPhyPortField := openflow13.NewInPhyPortField(self.Match.InPhyPort)
+		ofMatch.AddField(*inPhyPortField)
+	}
+
 	// Handle mac DA field
 	if self.Match.MacDa != nil {
 		if self.Match.MacDaMask != nil {
@@ -137,16 +242,40 @@ func (self *Flow) GetMatchFields() openflow13.Match {
 
 	// Handle Vlan id
 	if self.Match.VlanId != 0 {
-		vidField := openflow13.NewVlanIdField(self.Match.VlanId, nil)
+		vidField := openflow13.NewVlanIdField(self.Match.VlanId, self.Match.VlanIdMask)
 		ofMatch.AddField(*vidField)
 	}
 
+	// Handle Vlan priority
+	if self.Match.VlanPcp != nil {
+		pcpField := openflow13.NewVlanPcpField(*self.Match.VlanPcp)
+		ofMatch.AddField(*pcpField)
+	}
+
 	// Handle ARP Oper type
 	if self.Match.ArpOper != 0 {
 		arpOperField := openflow13.NewArpOperField(self.Match.ArpOper)
 		ofMatch.AddField(*arpOperField)
 	}
 
+	// Handle ARP addresses
+	if self.Match.ArpSpa != nil {
+		arpSpaField := openflow13.NewArpSpaField(*self.Match.ArpSpa, self.Match.ArpSpaMask)
+		ofMatch.AddField(*arpSpaField)
+	}
+	if self.Match.ArpTpa != nil {
+		arpTpaField := openflow13.NewArpTpaField(*self.Match.ArpTpa, self.Match.ArpTpaMask)
+		ofMatch.AddField(*arpTpaField)
+	}
+	if self.Match.ArpSha != nil {
+		arpShaField := openflow13.NewArpShaField(*self.Match.ArpSha, self.Match.ArpShaMask)
+		ofMatch.AddField(*arpShaField)
+	}
+	if self.Match.ArpTha != nil {
+		arpThaField := openflow13.NewArpThaField(*self.Match.ArpTha, self.Match.ArpThaMask)
+		ofMatch.AddField(*arpThaField)
+	}
+
 	// Handle IP Dst
 	if self.Match.IpDa != nil {
 		if self.Match.IpDaMask != nil {
@@ -191,6 +320,18 @@ func (self *Flow) GetMatchFields() openflow13.Match {
 		}
 	}
 
+	// Handle IPv6 flow label
+	if self.Match.Ipv6FlowLabel != nil {
+		flowLabelField := openflow13.NewIpv6FlowLabelField(*self.Match.Ipv6FlowLabel, self.Match.Ipv6FlowLabelMask)
+		ofMatch.AddField(*flowLabelField)
+	}
+
+	// Handle IPv6 extension headers
+	if self.Match.Ipv6ExtHdr != nil {
+		extHdrField := openflow13.NewIpv6ExtHdrField(*self.Match.Ipv6ExtHdr, self.Match.Ipv6ExtHdrMask)
+		ofMatch.AddField(*extHdrField)
+	}
+
 	// Handle IP protocol
 	if self.Match.IpProto != 0 {
 		protoField := openflow13.NewIpProtoField(self.Match.IpProto)
@@ -203,26 +344,92 @@ func (self *Flow) GetMatchFields() openflow13.Match {
 		ofMatch.AddField(*dscpField)
 	}
 
+	// Handle IP ecn
+	if self.Match.IpEcn != nil {
+		ecnField := openflow13.NewIpEcnField(*self.Match.IpEcn)
+		ofMatch.AddField(*ecnField)
+	}
+
 	// Handle port numbers
-	if self.Match.IpProto == IP_PROTO_TCP && self.Match.TcpSrcPort != 0 {
+	if self.Match.TcpSrcPort != 0 {
 		portField := openflow13.NewTcpSrcField(self.Match.TcpSrcPort)
 		ofMatch.AddField(*portField)
 	}
-	if self.Match.IpProto == IP_PROTO_TCP && self.Match.TcpDstPort != 0 {
+	if self.Match.TcpDstPort != 0 {
 		portField := openflow13.NewTcpDstField(self.Match.TcpDstPort)
 		ofMatch.AddField(*portField)
 	}
-	if self.Match.IpProto == IP_PROTO_UDP && self.Match.UdpSrcPort != 0 {
+	if self.Match.UdpSrcPort != 0 {
 		portField := openflow13.NewUdpSrcField(self.Match.UdpSrcPort)
 		ofMatch.AddField(*portField)
 	}
-	if self.Match.IpProto == IP_PROTO_UDP && self.Match.UdpDstPort != 0 {
+	if self.Match.UdpDstPort != 0 {
 		portField := openflow13.NewUdpDstField(self.Match.UdpDstPort)
 		ofMatch.AddField(*portField)
 	}
+	if self.Match.SctpSrcPort != 0 {
+		portField := openflow13.NewSctpSrcField(self.Match.SctpSrcPort)
+		ofMatch.AddField(*portField)
+	}
+	if self.Match.SctpDstPort != 0 {
+		portField := openflow13.NewSctpDstField(self.Match.SctpDstPort)
+		ofMatch.AddField(*portField)
+	}
+
+	// Handle ICMP type and code
+	if self.Match.IcmpType != nil {
+		icmpTypeField := openflow13.NewIcmpv4TypeField(*self.Match.IcmpType)
+		ofMatch.AddField(*icmpTypeField)
+	}
+	if self.Match.IcmpCode != nil {
+		icmpCodeField := openflow13.NewIcmpv4CodeField(*self.Match.IcmpCode)
+		ofMatch.AddField(*icmpCodeField)
+	}
+	if self.Match.Icmpv6Type != nil {
+		icmpTypeField := openflow13.NewIcmpv6TypeField(*self.Match.Icmpv6Type)
+		ofMatch.AddField(*icmpTypeField)
+	}
+	if self.Match.Icmpv6Code != nil {
+		icmpCodeField := openflow13.NewIcmpv6CodeField(*self.Match.Icmpv6Code)
+		ofMatch.AddField(*icmpCodeField)
+	}
+
+	// Handle IPv6 neighbor discovery
+	if self.Match.NdTarget != nil {
+		ndTargetField := openflow13.NewIpv6NdTargetField(*self.Match.NdTarget)
+		ofMatch.AddField(*ndTargetField)
+	}
+	if self.Match.NdSll != nil {
+		ndSllField := openflow13.NewIpv6NdSllField(*self.Match.NdSll)
+		ofMatch.AddField(*ndSllField)
+	}
+	if self.Match.NdTll != nil {
+		ndTllField := openflow13.NewIpv6NdTllField(*self.Match.NdTll)
+		ofMatch.AddField(*ndTllField)
+	}
+
+	// Handle MPLS
+	if self.Match.MplsLabel != nil {
+		mplsLabelField := openflow13.NewMplsLabelField(*self.Match.MplsLabel)
+		ofMatch.AddField(*mplsLabelField)
+	}
+	if self.Match.MplsTc != nil {
+		mplsTcField := openflow13.NewMplsTcField(*self.Match.MplsTc)
+		ofMatch.AddField(*mplsTcField)
+	}
+	if self.Match.MplsBos != nil {
+		mplsBosField := openflow13.NewMplsBosField(*self.Match.MplsBos)
+		ofMatch.AddField(*mplsBosField)
+	}
+
+	// Handle PBB
+	if self.Match.PbbIsid != nil {
+		pbbIsidField := openflow13.NewPbbIsidField(*self.Match.PbbIsid, self.Match.PbbIsidMask)
+		ofMatch.AddField(*pbbIsidField)
+	}
 
 	// Handle tcp flags
-	if self.Match.IpProto == IP_PROTO_TCP && self.Match.TcpFlags != nil {
+	if self.Match.TcpFlags != nil {
 		tcpFlagField := openflow13.NewTcpFlagsField(*self.Match.TcpFlags, self.Match.TcpFlagsMask)
 		ofMatch.AddField(*tcpFlagField)
 	}
@@ -240,176 +447,317 @@ func (self *Flow) GetMatchFields() openflow13.Match {
 
 	// Handle Vxlan tunnel id
 	if self.Match.TunnelId != 0 {
-		tunnelIdField := openflow13.NewTunnelIdField(self.Match.TunnelId)
+		tunnelIdField := openflow13.NewTunnelIdMaskField(self.Match.TunnelId, self.Match.TunnelIdMask)
 		ofMatch.AddField(*tunnelIdField)
 	}
 
-	return *ofMatch
-}
-
-var actInstr openflow13.Instruction
-
-func (self *Flow) GetFlowInstructions() openflow13.Instruction {
-
-	if len(self.FlowOutput) > 0 {
-		actInstr = openflow13.NewInstrApplyActions()
-		for _, flowOut := range self.FlowOutput {
-			switch flowOut.OutputType {
-			case "gotoCtrl":
-				//actInstr = openflow13.NewInstrApplyActions()
-				outputAct := openflow13.NewActionOutput(openflow13.P_CONTROLLER)
-				// Don't buffer the packets being sent to controller
-				outputAct.MaxLen = openflow13.OFPCML_NO_BUFFER
-				actInstr.AddAction(outputAct, false)
-				log.Debugf("flow output type %s", flowOut.OutputType)
-			case "gotoTbl":
-				actInstr = openflow13.NewInstrGotoTable(flowOut.TblId)
-				log.Debugf("flow output type %s", flowOut.OutputType)
-			case "drop":
-				fallthrough
-			case "flood":
-				fallthrough
-			case "normal":
-				fallthrough
-			case "outPort":
-				//actInstr = openflow13.NewInstrApplyActions()
-				outputAct := openflow13.NewActionOutput(flowOut.OutPortNo)
-				actInstr.AddAction(outputAct, false)
-				log.Debugf("flow output type %s", flowOut.OutputType)
-			default:
-				log.Fatalf("Unknown flow output type %s", flowOut.OutputType)
+	err := ofMatch.AddPrerequisites()
+	return *ofMatch, err
+}
+
+// Build the FlowMod adding the flow to the switch. Errors are logged, use
+// BuildFlowMod to get them
+func (self *Flow) GetFlowMod() *openflow13.FlowMod {
+	flowMod, err := self.buildFlowMod()
+	if err != nil {
+		log.Errorf("Invalid flow %+v. Err: %v", self.Match, err)
+	}
+	return flowMod
+}
+
+// Build the FlowMod adding the flow to the switch, returns an error if the
+// flow is invalid
+func (self *Flow) BuildFlowMod() (*openflow13.FlowMod, error) {
+	flowMod, err := self.buildFlowMod()
+	if err != nil {
+		return nil, err
+	}
+	return flowMod, nil
+}
+
+// Build the FlowMod even for an invalid flow, along with the first error
+func (self *Flow) buildFlowMod() (*openflow13.FlowMod, error) {
+	match, err := self.BuildMatch()
+	instrs, instrErr := self.GetFlowInstructions()
+	if err == nil {
+		err = instrErr
+	}
+
+	flowMod := openflow13.NewFlowMod()
+	flowMod.TableId = self.TableId
+	flowMod.Priority = self.Match.Priority
+	flowMod.Cookie = self.FlowID
+	flowMod.Match = match
+	flowMod.IdleTimeout = self.IdleTimeout
+	flowMod.HardTimeout = self.HardTimeout
+	flowMod.Flags = self.GetFlowModFlags()
+	for _, instr := range instrs {
+		flowMod.AddInstruction(instr)
+	}
+	return flowMod, err
+}
+
+// Get the flow mod flags of the flow
+func (self *Flow) GetFlowModFlags() uint16 {
+	var flags uint16
+	if self.SendFlowRem {
+		flags |= openflow13.FF_SEND_FLOW_REM
+	}
+	if self.CheckOverlap {
+		flags |= openflow13.FF_CHECK_OVERLAP
+	}
+	if self.ResetCounts {
+		flags |= openflow13.FF_RESET_COUNTS
+	}
+	if self.NoPktCounts {
+		flags |= openflow13.FF_NO_PKT_COUNTS
+	}
+	if self.NoBytCounts {
+		flags |= openflow13.FF_NO_BYT_COUNTS
+	}
+	return flags
+}
+
+// Build the instructions of the flow in the order the spec executes them:
+// meter, apply-actions, clear-actions, write-actions, write-metadata and
+// goto-table. Empty instructions are left out, a flow without instructions
+// drops the packets. An error is returned for an unknown action or output
+// type and for actions that can't be combined.
+func (self *Flow) GetFlowInstructions() ([]openflow13.Instruction, error) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	var meterInstr, clearInstr, metadataInstr, gotoInstr openflow13.Instruction
+	applyInstr := openflow13.NewInstrApplyActions()
+	writeInstr := openflow13.NewInstrWriteActions()
+
+	for _, flowAction := range self.FlowActions {
+		switch flowAction.actionType {
+		case ActionSetVlan:
+			if flowAction.vlanId > 0xfff {
+				return nil, fmt.Errorf("Invalid vlan id %d", flowAction.vlanId)
+			}
+
+			// Push Vlan Tag action
+			pushVlanAction := openflow13.NewActionPushVlan(0x8100)
+
+			// Set Outer vlan tag field
+			vlanField := openflow13.NewVlanIdField(flowAction.vlanId, nil)
+			setVlanAction := openflow13.NewActionSetField(*vlanField)
+
+			// Add push vlan & setvlan actions to the instruction
+			applyInstr.AddAction(pushVlanAction, false)
+			applyInstr.AddAction(setVlanAction, false)
+			log.Debugf("flow install. Added pushvlan action: %+v, setVlan actions: %+v",
+				pushVlanAction, setVlanAction)
+
+		case ActionPopVlan:
+			// Create pop vln action
+			popVlan := openflow13.NewActionPopVlan()
+
+			// Add it to instruction
+			applyInstr.AddAction(popVlan, false)
+			log.Debugf("flow install. Added popVlan action: %+v", popVlan)
+
+		case ActionSetMacDa:
+			// Set Outer MacDA field
+			macDaField := openflow13.NewEthDstField(flowAction.macAddr, nil)
+			setMacDaAction := openflow13.NewActionSetField(*macDaField)
+
+			// Add set macDa action to the instruction
+			applyInstr.AddAction(setMacDaAction, false)
+			log.Debugf("flow install. Added setMacDa action: %+v", setMacDaAction)
+
+		case ActionSetMacSa:
+			// Set Outer MacSA field
+			macSaField := openflow13.NewEthSrcField(flowAction.macAddr, nil)
+			setMacSaAction := openflow13.NewActionSetField(*macSaField)
+
+			// Add set macDa action to the instruction
+			applyInstr.AddAction(setMacSaAction, false)
+			log.Debugf("flow install. Added setMacSa Action: %+v", setMacSaAction)
+
+		case ActionSetTunnelId:
+			// Set tunnelId field
+			tunnelIdField := openflow13.NewTunnelIdField(flowAction.tunnelId)
+			setTunnelAction := openflow13.NewActionSetField(*tunnelIdField)
+
+			// Add set tunnel action to the instruction
+			applyInstr.AddAction(setTunnelAction, false)
+			log.Debugf("flow install. Added setTunnelId Action: %+v", setTunnelAction)
+
+		case ActionSetIPSa:
+			// Set IP src
+			ipSaField := openflow13.NewIpv4SrcField(flowAction.ipAddr, nil)
+			setIPSaAction := openflow13.NewActionSetField(*ipSaField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setIPSaAction, false)
+			log.Debugf("flow install. Added setIPSa Action: %+v", setIPSaAction)
+
+		case ActionSetIPDa:
+			// Set IP dst
+			ipDaField := openflow13.NewIpv4DstField(flowAction.ipAddr, nil)
+			setIPDaAction := openflow13.NewActionSetField(*ipDaField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setIPDaAction, false)
+			log.Debugf("flow install. Added setIPDa Action: %+v", setIPDaAction)
+
+		case ActionSetDscp:
+			// Set DSCP field
+			ipDscpField := openflow13.NewIpDscpField(flowAction.dscp)
+			setIPDscpAction := openflow13.NewActionSetField(*ipDscpField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setIPDscpAction, false)
+			log.Debugf("flow install. Added setDscp Action: %+v", setIPDscpAction)
+
+		case ActionSetTCPSrc:
+			// Set TCP src
+			tcpSrcField := openflow13.NewTcpSrcField(flowAction.l4Port)
+			setTCPSrcAction := openflow13.NewActionSetField(*tcpSrcField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setTCPSrcAction, false)
+			log.Debugf("flow install. Added setTCPSrc Action: %+v", setTCPSrcAction)
+
+		case ActionSetTCPDst:
+			// Set TCP dst
+			tcpDstField := openflow13.NewTcpDstField(flowAction.l4Port)
+			setTCPDstAction := openflow13.NewActionSetField(*tcpDstField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setTCPDstAction, false)
+			log.Debugf("flow install. Added setTCPDst Action: %+v", setTCPDstAction)
+
+		case ActionSetUDPSrc:
+			// Set UDP src
+			udpSrcField := openflow13.NewUdpSrcField(flowAction.l4Port)
+			setUDPSrcAction := openflow13.NewActionSetField(*udpSrcField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setUDPSrcAction, false)
+			log.Debugf("flow install. Added setUDPSrc Action: %+v", setUDPSrcAction)
+
+		case ActionSetUDPDst:
+			// Set UDP dst
+			udpDstField := openflow13.NewUdpDstField(flowAction.l4Port)
+			setUDPDstAction := openflow13.NewActionSetField(*udpDstField)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setUDPDstAction, false)
+			log.Debugf("flow install. Added setUDPDst Action: %+v", setUDPDstAction)
+
+		case ActionSetField:
+			setFieldAction := openflow13.NewActionSetField(*flowAction.field)
+
+			// Add set action to the instruction
+			applyInstr.AddAction(setFieldAction, false)
+			log.Debugf("flow install. Added setField Action: %+v", setFieldAction)
+
+		case ActionApply:
+			if flowAction.action == nil {
+				return nil, fmt.Errorf("Missing action to apply")
+			}
+			applyInstr.AddAction(flowAction.action, false)
+
+		case ActionWrite:
+			if flowAction.action == nil {
+				return nil, fmt.Errorf("Missing action to write")
+			}
+			writeInstr.AddAction(flowAction.action, false)
+
+		case ActionClear:
+			clearInstr = openflow13.NewInstrClearActions()
+
+		case ActionSetMetadata:
+			if metadataInstr != nil {
+				return nil, fmt.Errorf("Metadata set more than once")
+			}
+			metadataInstr = openflow13.NewInstrWriteMetadata(flowAction.metadata, flowAction.metadataMask)
+
+		case ActionMeter:
+			if meterInstr != nil {
+				return nil, fmt.Errorf("Meter set more than once")
 			}
+			meterInstr = openflow13.NewInstrMeter(flowAction.meterId)
+
+		default:
+			return nil, fmt.Errorf("Unknown action type %s", flowAction.actionType)
 		}
 	}
 
-	if len(self.FlowActions) > 0 {
-
-		for _, flowAction := range self.FlowActions {
-			switch flowAction.actionType {
-			case "setVlan":
-				// Push Vlan Tag action
-				pushVlanAction := openflow13.NewActionPushVlan(0x8100)
-
-				// Set Outer vlan tag field
-				vlanField := openflow13.NewVlanIdField(flowAction.vlanId, nil)
-				setVlanAction := openflow13.NewActionSetField(*vlanField)
-
-				// Prepend push vlan & setvlan actions to existing instruction
-				actInstr.AddAction(setVlanAction, true)
-				actInstr.AddAction(pushVlanAction, true)
-				log.Debugf("flow install. Added pushvlan action: %+v, setVlan actions: %+v",
-					pushVlanAction, setVlanAction)
-
-			case "popVlan":
-				// Create pop vln action
-				popVlan := openflow13.NewActionPopVlan()
-
-				// Add it to instruction
-				actInstr.AddAction(popVlan, true)
-				log.Debugf("flow install. Added popVlan action: %+v", popVlan)
-
-			case "setMacDa":
-				// Set Outer MacDA field
-				macDaField := openflow13.NewEthDstField(flowAction.macAddr, nil)
-				setMacDaAction := openflow13.NewActionSetField(*macDaField)
-
-				// Add set macDa action to the instruction
-				actInstr.AddAction(setMacDaAction, true)
-				log.Debugf("flow install. Added setMacDa action: %+v", setMacDaAction)
-
-			case "setMacSa":
-				// Set Outer MacSA field
-				macSaField := openflow13.NewEthSrcField(flowAction.macAddr, nil)
-				setMacSaAction := openflow13.NewActionSetField(*macSaField)
-
-				// Add set macDa action to the instruction
-				actInstr.AddAction(setMacSaAction, true)
-				log.Debugf("flow install. Added setMacSa Action: %+v", setMacSaAction)
-
-			case "setTunnelId":
-				// Set tunnelId field
-				tunnelIdField := openflow13.NewTunnelIdField(flowAction.tunnelId)
-				setTunnelAction := openflow13.NewActionSetField(*tunnelIdField)
-
-				// Add set tunnel action to the instruction
-				actInstr.AddAction(setTunnelAction, true)
-				log.Debugf("flow install. Added setTunnelId Action: %+v", setTunnelAction)
-
-			case "setIPSa":
-				// Set IP src
-				ipSaField := openflow13.NewIpv4SrcField(flowAction.ipAddr, nil)
-				setIPSaAction := openflow13.NewActionSetField(*ipSaField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setIPSaAction, true)
-				log.Debugf("flow install. Added setIPSa Action: %+v", setIPSaAction)
-
-			case "setIPDa":
-				// Set IP dst
-				ipDaField := openflow13.NewIpv4DstField(flowAction.ipAddr, nil)
-				setIPDaAction := openflow13.NewActionSetField(*ipDaField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setIPDaAction, true)
-				log.Debugf("flow install. Added setIPDa Action: %+v", setIPDaAction)
-
-			case "setDscp":
-				// Set DSCP field
-				ipDscpField := openflow13.NewIpDscpField(flowAction.dscp)
-				setIPDscpAction := openflow13.NewActionSetField(*ipDscpField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setIPDscpAction, true)
-				log.Debugf("flow install. Added setDscp Action: %+v", setIPDscpAction)
-
-			case "setTCPSrc":
-				// Set TCP src
-				tcpSrcField := openflow13.NewTcpSrcField(flowAction.l4Port)
-				setTCPSrcAction := openflow13.NewActionSetField(*tcpSrcField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setTCPSrcAction, true)
-				log.Debugf("flow install. Added setTCPSrc Action: %+v", setTCPSrcAction)
-
-			case "setTCPDst":
-				// Set TCP dst
-				tcpDstField := openflow13.NewTcpDstField(flowAction.l4Port)
-				setTCPDstAction := openflow13.NewActionSetField(*tcpDstField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setTCPDstAction, true)
-				log.Debugf("flow install. Added setTCPDst Action: %+v", setTCPDstAction)
-
-			case "setUDPSrc":
-				// Set UDP src
-				udpSrcField := openflow13.NewUdpSrcField(flowAction.l4Port)
-				setUDPSrcAction := openflow13.NewActionSetField(*udpSrcField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setUDPSrcAction, true)
-				log.Debugf("flow install. Added setUDPSrc Action: %+v", setUDPSrcAction)
-
-			case "setUDPDst":
-				// Set UDP dst
-				udpDstField := openflow13.NewUdpDstField(flowAction.l4Port)
-				setUDPDstAction := openflow13.NewActionSetField(*udpDstField)
-
-				// Add set action to the instruction
-				actInstr.AddAction(setUDPDstAction, true)
-				log.Debugf("flow install. Added setUDPDst Action: %+v", setUDPDstAction)
-
-			default:
-				log.Fatalf("Unknown action type %s", flowAction.actionType)
+	// Outputs come after the actions modifying the packet
+	drop := false
+	for _, flowOut := range self.FlowOutput {
+		switch flowOut.OutputType {
+		case OutputController:
+			outputAct := openflow13.NewActionOutput(openflow13.P_CONTROLLER)
+			// Don't buffer the packets being sent to controller
+			outputAct.MaxLen = openflow13.OFPCML_NO_BUFFER
+			applyInstr.AddAction(outputAct, false)
+			log.Debugf("flow output type %s", flowOut.OutputType)
+		case OutputGotoTable:
+			if gotoInstr != nil {
+				return nil, fmt.Errorf("Goto table set more than once")
+			}
+			if flowOut.TblId <= self.TableId {
+				return nil, fmt.Errorf("Goto table %d from table %d, only forward gotos are allowed",
+					flowOut.TblId, self.TableId)
 			}
+			gotoInstr = openflow13.NewInstrGotoTable(flowOut.TblId)
+			log.Debugf("flow output type %s", flowOut.OutputType)
+		case OutputDrop:
+			// Nothing to do, a packet with no output is dropped
+			drop = true
+			log.Debugf("flow output type %s", flowOut.OutputType)
+		case OutputFlood:
+			fallthrough
+		case OutputNormal:
+			fallthrough
+		case OutputPort:
+			outputAct := openflow13.NewActionOutput(flowOut.OutPortNo)
+			applyInstr.AddAction(outputAct, false)
+			log.Debugf("flow output type %s", flowOut.OutputType)
+		case OutputGroup:
+			groupAct := openflow13.NewActionGroup(flowOut.GroupId)
+			applyInstr.AddAction(groupAct, false)
+			log.Debugf("flow output type %s", flowOut.OutputType)
+		default:
+			return nil, fmt.Errorf("Unknown flow output type %s", flowOut.OutputType)
 		}
 	}
-	return actInstr
+	if drop && len(self.FlowOutput) > 1 {
+		return nil, fmt.Errorf("Drop can't be combined with other outputs")
+	}
+
+	instrs := make([]openflow13.Instruction, 0, 6)
+	if meterInstr != nil {
+		instrs = append(instrs, meterInstr)
+	}
+	if len(applyInstr.Actions) > 0 {
+		instrs = append(instrs, applyInstr)
+	}
+	if clearInstr != nil {
+		instrs = append(instrs, clearInstr)
+	}
+	if len(writeInstr.Actions) > 0 {
+		instrs = append(instrs, writeInstr)
+	}
+	if metadataInstr != nil {
+		instrs = append(instrs, metadataInstr)
+	}
+	if gotoInstr != nil {
+		instrs = append(instrs, gotoInstr)
+	}
+	return instrs, nil
 }
 
 func (self *Flow) GetWriteMetaDataFlowInstruction() (*openflow13.InstrWriteMetadata, error) {
 	for _, flowAction := range self.FlowActions {
 		switch flowAction.actionType {
-		case "setMetadata":
+		case ActionSetMetadata:
 			// Set Metadata instruction
 			metaDataInstr := openflow13.NewInstrWriteMetadata(flowAction.metadata, flowAction.metadataMask)
 			return metaDataInstr, nil
@@ -420,7 +768,7 @@ func (self *Flow) GetWriteMetaDataFlowInstruction() (*openflow13.InstrWriteMetad
 
 func (self *Flow) SetGotoControllerAction() {
 	flowOut := new(FlowOutput)
-	flowOut.OutputType = "gotoCtrl"
+	flowOut.OutputType = OutputController
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	self.FlowOutput = append(self.FlowOutput, flowOut)
@@ -428,7 +776,7 @@ func (self *Flow) SetGotoControllerAction() {
 
 func (self *Flow) SetGotoTableAction(tblID uint8) {
 	flowOut := new(FlowOutput)
-	flowOut.OutputType = "gotoTbl"
+	flowOut.OutputType = OutputGotoTable
 	flowOut.TblId = tblID
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -437,7 +785,7 @@ func (self *Flow) SetGotoTableAction(tblID uint8) {
 
 func (self *Flow) SetFloodAction() {
 	flowOut := new(FlowOutput)
-	flowOut.OutputType = "flood"
+	flowOut.OutputType = OutputFlood
 	flowOut.OutPortNo = openflow13.P_FLOOD
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -446,7 +794,7 @@ func (self *Flow) SetFloodAction() {
 
 func (self *Flow) SetOutputPortAction(portNo uint32) {
 	flowOut := new(FlowOutput)
-	flowOut.OutputType = "outPort"
+	flowOut.OutputType = OutputPort
 	flowOut.OutPortNo = portNo
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -455,7 +803,7 @@ func (self *Flow) SetOutputPortAction(portNo uint32) {
 
 func (self *Flow) SetNormalAction() {
 	flowOut := new(FlowOutput)
-	flowOut.OutputType = "normal"
+	flowOut.OutputType = OutputNormal
 	flowOut.OutPortNo = openflow13.P_NORMAL
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -464,16 +812,25 @@ func (self *Flow) SetNormalAction() {
 
 func (self *Flow) SetDropAction() {
 	flowOut := new(FlowOutput)
-	flowOut.OutputType = "drop"
+	flowOut.OutputType = OutputDrop
 	flowOut.OutPortNo = openflow13.P_ANY
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	self.FlowOutput = append(self.FlowOutput, flowOut)
 }
 
+func (self *Flow) SetGroupAction(groupId uint32) {
+	flowOut := new(FlowOutput)
+	flowOut.OutputType = OutputGroup
+	flowOut.GroupId = groupId
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowOutput = append(self.FlowOutput, flowOut)
+}
+
 func (self *Flow) SetVlan(vlanId uint16) {
 	action := new(FlowAction)
-	action.actionType = "setVlan"
+	action.actionType = ActionSetVlan
 	action.vlanId = vlanId
 
 	self.lock.Lock()
@@ -483,7 +840,7 @@ func (self *Flow) SetVlan(vlanId uint16) {
 
 func (self *Flow) PopVlan() {
 	action := new(FlowAction)
-	action.actionType = "popVlan"
+	action.actionType = ActionPopVlan
 
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -492,7 +849,7 @@ func (self *Flow) PopVlan() {
 
 func (self *Flow) SetMacDa(macDa net.HardwareAddr) {
 	action := new(FlowAction)
-	action.actionType = "setMacDa"
+	action.actionType = ActionSetMacDa
 	action.macAddr = macDa
 
 	self.lock.Lock()
@@ -502,7 +859,7 @@ func (self *Flow) SetMacDa(macDa net.HardwareAddr) {
 
 func (self *Flow) SetMacSa(macSa net.HardwareAddr) {
 	action := new(FlowAction)
-	action.actionType = "setMacSa"
+	action.actionType = ActionSetMacSa
 	action.macAddr = macSa
 
 	self.lock.Lock()
@@ -510,43 +867,86 @@ func (self *Flow) SetMacSa(macSa net.HardwareAddr) {
 	self.FlowActions = append(self.FlowActions, action)
 }
 
-func (self *Flow) SetIPField(ip net.IP, field string) {
+// Set the IPv4 source address
+func (self *Flow) SetIPSrc(ip net.IP) error {
+	return self.setIP(ip, ActionSetIPSa)
+}
+
+// Set the IPv4 destination address
+func (self *Flow) SetIPDst(ip net.IP) error {
+	return self.setIP(ip, ActionSetIPDa)
+}
+
+// field should has one of the following values Src or Dst
+//
+// Deprecated: use SetIPSrc or SetIPDst.
+func (self *Flow) SetIPField(ip net.IP, field string) error {
+	switch field {
+	case "Src":
+		return self.SetIPSrc(ip)
+	case "Dst":
+		return self.SetIPDst(ip)
+	}
+	return fmt.Errorf("IP field %s not supported", field)
+}
+
+func (self *Flow) setIP(ip net.IP, actionType ActionType) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%v is not an IPv4 address", ip)
+	}
 	action := new(FlowAction)
+	action.actionType = actionType
 	action.ipAddr = ip
-	if field == "Src" {
-		action.actionType = "setIPSa"
-	} else if field == "Dst" {
-		action.actionType = "setIPDa"
-	} else {
-		log.Fatalf("field not supported")
-	}
 
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	self.FlowActions = append(self.FlowActions, action)
+	return nil
 }
 
-// field should has one of the following values TCPSrc, TCPDst, UDPSrc or UDPDst
-func (self *Flow) SetL4Field(port uint16, field string) {
-	action := new(FlowAction)
-	action.l4Port = port
+// Set the TCP source port
+func (self *Flow) SetTCPSrc(port uint16) {
+	self.setL4Port(port, ActionSetTCPSrc)
+}
+
+// Set the TCP destination port
+func (self *Flow) SetTCPDst(port uint16) {
+	self.setL4Port(port, ActionSetTCPDst)
+}
 
+// Set the UDP source port
+func (self *Flow) SetUDPSrc(port uint16) {
+	self.setL4Port(port, ActionSetUDPSrc)
+}
+
+// Set the UDP destination port
+func (self *Flow) SetUDPDst(port uint16) {
+	self.setL4Port(port, ActionSetUDPDst)
+}
+
+// field should has one of the following values TCPSrc, TCPDst, UDPSrc or UDPDst
+//
+// Deprecated: use SetTCPSrc, SetTCPDst, SetUDPSrc or SetUDPDst.
+func (self *Flow) SetL4Field(port uint16, field string) error {
 	switch field {
 	case "TCPSrc":
-		action.actionType = "setTCPSrc"
-		break
+		self.SetTCPSrc(port)
 	case "TCPDst":
-		action.actionType = "setTCPDst"
-		break
+		self.SetTCPDst(port)
 	case "UDPSrc":
-		action.actionType = "setUDPSrc"
-		break
+		self.SetUDPSrc(port)
 	case "UDPDst":
-		action.actionType = "setUDPDst"
-		break
+		self.SetUDPDst(port)
 	default:
-		log.Fatalf("field not supported")
+		return fmt.Errorf("L4 field %s not supported", field)
 	}
+	return nil
+}
+
+func (self *Flow) setL4Port(port uint16, actionType ActionType) {
+	action := new(FlowAction)
+	action.actionType = actionType
+	action.l4Port = port
 
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -555,7 +955,7 @@ func (self *Flow) SetL4Field(port uint16, field string) {
 
 func (self *Flow) SetMetadata(metadata, metadataMask uint64) {
 	action := new(FlowAction)
-	action.actionType = "setMetadata"
+	action.actionType = ActionSetMetadata
 	action.metadata = metadata
 	action.metadataMask = metadataMask
 
@@ -564,9 +964,238 @@ func (self *Flow) SetMetadata(metadata, metadataMask uint64) {
 	self.FlowActions = append(self.FlowActions, action)
 }
 
+// Push an MPLS label, etherType is either 0x8847 or 0x8848
+func (self *Flow) PushMpls(etherType uint16) error {
+	if etherType != openflow13.ETH_TYPE_MPLS && etherType != openflow13.ETH_TYPE_MPLS_MCAST {
+		return fmt.Errorf("Invalid MPLS ethertype 0x%04x", etherType)
+	}
+	self.AddApplyAction(openflow13.NewActionPushMpls(etherType))
+	return nil
+}
+
+// Pop the outer MPLS label, etherType is the ethertype of the payload
+func (self *Flow) PopMpls(etherType uint16) {
+	self.AddApplyAction(openflow13.NewActionPopMpls(etherType))
+}
+
+// Push a PBB service tag
+func (self *Flow) PushPbb() {
+	self.AddApplyAction(openflow13.NewActionPushPbb(openflow13.ETH_TYPE_PBB))
+}
+
+func (self *Flow) PopPbb() {
+	self.AddApplyAction(openflow13.NewActionPopPbb())
+}
+
+func (self *Flow) SetMplsTtl(ttl uint8) {
+	self.AddApplyAction(openflow13.NewActionSetMplsTtl(ttl))
+}
+
+func (self *Flow) DecMplsTtl() {
+	self.AddApplyAction(openflow13.NewActionDecMplsTtl())
+}
+
+func (self *Flow) SetIPTtl(ttl uint8) {
+	self.AddApplyAction(openflow13.NewActionSetNwTtl(ttl))
+}
+
+func (self *Flow) DecIPTtl() {
+	self.AddApplyAction(openflow13.NewActionDecNwTtl())
+}
+
+// Copy the TTL from the next-to-outermost header to the outermost one
+func (self *Flow) CopyTtlOut() {
+	self.AddApplyAction(openflow13.NewActionCopyTtlOut())
+}
+
+// Copy the TTL from the outermost header to the next-to-outermost one
+func (self *Flow) CopyTtlIn() {
+	self.AddApplyAction(openflow13.NewActionCopyTtlIn())
+}
+
+// Set the queue used by the following outputs
+func (self *Flow) SetQueue(queueId uint32) {
+	self.AddApplyAction(openflow13.NewActionSetQueue(queueId))
+}
+
+// Send the packets of the flow through a meter before any other instruction
+func (self *Flow) SetMeter(meterId uint32) {
+	action := new(FlowAction)
+	action.actionType = ActionMeter
+	action.meterId = meterId
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowActions = append(self.FlowActions, action)
+}
+
+// Apply an action immediately, before the outputs of the flow
+func (self *Flow) AddApplyAction(act openflow13.Action) {
+	action := new(FlowAction)
+	action.actionType = ActionApply
+	action.action = act
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowActions = append(self.FlowActions, action)
+}
+
+// Write an action to the action set, executed at the end of the pipeline
+func (self *Flow) AddWriteAction(act openflow13.Action) {
+	action := new(FlowAction)
+	action.actionType = ActionWrite
+	action.action = act
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowActions = append(self.FlowActions, action)
+}
+
+// Clear the action set written by the previous tables
+func (self *Flow) ClearActions() {
+	action := new(FlowAction)
+	action.actionType = ActionClear
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowActions = append(self.FlowActions, action)
+}
+
+// Set a header field of the packets. The field is any field the switch can
+// match on, without mask
+func (self *Flow) SetField(field openflow13.MatchField) error {
+	if field.HasMask {
+		return fmt.Errorf("Can't set masked field %s",
+			openflow13.OxmFieldName(field.Class, field.Field))
+	}
+	if field.Class == openflow13.OXM_CLASS_OPENFLOW_BASIC {
+		switch field.Field {
+		case openflow13.OXM_FIELD_IN_PHY_PORT, openflow13.OXM_FIELD_METADATA,
+			openflow13.OXM_FIELD_IPV6_EXTHDR:
+			return fmt.Errorf("Field %s can't be set",
+				openflow13.OxmFieldName(field.Class, field.Field))
+		}
+	}
+
+	action := new(FlowAction)
+	action.actionType = ActionSetField
+	action.field = &field
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.FlowActions = append(self.FlowActions, action)
+	return nil
+}
+
+// Set the IPv6 source address. IPv4-mapped addresses are IPv6 addresses
+func (self *Flow) SetIPv6Src(ip net.IP) error {
+	if len(ip) != net.IPv6len {
+		return fmt.Errorf("%v is not an IPv6 address", ip)
+	}
+	return self.SetField(*openflow13.NewIpv6SrcField(ip, nil))
+}
+
+// Set the IPv6 destination address
+func (self *Flow) SetIPv6Dst(ip net.IP) error {
+	if len(ip) != net.IPv6len {
+		return fmt.Errorf("%v is not an IPv6 address", ip)
+	}
+	return self.SetField(*openflow13.NewIpv6DstField(ip, nil))
+}
+
+func (self *Flow) SetEcn(ecn uint8) error {
+	if ecn > 3 {
+		return fmt.Errorf("Invalid ECN %d", ecn)
+	}
+	return self.SetField(*openflow13.NewIpEcnField(ecn))
+}
+
+func (self *Flow) SetVlanPcp(pcp uint8) error {
+	if pcp > 7 {
+		return fmt.Errorf("Invalid vlan priority %d", pcp)
+	}
+	return self.SetField(*openflow13.NewVlanPcpField(pcp))
+}
+
+func (self *Flow) SetMplsLabel(label uint32) error {
+	if label > 0xfffff {
+		return fmt.Errorf("Invalid MPLS label %d", label)
+	}
+	return self.SetField(*openflow13.NewMplsLabelField(label))
+}
+
+func (self *Flow) SetMplsTc(tc uint8) error {
+	if tc > 7 {
+		return fmt.Errorf("Invalid MPLS traffic class %d", tc)
+	}
+	return self.SetField(*openflow13.NewMplsTcField(tc))
+}
+
+func (self *Flow) SetArpOper(oper uint16) error {
+	return self.SetField(*openflow13.NewArpOperField(oper))
+}
+
+// Set the ARP sender IP address
+func (self *Flow) SetArpSpa(ip net.IP) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%v is not an IPv4 address", ip)
+	}
+	return self.SetField(*openflow13.NewArpSpaField(ip, nil))
+}
+
+// Set the ARP target IP address
+func (self *Flow) SetArpTpa(ip net.IP) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%v is not an IPv4 address", ip)
+	}
+	return self.SetField(*openflow13.NewArpTpaField(ip, nil))
+}
+
+// Set the ARP sender hardware address
+func (self *Flow) SetArpSha(mac net.HardwareAddr) error {
+	return self.SetField(*openflow13.NewArpShaField(mac, nil))
+}
+
+// Set the ARP target hardware address
+func (self *Flow) SetArpTha(mac net.HardwareAddr) error {
+	return self.SetField(*openflow13.NewArpThaField(mac, nil))
+}
+
+func (self *Flow) SetIcmpType(icmpType uint8) error {
+	return self.SetField(*openflow13.NewIcmpv4TypeField(icmpType))
+}
+
+func (self *Flow) SetIcmpCode(icmpCode uint8) error {
+	return self.SetField(*openflow13.NewIcmpv4CodeField(icmpCode))
+}
+
+func (self *Flow) SetIcmpv6Type(icmpType uint8) error {
+	return self.SetField(*openflow13.NewIcmpv6TypeField(icmpType))
+}
+
+func (self *Flow) SetIcmpv6Code(icmpCode uint8) error {
+	return self.SetField(*openflow13.NewIcmpv6CodeField(icmpCode))
+}
+
+// Set the IPv4 source of the tunnel
+func (self *Flow) SetTunnelIPSrc(ip net.IP) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%v is not an IPv4 address", ip)
+	}
+	return self.SetField(*openflow13.NewTunnelIpv4SrcField(ip, nil))
+}
+
+// Set the IPv4 destination of the tunnel
+func (self *Flow) SetTunnelIPDst(ip net.IP) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%v is not an IPv4 address", ip)
+	}
+	return self.SetField(*openflow13.NewTunnelIpv4DstField(ip, nil))
+}
+
 func (self *Flow) SetTunnelId(tunnelId uint64) {
 	action := new(FlowAction)
-	action.actionType = "setTunnelId"
+	action.actionType = ActionSetTunnelId
 	action.tunnelId = tunnelId
 
 	self.lock.Lock()
@@ -576,7 +1205,7 @@ func (self *Flow) SetTunnelId(tunnelId uint64) {
 
 func (self *Flow) SetDscp(dscp uint8) {
 	action := new(FlowAction)
-	action.actionType = "setDscp"
+	action.actionType = ActionSetDscp
 	action.dscp = dscp
 
 	self.lock.Lock()
@@ -587,9 +1216,11 @@ func (self *Flow) SetDscp(dscp uint8) {
 func (self *Flow) UnsetDscp() {
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	for idx, act := range self.FlowActions {
-		if act.actionType == "setDscp" {
-			self.FlowActions = append(self.FlowActions[:idx], self.FlowActions[idx+1:]...)
+	actions := make([]*FlowAction, 0, len(self.FlowActions))
+	for _, act := range self.FlowActions {
+		if act.actionType != ActionSetDscp {
+			actions = append(actions, act)
 		}
 	}
+	self.FlowActions = actions
 }
\ No newline at end of file
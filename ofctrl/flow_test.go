@@ -0,0 +1,211 @@
+package ofctrl_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Returns the encoded instructions of a flow
+func instrBytes(t *testing.T, flow *ofctrl.Flow) []byte {
+	t.Helper()
+	instrs, err := flow.GetFlowInstructions()
+	if err != nil {
+		t.Fatalf("Error building instructions. Err: %v", err)
+	}
+	var data []byte
+	for _, instr := range instrs {
+		b, err := instr.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Error encoding instruction. Err: %v", err)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+// The deprecated string selectors set the same fields as the setters
+func TestSetIPAndL4Fields(t *testing.T) {
+	ip := net.ParseIP("10.0.0.1")
+	flow := ofctrl.NewFlow(0)
+	if err := flow.SetIPSrc(ip); err != nil {
+		t.Fatalf("Error setting ip src. Err: %v", err)
+	}
+	if err := flow.SetIPDst(ip); err != nil {
+		t.Fatalf("Error setting ip dst. Err: %v", err)
+	}
+	flow.SetTCPSrc(1)
+	flow.SetTCPDst(2)
+	flow.SetUDPSrc(3)
+	flow.SetUDPDst(4)
+
+	deprecated := ofctrl.NewFlow(0)
+	for _, field := range []string{"Src", "Dst"} {
+		if err := deprecated.SetIPField(ip, field); err != nil {
+			t.Fatalf("Error setting ip %s. Err: %v", field, err)
+		}
+	}
+	for port, field := range []string{"TCPSrc", "TCPDst", "UDPSrc", "UDPDst"} {
+		if err := deprecated.SetL4Field(uint16(port+1), field); err != nil {
+			t.Fatalf("Error setting %s. Err: %v", field, err)
+		}
+	}
+
+	if got, want := instrBytes(t, deprecated), instrBytes(t, flow); !bytes.Equal(got, want) {
+		t.Errorf("Wrong actions of the deprecated setters: got %x, want %x", got, want)
+	}
+
+	if err := flow.SetIPSrc(net.ParseIP("2001:db8::1")); err == nil {
+		t.Errorf("IPv6 address set as IPv4 source")
+	}
+	if err := deprecated.SetIPField(ip, "Tos"); err == nil {
+		t.Errorf("Unknown IP field set")
+	}
+	if err := deprecated.SetL4Field(1, "SCTPSrc"); err == nil {
+		t.Errorf("Unknown L4 field set")
+	}
+}
+
+// The set-field setters check the address family of their address
+func TestSetAddressFields(t *testing.T) {
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	tests := []struct {
+		name string
+		set  func(flow *ofctrl.Flow) error
+		want *openflow13.MatchField // Field set by the flow, nil if the setter fails
+	}{
+		{"ipv6 src", func(f *ofctrl.Flow) error { return f.SetIPv6Src(net.ParseIP("2001:db8::1")) },
+			openflow13.NewIpv6SrcField(net.ParseIP("2001:db8::1"), nil)},
+		{"ipv4-mapped ipv6 dst", func(f *ofctrl.Flow) error { return f.SetIPv6Dst(net.ParseIP("::ffff:10.0.0.1")) },
+			openflow13.NewIpv6DstField(net.ParseIP("::ffff:10.0.0.1"), nil)},
+		{"ipv4 as ipv6", func(f *ofctrl.Flow) error { return f.SetIPv6Src(net.IP{10, 0, 0, 1}) }, nil},
+		{"arp spa", func(f *ofctrl.Flow) error { return f.SetArpSpa(net.ParseIP("10.0.0.1")) },
+			openflow13.NewArpSpaField(net.ParseIP("10.0.0.1"), nil)},
+		{"ipv6 as arp tpa", func(f *ofctrl.Flow) error { return f.SetArpTpa(net.ParseIP("2001:db8::1")) }, nil},
+		{"arp tha", func(f *ofctrl.Flow) error { return f.SetArpTha(mac) }, openflow13.NewArpThaField(mac, nil)},
+		{"tunnel dst", func(f *ofctrl.Flow) error { return f.SetTunnelIPDst(net.ParseIP("192.168.0.1")) },
+			openflow13.NewTunnelIpv4DstField(net.ParseIP("192.168.0.1"), nil)},
+	}
+
+	for _, test := range tests {
+		flow := ofctrl.NewFlow(0)
+		err := test.set(flow)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("Wrong result of %s: got no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error setting %s. Err: %v", test.name, err)
+			continue
+		}
+		expected := ofctrl.NewFlow(0)
+		if err := expected.SetField(*test.want); err != nil {
+			t.Fatalf("Error setting field of %s. Err: %v", test.name, err)
+		}
+		if got, want := instrBytes(t, flow), instrBytes(t, expected); !bytes.Equal(got, want) {
+			t.Errorf("Wrong actions of %s: got %x, want %x", test.name, got, want)
+		}
+	}
+}
+
+// Returns the types of the instructions of a flow, in order
+func instrTypes(t *testing.T, flow *ofctrl.Flow) []uint16 {
+	t.Helper()
+	instrs, err := flow.GetFlowInstructions()
+	if err != nil {
+		t.Fatalf("Error building instructions. Err: %v", err)
+	}
+	types := make([]uint16, 0, len(instrs))
+	for _, instr := range instrs {
+		b, err := instr.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Error encoding instruction. Err: %v", err)
+		}
+		types = append(types, binary.BigEndian.Uint16(b))
+	}
+	return types
+}
+
+// Instructions follow the execution order of the spec whatever the order
+// they are set in
+func TestFlowInstructionOrder(t *testing.T) {
+	flow := ofctrl.NewFlow(1)
+	flow.SetGotoTableAction(5)
+	flow.SetMetadata(0x10, 0xff)
+	flow.AddWriteAction(openflow13.NewActionOutput(2))
+	flow.ClearActions()
+	flow.SetOutputPortAction(1)
+	flow.SetMeter(3)
+
+	want := []uint16{
+		openflow13.InstrType_METER,
+		openflow13.InstrType_APPLY_ACTIONS,
+		openflow13.InstrType_CLEAR_ACTIONS,
+		openflow13.InstrType_WRITE_ACTIONS,
+		openflow13.InstrType_WRITE_METADATA,
+		openflow13.InstrType_GOTO_TABLE,
+	}
+	if got := instrTypes(t, flow); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong instruction order: got %v, want %v", got, want)
+	}
+
+	// Empty instructions are left out
+	if got := instrTypes(t, ofctrl.NewFlow(0)); len(got) != 0 {
+		t.Errorf("Wrong instructions of an empty flow: got %v, want none", got)
+	}
+	drop := ofctrl.NewFlow(0)
+	drop.SetDropAction()
+	if got := instrTypes(t, drop); len(got) != 0 {
+		t.Errorf("Wrong instructions of a drop flow: got %v, want none", got)
+	}
+}
+
+func TestFlowInstructionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(flow *ofctrl.Flow)
+		err  string // Part of the error
+	}{
+		{"invalid vlan", func(f *ofctrl.Flow) { f.SetVlan(0x1000) }, "Invalid vlan id"},
+		{"nil apply action", func(f *ofctrl.Flow) { f.AddApplyAction(nil) }, "Missing action to apply"},
+		{"nil write action", func(f *ofctrl.Flow) { f.AddWriteAction(nil) }, "Missing action to write"},
+		{"metadata twice", func(f *ofctrl.Flow) {
+			f.SetMetadata(1, 1)
+			f.SetMetadata(2, 2)
+		}, "Metadata set more than once"},
+		{"meter twice", func(f *ofctrl.Flow) {
+			f.SetMeter(1)
+			f.SetMeter(2)
+		}, "Meter set more than once"},
+		{"goto twice", func(f *ofctrl.Flow) {
+			f.SetGotoTableAction(2)
+			f.SetGotoTableAction(3)
+		}, "Goto table set more than once"},
+		{"goto same table", func(f *ofctrl.Flow) { f.SetGotoTableAction(1) }, "only forward gotos"},
+		{"goto previous table", func(f *ofctrl.Flow) { f.SetGotoTableAction(0) }, "only forward gotos"},
+		{"drop and output", func(f *ofctrl.Flow) {
+			f.SetDropAction()
+			f.SetOutputPortAction(1)
+		}, "Drop can't be combined"},
+		{"unknown output", func(f *ofctrl.Flow) {
+			f.FlowOutput = append(f.FlowOutput, &ofctrl.FlowOutput{OutputType: "bogus"})
+		}, "Unknown flow output type"},
+	}
+
+	for _, test := range tests {
+		flow := ofctrl.NewFlow(1)
+		test.set(flow)
+		_, err := flow.GetFlowInstructions()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Wrong error of %s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package ofctrl
+
+// This file implements the identity of a flow on a switch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Identity of a flow on a switch: its table, priority and match. Two flows
+// with the same FlowKey replace each other on the switch. FlowKeys are
+// comparable and can be used as map keys.
+type FlowKey struct {
+	TableId  uint8
+	Priority uint16
+	match    string // Normalized match fields
+}
+
+// Build the identity of a flow. The match is normalized: fields are sorted,
+// masked bits are cleared from the value and an all-ones mask is dropped,
+// so that matches the switch treats the same have the same identity.
+func NewFlowKey(tableId uint8, priority uint16, match openflow13.Match) FlowKey {
+	fields := make([]string, 0, len(match.Fields))
+	for _, field := range match.Fields {
+		fields = append(fields, normalizeField(field))
+	}
+	sort.Strings(fields)
+
+	return FlowKey{
+		TableId:  tableId,
+		Priority: priority,
+		match:    strings.Join(fields, ""),
+	}
+}
+
+// Returns true if both keys identify the same flow
+func (key FlowKey) Equal(other FlowKey) bool {
+	return key == other
+}
+
+// Order flows by table, then by decreasing priority as the switch looks
+// them up, then by match
+func (key FlowKey) Less(other FlowKey) bool {
+	if key.TableId != other.TableId {
+		return key.TableId < other.TableId
+	}
+	if key.Priority != other.Priority {
+		return key.Priority > other.Priority
+	}
+	return key.match < other.match
+}
+
+// Returns true if both keys have the same normalized match, whatever their
+// table and priority
+func (key FlowKey) SameMatch(other FlowKey) bool {
+	return key.match == other.match
+}
+
+func (key FlowKey) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "table=%d,priority=%d", key.TableId, key.Priority)
+
+	m := []byte(key.match)
+	for len(m) >= 6 {
+		class := binary.BigEndian.Uint16(m[0:])
+		field := m[2]
+		hasMask := m[3] == 1
+		n := int(binary.BigEndian.Uint16(m[4:]))
+		m = m[6:]
+		fmt.Fprintf(&buf, ",%s=0x%x", openflow13.OxmFieldName(class, field), m[:n])
+		if hasMask {
+			fmt.Fprintf(&buf, "/0x%x", m[n:2*n])
+			n *= 2
+		}
+		m = m[n:]
+	}
+	return buf.String()
+}
+
+// Sort flow keys in place following Less
+func SortFlowKeys(keys []FlowKey) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Less(keys[j]) })
+}
+
+// Encode a match field as class, field, mask flag, value length, value and
+// mask, with the value masked
+func normalizeField(field openflow13.MatchField) string {
+	b, _ := field.Value.MarshalBinary()
+	value := make([]byte, len(b))
+	copy(value, b)
+	mask := fieldMask(field, len(value))
+
+	allOnes := true
+	for i := range value {
+		value[i] &= mask[i]
+		if mask[i] != 0xff {
+			allOnes = false
+		}
+	}
+
+	b = make([]byte, 6, 6+2*len(value))
+	binary.BigEndian.PutUint16(b[0:], field.Class)
+	b[2] = field.Field
+	binary.BigEndian.PutUint16(b[4:], uint16(len(value)))
+	b = append(b, value...)
+	if !allOnes {
+		b[3] = 1
+		b = append(b, mask...)
+	}
+	return string(b)
+}
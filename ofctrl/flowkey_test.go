@@ -0,0 +1,96 @@
+package ofctrl_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+func newTestKey(tableId uint8, priority uint16, fields ...*openflow13.MatchField) ofctrl.FlowKey {
+	match := openflow13.NewMatch()
+	for _, f := range fields {
+		match.AddField(*f)
+	}
+	return ofctrl.NewFlowKey(tableId, priority, *match)
+}
+
+// Matches the switch treats the same have the same key
+func TestFlowKeyNormalization(t *testing.T) {
+	ip := func(s string) net.IP { return net.ParseIP(s) }
+	netMask := net.ParseIP("255.255.255.0").To4()
+	fullMask := net.ParseIP("255.255.255.255").To4()
+	ipv4 := openflow13.NewEthTypeField(0x0800)
+
+	tests := []struct {
+		name string
+		a, b ofctrl.FlowKey
+		same bool
+	}{
+		{"field order", newTestKey(0, 1, ipv4, openflow13.NewIpv4SrcField(ip("10.0.0.1"), nil)),
+			newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.1"), nil), ipv4), true},
+		{"bits outside the mask", newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.1"), &netMask)),
+			newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.0"), &netMask)), true},
+		{"all-ones mask", newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.1"), &fullMask)),
+			newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.1"), nil)), true},
+		{"different values", newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.1"), nil)),
+			newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.2"), nil)), false},
+		{"different fields", newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.1"), nil)),
+			newTestKey(0, 1, openflow13.NewIpv4DstField(ip("10.0.0.1"), nil)), false},
+		{"partial mask", newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.0"), &netMask)),
+			newTestKey(0, 1, openflow13.NewIpv4SrcField(ip("10.0.0.0"), nil)), false},
+		{"different table", newTestKey(0, 1, ipv4), newTestKey(1, 1, ipv4), false},
+		{"different priority", newTestKey(0, 1, ipv4), newTestKey(0, 2, ipv4), false},
+	}
+
+	for _, test := range tests {
+		if got := test.a.Equal(test.b); got != test.same {
+			t.Errorf("Wrong equality of %s: got %v, want %v", test.name, got, test.same)
+		}
+		if got := test.a == test.b; got != test.same {
+			t.Errorf("Wrong comparison of %s: got %v, want %v", test.name, got, test.same)
+		}
+	}
+
+	// The match is compared without the table and priority
+	if !newTestKey(0, 1, ipv4).SameMatch(newTestKey(3, 5, ipv4)) {
+		t.Errorf("Wrong SameMatch of keys with the same match")
+	}
+}
+
+func TestFlowKeyString(t *testing.T) {
+	netMask := net.ParseIP("255.255.255.0").To4()
+	key := newTestKey(2, 100, openflow13.NewIpv4SrcField(net.ParseIP("10.0.0.1"), &netMask),
+		openflow13.NewEthTypeField(0x0800))
+
+	want := "table=2,priority=100,eth_type=0x0800,ipv4_src=0x0a000000/0xffffff00"
+	if got := key.String(); got != want {
+		t.Errorf("Wrong string: got %s, want %s", got, want)
+	}
+}
+
+// Keys are sorted by table, then by decreasing priority, then by match
+func TestSortFlowKeys(t *testing.T) {
+	tcp := openflow13.NewIpProtoField(6)
+	udp := openflow13.NewIpProtoField(17)
+	keys := []ofctrl.FlowKey{
+		newTestKey(1, 10, tcp),
+		newTestKey(0, 10, udp),
+		newTestKey(0, 20, tcp),
+		newTestKey(0, 10, tcp),
+	}
+	want := []ofctrl.FlowKey{keys[2], keys[3], keys[1], keys[0]}
+
+	ofctrl.SortFlowKeys(keys)
+	for i := range keys {
+		if keys[i] != want[i] {
+			t.Errorf("Wrong key %d: got %s, want %s", i, keys[i], want[i])
+		}
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i].Less(keys[i-1]) {
+			t.Errorf("Key %s sorted after %s", keys[i-1], keys[i])
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package ofctrl
+
+// This file implements the controller metrics exported in the Prometheus
+// text exposition format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// Window over which the PacketIn rate is averaged
+const packetInRateWindow = 10
+
+type errorKey struct {
+	errType uint16
+	code    uint16
+}
+
+// Counters of one switch, kept per DPID across reconnections
+type switchMetrics struct {
+	lock      sync.Mutex
+	dpid      string
+	sw        *OFSwitch // Current connection of the switch
+	connected bool
+	msgsIn    map[uint8]uint64
+	msgsOut   map[uint8]uint64
+	errors    map[errorKey]uint64
+
+	// PacketIn counts of the last seconds, used for the PacketIn rate
+	pktInBuckets [packetInRateWindow]uint64
+	pktInSecond  int64
+}
+
+type Metrics struct {
+	lock     sync.Mutex
+	switches map[string]*switchMetrics // keyed by dpid
+	poller   *StatsPoller
+}
+
+// Create a new metrics collector
+func NewMetrics() *Metrics {
+	m := new(Metrics)
+	m.switches = make(map[string]*switchMetrics)
+	return m
+}
+
+// Export the port counters polled by a stats poller
+func (m *Metrics) SetStatsPoller(poller *StatsPoller) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.poller = poller
+}
+
+// Serve the metrics on a local address, e.g. "127.0.0.1:9100"
+func (m *Metrics) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Returns the metrics of a switch, creating them on first use
+func (m *Metrics) switchMetrics(sw *OFSwitch) *switchMetrics {
+	dpid := sw.DPID().String()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sm, ok := m.switches[dpid]
+	if !ok {
+		sm = &switchMetrics{
+			dpid:    dpid,
+			msgsIn:  make(map[uint8]uint64),
+			msgsOut: make(map[uint8]uint64),
+			errors:  make(map[errorKey]uint64),
+		}
+		m.switches[dpid] = sm
+	}
+
+	sm.lock.Lock()
+	sm.sw = sw
+	sm.lock.Unlock()
+	return sm
+}
+
+// Returns the OpenFlow type of a message. All messages carry the
+// common header in a field named Header
+func messageType(msg util.Message) (uint8, bool) {
+	if h, ok := msg.(*common.Header); ok {
+		return h.Type, true
+	}
+
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := v.Elem().FieldByName("Header")
+	if !f.IsValid() || !f.CanInterface() {
+		return 0, false
+	}
+	if h, ok := f.Interface().(common.Header); ok {
+		return h.Type, true
+	}
+	return 0, false
+}
+
+func (sm *switchMetrics) messageIn(msg util.Message) {
+	msgType, ok := messageType(msg)
+	if !ok {
+		return
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.msgsIn[msgType]++
+
+	if msgType == openflow13.Type_PacketIn {
+		sm.advancePacketInWindow(time.Now().Unix())
+		sm.pktInBuckets[sm.pktInSecond%packetInRateWindow]++
+	}
+}
+
+func (sm *switchMetrics) messageOut(msg util.Message) {
+	msgType, ok := messageType(msg)
+	if !ok {
+		return
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.msgsOut[msgType]++
+}
+
+func (sm *switchMetrics) errorMsg(errMsg *openflow13.ErrorMsg) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.errors[errorKey{errMsg.Type, errMsg.Code}]++
+}
+
+func (sm *switchMetrics) setConnected(connected bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.connected = connected
+}
+
+// Move the PacketIn window to the current second, clearing the
+// buckets of the seconds without any PacketIn
+func (sm *switchMetrics) advancePacketInWindow(now int64) {
+	if now <= sm.pktInSecond {
+		return
+	}
+	for sec := sm.pktInSecond + 1; sec <= now && sec <= sm.pktInSecond+packetInRateWindow; sec++ {
+		sm.pktInBuckets[sec%packetInRateWindow] = 0
+	}
+	sm.pktInSecond = now
+}
+
+// PacketIns per second over the last complete seconds of the window
+func (sm *switchMetrics) packetInRate() float64 {
+	now := time.Now().Unix()
+	sm.advancePacketInWindow(now)
+
+	var total uint64
+	for sec := now - packetInRateWindow + 1; sec < now; sec++ {
+		total += sm.pktInBuckets[sec%packetInRateWindow]
+	}
+	return float64(total) / float64(packetInRateWindow-1)
+}
+
+// Serve the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	m.writeMetrics(bw)
+	bw.Flush()
+}
+
+// A metric family being written out
+type metricFamily struct {
+	name    string
+	help    string
+	mtype   string
+	samples []string
+}
+
+func (f *metricFamily) add(labels string, value interface{}) {
+	f.samples = append(f.samples, fmt.Sprintf("%s{%s} %v", f.name, labels, value))
+}
+
+// Write all metrics in the Prometheus text format
+func (m *Metrics) writeMetrics(w io.Writer) {
+	m.lock.Lock()
+	dpids := make([]string, 0, len(m.switches))
+	for dpid := range m.switches {
+		dpids = append(dpids, dpid)
+	}
+	sort.Strings(dpids)
+	switches := make([]*switchMetrics, 0, len(dpids))
+	for _, dpid := range dpids {
+		switches = append(switches, m.switches[dpid])
+	}
+	poller := m.poller
+	m.lock.Unlock()
+
+	connected := &metricFamily{name: "ofctrl_switch_connected", help: "Whether the switch is connected to the controller.", mtype: "gauge"}
+	msgsIn := &metricFamily{name: "ofctrl_messages_in_total", help: "OpenFlow messages received from the switch by type.", mtype: "counter"}
+	msgsOut := &metricFamily{name: "ofctrl_messages_out_total", help: "OpenFlow messages sent to the switch by type.", mtype: "counter"}
+	pktInRate := &metricFamily{name: "ofctrl_packet_in_rate", help: "PacketIn messages per second received from the switch.", mtype: "gauge"}
+	errors := &metricFamily{name: "ofctrl_errors_total", help: "OpenFlow error messages received from the switch by type and code.", mtype: "counter"}
+	echoRTT := &metricFamily{name: "ofctrl_echo_rtt_seconds", help: "Round trip time of the last echo request.", mtype: "gauge"}
+	flows := &metricFamily{name: "ofctrl_flows_installed", help: "Flows installed on the switch by the controller.", mtype: "gauge"}
+	ports := newPortFamilies()
+	families := append([]*metricFamily{connected, msgsIn, msgsOut, pktInRate, errors, echoRTT, flows}, ports.families()...)
+
+	for _, sm := range switches {
+		sm.lock.Lock()
+		dpidLabel := fmt.Sprintf("dpid=%q", sm.dpid)
+		connected.add(dpidLabel, boolToInt(sm.connected))
+		for _, msgType := range sortedTypes(sm.msgsIn) {
+			msgsIn.add(fmt.Sprintf("%s,type=%q", dpidLabel, openflow13.MessageTypeName(msgType)), sm.msgsIn[msgType])
+		}
+		for _, msgType := range sortedTypes(sm.msgsOut) {
+			msgsOut.add(fmt.Sprintf("%s,type=%q", dpidLabel, openflow13.MessageTypeName(msgType)), sm.msgsOut[msgType])
+		}
+		pktInRate.add(dpidLabel, sm.packetInRate())
+		for _, key := range sortedErrors(sm.errors) {
+			errors.add(fmt.Sprintf("%s,type=\"%d\",code=\"%d\"", dpidLabel, key.errType, key.code), sm.errors[key])
+		}
+		sw := sm.sw
+		sm.lock.Unlock()
+
+		if sw == nil {
+			continue
+		}
+		echoRTT.add(dpidLabel, sw.EchoRTT().Seconds())
+		sw.lock.Lock()
+		flows.add(dpidLabel, len(sw.flows))
+		sw.lock.Unlock()
+
+		if poller != nil {
+			if sample := poller.LastSample(sw); sample != nil {
+				ports.add(dpidLabel, sample)
+			}
+		}
+	}
+
+	for _, f := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.mtype)
+		for _, s := range f.samples {
+			fmt.Fprintln(w, s)
+		}
+	}
+}
+
+// Port counters and rates of the last stats poll
+type portFamilies struct {
+	rxPackets, txPackets, rxBytes, txBytes   *metricFamily
+	rxDropped, txDropped, rxErrors, txErrors *metricFamily
+	rxBps, txBps                             *metricFamily
+}
+
+func newPortFamilies() *portFamilies {
+	counter := func(name, help string) *metricFamily {
+		return &metricFamily{name: "ofctrl_port_" + name, help: help, mtype: "counter"}
+	}
+	gauge := func(name, help string) *metricFamily {
+		return &metricFamily{name: "ofctrl_port_" + name, help: help, mtype: "gauge"}
+	}
+	return &portFamilies{
+		rxPackets: counter("rx_packets_total", "Packets received on the port."),
+		txPackets: counter("tx_packets_total", "Packets sent on the port."),
+		rxBytes:   counter("rx_bytes_total", "Bytes received on the port."),
+		txBytes:   counter("tx_bytes_total", "Bytes sent on the port."),
+		rxDropped: counter("rx_dropped_total", "Received packets dropped on the port."),
+		txDropped: counter("tx_dropped_total", "Sent packets dropped on the port."),
+		rxErrors:  counter("rx_errors_total", "Receive errors on the port."),
+		txErrors:  counter("tx_errors_total", "Transmit errors on the port."),
+		rxBps:     gauge("rx_bps", "Bits per second received on the port."),
+		txBps:     gauge("tx_bps", "Bits per second sent on the port."),
+	}
+}
+
+func (p *portFamilies) families() []*metricFamily {
+	return []*metricFamily{p.rxPackets, p.txPackets, p.rxBytes, p.txBytes,
+		p.rxDropped, p.txDropped, p.rxErrors, p.txErrors, p.rxBps, p.txBps}
+}
+
+func (p *portFamilies) add(dpidLabel string, sample *StatsSample) {
+	for _, pr := range sample.Ports {
+		labels := fmt.Sprintf("%s,port=\"%d\"", dpidLabel, pr.PortNo)
+		p.rxPackets.add(labels, pr.Stats.RxPackets)
+		p.txPackets.add(labels, pr.Stats.TxPackets)
+		p.rxBytes.add(labels, pr.Stats.RxBytes)
+		p.txBytes.add(labels, pr.Stats.TxBytes)
+		p.rxDropped.add(labels, pr.Stats.RxDropped)
+		p.txDropped.add(labels, pr.Stats.TxDropped)
+		p.rxErrors.add(labels, pr.Stats.RxErrors)
+		p.txErrors.add(labels, pr.Stats.TxErrors)
+		p.rxBps.add(labels, pr.RxBps)
+		p.txBps.add(labels, pr.TxBps)
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedTypes(counts map[uint8]uint64) []uint8 {
+	types := make([]uint8, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func sortedErrors(counts map[errorKey]uint64) []errorKey {
+	keys := make([]errorKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].errType != keys[j].errType {
+			return keys[i].errType < keys[j].errType
+		}
+		return keys[i].code < keys[j].code
+	})
+	return keys
+}
@@ -0,0 +1,141 @@
+package ofctrl
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+func TestMessageType(t *testing.T) {
+	tests := []struct {
+		msg      util.Message
+		wantType uint8
+		wantOk   bool
+	}{
+		{openflow13.NewEchoRequest(), openflow13.Type_EchoRequest, true},
+		{openflow13.NewFlowMod(), openflow13.Type_FlowMod, true},
+		{openflow13.NewPacketOut(), openflow13.Type_PacketOut, true},
+		{openflow13.NewErrorMsg(), openflow13.Type_Error, true},
+		{new(common.Hello), 0, true},
+		{util.NewBuffer(nil), 0, false},
+	}
+
+	for _, test := range tests {
+		msgType, ok := messageType(test.msg)
+		if msgType != test.wantType || ok != test.wantOk {
+			t.Errorf("Wrong type of %T: got %d %v, want %d %v", test.msg, msgType, ok, test.wantType, test.wantOk)
+		}
+	}
+}
+
+func TestPacketInWindow(t *testing.T) {
+	sm := &switchMetrics{}
+	count := func(now int64, n uint64) {
+		sm.advancePacketInWindow(now)
+		sm.pktInBuckets[now%packetInRateWindow] += n
+	}
+	count(100, 3)
+	count(101, 5)
+	count(101, 1)
+	if sm.pktInBuckets[100%packetInRateWindow] != 3 || sm.pktInBuckets[101%packetInRateWindow] != 6 {
+		t.Errorf("Wrong buckets: %v", sm.pktInBuckets)
+	}
+
+	// A bucket reused a window later starts from zero
+	count(110, 2)
+	if sm.pktInBuckets[110%packetInRateWindow] != 2 || sm.pktInBuckets[101%packetInRateWindow] != 6 {
+		t.Errorf("Wrong buckets after a window: %v", sm.pktInBuckets)
+	}
+
+	// Going back in time keeps the current second
+	sm.advancePacketInWindow(105)
+	if sm.pktInSecond != 110 {
+		t.Errorf("Wrong current second: got %d, want 110", sm.pktInSecond)
+	}
+
+	// A gap longer than the window clears every bucket
+	sm.advancePacketInWindow(200)
+	for i, n := range sm.pktInBuckets {
+		if n != 0 {
+			t.Errorf("Wrong bucket %d after a gap: got %d, want 0", i, n)
+		}
+	}
+}
+
+// Families are written in a fixed order with their samples sorted by dpid,
+// type and code
+func TestWriteMetrics(t *testing.T) {
+	m := NewMetrics()
+	sw := &OFSwitch{dpid: net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}, echoRTT: 2 * time.Millisecond}
+	sw.flows = map[FlowKey]*Flow{{Priority: 1}: NewFlow(0), {Priority: 2}: NewFlow(0)}
+	sm := m.switchMetrics(sw)
+	sm.setConnected(true)
+	sm.messageIn(openflow13.NewEchoRequest())
+	sm.messageIn(openflow13.NewEchoRequest())
+	sm.messageOut(openflow13.NewFlowMod())
+	sm.messageOut(openflow13.NewEchoReply())
+	for _, code := range []uint16{3, 1, 3} {
+		errMsg := openflow13.NewErrorMsg()
+		errMsg.Type = 5
+		errMsg.Code = code
+		sm.errorMsg(errMsg)
+	}
+
+	other := m.switchMetrics(&OFSwitch{dpid: net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 2}})
+	other.lock.Lock()
+	other.sw = nil
+	other.lock.Unlock()
+
+	poller := NewStatsPoller(time.Second, nil)
+	poller.switches[sw.DPID().String()] = &switchStats{sw: sw, sample: &StatsSample{
+		Ports: []PortRate{{PortNo: 1, RxBps: 800, Stats: &openflow13.PortStats{RxPackets: 10, TxBytes: 20}}},
+	}}
+	m.SetStatsPoller(poller)
+
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+	out := buf.String()
+
+	dpid1 := `dpid="00:00:00:00:00:00:00:01"`
+	dpid2 := `dpid="00:00:00:00:00:00:00:02"`
+	want := []string{
+		"# HELP ofctrl_switch_connected Whether the switch is connected to the controller.",
+		"# TYPE ofctrl_switch_connected gauge",
+		"ofctrl_switch_connected{" + dpid1 + "} 1",
+		"ofctrl_switch_connected{" + dpid2 + "} 0",
+		"# TYPE ofctrl_messages_in_total counter",
+		"ofctrl_messages_in_total{" + dpid1 + `,type="echo_request"} 2`,
+		"ofctrl_messages_out_total{" + dpid1 + `,type="echo_reply"} 1`,
+		"ofctrl_messages_out_total{" + dpid1 + `,type="flow_mod"} 1`,
+		"ofctrl_errors_total{" + dpid1 + `,type="5",code="1"} 1`,
+		"ofctrl_errors_total{" + dpid1 + `,type="5",code="3"} 2`,
+		"ofctrl_echo_rtt_seconds{" + dpid1 + "} 0.002",
+		"ofctrl_flows_installed{" + dpid1 + "} 2",
+		"ofctrl_port_rx_packets_total{" + dpid1 + `,port="1"} 10`,
+		"ofctrl_port_tx_bytes_total{" + dpid1 + `,port="1"} 20`,
+		"ofctrl_port_rx_bps{" + dpid1 + `,port="1"} 800`,
+	}
+
+	// Every line is present and in order
+	pos := 0
+	for _, line := range want {
+		i := strings.Index(out[pos:], line+"\n")
+		if i < 0 {
+			t.Fatalf("Missing or misplaced line %q in:\n%s", line, out)
+		}
+		pos += i + len(line)
+	}
+
+	// The switch without a connection has no connection metrics
+	for _, name := range []string{"ofctrl_echo_rtt_seconds", "ofctrl_flows_installed"} {
+		if strings.Contains(out, name+"{"+dpid2) {
+			t.Errorf("Unexpected %s of the disconnected switch", name)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package ofctrl
+
+// This file implements converting flows from and to the ovs-ofctl syntax
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// Parse a flow in the ovs-ofctl syntax, e.g.
+// "table=0,priority=100,tcp,nw_dst=10.0.0.0/24,tp_dst=80,actions=output:2"
+func ParseFlow(s string) (*Flow, error) {
+	flowMod, err := openflow13.ParseFlowMod(s)
+	if err != nil {
+		return nil, err
+	}
+	return NewFlowFromFlowMod(flowMod)
+}
+
+// Create a flow installing the same entry as a flow mod. The actions are
+// added as raw actions, in order. An error is returned for the match
+// fields a FlowMatch can't hold, e.g. a zero tcp port.
+func NewFlowFromFlowMod(flowMod *openflow13.FlowMod) (*Flow, error) {
+	flow := NewFlow(flowMod.TableId)
+	flow.Match.Priority = flowMod.Priority
+	flow.FlowID = flowMod.Cookie
+	flow.IdleTimeout = flowMod.IdleTimeout
+	flow.HardTimeout = flowMod.HardTimeout
+	flow.SendFlowRem = flowMod.Flags&openflow13.FF_SEND_FLOW_REM != 0
+	flow.CheckOverlap = flowMod.Flags&openflow13.FF_CHECK_OVERLAP != 0
+	flow.ResetCounts = flowMod.Flags&openflow13.FF_RESET_COUNTS != 0
+	flow.NoPktCounts = flowMod.Flags&openflow13.FF_NO_PKT_COUNTS != 0
+	flow.NoBytCounts = flowMod.Flags&openflow13.FF_NO_BYT_COUNTS != 0
+
+	// A match on any tagged packet is inferred from vlan_pcp
+	anyVlan := false
+	for _, f := range flowMod.Match.Fields {
+		if f.Class == openflow13.OXM_CLASS_OPENFLOW_BASIC && f.Field == openflow13.OXM_FIELD_VLAN_PCP {
+			anyVlan = true
+		}
+	}
+	for i := range flowMod.Match.Fields {
+		if err := setFlowMatchField(&flow.Match, &flowMod.Match.Fields[i], anyVlan); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, instr := range flowMod.Instructions {
+		switch instr := instr.(type) {
+		case *openflow13.InstrMeter:
+			flow.SetMeter(instr.MeterId)
+		case *openflow13.InstrGotoTable:
+			flow.SetGotoTableAction(instr.TableId)
+		case *openflow13.InstrWriteMetadata:
+			flow.SetMetadata(instr.Metadata, instr.MetadataMask)
+		case *openflow13.InstrActions:
+			switch instr.Type {
+			case openflow13.InstrType_APPLY_ACTIONS:
+				for _, act := range instr.Actions {
+					flow.AddApplyAction(act)
+				}
+			case openflow13.InstrType_WRITE_ACTIONS:
+				for _, act := range instr.Actions {
+					flow.AddWriteAction(act)
+				}
+			case openflow13.InstrType_CLEAR_ACTIONS:
+				flow.ClearActions()
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported instruction %+v", instr)
+		}
+	}
+	return flow, nil
+}
+
+// Returns the flow in the ovs-ofctl syntax
+func (self *Flow) String() string {
+	flowMod, _ := self.buildFlowMod()
+	return flowMod.String()
+}
+
+func messageBytes(m util.Message) []byte {
+	if m == nil {
+		return nil
+	}
+	b, _ := m.MarshalBinary()
+	return b
+}
+
+func bytesUint(b []byte) uint64 {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+// Set the FlowMatch field of an openflow match field. anyVlan allows a
+// match on any tagged packet
+func setFlowMatchField(m *FlowMatch, f *openflow13.MatchField, anyVlan bool) error {
+	name := openflow13.OxmFieldName(f.Class, f.Field)
+	if f.Class != openflow13.OXM_CLASS_OPENFLOW_BASIC {
+		return fmt.Errorf("Match field %s is not supported by flows", name)
+	}
+
+	b := messageBytes(f.Value)
+	v := bytesUint(b)
+	var mask []byte
+	if f.HasMask {
+		mask = messageBytes(f.Mask)
+	}
+	maskUint := func() *uint64 {
+		if mask == nil {
+			return nil
+		}
+		m := bytesUint(mask)
+		return &m
+	}
+	mac := func(b []byte) *net.HardwareAddr {
+		if b == nil {
+			return nil
+		}
+		addr := net.HardwareAddr(append([]byte(nil), b...))
+		return &addr
+	}
+	ip := func(b []byte) *net.IP {
+		if b == nil {
+			return nil
+		}
+		addr := net.IP(append([]byte(nil), b...))
+		return &addr
+	}
+	u8 := func() *uint8 {
+		x := uint8(v)
+		return &x
+	}
+	u16 := func(p *uint64) *uint16 {
+		if p == nil {
+			return nil
+		}
+		x := uint16(*p)
+		return &x
+	}
+	u32 := func(p *uint64) *uint32 {
+		if p == nil {
+			return nil
+		}
+		x := uint32(*p)
+		return &x
+	}
+
+	// Fields a FlowMatch leaves out when zero
+	zeroUnset := false
+	switch f.Field {
+	case openflow13.OXM_FIELD_IN_PORT:
+		m.InputPort, zeroUnset = uint32(v), v == 0
+	case openflow13.OXM_FIELD_IN_PHY_PORT:
+		m.InPhyPort, zeroUnset = uint32(v), v == 0
+	case openflow13.OXM_FIELD_METADATA:
+		m.Metadata, m.MetadataMask = &v, maskUint()
+	case openflow13.OXM_FIELD_ETH_DST:
+		m.MacDa, m.MacDaMask = mac(b), mac(mask)
+	case openflow13.OXM_FIELD_ETH_SRC:
+		m.MacSa, m.MacSaMask = mac(b), mac(mask)
+	case openflow13.OXM_FIELD_ETH_TYPE:
+		m.Ethertype, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_VLAN_VID:
+		// The flow sets OFPVID_PRESENT
+		if v&openflow13.OFPVID_PRESENT == 0 {
+			return fmt.Errorf("Match field %s without OFPVID_PRESENT is not supported by flows", name)
+		}
+		vid := uint16(v &^ openflow13.OFPVID_PRESENT)
+		if vid == 0 && mask != nil && anyVlan {
+			return nil
+		}
+		m.VlanId, m.VlanIdMask, zeroUnset = vid, u16(maskUint()), vid == 0
+	case openflow13.OXM_FIELD_VLAN_PCP:
+		m.VlanPcp = u8()
+	case openflow13.OXM_FIELD_IP_DSCP:
+		m.IpDscp, zeroUnset = uint8(v), v == 0
+	case openflow13.OXM_FIELD_IP_ECN:
+		m.IpEcn = u8()
+	case openflow13.OXM_FIELD_IP_PROTO:
+		m.IpProto, zeroUnset = uint8(v), v == 0
+	case openflow13.OXM_FIELD_IPV4_SRC:
+		m.IpSa, m.IpSaMask = ip(b), ip(mask)
+	case openflow13.OXM_FIELD_IPV4_DST:
+		m.IpDa, m.IpDaMask = ip(b), ip(mask)
+	case openflow13.OXM_FIELD_TCP_SRC:
+		m.TcpSrcPort, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_TCP_DST:
+		m.TcpDstPort, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_UDP_SRC:
+		m.UdpSrcPort, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_UDP_DST:
+		m.UdpDstPort, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_SCTP_SRC:
+		m.SctpSrcPort, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_SCTP_DST:
+		m.SctpDstPort, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_ICMPV4_TYPE:
+		m.IcmpType = u8()
+	case openflow13.OXM_FIELD_ICMPV4_CODE:
+		m.IcmpCode = u8()
+	case openflow13.OXM_FIELD_ARP_OP:
+		m.ArpOper, zeroUnset = uint16(v), v == 0
+	case openflow13.OXM_FIELD_ARP_SPA:
+		m.ArpSpa, m.ArpSpaMask = ip(b), ip(mask)
+	case openflow13.OXM_FIELD_ARP_TPA:
+		m.ArpTpa, m.ArpTpaMask = ip(b), ip(mask)
+	case openflow13.OXM_FIELD_ARP_SHA:
+		m.ArpSha, m.ArpShaMask = mac(b), mac(mask)
+	case openflow13.OXM_FIELD_ARP_THA:
+		m.ArpTha, m.ArpThaMask = mac(b), mac(mask)
+	case openflow13.OXM_FIELD_IPV6_SRC:
+		m.Ipv6Sa, m.Ipv6SaMask = ip(b), ip(mask)
+	case openflow13.OXM_FIELD_IPV6_DST:
+		m.Ipv6Da, m.Ipv6DaMask = ip(b), ip(mask)
+	case openflow13.OXM_FIELD_IPV6_FLABEL:
+		m.Ipv6FlowLabel, m.Ipv6FlowLabelMask = u32(&v), u32(maskUint())
+	case openflow13.OXM_FIELD_ICMPV6_TYPE:
+		m.Icmpv6Type = u8()
+	case openflow13.OXM_FIELD_ICMPV6_CODE:
+		m.Icmpv6Code = u8()
+	case openflow13.OXM_FIELD_IPV6_ND_TARGET:
+		m.NdTarget = ip(b)
+	case openflow13.OXM_FIELD_IPV6_ND_SLL:
+		m.NdSll = mac(b)
+	case openflow13.OXM_FIELD_IPV6_ND_TLL:
+		m.NdTll = mac(b)
+	case openflow13.OXM_FIELD_MPLS_LABEL:
+		m.MplsLabel = u32(&v)
+	case openflow13.OXM_FIELD_MPLS_TC:
+		m.MplsTc = u8()
+	case openflow13.OXM_FIELD_MPLS_BOS:
+		m.MplsBos = u8()
+	case openflow13.OXM_FIELD_PBB_ISID:
+		m.PbbIsid, m.PbbIsidMask = u32(&v), u32(maskUint())
+	case openflow13.OXM_FIELD_TUNNEL_ID:
+		m.TunnelId, m.TunnelIdMask, zeroUnset = v, maskUint(), v == 0
+	case openflow13.OXM_FIELD_IPV6_EXTHDR:
+		m.Ipv6ExtHdr, m.Ipv6ExtHdrMask = u16(&v), u16(maskUint())
+	case openflow13.OXM_FIELD_TCP_FLAGS:
+		m.TcpFlags, m.TcpFlagsMask = u16(&v), u16(maskUint())
+	default:
+		return fmt.Errorf("Match field %s is not supported by flows", name)
+	}
+
+	if zeroUnset {
+		return fmt.Errorf("Match field %s=0 is not supported by flows", name)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ofctrl_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+)
+
+func TestFlowRoundTrip(t *testing.T) {
+	tests := []struct {
+		flow string
+		want string // Flow written by String, empty if the same
+	}{
+		{"table=0,priority=100,tcp,nw_dst=10.0.0.0/24,tp_dst=80,actions=output:2", ""},
+		{"table=1,priority=10,cookie=0x1f,idle_timeout=30,hard_timeout=60,send_flow_rem,in_port=3,dl_vlan=100,actions=pop_vlan,NORMAL", ""},
+		{"table=2,priority=5,arp,arp_op=1,arp_tpa=10.0.0.1,actions=push_vlan:0x8100,set_field:10->dl_vlan,group:7", ""},
+		{"table=4,priority=7,metadata=0x10/0xf0,tun_id=0x64,actions=meter:2,dec_ttl,clear_actions,write_actions(set_queue:1,LOCAL),write_metadata:0x1/0xff,goto_table:9", ""},
+		{"table=2,priority=1000,udp6,ipv6_dst=2001:db8::/32,tp_src=53,actions=CONTROLLER:65535", ""},
+		{"table=0,priority=100,ip,nw_src=10.0.0.1,actions=mod_nw_dst:10.0.0.2,output:1",
+			"table=0,priority=100,ip,nw_src=10.0.0.1,actions=set_field:10.0.0.2->nw_dst,output:1"},
+		{"table=0,priority=100,dl_vlan_pcp=3,actions=drop", "table=0,priority=100,vlan_vid=0x1000/0x1000,dl_vlan_pcp=3,actions=drop"},
+		{"table=0,priority=100,vlan_tci=0xb064,actions=drop", "table=0,priority=100,dl_vlan=100,dl_vlan_pcp=5,actions=drop"},
+		{"table=0,priority=100,ip,nw_tos=0x28,actions=drop", "table=0,priority=100,ip,ip_dscp=10,actions=drop"},
+	}
+
+	for _, test := range tests {
+		want := test.want
+		if want == "" {
+			want = test.flow
+		}
+		flow, err := ofctrl.ParseFlow(test.flow)
+		if err != nil {
+			t.Errorf("Error parsing %s. Err: %v", test.flow, err)
+			continue
+		}
+		if got := flow.String(); got != want {
+			t.Errorf("Wrong flow parsed from %s:\n got %s\nwant %s", test.flow, got, want)
+			continue
+		}
+
+		// A flow created from the flow mod of the flow installs the same entry
+		flowMod := flow.GetFlowMod()
+		again, err := ofctrl.NewFlowFromFlowMod(flowMod)
+		if err != nil {
+			t.Errorf("Error creating flow of %s. Err: %v", test.flow, err)
+			continue
+		}
+		againMod := again.GetFlowMod()
+		againMod.Xid = flowMod.Xid
+		data, _ := flowMod.MarshalBinary()
+		againData, _ := againMod.MarshalBinary()
+		if !bytes.Equal(data, againData) {
+			t.Errorf("Wrong flow created from %s: got %s", test.flow, again)
+		}
+	}
+}
+
+// Matches a FlowMatch can't hold are errors
+func TestParseFlowErrors(t *testing.T) {
+	tests := []struct {
+		flow string
+		err  string // Part of the error
+	}{
+		{"table=0,priority=100,tcp,tp_dst=0,actions=drop", "tcp_dst=0 is not supported"},
+		{"table=0,priority=100,tun_src=1.1.1.1,actions=drop", "tun_ipv4_src is not supported"},
+		{"table=0,priority=100,vlan_vid=0,actions=drop", "without OFPVID_PRESENT"},
+		{"table=0,priority=100,bogus=1,actions=drop", "Unknown match field"},
+	}
+
+	for _, test := range tests {
+		_, err := ofctrl.ParseFlow(test.flow)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Wrong error of %s: got %v, want %q", test.flow, err, test.err)
+		}
+	}
+}
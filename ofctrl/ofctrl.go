@@ -1,6 +1,7 @@
 package ofctrl
 
 import (
+	"errors"
 	"net"
 	"strings"
 	"sync"
@@ -33,22 +34,214 @@ type ConsumerInterface interface {
 	FlowRemoved(sw *OFSwitch, flowRemoved *openflow13.FlowRemoved)
 }
 
+// A flow installed through OFSwitch removed from the switch
+type RemovedFlow struct {
+	Flow        *Flow         // Flow as it was installed
+	Reason      uint8         // One of openflow13.RR_*
+	Duration    time.Duration // Time the flow was on the switch
+	PacketCount uint64        // Final packet count
+	ByteCount   uint64        // Final byte count
+	Msg         *openflow13.FlowRemoved
+}
+
+// Consumers implementing this interface are handed the Flow of the
+// FlowRemoved messages received, after the flow was forgotten. Only flows
+// installed with SendFlowRem set are reported by the switch.
+type FlowRemovedConsumer interface {
+	FlowEvicted(sw *OFSwitch, removed *RemovedFlow)
+}
+
 type Controller struct {
 	consumer      ConsumerInterface
 	listener *net.TCPListener
 	wg       sync.WaitGroup
 	Bridge   *OFSwitch
+	bridgeLock sync.Mutex // protects Bridge against concurrent connections
+	metrics  *Metrics
+
+	// Desired state of the switches, replayed when they reconnect
+	store *StateStore
+
+	// Reconcile the flows of reconnecting switches
+	reconcile     bool
+	reconcileLock sync.Mutex
+
+	// Dispatcher of the PacketIns, nil to call PacketRcvd directly
+	dispatcher *PacketInDispatcher
+
+	// Bus delivering the events of the switches to the subscribers
+	events *EventBus
+
+	// Pipeline installed on the switches, nil if the apps install all flows
+	pipeline *Pipeline
+
+	// Cookie namespaces of the apps
+	cookies *CookieAllocator
+
+	// Declarative configuration installed on the switches, nil if none
+	flowConfig *FlowConfig
+
+	// Capture of the OpenFlow channels, nil if none
+	capture *util.CaptureWriter
 }
 
-// Create a new controller
+// Consumer of a controller whose apps only subscribe to the event bus
+type noConsumer struct{}
+
+func (noConsumer) SwitchConnected(sw *OFSwitch)                                     {}
+func (noConsumer) SwitchDisconnected(sw *OFSwitch)                                  {}
+func (noConsumer) PacketRcvd(sw *OFSwitch, pkt *openflow13.PacketIn)                {}
+func (noConsumer) MultipartReply(sw *OFSwitch, rep *openflow13.MultipartReply)      {}
+func (noConsumer) PortStatusChange(sw *OFSwitch, portStatus *openflow13.PortStatus) {}
+func (noConsumer) FlowRemoved(sw *OFSwitch, flowRemoved *openflow13.FlowRemoved)    {}
+
+// Create a new controller. The consumer may be nil when the apps subscribe
+// to the event bus instead
 func NewController(consumer ConsumerInterface) *Controller {
 	c := new(Controller)
 
 	// keep the consumer
+	if consumer == nil {
+		consumer = noConsumer{}
+	}
 	c.consumer = consumer
+	c.store = NewStateStore()
+	c.events = NewEventBus()
+	c.cookies, _ = NewCookieAllocator(DefaultCookieNamespaceBits)
 	return c
 }
 
+// Returns the allocator of the cookie namespaces of the apps
+func (c *Controller) Cookies() *CookieAllocator {
+	return c.cookies
+}
+
+// Returns the bus delivering the events of the switches to any number of
+// subscribers, besides the consumer
+func (c *Controller) Events() *EventBus {
+	return c.events
+}
+
+// Record the messages exchanged with the switches to a capture, e.g.
+// util.CreateCapture("session.pcap"). The records carry the datapath id of
+// their switch. Must be called before Listen.
+func (c *Controller) SetCapture(w *util.CaptureWriter) {
+	c.capture = w
+}
+
+// Start collecting metrics of the switches connecting to the controller.
+// Must be called before Listen.
+func (c *Controller) EnableMetrics() *Metrics {
+	if c.metrics == nil {
+		c.metrics = NewMetrics()
+	}
+	return c.metrics
+}
+
+// Reconcile the flows of reconnecting switches: the desired flows are
+// compared with the flows on the switch and only the difference is added,
+// modified or deleted. Without reconciliation the desired flows are
+// re-added.
+func (c *Controller) EnableReconciliation() {
+	c.reconcileLock.Lock()
+	defer c.reconcileLock.Unlock()
+	c.reconcile = true
+}
+
+// Called for a new switch connection before the consumer is notified.
+// Restores the desired state of a switch that was programmed before.
+func (c *Controller) switchConnecting(sw *OFSwitch) {
+	st := c.store.Switch(sw.DPID())
+	if c.flowConfig != nil {
+		// Every switch gets its own flows
+		b, err := c.flowConfig.build()
+		if err == nil {
+			err = b.load(st)
+		}
+		if err != nil {
+			log.Errorf("Error loading the flow config of switch %s. Err: %v", sw.DPID(), err)
+		}
+	}
+
+	sw.lock.Lock()
+	for _, flow := range st.Flows() {
+		sw.flows[flow.FlowKey()] = flow
+	}
+	sw.lock.Unlock()
+
+	c.reconcileLock.Lock()
+	reconcile := c.reconcile
+	c.reconcileLock.Unlock()
+
+	if err := st.Replay(sw, reconcile); err != nil {
+		log.Errorf("Error restoring the state of switch %s. Err: %v", sw.DPID(), err)
+	}
+
+	if c.pipeline != nil {
+		if err := c.pipeline.Install(sw); err != nil {
+			log.Errorf("Error installing the pipeline on switch %s. Err: %v", sw.DPID(), err)
+		}
+	}
+}
+
+// Install the table-miss flows of a pipeline on the switches when they
+// connect, before the consumer is notified. Flows installed afterwards
+// must be in a table of the pipeline and only go to later tables. Must be
+// called before Listen.
+func (c *Controller) SetPipeline(p *Pipeline) {
+	c.pipeline = p
+}
+
+// Returns the pipeline of the switches, nil if none was set
+func (c *Controller) Pipeline() *Pipeline {
+	return c.pipeline
+}
+
+// Install a declarative configuration on the switches when they connect,
+// before the consumer is notified. It is recorded in the desired state of
+// a switch when it first connects and restored with it on reconnections,
+// so that its meters and groups aren't added twice. The tables of
+// the configuration become the pipeline of the controller. Must be called
+// before Listen.
+func (c *Controller) SetFlowConfig(cfg *FlowConfig) error {
+	b, err := cfg.build()
+	if err != nil {
+		return err
+	}
+	if b.pipeline != nil {
+		if c.pipeline != nil {
+			return errors.New("Flow config has tables but a pipeline is already set")
+		}
+		c.pipeline = b.pipeline
+	}
+	c.flowConfig = cfg
+	return nil
+}
+
+// Dispatch the PacketIns of the switches to the handlers registered on d
+// instead of calling the consumer's PacketRcvd from the receive loop of the
+// switch. PacketIns no handler selects go to PacketRcvd unless d has a
+// default handler. Must be called before Listen.
+func (c *Controller) SetPacketInDispatcher(d *PacketInDispatcher) {
+	d.lock.Lock()
+	if d.defHandler == nil {
+		d.defHandler = c.consumer.PacketRcvd
+	}
+	d.lock.Unlock()
+	c.dispatcher = d
+}
+
+// Returns the store keeping the desired state of the switches
+func (c *Controller) StateStore() *StateStore {
+	return c.store
+}
+
+// Use a store, e.g. loaded from a snapshot, as desired state of the
+// switches. Must be called before Listen.
+func (c *Controller) SetStateStore(store *StateStore) {
+	c.store = store
+}
+
 // Listen on a port
 func (c *Controller) Listen(port string) {
 	addr, _ := net.ResolveTCPAddr("tcp", port)
@@ -77,9 +270,19 @@ func (c *Controller) Listen(port string) {
 
 }
 
+// Serve a connection to a switch the controller didn't accept itself, e.g.
+// one end of a net.Pipe. Returns once the switch is connected or the
+// handshake failed
+func (c *Controller) ServeConn(conn net.Conn) {
+	c.wg.Add(1)
+	c.handleConnection(conn)
+}
+
 // Cleanup the controller
 func (c *Controller) Delete() {
-	c.listener.Close()
+	if c.listener != nil {
+		c.listener.Close()
+	}
 	c.wg.Wait()
 	c.consumer = nil
 }
@@ -88,7 +291,7 @@ func (c *Controller) Delete() {
 func (c *Controller) handleConnection(conn net.Conn) {
 	defer c.wg.Done()
 
-	stream := util.NewMessageStream(conn, c)
+	stream := util.NewCaptureMessageStream(conn, c, c.capture)
 
 	log.Println("New connection..")
 
@@ -129,10 +332,14 @@ func (c *Controller) handleConnection(conn net.Conn) {
 			case *openflow13.SwitchFeatures:
 				log.Printf("Received ofp1.3 Switch feature response: %+v", *m)
 
-				// Create a new switch and handover the stream
-				if c.Bridge == nil {
-					c.Bridge = NewSwitch(stream, m.DPID, c.consumer)
+				// Create a new switch and handover the stream. A switch
+				// reconnecting replaces its previous connection
+				c.bridgeLock.Lock()
+				if c.Bridge == nil || !c.Bridge.connected() ||
+					c.Bridge.DPID().String() == m.DPID.String() {
+					c.Bridge = newSwitch(stream, m.DPID, m, c.consumer, c)
 				}
+				c.bridgeLock.Unlock()
 				// Let switch instance handle all future messages..
 				return
 
@@ -1,6 +1,7 @@
 package ofctrl
 
 import (
+	"fmt"
 	"net"
 	"time"
 
@@ -10,34 +11,93 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"sync"
+	"sync/atomic"
 )
 
 type OFSwitch struct {
 	stream *util.MessageStream
 	dpid   net.HardwareAddr
 	consumer    ConsumerInterface
-	flows  map[string]*Flow
+	flows  map[FlowKey]*Flow
+	// Deleted flows with SendFlowRem set, until their FlowRemoved arrives
+	pendingRemovals map[FlowKey]*Flow
 	lock    sync.Mutex
-	isConnected bool
+	isConnected int32 // 1 while connected, accessed atomically
+
+	// Outstanding multipart requests waiting for replies, keyed by xid
+	mpLock     sync.Mutex
+	mpRequests map[uint32]*mpTransaction
+
+	// Requests handling the error they may cause, keyed by xid
+	errLock     sync.Mutex
+	errHandlers map[uint32]func(*openflow13.ErrorMsg)
+
+	// Controller owning the switch, nil if created outside of a controller
+	ctrler *Controller
+
+	// Metrics of the switch, nil if the controller doesn't collect them
+	swMetrics *switchMetrics
+
+	// Last features reply of the switch, nil if unknown
+	featuresLock sync.Mutex
+	features     *openflow13.SwitchFeatures
+
+	// Last echo request sent and the measured round trip time
+	echoLock sync.Mutex
+	echoXid  uint32
+	echoSent time.Time
+	echoRTT  time.Duration
 }
 
+// A multipart request sent by the library, collecting its reply parts
+type mpTransaction struct {
+	bodies []util.Message
+	done   chan []util.Message
+}
+
+// How long to wait for all parts of a multipart reply
+const multipartTimeout = 5 * time.Second
+
+// How long a request waits for the error it may cause
+const errorTimeout = 5 * time.Second
+
 // Builds and populates a Switch struct then starts listening
 // for OpenFlow messages on conn.
 func NewSwitch(stream *util.MessageStream, dpid net.HardwareAddr, consumer ConsumerInterface) *OFSwitch {
+	return newSwitch(stream, dpid, nil, consumer, nil)
+}
+
+// Builds a switch owned by a controller
+func newSwitch(stream *util.MessageStream, dpid net.HardwareAddr, features *openflow13.SwitchFeatures,
+	consumer ConsumerInterface, ctrler *Controller) *OFSwitch {
 	var s *OFSwitch
 
 	log.Infoln("Openflow Connection for new switch:", dpid)
 
 	s = new(OFSwitch)
 	s.consumer = consumer
+	s.ctrler = ctrler
 	s.stream = stream
 	s.dpid = dpid
-	s.isConnected = false
-	s.flows = make(map[string]*Flow)
+	s.features = features
+	s.flows = make(map[FlowKey]*Flow)
+	s.pendingRemovals = make(map[FlowKey]*Flow)
+	s.mpRequests = make(map[uint32]*mpTransaction)
+	s.errHandlers = make(map[uint32]func(*openflow13.ErrorMsg))
+
+	// Look up the metrics once, before any message is counted
+	if ctrler != nil && ctrler.metrics != nil {
+		s.swMetrics = ctrler.metrics.switchMetrics(s)
+	}
 
 	// Main receive loop for the switch
 	go s.receive()
 
+	// Let the controller restore the state of a reconnecting switch
+	if ctrler != nil {
+		ctrler.switchConnecting(s)
+	}
+
 	// send Switch connected callback
 	s.switchConnected()
 
@@ -50,11 +110,74 @@ func (self *OFSwitch) DPID() net.HardwareAddr {
 	return self.dpid
 }
 
+// Returns whether the switch completed its connection and hasn't
+// disconnected since
+func (self *OFSwitch) connected() bool {
+	return atomic.LoadInt32(&self.isConnected) == 1
+}
+
+// Returns the last features reply of the switch, nil if unknown
+func (self *OFSwitch) Features() *openflow13.SwitchFeatures {
+	self.featuresLock.Lock()
+	defer self.featuresLock.Unlock()
+	return self.features
+}
+
+// Number of flow tables of the switch, 0 if unknown
+func (self *OFSwitch) NumTables() uint8 {
+	if features := self.Features(); features != nil {
+		return features.NumTables
+	}
+	return 0
+}
+
 // Sends an OpenFlow message to the Switch.
 func (self *OFSwitch) Send(req util.Message) {
+	if m := self.metrics(); m != nil {
+		m.messageOut(req)
+	}
 	self.stream.Outbound <- req
 }
 
+// Returns the metrics of the switch if the controller collects them
+func (self *OFSwitch) metrics() *switchMetrics {
+	return self.swMetrics
+}
+
+// Returns the event bus of the switch's controller, if any
+func (self *OFSwitch) events() *EventBus {
+	if self.ctrler == nil {
+		return nil
+	}
+	return self.ctrler.events
+}
+
+// Request a role for the controller on the switch, one of
+// openflow13.OFPCR_ROLE_*. The role granted is reported to the
+// RoleChangeListeners of the event bus
+func (self *OFSwitch) SetRole(role uint32, generationId uint64) {
+	self.Send(openflow13.NewRoleRequest(role, generationId))
+}
+
+// Send an echo request, keeping its send time to measure the round trip
+func (self *OFSwitch) sendEchoRequest() {
+	req := openflow13.NewEchoRequest()
+
+	self.echoLock.Lock()
+	self.echoXid = req.Xid
+	self.echoSent = time.Now()
+	self.echoLock.Unlock()
+
+	self.Send(req)
+}
+
+// Round trip time of the last answered echo request
+func (self *OFSwitch) EchoRTT() time.Duration {
+	self.echoLock.Lock()
+	defer self.echoLock.Unlock()
+	return self.echoRTT
+}
+
 func (self *OFSwitch) Disconnect() {
 	self.stream.Shutdown <- true
 	self.switchDisconnected()
@@ -62,21 +185,37 @@ func (self *OFSwitch) Disconnect() {
 
 // Handle switch connected event
 func (self *OFSwitch) switchConnected() {
+	if m := self.metrics(); m != nil {
+		m.setConnected(true)
+	}
 	self.consumer.SwitchConnected(self)
+	if bus := self.events(); bus != nil {
+		bus.switchConnected(self)
+	}
 
 	// Send new feature request
 	self.Send(openflow13.NewFeaturesRequest())
 
 	// FIXME: This is too fragile. Create a periodic timer
 	// Start the periodic echo request loop
-	self.Send(openflow13.NewEchoRequest())
-	self.isConnected = true
+	self.sendEchoRequest()
+	atomic.StoreInt32(&self.isConnected, 1)
 }
 
 // Handle switch disconnected event
 func (self *OFSwitch) switchDisconnected() {
+	if self.ctrler != nil && self.ctrler.dispatcher != nil {
+		// Handlers may still wait on the switch, don't wait for them
+		go self.ctrler.dispatcher.RemoveSwitch(self)
+	}
 	self.consumer.SwitchDisconnected(self)
-	self.isConnected = false
+	if bus := self.events(); bus != nil {
+		bus.switchDisconnected(self)
+	}
+	atomic.StoreInt32(&self.isConnected, 0)
+	if m := self.metrics(); m != nil {
+		m.setConnected(false)
+	}
 }
 
 // Receive loop for each Switch.
@@ -99,7 +238,11 @@ func (self *OFSwitch) receive() {
 
 // Handle openflow messages from the switch
 func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
-	log.Debugf("Received message: %+v, on switch: %s", msg, dpid.String())
+	log.Debugf("Received message: %v, on switch: %s", msg, dpid.String())
+
+	if m := self.metrics(); m != nil {
+		m.messageIn(msg)
+	}
 
 	switch t := msg.(type) {
 	case *common.Header:
@@ -118,6 +261,11 @@ func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
 			self.Send(res)
 
 		case openflow13.Type_EchoReply:
+			self.echoLock.Lock()
+			if t.Xid == self.echoXid {
+				self.echoRTT = time.Since(self.echoSent)
+			}
+			self.echoLock.Unlock()
 
 			// FIXME: This is too fragile. Create a periodic timer
 			// Wait three seconds then send an echo_request message.
@@ -125,8 +273,7 @@ func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
 				<-time.After(time.Second * 3)
 
 				// Send echo request
-				res := openflow13.NewEchoRequest()
-				self.Send(res)
+				self.sendEchoRequest()
 			}()
 
 		case openflow13.Type_FeaturesRequest:
@@ -139,10 +286,23 @@ func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
 
 		}
 	case *openflow13.ErrorMsg:
-		log.Errorf("Received ofp1.3 error msg: %+v", *t)
+		if m := self.metrics(); m != nil {
+			m.errorMsg(t)
+		}
+		// Errors expected by the request are not reported
+		if self.handleError(t) {
+			return
+		}
+		log.Errorf("Received ofp1.3 error msg: %v", t)
+		if bus := self.events(); bus != nil {
+			bus.errorRcvd(self, t)
+		}
 	case *openflow13.VendorHeader:
 
 	case *openflow13.SwitchFeatures:
+		self.featuresLock.Lock()
+		self.features = t
+		self.featuresLock.Unlock()
 
 	case *openflow13.SwitchConfig:
 		switch t.Header.Type {
@@ -153,16 +313,32 @@ func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
 		}
 	case *openflow13.PacketIn:
 		log.Debugf("Received packet(ofctrl): %+v", t)
+		if bus := self.events(); bus != nil {
+			bus.packetRcvd(self, t)
+		}
+		// Hand the packet to the dispatcher workers if any, the receive
+		// loop must not wait for slow handlers
+		if self.ctrler != nil && self.ctrler.dispatcher != nil {
+			self.ctrler.dispatcher.Dispatch(self, t)
+			return
+		}
 		// send packet rcvd callback
 		self.consumer.PacketRcvd(self, (*openflow13.PacketIn)(t))
 
 	case *openflow13.FlowRemoved:
 		log.Debugf("Flow removed: %+v", t)
+		self.flowRemoved(t)
 		self.consumer.FlowRemoved(self, (*openflow13.FlowRemoved)(t))
+		if bus := self.events(); bus != nil {
+			bus.flowRemoved(self, t)
+		}
 
 	case *openflow13.PortStatus:
 		log.Debugf("Port Stats: %+v", t)
 		self.consumer.PortStatusChange(self, (*openflow13.PortStatus)(t))
+		if bus := self.events(); bus != nil {
+			bus.portStatusChange(self, t)
+		}
 
 	case *openflow13.PacketOut:
 
@@ -174,42 +350,411 @@ func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
 
 	case *openflow13.MultipartReply:
 		log.Debugf("Received MultipartReply")
+		// Replies to requests sent by the library are not forwarded
+		if self.collectMultipartReply(t) {
+			return
+		}
 		// send packet rcvd callback
 		self.consumer.MultipartReply(self, (*openflow13.MultipartReply)(t))
+		if bus := self.events(); bus != nil {
+			bus.multipartReply(self, t)
+		}
+
+	case *openflow13.RoleRequest:
+		if t.Header.Type == openflow13.Type_RoleReply {
+			log.Debugf("Role reply: %+v", t)
+			if bus := self.events(); bus != nil {
+				bus.roleChanged(self, t)
+			}
+		}
 
 	}
 }
 
-func (self *OFSwitch) InstallFlow(flow *Flow) {
+// Install a flow on the switch. Nothing is sent for an invalid flow
+func (self *OFSwitch) InstallFlow(flow *Flow) error {
+	flowMod, err := flow.BuildFlowMod()
+	if err == nil {
+		err = self.validateFlow(flow)
+	}
+	if err != nil {
+		log.Errorf("Error installing flow %+v. Err: %v", flow.Match, err)
+		return err
+	}
+
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	flowMod := openflow13.NewFlowMod()
-	flowMod.TableId = flow.TableId
-	flowMod.Priority = flow.Match.Priority
-	flowMod.Cookie = flow.FlowID
-	flowMod.Match = flow.GetMatchFields()
-	flowMod.IdleTimeout = flow.IdleTimeout
-	flowMod.HardTimeout = flow.HardTimeout
-	flowMod.AddInstruction(flow.GetFlowInstructions())
 
 	log.Debugf("Add flow: %+v", flowMod)
-	self.Send(flowMod)
 	self.flows [flow.FlowKey()] = flow
+	if st := self.state(); st != nil {
+		st.addFlowMod(flow, flowMod)
+	}
+	self.Send(flowMod)
+	return nil
 }
 
+// Delete a flow from the switch. Only the flow with the same table,
+// priority and match is deleted.
+//
+// Deprecated: use DeleteFlowStrict, which takes the flow by pointer since
+// a Flow holds a lock and must not be copied.
 func (self *OFSwitch) DeleteFlow(flow Flow) {
-	self.lock.Lock()
-	defer self.lock.Unlock()
-	flowMod := openflow13.NewFlowMod()
-	flowMod.Command = openflow13.FC_DELETE
-	flowMod.TableId = flow.TableId
+	self.DeleteFlowStrict(&flow)
+}
+
+// Delete the flow with the same table, priority and match. A flow with a
+// FlowID only deletes the flow with the same cookie, so that an app doesn't
+// delete the flow of another app
+func (self *OFSwitch) DeleteFlowStrict(flow *Flow) {
+	flowMod := newFlowDelete(openflow13.FC_DELETE_STRICT, flow.TableId)
 	flowMod.Priority = flow.Match.Priority
-	flowMod.Cookie = flow.FlowID
-	flowMod.CookieMask = 0xffffffffffffffff
+	flowMod.Match = flow.GetMatchFields()
+	if flow.FlowID != 0 {
+		flowMod.Cookie = flow.FlowID
+		flowMod.CookieMask = ^uint64(0)
+	}
+	self.deleteFlows(flowMod)
+}
+
+// Delete all flows of a table matching at least the fields of match,
+// whatever their priority. OFPTT_ALL selects all tables
+func (self *OFSwitch) DeleteFlowsByMatch(tableId uint8, match FlowMatch) {
+	flowMod := newFlowDelete(openflow13.FC_DELETE, tableId)
+	flowMod.Match = (&Flow{Match: match}).GetMatchFields()
+	self.deleteFlows(flowMod)
+}
+
+// Delete all flows whose cookie matches cookie/cookieMask in all tables
+func (self *OFSwitch) DeleteFlowsByCookie(cookie, cookieMask uint64) {
+	flowMod := newFlowDelete(openflow13.FC_DELETE, openflow13.OFPTT_ALL)
+	flowMod.Cookie = cookie
+	flowMod.CookieMask = cookieMask
+	self.deleteFlows(flowMod)
+}
+
+// Delete all flows of a table. OFPTT_ALL deletes all flows of the switch
+func (self *OFSwitch) DeleteFlowsInTable(tableId uint8) {
+	self.deleteFlows(newFlowDelete(openflow13.FC_DELETE, tableId))
+}
+
+// Build a delete request not filtering on cookie, out_port or out_group
+func newFlowDelete(command uint8, tableId uint8) *openflow13.FlowMod {
+	flowMod := openflow13.NewFlowMod()
+	flowMod.Command = command
+	flowMod.TableId = tableId
 	flowMod.OutPort = openflow13.P_ANY
 	flowMod.OutGroup = openflow13.OFPG_ANY
+	return flowMod
+}
+
+// Send a delete request and forget the flows it deletes
+func (self *OFSwitch) deleteFlows(flowMod *openflow13.FlowMod) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 
 	log.Debugf("Delete flow: %+v", flowMod)
+	for _, flow := range self.evictFlows(flowMod) {
+		// The switch reports the deletion, keep the Flow for FlowEvicted
+		if flow.SendFlowRem {
+			self.pendingRemovals[flow.FlowKey()] = flow
+		}
+	}
+	self.Send(flowMod)
+}
+
+// Forget the flows selected by a delete request and return them.
+// Must be called with the switch lock held
+func (self *OFSwitch) evictFlows(flowMod *openflow13.FlowMod) []*Flow {
+	var evicted []*Flow
+	for key, flow := range self.flows {
+		if FlowModSelects(flowMod, flow.GetFlowMod()) {
+			evicted = append(evicted, flow)
+			delete(self.flows, key)
+		}
+	}
+	if st := self.state(); st != nil {
+		st.deleteFlows(flowMod)
+	}
+	return evicted
+}
+
+// Forget the flow a FlowRemoved message is about and notify consumers
+// interested in the Flow they installed
+func (self *OFSwitch) flowRemoved(msg *openflow13.FlowRemoved) {
+	flowMod := newFlowDelete(openflow13.FC_DELETE_STRICT, msg.TableId)
+	flowMod.Priority = msg.Priority
+	flowMod.Match = msg.Match
+	flowMod.Cookie = msg.Cookie
+	flowMod.CookieMask = 0xffffffffffffffff
+
+	// A flow deleted by the controller was already forgotten
+	self.lock.Lock()
+	key := NewFlowKey(msg.TableId, msg.Priority, msg.Match)
+	flow, ok := self.pendingRemovals[key]
+	if ok && flow.FlowID == msg.Cookie {
+		delete(self.pendingRemovals, key)
+	} else if evicted := self.evictFlows(flowMod); len(evicted) > 0 {
+		flow = evicted[0]
+	} else {
+		flow = nil
+	}
+	self.lock.Unlock()
+
+	consumer, ok := self.consumer.(FlowRemovedConsumer)
+	if !ok || flow == nil {
+		return
+	}
+	consumer.FlowEvicted(self, &RemovedFlow{
+		Flow:        flow,
+		Reason:      msg.Reason,
+		Duration:    time.Duration(msg.DurationSec)*time.Second + time.Duration(msg.DurationNSec),
+		PacketCount: msg.PacketCount,
+		ByteCount:   msg.ByteCount,
+		Msg:         msg,
+	})
+}
+
+// Replace the instructions of all flows of the flow's table matching at
+// least the fields of the flow's match, whatever their priority. Flows are
+// never added, cookies and timeouts are left unchanged
+func (self *OFSwitch) ModifyFlow(flow *Flow) error {
+	flowMod, err := flow.BuildFlowMod()
+	if err != nil {
+		return err
+	}
+	flowMod.Command = openflow13.FC_MODIFY
+	return self.modifyFlows(flowMod, flow)
+}
+
+// Replace the instructions of the flow with the same table, priority and
+// match
+func (self *OFSwitch) ModifyFlowStrict(flow *Flow) error {
+	flowMod, err := flow.BuildFlowMod()
+	if err != nil {
+		return err
+	}
+	flowMod.Command = openflow13.FC_MODIFY_STRICT
+	return self.modifyFlows(flowMod, flow)
+}
+
+// Send a modify request and update the flows it modifies. The Flow of a
+// strict modify replaces the Flow previously installed, the flows selected
+// by a non-strict modify take the actions and outputs of flow. Nothing is
+// sent for a flow the pipeline rejects
+func (self *OFSwitch) modifyFlows(flowMod *openflow13.FlowMod, flow *Flow) error {
+	if err := self.validateFlow(flow); err != nil {
+		log.Errorf("Error modifying flow %+v. Err: %v", flow.Match, err)
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	log.Debugf("Modify flow: %+v", flowMod)
+
+	strict := flowMod.Command == openflow13.FC_MODIFY_STRICT
+	if strict {
+		if _, ok := self.flows[flow.FlowKey()]; ok {
+			self.flows[flow.FlowKey()] = flow
+		}
+	} else {
+		for _, f := range self.flows {
+			if f != flow && FlowModSelects(flowMod, f.GetFlowMod()) {
+				f.copyActions(flow)
+			}
+		}
+	}
+	if st := self.state(); st != nil {
+		if strict {
+			st.modifyFlows(flowMod, flow)
+		} else {
+			st.modifyFlows(flowMod, nil)
+		}
+	}
 	self.Send(flowMod)
-	delete(self.flows, flow.FlowKey())
-}
\ No newline at end of file
+	return nil
+}
+
+// Check a flow against the pipeline of the controller, if any
+func (self *OFSwitch) validateFlow(flow *Flow) error {
+	if self.ctrler == nil || self.ctrler.pipeline == nil {
+		return nil
+	}
+	return self.ctrler.pipeline.ValidateFlow(flow)
+}
+
+// Add or modify a group on the switch
+func (self *OFSwitch) InstallGroup(groupMod *openflow13.GroupMod) {
+	log.Debugf("Install group: %+v", groupMod)
+	if st := self.state(); st != nil {
+		st.AddGroup(groupMod)
+	}
+	self.Send(groupMod)
+}
+
+// Delete a group from the switch
+func (self *OFSwitch) DeleteGroup(groupId uint32) {
+	groupMod := openflow13.NewGroupMod()
+	groupMod.Command = openflow13.OFPGC_DELETE
+	groupMod.GroupId = groupId
+
+	log.Debugf("Delete group: %+v", groupMod)
+	self.Send(groupMod)
+	if st := self.state(); st != nil {
+		st.RemoveGroup(groupId)
+	}
+}
+
+// Add or modify a meter on the switch
+func (self *OFSwitch) InstallMeter(meterMod *openflow13.MeterMod) {
+	log.Debugf("Install meter: %+v", meterMod)
+	if st := self.state(); st != nil {
+		st.AddMeter(meterMod)
+	}
+	self.Send(meterMod)
+}
+
+// Delete a meter from the switch
+func (self *OFSwitch) DeleteMeter(meterId uint32) {
+	meterMod := openflow13.NewMeterMod()
+	meterMod.Command = openflow13.OFPMC_DELETE
+	meterMod.MeterId = meterId
+
+	log.Debugf("Delete meter: %+v", meterMod)
+	self.Send(meterMod)
+	if st := self.state(); st != nil {
+		st.RemoveMeter(meterId)
+	}
+}
+
+// Returns the desired state of the switch kept by the controller, nil if
+// the switch was created outside of a controller
+func (self *OFSwitch) state() *SwitchState {
+	if self.ctrler == nil || self.ctrler.store == nil {
+		return nil
+	}
+	return self.ctrler.store.Switch(self.dpid)
+}
+// Send a multipart request and wait for all parts of its reply.
+// Returns the reply bodies of all the parts in the order received.
+func (self *OFSwitch) SendMultipartRequest(req *openflow13.MultipartRequest) ([]util.Message, error) {
+	tx := &mpTransaction{done: make(chan []util.Message, 1)}
+	failed := make(chan *openflow13.ErrorMsg, 1)
+
+	self.mpLock.Lock()
+	self.mpRequests[req.Xid] = tx
+	self.mpLock.Unlock()
+	self.setErrorHandler(req.Xid, func(errMsg *openflow13.ErrorMsg) { failed <- errMsg })
+	defer self.setErrorHandler(req.Xid, nil)
+
+	self.Send(req)
+
+	var err error
+	select {
+	case bodies := <-tx.done:
+		return bodies, nil
+	case errMsg := <-failed:
+		err = fmt.Errorf("Multipart request %d failed on switch %s, error type %d code %d",
+			req.Xid, self.dpid, errMsg.Type, errMsg.Code)
+	case <-time.After(multipartTimeout):
+		err = fmt.Errorf("Timed out waiting for multipart reply %d from switch %s", req.Xid, self.dpid)
+	}
+	self.mpLock.Lock()
+	delete(self.mpRequests, req.Xid)
+	self.mpLock.Unlock()
+	return nil, err
+}
+
+// Send a request, calling handler with the error it causes instead of
+// reporting the error. The handler is dropped after errorTimeout
+func (self *OFSwitch) sendHandlingError(req util.Message, xid uint32, handler func(*openflow13.ErrorMsg)) {
+	self.setErrorHandler(xid, handler)
+	time.AfterFunc(errorTimeout, func() { self.setErrorHandler(xid, nil) })
+	self.Send(req)
+}
+
+// Set the handler of the error caused by the request with xid, a nil
+// handler removes it
+func (self *OFSwitch) setErrorHandler(xid uint32, handler func(*openflow13.ErrorMsg)) {
+	self.errLock.Lock()
+	defer self.errLock.Unlock()
+	if handler == nil {
+		delete(self.errHandlers, xid)
+	} else {
+		self.errHandlers[xid] = handler
+	}
+}
+
+// Call the handler of the request an error is about. Returns false if the
+// request has none
+func (self *OFSwitch) handleError(errMsg *openflow13.ErrorMsg) bool {
+	self.errLock.Lock()
+	handler, ok := self.errHandlers[errMsg.Xid]
+	delete(self.errHandlers, errMsg.Xid)
+	self.errLock.Unlock()
+
+	if ok {
+		handler(errMsg)
+	}
+	return ok
+}
+
+// Add a multipart reply part to its outstanding request.
+// Returns false if the reply was not solicited by the library.
+func (self *OFSwitch) collectMultipartReply(rep *openflow13.MultipartReply) bool {
+	self.mpLock.Lock()
+	defer self.mpLock.Unlock()
+
+	tx, ok := self.mpRequests[rep.Xid]
+	if !ok {
+		return false
+	}
+
+	tx.bodies = append(tx.bodies, rep.Body...)
+	if rep.Flags&openflow13.OFPMPF_REPLY_MORE == 0 {
+		delete(self.mpRequests, rep.Xid)
+		tx.done <- tx.bodies
+	}
+	return true
+}
+
+// Dump the port counters of a port, P_ANY dumps all ports
+func (self *OFSwitch) DumpPortStats(portNo uint32) ([]*openflow13.PortStats, error) {
+	portReq := openflow13.NewPortStatsRequest()
+	portReq.PortNo = portNo
+	req := openflow13.NewMpRequest(openflow13.MultipartType_Port)
+	req.Body = portReq
+
+	bodies, err := self.SendMultipartRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*openflow13.PortStats, 0, len(bodies))
+	for _, body := range bodies {
+		if ps, ok := body.(*openflow13.PortStats); ok {
+			stats = append(stats, ps)
+		}
+	}
+	return stats, nil
+}
+
+// Dump the flows matching a flow stats request
+func (self *OFSwitch) DumpFlowStats(flowReq *openflow13.FlowStatsRequest) ([]*openflow13.FlowStats, error) {
+	req := openflow13.NewMpRequest(openflow13.MultipartType_Flow)
+	req.Body = flowReq
+
+	bodies, err := self.SendMultipartRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*openflow13.FlowStats, 0, len(bodies))
+	for _, body := range bodies {
+		if fs, ok := body.(*openflow13.FlowStats); ok {
+			stats = append(stats, fs)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,167 @@
+package ofctrl_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+var testDPID = net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+
+// App handing the switch it is connected to over a channel
+type testApp struct {
+	connected chan *ofctrl.OFSwitch
+}
+
+func newTestApp() *testApp {
+	return &testApp{connected: make(chan *ofctrl.OFSwitch, 1)}
+}
+
+func (app *testApp) SwitchConnected(sw *ofctrl.OFSwitch)                                     { app.connected <- sw }
+func (app *testApp) SwitchDisconnected(sw *ofctrl.OFSwitch)                                  {}
+func (app *testApp) PacketRcvd(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn)                {}
+func (app *testApp) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply)      {}
+func (app *testApp) PortStatusChange(sw *ofctrl.OFSwitch, portStatus *openflow13.PortStatus) {}
+func (app *testApp) FlowRemoved(sw *ofctrl.OFSwitch, flowRemoved *openflow13.FlowRemoved)    {}
+
+// Connect a fake switch to a new controller of app, returns the switch of
+// the controller once the app is notified
+func connectSwitch(t *testing.T, app ofctrl.ConsumerInterface, connected chan *ofctrl.OFSwitch) (*ofswitchtest.Switch, *ofctrl.OFSwitch) {
+	fake := ofswitchtest.NewSwitch(testDPID)
+	return fake, connectController(t, fake, ofctrl.NewController(app), connected)
+}
+
+// Connect a fake switch to a controller, returns the switch of the
+// controller once the app is notified
+func connectController(t *testing.T, fake *ofswitchtest.Switch, ctrler *ofctrl.Controller,
+	connected chan *ofctrl.OFSwitch) *ofctrl.OFSwitch {
+	if err := fake.ConnectController(ctrler); err != nil {
+		t.Fatalf("Error connecting the switch. Err: %v", err)
+	}
+	select {
+	case sw := <-connected:
+		return sw
+	case <-time.After(fake.Timeout):
+		fake.Close()
+		t.Fatalf("Switch connected is not notified")
+	}
+	return nil
+}
+
+func newTCPFlow(tableId uint8, priority uint16, port uint16, outPort uint32) *ofctrl.Flow {
+	flow := ofctrl.NewFlow(tableId)
+	flow.Match.Priority = priority
+	flow.Match.Ethertype = 0x0800
+	flow.Match.IpProto = 6
+	if port != 0 {
+		flow.Match.TcpDstPort = port
+	}
+	flow.SetOutputPortAction(outPort)
+	return flow
+}
+
+// A non-strict modify updates the Flow of every flow it selects
+func TestModifyFlow(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connectSwitch(t, app, app.connected)
+	defer fake.Close()
+
+	http := newTCPFlow(0, 100, 80, 1)
+	https := newTCPFlow(0, 200, 443, 1)
+	for _, flow := range []*ofctrl.Flow{http, https} {
+		if err := sw.InstallFlow(flow); err != nil {
+			t.Fatalf("Error installing flow. Err: %v", err)
+		}
+	}
+
+	if err := sw.ModifyFlow(newTCPFlow(0, 0, 0, 2)); err != nil {
+		t.Fatalf("Error modifying flows. Err: %v", err)
+	}
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:2")
+	fake.ExpectFlow(t, "table=0,priority=200,tcp,tp_dst=443,actions=output:2")
+
+	for _, flow := range []*ofctrl.Flow{http, https} {
+		got := openflow13.FormatInstructions(flow.GetFlowMod().Instructions)
+		if got != "output:2" {
+			t.Errorf("Wrong actions of modified flow %+v: got %s, want output:2", flow.Match, got)
+		}
+	}
+}
+
+// App notified of the flows removed from the switch
+type evictionApp struct {
+	*testApp
+	evicted chan *ofctrl.RemovedFlow
+}
+
+func (app *evictionApp) FlowEvicted(sw *ofctrl.OFSwitch, removed *ofctrl.RemovedFlow) {
+	app.evicted <- removed
+}
+
+// Flows the controller deletes are reported once the switch removed them
+func TestFlowEvictedOnDelete(t *testing.T) {
+	app := &evictionApp{testApp: newTestApp(), evicted: make(chan *ofctrl.RemovedFlow, 4)}
+	fake, sw := connectSwitch(t, app, app.connected)
+	defer fake.Close()
+
+	http := newTCPFlow(0, 100, 80, 1)
+	http.SendFlowRem = true
+	https := newTCPFlow(0, 100, 443, 1)
+	https.SendFlowRem = true
+	for _, flow := range []*ofctrl.Flow{http, https} {
+		if err := sw.InstallFlow(flow); err != nil {
+			t.Fatalf("Error installing flow. Err: %v", err)
+		}
+	}
+	fake.ExpectFlowCount(t, 2)
+
+	sw.DeleteFlowStrict(http)
+	sw.DeleteFlowsByMatch(0, ofctrl.FlowMatch{Ethertype: 0x0800, IpProto: 6})
+	// The messages of the switch are handled in order
+	for _, flow := range []*ofctrl.Flow{http, https} {
+		select {
+		case removed := <-app.evicted:
+			if removed.Flow != flow {
+				t.Errorf("Wrong flow evicted: got %+v, want %+v", removed.Flow.Match, flow.Match)
+			}
+			if removed.Reason != openflow13.RR_DELETE {
+				t.Errorf("Wrong reason of evicted flow: got %d, want %d", removed.Reason, openflow13.RR_DELETE)
+			}
+		case <-time.After(fake.Timeout):
+			t.Fatalf("Flow %+v is not evicted", flow.Match)
+		}
+	}
+}
+
+// Switches connecting while the switch of the controller disconnects, run
+// with -race
+func TestConcurrentConnections(t *testing.T) {
+	const count = 8
+	app := &testApp{connected: make(chan *ofctrl.OFSwitch, count+1)}
+	ctrler := ofctrl.NewController(app)
+	first := ofswitchtest.NewSwitch(testDPID)
+	connectController(t, first, ctrler, app.connected)
+
+	fakes := make([]*ofswitchtest.Switch, count)
+	var wg sync.WaitGroup
+	for i := range fakes {
+		fakes[i] = ofswitchtest.NewSwitch(net.HardwareAddr{0, 0, 0, 0, 0, 0, 1, byte(i)})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fakes[i].ConnectController(ctrler); err != nil {
+				t.Errorf("Error connecting switch %d. Err: %v", i, err)
+			}
+		}(i)
+	}
+	first.Close()
+	wg.Wait()
+	for _, fake := range fakes {
+		fake.Close()
+	}
+}
@@ -0,0 +1,149 @@
+package ofswitchtest
+
+// This file implements the assertions of tests on the fake switch. As the
+// controller installs flows asynchronously, they wait up to the switch
+// Timeout for the expected state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Wait for cond to be true, returns false on timeout
+func (s *Switch) waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(s.Timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Parse a flow of an assertion, fails the test on error
+func parseFlow(t testing.TB, flow string) *openflow13.FlowMod {
+	flowMod, err := openflow13.ParseFlowMod(flow)
+	if err != nil {
+		t.Fatalf("Error parsing flow %s. Err: %v", flow, err)
+	}
+	return flowMod
+}
+
+// Returns the installed flow with the table, priority and match of flowMod
+func (s *Switch) findFlow(flowMod *openflow13.FlowMod) *openflow13.FlowMod {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if entry, ok := s.flows[flowKey(flowMod)]; ok {
+		return entry.flowMod
+	}
+	return nil
+}
+
+func (s *Switch) dump() string {
+	flows := s.DumpFlows()
+	if len(flows) == 0 {
+		return "  (no flows)"
+	}
+	return "  " + strings.Join(flows, "\n  ")
+}
+
+// Expect a flow in the ovs-ofctl syntax to be installed, with the same
+// actions. The cookie is checked too when the flow has one
+func (s *Switch) ExpectFlow(t testing.TB, flow string) bool {
+	t.Helper()
+	want := parseFlow(t, flow)
+	wantActions := openflow13.FormatInstructions(want.Instructions)
+
+	ok := s.waitFor(func() bool {
+		got := s.findFlow(want)
+		return got != nil && openflow13.FormatInstructions(got.Instructions) == wantActions &&
+			(want.Cookie == 0 || got.Cookie == want.Cookie)
+	})
+	if !ok {
+		t.Errorf("Flow %s is not installed, flows:\n%s", flow, s.dump())
+	}
+	return ok
+}
+
+// Expect no flow with the table, priority and match of a flow in the
+// ovs-ofctl syntax. The actions are ignored
+func (s *Switch) ExpectNoFlow(t testing.TB, flow string) bool {
+	t.Helper()
+	want := parseFlow(t, flow)
+
+	ok := s.waitFor(func() bool { return s.findFlow(want) == nil })
+	if !ok {
+		t.Errorf("Flow %s is installed, flows:\n%s", flow, s.dump())
+	}
+	return ok
+}
+
+// Expect a number of flows installed on the switch
+func (s *Switch) ExpectFlowCount(t testing.TB, count int) bool {
+	t.Helper()
+	ok := s.waitFor(func() bool { return len(s.Flows()) == count })
+	if !ok {
+		t.Errorf("Expected %d flows, flows:\n%s", count, s.dump())
+	}
+	return ok
+}
+
+// Expect a group installed on the switch
+func (s *Switch) ExpectGroup(t testing.TB, groupId uint32) bool {
+	t.Helper()
+	ok := s.waitFor(func() bool { return s.Group(groupId) != nil })
+	if !ok {
+		t.Errorf("Group %d is not installed", groupId)
+	}
+	return ok
+}
+
+// Expect a group not to be installed on the switch
+func (s *Switch) ExpectNoGroup(t testing.TB, groupId uint32) bool {
+	t.Helper()
+	ok := s.waitFor(func() bool { return s.Group(groupId) == nil })
+	if !ok {
+		t.Errorf("Group %d is installed", groupId)
+	}
+	return ok
+}
+
+// Expect a meter installed on the switch
+func (s *Switch) ExpectMeter(t testing.TB, meterId uint32) bool {
+	t.Helper()
+	ok := s.waitFor(func() bool { return s.Meter(meterId) != nil })
+	if !ok {
+		t.Errorf("Meter %d is not installed", meterId)
+	}
+	return ok
+}
+
+// Expect a meter not to be installed on the switch
+func (s *Switch) ExpectNoMeter(t testing.TB, meterId uint32) bool {
+	t.Helper()
+	ok := s.waitFor(func() bool { return s.Meter(meterId) == nil })
+	if !ok {
+		t.Errorf("Meter %d is installed", meterId)
+	}
+	return ok
+}
+
+// Expect at least count packet outs from the controller and return them
+func (s *Switch) ExpectPacketOuts(t testing.TB, count int) []*openflow13.PacketOut {
+	t.Helper()
+	var pktOuts []*openflow13.PacketOut
+	ok := s.waitFor(func() bool {
+		pktOuts = s.PacketOuts()
+		return len(pktOuts) >= count
+	})
+	if !ok {
+		t.Errorf("Expected %d packet outs, received %d", count, len(pktOuts))
+	}
+	return pktOuts
+}
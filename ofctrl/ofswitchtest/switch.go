@@ -0,0 +1,749 @@
+// Package ofswitchtest provides a fake OpenFlow 1.3 switch to test
+// controllers and ofctrl apps without OVS.
+//
+// The switch performs the Hello/Features handshake, answers echo, barrier,
+// get config, role and multipart requests, and keeps the flows, groups and
+// meters it is sent in memory. Tests inject packet ins, port status and
+// error messages, and check what the controller installed:
+//
+//	ctrler := ofctrl.NewController(app)
+//	sw := ofswitchtest.NewSwitch(dpid)
+//	if err := sw.ConnectController(ctrler); err != nil {
+//		t.Fatal(err)
+//	}
+//	defer sw.Close()
+//	sw.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:2")
+package ofswitchtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/internal/switchutil"
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// A fake OpenFlow 1.3 switch
+type Switch struct {
+	DPID      net.HardwareAddr
+	NumTables uint8
+	// How long the handshake and the assertions wait
+	Timeout time.Duration
+
+	conn      net.Conn
+	outbound  chan []byte
+	connected chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	lock      sync.Mutex
+	flows     map[ofctrl.FlowKey]*flowEntry
+	groups    map[uint32]*openflow13.GroupMod
+	meters    map[uint32]*openflow13.MeterMod
+	portStats map[uint32]*openflow13.PortStats
+	config    openflow13.SwitchConfig
+	received  []util.Message
+	failures  map[uint8][]failure
+}
+
+// A flow installed on the switch
+type flowEntry struct {
+	flowMod     *openflow13.FlowMod
+	installed   time.Time
+	packetCount uint64
+	byteCount   uint64
+}
+
+// An error the switch answers a message with instead of applying it
+type failure struct {
+	errType uint16
+	code    uint16
+}
+
+// Create a fake switch with 254 tables
+func NewSwitch(dpid net.HardwareAddr) *Switch {
+	s := new(Switch)
+	s.DPID = dpid
+	s.NumTables = openflow13.OFPTT_MAX
+	s.Timeout = 2 * time.Second
+	s.outbound = make(chan []byte, 256)
+	s.connected = make(chan struct{})
+	s.done = make(chan struct{})
+	s.flows = make(map[ofctrl.FlowKey]*flowEntry)
+	s.groups = make(map[uint32]*openflow13.GroupMod)
+	s.meters = make(map[uint32]*openflow13.MeterMod)
+	s.portStats = make(map[uint32]*openflow13.PortStats)
+	s.config.MissSendLen = 0xffff
+	s.failures = make(map[uint8][]failure)
+	return s
+}
+
+// Connect to a controller listening on a TCP address, e.g. "127.0.0.1:6633"
+func (s *Switch) Connect(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Connect to a controller over a net.Pipe, the controller doesn't need to
+// listen
+func (s *Switch) ConnectController(ctrler *ofctrl.Controller) error {
+	conn, ctrlerConn := net.Pipe()
+	go ctrler.ServeConn(ctrlerConn)
+	return s.Serve(conn)
+}
+
+// Serve the controller at the other end of conn. Returns once the features
+// reply is sent or the handshake failed
+func (s *Switch) Serve(conn net.Conn) error {
+	s.conn = conn
+	go s.send()
+	go s.receive()
+
+	h, err := common.NewHello(openflow13.VERSION)
+	if err != nil {
+		return err
+	}
+	if err := s.Send(h); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.connected:
+		return nil
+	case <-s.done:
+		return errors.New("Connection closed during the handshake")
+	case <-time.After(s.Timeout):
+		s.Close()
+		return errors.New("Timeout waiting for the features request")
+	}
+}
+
+// Disconnect from the controller
+func (s *Switch) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	})
+}
+
+// Send a message to the controller
+func (s *Switch) Send(msg util.Message) error {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.write(data)
+}
+
+// Queue a marshaled message for the writer
+func (s *Switch) write(data []byte) error {
+	select {
+	case s.outbound <- data:
+		return nil
+	case <-s.done:
+		return errors.New("Switch is disconnected")
+	}
+}
+
+// Send a packet in from a port, as a table miss of table 0
+func (s *Switch) SendPacketIn(inPort uint32, pkt *protocol.Ethernet) error {
+	pktIn := openflow13.NewPacketIn()
+	pktIn.Reason = openflow13.R_NO_MATCH
+	pktIn.Match.AddField(*openflow13.NewInPortField(inPort))
+	pktIn.Data = *pkt
+	pktIn.TotalLen = pkt.Len()
+	return s.Send(pktIn)
+}
+
+// Send a port status message, reason is one of PR_ADD, PR_DELETE or
+// PR_MODIFY
+func (s *Switch) SendPortStatus(reason uint8, port openflow13.PhyPort) error {
+	status := openflow13.NewPortStatus()
+	status.Reason = reason
+	status.Desc = port
+	return s.Send(status)
+}
+
+// Send an error message not caused by any request
+func (s *Switch) SendError(errType, code uint16, data []byte) error {
+	errMsg := openflow13.NewErrorMsg()
+	errMsg.Type = errType
+	errMsg.Code = code
+	errMsg.Data = *util.NewBuffer(data)
+	return s.Send(errMsg)
+}
+
+// Reject the next message of a type, e.g. Type_FlowMod, with an error
+// instead of applying it
+func (s *Switch) FailNext(msgType uint8, errType, code uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failures[msgType] = append(s.failures[msgType], failure{errType, code})
+}
+
+// Install a flow in the ovs-ofctl syntax without the controller, e.g. a
+// flow left by a previous controller before connecting
+func (s *Switch) AddFlow(flow string) error {
+	flowMod, err := openflow13.ParseFlowMod(flow)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flows[flowKey(flowMod)] = &flowEntry{flowMod: flowMod, installed: time.Now()}
+	return nil
+}
+
+// Install a group without the controller
+func (s *Switch) AddGroup(groupMod *openflow13.GroupMod) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.groups[groupMod.GroupId] = groupMod
+}
+
+// Install a meter without the controller
+func (s *Switch) AddMeter(meterMod *openflow13.MeterMod) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.meters[meterMod.MeterId] = meterMod
+}
+
+// Set the counters the switch reports for a flow in the ovs-ofctl syntax
+func (s *Switch) SetFlowCounters(flow string, packetCount, byteCount uint64) error {
+	flowMod, err := openflow13.ParseFlowMod(flow)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.flows[flowKey(flowMod)]
+	if !ok {
+		return fmt.Errorf("Flow %s is not installed", flow)
+	}
+	entry.packetCount = packetCount
+	entry.byteCount = byteCount
+	return nil
+}
+
+// Set the counters the switch reports for a port
+func (s *Switch) SetPortStats(stats *openflow13.PortStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.portStats[stats.PortNo] = stats
+}
+
+// Returns the flow mods of the installed flows, sorted by table, priority
+// and match
+func (s *Switch) Flows() []*openflow13.FlowMod {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]ofctrl.FlowKey, 0, len(s.flows))
+	for key := range s.flows {
+		keys = append(keys, key)
+	}
+	ofctrl.SortFlowKeys(keys)
+
+	flows := make([]*openflow13.FlowMod, 0, len(keys))
+	for _, key := range keys {
+		flows = append(flows, s.flows[key].flowMod)
+	}
+	return flows
+}
+
+// Returns the installed flows in the ovs-ofctl syntax
+func (s *Switch) DumpFlows() []string {
+	var flows []string
+	for _, flowMod := range s.Flows() {
+		flows = append(flows, flowMod.String())
+	}
+	return flows
+}
+
+// Returns the group mod of an installed group, nil if there is none
+func (s *Switch) Group(groupId uint32) *openflow13.GroupMod {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.groups[groupId]
+}
+
+// Returns the meter mod of an installed meter, nil if there is none
+func (s *Switch) Meter(meterId uint32) *openflow13.MeterMod {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.meters[meterId]
+}
+
+// Returns all messages received from the controller, in order
+func (s *Switch) Received() []util.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]util.Message(nil), s.received...)
+}
+
+// Returns the packet outs received from the controller, in order
+func (s *Switch) PacketOuts() []*openflow13.PacketOut {
+	var pktOuts []*openflow13.PacketOut
+	for _, msg := range s.Received() {
+		if pktOut, ok := msg.(*openflow13.PacketOut); ok {
+			pktOuts = append(pktOuts, pktOut)
+		}
+	}
+	return pktOuts
+}
+
+// Write the outbound messages to the connection
+func (s *Switch) send() {
+	for {
+		select {
+		case data := <-s.outbound:
+			if _, err := s.conn.Write(data); err != nil {
+				s.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Read the messages of the controller and handle them in order
+func (s *Switch) receive() {
+	defer s.Close()
+	for {
+		data, err := switchutil.ReadMessage(s.conn)
+		if err != nil {
+			return
+		}
+		msg, err := openflow13.Parse(data)
+		if err != nil || msg == nil {
+			log.Warnf("ofswitchtest: unsupported message type %d", data[1])
+			s.replyError(data, openflow13.ET_BAD_REQUEST, openflow13.BRC_BAD_TYPE)
+			continue
+		}
+		s.handleMessage(msg, data)
+	}
+}
+
+func (s *Switch) handleMessage(msg util.Message, data []byte) {
+	xid := binary.BigEndian.Uint32(data[4:])
+	msgType := data[1]
+
+	s.lock.Lock()
+	s.received = append(s.received, msg)
+	var fail *failure
+	if failures := s.failures[msgType]; len(failures) > 0 {
+		fail = &failures[0]
+		s.failures[msgType] = failures[1:]
+	}
+	s.lock.Unlock()
+
+	if fail != nil {
+		s.replyError(data, fail.errType, fail.code)
+		return
+	}
+
+	switch m := msg.(type) {
+	case *common.Hello:
+		// Our hello was sent when connecting
+	case *common.Header:
+		switch m.Type {
+		case openflow13.Type_EchoRequest:
+			s.reply(openflow13.NewEchoReply(), xid)
+		case openflow13.Type_FeaturesRequest:
+			s.reply(s.features(), xid)
+			select {
+			case <-s.connected:
+			default:
+				close(s.connected)
+			}
+		case openflow13.Type_BarrierRequest:
+			res := openflow13.NewOfp13Header()
+			res.Type = openflow13.Type_BarrierReply
+			s.reply(&res, xid)
+		case openflow13.Type_GetConfigRequest:
+			s.lock.Lock()
+			res := s.config
+			s.lock.Unlock()
+			res.Header = openflow13.NewOfp13Header()
+			res.Header.Type = openflow13.Type_GetConfigReply
+			s.reply(&res, xid)
+		}
+	case *openflow13.SwitchConfig:
+		s.lock.Lock()
+		s.config.Flags = m.Flags
+		s.config.MissSendLen = m.MissSendLen
+		s.lock.Unlock()
+	case *openflow13.RoleRequest:
+		s.reply(openflow13.NewRoleReply(m.Role, m.GenerationId), xid)
+	case *openflow13.FlowMod:
+		s.flowMod(m, data)
+	case *openflow13.GroupMod:
+		s.groupMod(m, data)
+	case *openflow13.MeterMod:
+		s.meterMod(m, data)
+	case *openflow13.MultipartRequest:
+		s.multipartRequest(m, data)
+	}
+}
+
+// Send a reply to the request with xid
+func (s *Switch) reply(msg util.Message, xid uint32) {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		log.Errorf("ofswitchtest: error marshaling reply. Err: %v", err)
+		return
+	}
+	binary.BigEndian.PutUint32(data[4:], xid)
+	s.write(data)
+}
+
+// Send the error caused by a request, with the start of the request as data
+func (s *Switch) replyError(request []byte, errType, code uint16) {
+	if len(request) > 64 {
+		request = request[:64]
+	}
+	errMsg := openflow13.NewErrorMsg()
+	errMsg.Type = errType
+	errMsg.Code = code
+	errMsg.Data = *util.NewBuffer(append([]byte(nil), request...))
+	s.reply(errMsg, binary.BigEndian.Uint32(request[4:]))
+}
+
+func (s *Switch) features() *openflow13.SwitchFeatures {
+	res := openflow13.NewFeaturesReply()
+	copy(res.DPID, s.DPID)
+	res.Buffers = 0
+	res.NumTables = s.NumTables
+	res.Capabilities = openflow13.C_FLOW_STATS | openflow13.C_TABLE_STATS |
+		openflow13.C_PORT_STATS | openflow13.C_GROUP_STATS
+	return res
+}
+
+func flowKey(flowMod *openflow13.FlowMod) ofctrl.FlowKey {
+	return ofctrl.NewFlowKey(flowMod.TableId, flowMod.Priority, flowMod.Match)
+}
+
+// Apply a flow mod the way a switch does
+func (s *Switch) flowMod(flowMod *openflow13.FlowMod, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if flowMod.Command == openflow13.FC_ADD || flowMod.Command == openflow13.FC_MODIFY ||
+		flowMod.Command == openflow13.FC_MODIFY_STRICT {
+		if flowMod.TableId >= s.NumTables {
+			s.replyError(data, openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_BAD_TABLE_ID)
+			return
+		}
+		if errType, code, ok := s.checkInstructions(flowMod.Instructions); !ok {
+			s.replyError(data, errType, code)
+			return
+		}
+	}
+
+	switch flowMod.Command {
+	case openflow13.FC_ADD:
+		if flowMod.Flags&openflow13.FF_CHECK_OVERLAP != 0 {
+			for _, other := range s.flows {
+				if switchutil.Overlaps(flowMod, other.flowMod) {
+					s.replyError(data, openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_OVERLAP)
+					return
+				}
+			}
+		}
+		entry := &flowEntry{flowMod: flowMod, installed: time.Now()}
+		if old, ok := s.flows[flowKey(flowMod)]; ok && flowMod.Flags&openflow13.FF_RESET_COUNTS == 0 {
+			entry.packetCount = old.packetCount
+			entry.byteCount = old.byteCount
+		}
+		s.flows[flowKey(flowMod)] = entry
+	case openflow13.FC_MODIFY, openflow13.FC_MODIFY_STRICT:
+		for _, entry := range s.flows {
+			if !switchutil.Selects(flowMod, entry.flowMod) {
+				continue
+			}
+			modified := *entry.flowMod
+			modified.Instructions = flowMod.Instructions
+			entry.flowMod = &modified
+			if flowMod.Flags&openflow13.FF_RESET_COUNTS != 0 {
+				entry.packetCount = 0
+				entry.byteCount = 0
+			}
+		}
+	case openflow13.FC_DELETE, openflow13.FC_DELETE_STRICT:
+		for key, entry := range s.flows {
+			if !switchutil.Selects(flowMod, entry.flowMod) {
+				continue
+			}
+			delete(s.flows, key)
+			if entry.flowMod.Flags&openflow13.FF_SEND_FLOW_REM != 0 {
+				s.Send(entry.flowRemoved(openflow13.RR_DELETE))
+			}
+		}
+	default:
+		s.replyError(data, openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_BAD_COMMAND)
+	}
+}
+
+// Check the groups and meters the instructions refer to exist. Must be
+// called with the switch lock held
+func (s *Switch) checkInstructions(instrs []openflow13.Instruction) (uint16, uint16, bool) {
+	for _, instr := range instrs {
+		switch instr := instr.(type) {
+		case *openflow13.InstrMeter:
+			if _, ok := s.meters[instr.MeterId]; !ok {
+				return openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_UNKNOWN_METER, false
+			}
+		case *openflow13.InstrGotoTable:
+			if instr.TableId >= s.NumTables {
+				return openflow13.ET_BAD_INSTRUCTION, openflow13.BIC_BAD_TABLE_ID, false
+			}
+		case *openflow13.InstrActions:
+			if !s.checkActions(instr.Actions) {
+				return openflow13.ET_BAD_ACTION, openflow13.BAC_BAD_OUT_GROUP, false
+			}
+		}
+	}
+	return 0, 0, true
+}
+
+// Must be called with the switch lock held
+func (s *Switch) checkActions(actions []openflow13.Action) bool {
+	for _, act := range actions {
+		if group, ok := act.(*openflow13.ActionGroup); ok {
+			if _, ok := s.groups[group.GroupId]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (entry *flowEntry) flowRemoved(reason uint8) *openflow13.FlowRemoved {
+	duration := time.Since(entry.installed)
+	msg := openflow13.NewFlowRemoved()
+	msg.Cookie = entry.flowMod.Cookie
+	msg.Priority = entry.flowMod.Priority
+	msg.Reason = reason
+	msg.TableId = entry.flowMod.TableId
+	msg.DurationSec = uint32(duration / time.Second)
+	msg.DurationNSec = uint32(duration % time.Second)
+	msg.IdleTimeout = entry.flowMod.IdleTimeout
+	msg.HardTimeout = entry.flowMod.HardTimeout
+	msg.PacketCount = entry.packetCount
+	msg.ByteCount = entry.byteCount
+	msg.Match = entry.flowMod.Match
+	return msg
+}
+
+func (entry *flowEntry) flowStats() *openflow13.FlowStats {
+	duration := time.Since(entry.installed)
+	stats := openflow13.NewFlowStats()
+	stats.TableId = entry.flowMod.TableId
+	stats.DurationSec = uint32(duration / time.Second)
+	stats.DurationNSec = uint32(duration % time.Second)
+	stats.Priority = entry.flowMod.Priority
+	stats.IdleTimeout = entry.flowMod.IdleTimeout
+	stats.HardTimeout = entry.flowMod.HardTimeout
+	stats.Flags = entry.flowMod.Flags
+	stats.Cookie = entry.flowMod.Cookie
+	stats.PacketCount = entry.packetCount
+	stats.ByteCount = entry.byteCount
+	stats.Match = entry.flowMod.Match
+	stats.Instructions = entry.flowMod.Instructions
+	stats.Length = stats.Len()
+	return stats
+}
+
+func (s *Switch) groupMod(groupMod *openflow13.GroupMod, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, exists := s.groups[groupMod.GroupId]
+	switch groupMod.Command {
+	case openflow13.OFPGC_ADD, openflow13.OFPGC_MODIFY:
+		if groupMod.Command == openflow13.OFPGC_ADD && exists {
+			s.replyError(data, openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_GROUP_EXISTS)
+			return
+		}
+		if groupMod.Command == openflow13.OFPGC_MODIFY && !exists {
+			s.replyError(data, openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_UNKNOWN_GROUP)
+			return
+		}
+		for _, bucket := range groupMod.Buckets {
+			if !s.checkActions(bucket.Actions) {
+				s.replyError(data, openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_BAD_BUCKET)
+				return
+			}
+		}
+		s.groups[groupMod.GroupId] = groupMod
+	case openflow13.OFPGC_DELETE:
+		if groupMod.GroupId == openflow13.OFPG_ALL {
+			s.groups = make(map[uint32]*openflow13.GroupMod)
+		} else {
+			delete(s.groups, groupMod.GroupId)
+		}
+	default:
+		s.replyError(data, openflow13.ET_GROUP_MOD_FAILED, openflow13.GMFC_BAD_COMMAND)
+	}
+}
+
+func (s *Switch) meterMod(meterMod *openflow13.MeterMod, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, exists := s.meters[meterMod.MeterId]
+	switch meterMod.Command {
+	case openflow13.OFPMC_ADD:
+		if exists {
+			s.replyError(data, openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_METER_EXISTS)
+			return
+		}
+		s.meters[meterMod.MeterId] = meterMod
+	case openflow13.OFPMC_MODIFY:
+		if !exists {
+			s.replyError(data, openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_UNKNOWN_METER)
+			return
+		}
+		s.meters[meterMod.MeterId] = meterMod
+	case openflow13.OFPMC_DELETE:
+		if meterMod.MeterId == openflow13.OFPM_ALL {
+			s.meters = make(map[uint32]*openflow13.MeterMod)
+		} else {
+			delete(s.meters, meterMod.MeterId)
+		}
+	default:
+		s.replyError(data, openflow13.ET_METER_MOD_FAILED, openflow13.MMFC_BAD_COMMAND)
+	}
+}
+
+func (s *Switch) multipartRequest(req *openflow13.MultipartRequest, data []byte) {
+	var bodies []util.Message
+	switch req.Type {
+	case openflow13.MultipartType_Desc:
+		desc := openflow13.NewDescStats()
+		copy(desc.MfrDesc, "libOpenflow")
+		copy(desc.HWDesc, "ofswitchtest")
+		copy(desc.SWDesc, "ofswitchtest")
+		copy(desc.DPDesc, s.DPID.String())
+		bodies = append(bodies, desc)
+	case openflow13.MultipartType_Flow:
+		for _, entry := range s.selectFlows(req.Body.(*openflow13.FlowStatsRequest)) {
+			bodies = append(bodies, entry.flowStats())
+		}
+	case openflow13.MultipartType_Aggregate:
+		flowReq := switchutil.AggregateFilter(req.Body.(*openflow13.AggregateStatsRequest))
+		stats := openflow13.NewAggregateStats()
+		for _, entry := range s.selectFlows(flowReq) {
+			stats.PacketCount += entry.packetCount
+			stats.ByteCount += entry.byteCount
+			stats.FlowCount++
+		}
+		bodies = append(bodies, stats)
+	case openflow13.MultipartType_Table:
+		s.lock.Lock()
+		active := make(map[uint8]uint32)
+		for _, entry := range s.flows {
+			active[entry.flowMod.TableId]++
+		}
+		s.lock.Unlock()
+		for tableId := 0; tableId < int(s.NumTables); tableId++ {
+			stats := openflow13.NewTableStats()
+			stats.TableId = uint8(tableId)
+			stats.ActiveCount = active[uint8(tableId)]
+			bodies = append(bodies, stats)
+		}
+	case openflow13.MultipartType_GroupDesc:
+		s.lock.Lock()
+		for _, groupId := range sortedIds(s.groups) {
+			bodies = append(bodies, openflow13.NewGroupDesc(s.groups[groupId]))
+		}
+		s.lock.Unlock()
+	case openflow13.MultipartType_MeterConfig:
+		meterId := req.Body.(*openflow13.MeterMultipartRequest).MeterId
+		s.lock.Lock()
+		for _, id := range sortedIds(s.meters) {
+			if meterId == openflow13.OFPM_ALL || id == meterId {
+				bodies = append(bodies, openflow13.NewMeterConfig(s.meters[id]))
+			}
+		}
+		s.lock.Unlock()
+	case openflow13.MultipartType_Port:
+		portNo := req.Body.(*openflow13.PortStatsRequest).PortNo
+		s.lock.Lock()
+		var ports []uint32
+		for port := range s.portStats {
+			if portNo == openflow13.P_ANY || port == portNo {
+				ports = append(ports, port)
+			}
+		}
+		sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+		for _, port := range ports {
+			bodies = append(bodies, s.portStats[port])
+		}
+		s.lock.Unlock()
+	default:
+		s.replyError(data, openflow13.ET_BAD_REQUEST, openflow13.BRC_BAD_MULTIPART)
+		return
+	}
+	for _, rep := range switchutil.MultipartReplies(req.Type, bodies) {
+		s.reply(rep, req.Xid)
+	}
+}
+
+// Returns the ids of the groups or meters of the switch, sorted
+func sortedIds(mods interface{}) []uint32 {
+	var ids []uint32
+	switch mods := mods.(type) {
+	case map[uint32]*openflow13.GroupMod:
+		for id := range mods {
+			ids = append(ids, id)
+		}
+	case map[uint32]*openflow13.MeterMod:
+		for id := range mods {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Returns the flows a flow stats request selects, sorted
+func (s *Switch) selectFlows(req *openflow13.FlowStatsRequest) []*flowEntry {
+	filter := switchutil.StatsFilter(req)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var keys []ofctrl.FlowKey
+	for key, entry := range s.flows {
+		if switchutil.Selects(filter, entry.flowMod) {
+			keys = append(keys, key)
+		}
+	}
+	ofctrl.SortFlowKeys(keys)
+
+	entries := make([]*flowEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, s.flows[key])
+	}
+	return entries
+}
@@ -0,0 +1,223 @@
+package ofswitchtest_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+var testDPID = net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+
+// App handing its events over channels
+type testApp struct {
+	connected chan *ofctrl.OFSwitch
+	packets   chan *openflow13.PacketIn
+	errors    chan *openflow13.ErrorMsg
+}
+
+func newTestApp() *testApp {
+	return &testApp{
+		connected: make(chan *ofctrl.OFSwitch, 1),
+		packets:   make(chan *openflow13.PacketIn, 16),
+		errors:    make(chan *openflow13.ErrorMsg, 16),
+	}
+}
+
+func (app *testApp) SwitchConnected(sw *ofctrl.OFSwitch)                                     { app.connected <- sw }
+func (app *testApp) SwitchDisconnected(sw *ofctrl.OFSwitch)                                  {}
+func (app *testApp) PacketRcvd(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn)                { app.packets <- pkt }
+func (app *testApp) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply)      {}
+func (app *testApp) PortStatusChange(sw *ofctrl.OFSwitch, portStatus *openflow13.PortStatus) {}
+func (app *testApp) FlowRemoved(sw *ofctrl.OFSwitch, flowRemoved *openflow13.FlowRemoved)    {}
+func (app *testApp) ErrorRcvd(sw *ofctrl.OFSwitch, errMsg *openflow13.ErrorMsg)              { app.errors <- errMsg }
+
+// Connect a fake switch to a new controller, returns the switch of the
+// controller once the app is notified
+func connect(t *testing.T, app *testApp) (*ofswitchtest.Switch, *ofctrl.OFSwitch) {
+	ctrler := ofctrl.NewController(app)
+	if _, err := ctrler.Events().Subscribe(ofctrl.ErrorListener(app)); err != nil {
+		t.Fatalf("Error subscribing the app. Err: %v", err)
+	}
+	fake := ofswitchtest.NewSwitch(testDPID)
+	if err := fake.ConnectController(ctrler); err != nil {
+		t.Fatalf("Error connecting the switch. Err: %v", err)
+	}
+	select {
+	case sw := <-app.connected:
+		return fake, sw
+	case <-time.After(fake.Timeout):
+		fake.Close()
+		t.Fatalf("Switch connected is not notified")
+	}
+	return nil, nil
+}
+
+func newTCPFlow(priority, port uint16, outPort uint32) *ofctrl.Flow {
+	flow := ofctrl.NewFlow(0)
+	flow.Match.Priority = priority
+	flow.Match.Ethertype = 0x0800
+	flow.Match.IpProto = 6
+	flow.Match.TcpDstPort = port
+	flow.SetOutputPortAction(outPort)
+	return flow
+}
+
+func TestHandshake(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connect(t, app)
+	defer fake.Close()
+
+	if sw.DPID().String() != testDPID.String() {
+		t.Errorf("Wrong dpid: got %s, want %s", sw.DPID(), testDPID)
+	}
+	features := sw.Features()
+	if features == nil || features.NumTables != fake.NumTables {
+		t.Errorf("Wrong features reply: %+v", features)
+	}
+}
+
+func TestInstallFlow(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connect(t, app)
+	defer fake.Close()
+
+	if err := sw.InstallFlow(newTCPFlow(100, 80, 2)); err != nil {
+		t.Fatalf("Error installing flow. Err: %v", err)
+	}
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:2")
+	fake.ExpectFlowCount(t, 1)
+}
+
+// A strict delete removes one flow, a delete by match all the flows the
+// match covers whatever their priority
+func TestDeleteFlows(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connect(t, app)
+	defer fake.Close()
+
+	http := newTCPFlow(100, 80, 1)
+	for _, flow := range []*ofctrl.Flow{http, newTCPFlow(200, 80, 1), newTCPFlow(100, 443, 1)} {
+		if err := sw.InstallFlow(flow); err != nil {
+			t.Fatalf("Error installing flow. Err: %v", err)
+		}
+	}
+	fake.ExpectFlowCount(t, 3)
+
+	sw.DeleteFlowStrict(http)
+	fake.ExpectNoFlow(t, "table=0,priority=100,tcp,tp_dst=80")
+	fake.ExpectFlowCount(t, 2)
+
+	sw.DeleteFlowsByMatch(0, ofctrl.FlowMatch{Ethertype: 0x0800, IpProto: 6, TcpDstPort: 80})
+	fake.ExpectNoFlow(t, "table=0,priority=200,tcp,tp_dst=80")
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=443,actions=output:1")
+	fake.ExpectFlowCount(t, 1)
+}
+
+// Deletes and flow stats requests filter on out_port, adds checking
+// overlaps fail on an overlapping flow
+func TestFlowModFilters(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connect(t, app)
+	defer fake.Close()
+
+	for _, flow := range []*ofctrl.Flow{newTCPFlow(100, 80, 1), newTCPFlow(100, 443, 2)} {
+		if err := sw.InstallFlow(flow); err != nil {
+			t.Fatalf("Error installing flow. Err: %v", err)
+		}
+	}
+	fake.ExpectFlowCount(t, 2)
+
+	flowReq := openflow13.NewFlowStatsRequest()
+	flowReq.TableId = openflow13.OFPTT_ALL
+	flowReq.OutPort = 2
+	stats, err := sw.DumpFlowStats(flowReq)
+	if err != nil {
+		t.Fatalf("Error dumping flows. Err: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Match.String() != "tcp,tp_dst=443" {
+		t.Errorf("Wrong flows output to port 2: %v", stats)
+	}
+
+	overlap := newTCPFlow(100, 0, 3)
+	overlap.CheckOverlap = true
+	if err := sw.InstallFlow(overlap); err != nil {
+		t.Fatalf("Error installing flow. Err: %v", err)
+	}
+	select {
+	case errMsg := <-app.errors:
+		if errMsg.Type != openflow13.ET_FLOW_MOD_FAILED || errMsg.Code != openflow13.FMFC_OVERLAP {
+			t.Errorf("Wrong error: type %d code %d", errMsg.Type, errMsg.Code)
+		}
+	case <-time.After(fake.Timeout):
+		t.Errorf("Overlapping flow is not refused")
+	}
+
+	del := openflow13.NewFlowMod()
+	del.Command = openflow13.FC_DELETE
+	del.TableId = openflow13.OFPTT_ALL
+	del.OutPort = 1
+	del.OutGroup = openflow13.OFPG_ANY
+	sw.Send(del)
+	fake.ExpectNoFlow(t, "table=0,priority=100,tcp,tp_dst=80")
+	fake.ExpectFlowCount(t, 1)
+}
+
+// An error injected by FailNext reaches the controller and the message is
+// not applied, the next one is
+func TestFailNext(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connect(t, app)
+	defer fake.Close()
+
+	fake.FailNext(openflow13.Type_FlowMod, openflow13.ET_FLOW_MOD_FAILED, openflow13.FMFC_TABLE_FULL)
+	if err := sw.InstallFlow(newTCPFlow(100, 80, 1)); err != nil {
+		t.Fatalf("Error installing flow. Err: %v", err)
+	}
+	select {
+	case errMsg := <-app.errors:
+		if errMsg.Type != openflow13.ET_FLOW_MOD_FAILED || errMsg.Code != openflow13.FMFC_TABLE_FULL {
+			t.Errorf("Wrong error: type %d code %d", errMsg.Type, errMsg.Code)
+		}
+	case <-time.After(fake.Timeout):
+		t.Fatalf("Error is not received")
+	}
+	fake.ExpectFlowCount(t, 0)
+
+	if err := sw.InstallFlow(newTCPFlow(100, 80, 1)); err != nil {
+		t.Fatalf("Error installing flow. Err: %v", err)
+	}
+	fake.ExpectFlowCount(t, 1)
+}
+
+func TestPacketIn(t *testing.T) {
+	app := newTestApp()
+	fake, _ := connect(t, app)
+	defer fake.Close()
+
+	eth := protocol.NewEthernet()
+	eth.HWSrc = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	eth.HWDst = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	eth.Ethertype = 0x0806
+	arp, _ := protocol.NewARP(protocol.Type_Request)
+	eth.Data = arp
+	if err := fake.SendPacketIn(3, eth); err != nil {
+		t.Fatalf("Error sending packet in. Err: %v", err)
+	}
+
+	select {
+	case pkt := <-app.packets:
+		if pkt.Data.Ethertype != 0x0806 || pkt.Data.HWSrc.String() != eth.HWSrc.String() {
+			t.Errorf("Wrong packet: %+v", pkt.Data)
+		}
+		if pkt.Match.String() != "in_port=3" {
+			t.Errorf("Wrong packet in match: %s", pkt.Match.String())
+		}
+	case <-time.After(fake.Timeout):
+		t.Fatalf("Packet in is not received")
+	}
+}
@@ -0,0 +1,69 @@
+package ofctrl
+
+// This file implements sending packets out of a switch
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+// Send a packet out of the switch. The actions are applied to the packet
+// in order, an output to openflow13.P_TABLE submits the packet to the flow
+// tables as if it was received on inPort. An inPort of 0 stands for
+// openflow13.P_CONTROLLER, i.e. a packet built by the controller.
+func (self *OFSwitch) SendPacket(inPort uint32, actions []openflow13.Action, pkt *protocol.Ethernet) error {
+	if pkt == nil {
+		return errors.New("No packet to send")
+	}
+	pktOut, err := newPacketOut(openflow13.OFP_NO_BUFFER, inPort, actions)
+	if err != nil {
+		return err
+	}
+	pktOut.Data = pkt
+
+	log.Debugf("Send packet out of switch %s: %+v", self.dpid, pktOut)
+	self.Send(pktOut)
+	return nil
+}
+
+// Release a packet buffered on the switch, as reported by the BufferId of
+// a PacketIn, applying the actions to it. inPort is the InPort of the
+// PacketIn, it is used when the packet is submitted to openflow13.P_TABLE.
+func (self *OFSwitch) ReleaseBuffer(bufferId uint32, inPort uint32, actions []openflow13.Action) error {
+	if bufferId == openflow13.OFP_NO_BUFFER {
+		return errors.New("Packet is not buffered on the switch")
+	}
+	pktOut, err := newPacketOut(bufferId, inPort, actions)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Release buffer %d of switch %s: %+v", bufferId, self.dpid, pktOut)
+	self.Send(pktOut)
+	return nil
+}
+
+func newPacketOut(bufferId uint32, inPort uint32, actions []openflow13.Action) (*openflow13.PacketOut, error) {
+	if inPort == 0 {
+		inPort = openflow13.P_CONTROLLER
+	}
+
+	pktOut := openflow13.NewPacketOut()
+	pktOut.BufferId = bufferId
+	pktOut.InPort = inPort
+	for _, act := range actions {
+		if act == nil {
+			return nil, errors.New("Nil action in packet out")
+		}
+		// The flow tables need a valid input port to look the packet up
+		if output, ok := act.(*openflow13.ActionOutput); ok &&
+			output.Port == openflow13.P_TABLE && inPort == openflow13.P_ANY {
+			return nil, errors.New("Output to table requires an input port")
+		}
+		pktOut.AddAction(act)
+	}
+	return pktOut, nil
+}
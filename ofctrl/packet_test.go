@@ -0,0 +1,82 @@
+package ofctrl_test
+
+import (
+	"testing"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+func TestSendPacket(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connectSwitch(t, app, app.connected)
+	defer fake.Close()
+
+	output := []openflow13.Action{openflow13.NewActionOutput(2)}
+	if err := sw.SendPacket(0, output, newIPPacket(17)); err != nil {
+		t.Fatalf("Error sending packet. Err: %v", err)
+	}
+	toTable := []openflow13.Action{openflow13.NewActionOutput(openflow13.P_TABLE)}
+	if err := sw.ReleaseBuffer(42, 3, toTable); err != nil {
+		t.Fatalf("Error releasing buffer. Err: %v", err)
+	}
+
+	pktOuts := fake.ExpectPacketOuts(t, 2)
+	if len(pktOuts) != 2 {
+		t.FailNow()
+	}
+	tests := []struct {
+		name     string
+		bufferId uint32
+		inPort   uint32
+		outPort  uint32
+		hasData  bool
+	}{
+		{"sent packet", openflow13.OFP_NO_BUFFER, openflow13.P_CONTROLLER, 2, true},
+		{"released buffer", 42, 3, openflow13.P_TABLE, false},
+	}
+	for i, test := range tests {
+		pktOut := pktOuts[i]
+		if pktOut.BufferId != test.bufferId || pktOut.InPort != test.inPort {
+			t.Errorf("Wrong buffer or in port of %s: got %d %d, want %d %d", test.name,
+				pktOut.BufferId, pktOut.InPort, test.bufferId, test.inPort)
+		}
+		if len(pktOut.Actions) != 1 {
+			t.Errorf("Wrong actions of %s: %+v", test.name, pktOut.Actions)
+		} else if out, ok := pktOut.Actions[0].(*openflow13.ActionOutput); !ok || out.Port != test.outPort {
+			t.Errorf("Wrong output of %s: got %+v, want port %d", test.name, pktOut.Actions[0], test.outPort)
+		}
+		if _, ok := pktOut.Data.(*protocol.Ethernet); ok != test.hasData {
+			t.Errorf("Wrong data of %s: got %T", test.name, pktOut.Data)
+		}
+	}
+}
+
+// Invalid packet outs are rejected before being sent
+func TestSendPacketErrors(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connectSwitch(t, app, app.connected)
+	defer fake.Close()
+
+	output := []openflow13.Action{openflow13.NewActionOutput(2)}
+	toTable := []openflow13.Action{openflow13.NewActionOutput(openflow13.P_TABLE)}
+	tests := []struct {
+		name string
+		send func() error
+	}{
+		{"no packet", func() error { return sw.SendPacket(1, output, nil) }},
+		{"nil action", func() error { return sw.SendPacket(1, []openflow13.Action{nil}, newPacket(0x0800)) }},
+		{"table without in port", func() error { return sw.SendPacket(openflow13.P_ANY, toTable, newPacket(0x0800)) }},
+		{"unbuffered release", func() error { return sw.ReleaseBuffer(openflow13.OFP_NO_BUFFER, 1, output) }},
+		{"release to table without in port", func() error { return sw.ReleaseBuffer(42, openflow13.P_ANY, toTable) }},
+	}
+
+	for _, test := range tests {
+		if err := test.send(); err == nil {
+			t.Errorf("Wrong result of %s: got no error", test.name)
+		}
+	}
+	if pktOuts := fake.PacketOuts(); len(pktOuts) != 0 {
+		t.Errorf("Wrong packet outs sent: %+v", pktOuts)
+	}
+}
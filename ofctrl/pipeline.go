@@ -0,0 +1,221 @@
+package ofctrl
+
+// This file implements declaring the tables of the switches pipeline
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// What a table does with the packets no flow matches
+type MissAction int
+
+const (
+	MissDrop       MissAction = iota // Drop the packets
+	MissController                   // Send the packets to the controller
+	MissNext                         // Continue in the next table of the pipeline
+	MissNormal                       // Switch normal processing
+)
+
+// A table of the pipeline
+type PipelineTable struct {
+	Name  string     // Unique name of the table
+	Id    uint8      // Openflow table id
+	Miss  MissAction // What to do with the packets no flow matches
+	Owner string     // Module owning the table
+}
+
+// The tables of the switches in the order packets go through them. Table
+// ids increase along the pipeline since a flow can only go to a later
+// table. When set on a controller, the table-miss flows are installed on
+// every connecting switch and the flows installed are checked against the
+// pipeline.
+type Pipeline struct {
+	lock   sync.RWMutex
+	tables []*PipelineTable
+	byName map[string]*PipelineTable
+	byId   map[uint8]*PipelineTable
+}
+
+// Create an empty pipeline
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		byName: make(map[string]*PipelineTable),
+		byId:   make(map[uint8]*PipelineTable),
+	}
+}
+
+// Add a table at the end of the pipeline
+func (p *Pipeline) AddTable(name string, id uint8, miss MissAction, owner string) (*PipelineTable, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.byName[name]; ok {
+		return nil, fmt.Errorf("Duplicate pipeline table %s", name)
+	}
+	if t, ok := p.byId[id]; ok {
+		return nil, fmt.Errorf("Table %d of %s already used by %s", id, name, t.Name)
+	}
+	if n := len(p.tables); n > 0 && id < p.tables[n-1].Id {
+		return nil, fmt.Errorf("Table %s: id %d is before the last table %s (%d)",
+			name, id, p.tables[n-1].Name, p.tables[n-1].Id)
+	}
+
+	t := &PipelineTable{Name: name, Id: id, Miss: miss, Owner: owner}
+	p.tables = append(p.tables, t)
+	p.byName[name] = t
+	p.byId[id] = t
+	return t, nil
+}
+
+// Returns a table by name, nil if unknown
+func (p *Pipeline) Table(name string) *PipelineTable {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.byName[name]
+}
+
+// Returns the tables in pipeline order
+func (p *Pipeline) Tables() []*PipelineTable {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return append([]*PipelineTable(nil), p.tables...)
+}
+
+// Returns the tables owned by a module
+func (p *Pipeline) TablesOf(owner string) []*PipelineTable {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	var tables []*PipelineTable
+	for _, t := range p.tables {
+		if t.Owner == owner {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// Create a flow in a named table
+func (p *Pipeline) NewFlow(table string) (*Flow, error) {
+	t := p.Table(table)
+	if t == nil {
+		return nil, fmt.Errorf("Unknown pipeline table %s", table)
+	}
+	return NewFlow(t.Id), nil
+}
+
+// Make a flow continue in a named table
+func (p *Pipeline) SetGotoTable(flow *Flow, table string) error {
+	t := p.Table(table)
+	if t == nil {
+		return fmt.Errorf("Unknown pipeline table %s", table)
+	}
+	if err := p.checkGoto(flow.TableId, t.Id); err != nil {
+		return err
+	}
+	flow.SetGotoTableAction(t.Id)
+	return nil
+}
+
+// Check the pipeline fits a switch with numTables tables. A numTables of
+// 0 skips the check of the table ids
+func (p *Pipeline) Validate(numTables uint8) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for i, t := range p.tables {
+		if numTables != 0 && t.Id >= numTables {
+			return fmt.Errorf("Table %s: id %d is beyond the %d tables of the switch",
+				t.Name, t.Id, numTables)
+		}
+		if t.Miss == MissNext && i == len(p.tables)-1 {
+			return fmt.Errorf("Table %s: last table of the pipeline has no next table", t.Name)
+		}
+	}
+	return nil
+}
+
+// Check a flow is in a table of the pipeline and only goes to a later one
+func (p *Pipeline) ValidateFlow(flow *Flow) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if _, ok := p.byId[flow.TableId]; !ok {
+		return fmt.Errorf("Table %d is not part of the pipeline", flow.TableId)
+	}
+
+	flow.lock.RLock()
+	defer flow.lock.RUnlock()
+	for _, flowOut := range flow.FlowOutput {
+		if flowOut.OutputType != OutputGotoTable {
+			continue
+		}
+		if err := p.checkGotoLocked(flow.TableId, flowOut.TblId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) checkGoto(from, to uint8) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.checkGotoLocked(from, to)
+}
+
+func (p *Pipeline) checkGotoLocked(from, to uint8) error {
+	src, ok := p.byId[from]
+	if !ok {
+		return fmt.Errorf("Table %d is not part of the pipeline", from)
+	}
+	dst, ok := p.byId[to]
+	if !ok {
+		return fmt.Errorf("Table %s: goto table %d is not part of the pipeline", src.Name, to)
+	}
+	if dst.Id <= src.Id {
+		return fmt.Errorf("Table %s: backward goto to table %s", src.Name, dst.Name)
+	}
+	return nil
+}
+
+// Returns the table-miss flows of the pipeline, at priority 0
+func (p *Pipeline) MissFlows() []*Flow {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	flows := make([]*Flow, 0, len(p.tables))
+	for i, t := range p.tables {
+		flow := NewFlow(t.Id)
+		switch t.Miss {
+		case MissDrop:
+			flow.SetDropAction()
+		case MissController:
+			flow.SetGotoControllerAction()
+		case MissNext:
+			if i+1 < len(p.tables) {
+				flow.SetGotoTableAction(p.tables[i+1].Id)
+			}
+		case MissNormal:
+			flow.SetNormalAction()
+		}
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+// Validate the pipeline against a switch and install its table-miss flows
+func (p *Pipeline) Install(sw *OFSwitch) error {
+	if err := p.Validate(sw.NumTables()); err != nil {
+		return err
+	}
+	for _, flow := range p.MissFlows() {
+		if err := sw.InstallFlow(flow); err != nil {
+			return err
+		}
+	}
+	log.Infof("Installed pipeline of %d tables on switch %s", len(p.Tables()), sw.DPID())
+	return nil
+}
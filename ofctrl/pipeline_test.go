@@ -0,0 +1,183 @@
+package ofctrl_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+)
+
+func newTestPipeline(t *testing.T) *ofctrl.Pipeline {
+	p := ofctrl.NewPipeline()
+	tables := []struct {
+		name  string
+		id    uint8
+		miss  ofctrl.MissAction
+		owner string
+	}{
+		{"classifier", 0, ofctrl.MissNext, "core"},
+		{"acl", 10, ofctrl.MissDrop, "acl"},
+		{"l2", 20, ofctrl.MissController, "core"},
+		{"out", 30, ofctrl.MissNormal, "core"},
+	}
+	for _, table := range tables {
+		if _, err := p.AddTable(table.name, table.id, table.miss, table.owner); err != nil {
+			t.Fatalf("Error adding table %s. Err: %v", table.name, err)
+		}
+	}
+	return p
+}
+
+func TestPipelineTables(t *testing.T) {
+	p := newTestPipeline(t)
+
+	tests := []struct {
+		name string
+		id   uint8
+		err  string // Part of the error of adding the table
+	}{
+		{"acl", 40, "Duplicate pipeline table"},
+		{"other", 10, "already used by acl"},
+		{"other", 25, "is before the last table"},
+	}
+	for _, test := range tests {
+		_, err := p.AddTable(test.name, test.id, ofctrl.MissDrop, "")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Wrong error of table %s %d: got %v, want %q", test.name, test.id, err, test.err)
+		}
+	}
+
+	var names []string
+	for _, table := range p.Tables() {
+		names = append(names, table.Name)
+	}
+	if got := strings.Join(names, ","); got != "classifier,acl,l2,out" {
+		t.Errorf("Wrong tables: got %s, want classifier,acl,l2,out", got)
+	}
+	if table := p.Table("l2"); table == nil || table.Id != 20 {
+		t.Errorf("Wrong table l2: %+v", table)
+	}
+	if table := p.Table("unknown"); table != nil {
+		t.Errorf("Wrong unknown table: %+v", table)
+	}
+	if tables := p.TablesOf("core"); len(tables) != 3 {
+		t.Errorf("Wrong tables of core: %+v", tables)
+	}
+}
+
+func TestPipelineValidate(t *testing.T) {
+	p := newTestPipeline(t)
+	if err := p.Validate(0); err != nil {
+		t.Errorf("Error validating without table count. Err: %v", err)
+	}
+	if err := p.Validate(31); err != nil {
+		t.Errorf("Error validating 31 tables. Err: %v", err)
+	}
+	if err := p.Validate(30); err == nil || !strings.Contains(err.Error(), "beyond the 30 tables") {
+		t.Errorf("Wrong error of 30 tables: got %v", err)
+	}
+
+	last := ofctrl.NewPipeline()
+	last.AddTable("classifier", 0, ofctrl.MissNext, "core")
+	if err := last.Validate(0); err == nil || !strings.Contains(err.Error(), "has no next table") {
+		t.Errorf("Wrong error of a last table going to the next one: got %v", err)
+	}
+}
+
+func TestPipelineValidateFlow(t *testing.T) {
+	p := newTestPipeline(t)
+	tests := []struct {
+		name      string
+		table     uint8
+		gotoTable uint8 // Table the flow goes to, 0 for none
+		err       string
+	}{
+		{"flow without goto", 10, 0, ""},
+		{"forward goto", 0, 20, ""},
+		{"unknown table", 5, 0, "Table 5 is not part of the pipeline"},
+		{"goto unknown table", 0, 15, "goto table 15 is not part of the pipeline"},
+		{"backward goto", 20, 10, "backward goto to table acl"},
+		{"goto same table", 20, 20, "backward goto to table l2"},
+	}
+
+	for _, test := range tests {
+		flow := ofctrl.NewFlow(test.table)
+		if test.gotoTable != 0 {
+			flow.SetGotoTableAction(test.gotoTable)
+		}
+		err := p.ValidateFlow(flow)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("Error validating %s. Err: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Wrong error of %s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	// Named gotos are checked when set
+	flow, err := p.NewFlow("l2")
+	if err != nil {
+		t.Fatalf("Error creating flow. Err: %v", err)
+	}
+	if err := p.SetGotoTable(flow, "acl"); err == nil {
+		t.Errorf("Backward goto is set")
+	}
+	if err := p.SetGotoTable(flow, "unknown"); err == nil {
+		t.Errorf("Goto to an unknown table is set")
+	}
+	if _, err := p.NewFlow("unknown"); err == nil {
+		t.Errorf("Flow created in an unknown table")
+	}
+}
+
+// The table-miss flows are installed on connection and the flows installed
+// or modified afterwards are checked against the pipeline
+func TestPipelineInstall(t *testing.T) {
+	app := newTestApp()
+	ctrler := ofctrl.NewController(app)
+	ctrler.SetPipeline(newTestPipeline(t))
+	fake := ofswitchtest.NewSwitch(testDPID)
+	sw := connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+
+	fake.ExpectFlow(t, "table=0,priority=0,actions=goto_table:10")
+	fake.ExpectFlow(t, "table=10,priority=0,actions=drop")
+	fake.ExpectFlow(t, "table=20,priority=0,actions=CONTROLLER")
+	fake.ExpectFlow(t, "table=30,priority=0,actions=NORMAL")
+	fake.ExpectFlowCount(t, 4)
+
+	flow := newTCPFlow(0, 100, 80, 1)
+	if err := sw.InstallFlow(flow); err != nil {
+		t.Fatalf("Error installing flow. Err: %v", err)
+	}
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:1")
+
+	outside := newTCPFlow(5, 100, 80, 1)
+	tests := []struct {
+		name    string
+		install func() error
+	}{
+		{"install outside the pipeline", func() error { return sw.InstallFlow(outside) }},
+		{"modify outside the pipeline", func() error { return sw.ModifyFlow(outside) }},
+		{"strict modify outside the pipeline", func() error { return sw.ModifyFlowStrict(outside) }},
+		{"modify with a backward goto", func() error {
+			modified := newTCPFlow(0, 100, 80, 1)
+			modified.SetGotoTableAction(0)
+			return sw.ModifyFlowStrict(modified)
+		}},
+	}
+	for _, test := range tests {
+		if err := test.install(); err == nil {
+			t.Errorf("Wrong result of %s: got no error", test.name)
+		}
+	}
+
+	// Nothing was sent for the rejected flows
+	fake.ExpectNoFlow(t, "table=5,priority=100,tcp,tp_dst=80")
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:1")
+	fake.ExpectFlowCount(t, 5)
+}
@@ -0,0 +1,196 @@
+package ofctrl
+
+// This file implements reconciling the flows installed on a switch with
+// the flows the controller wants on it
+
+import (
+	"bytes"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Outcome of a flow reconciliation
+type ReconcileResult struct {
+	Unchanged int // Flows already on the switch
+	Added     int // Flows missing on the switch
+	Modified  int // Flows whose instructions were updated
+	Replaced  int // Flows whose cookie, timeouts or flags differed
+	Deleted   int // Stale flows removed from the switch
+}
+
+// Reconcile the flows on the switch with the desired flows. Flows are
+// identified by table, priority and match. Only the switch flows whose
+// cookie matches cookie/cookieMask are considered, a zero mask reconciles
+// all the flows of the switch.
+func (self *OFSwitch) ReconcileFlows(desired []*Flow, cookie, cookieMask uint64) (*ReconcileResult, error) {
+	flowMods := make([]*openflow13.FlowMod, 0, len(desired))
+	for _, flow := range desired {
+		flowMod, err := flow.BuildFlowMod()
+		if err != nil {
+			return nil, err
+		}
+		flowMods = append(flowMods, flowMod)
+	}
+	return self.ReconcileFlowMods(flowMods, cookie, cookieMask)
+}
+
+// Reconcile the flows on the switch with the flows added by the desired
+// FlowMods
+func (self *OFSwitch) ReconcileFlowMods(desired []*openflow13.FlowMod, cookie, cookieMask uint64) (*ReconcileResult, error) {
+	wanted := make(map[FlowKey]*openflow13.FlowMod)
+	for _, flowMod := range desired {
+		key := NewFlowKey(flowMod.TableId, flowMod.Priority, flowMod.Match)
+		if _, ok := wanted[key]; ok {
+			return nil, fmt.Errorf("Duplicate desired flow %s", key)
+		}
+		wanted[key] = flowMod
+	}
+
+	flowReq := openflow13.NewFlowStatsRequest()
+	flowReq.TableId = openflow13.OFPTT_ALL
+	flowReq.Cookie = cookie
+	flowReq.CookieMask = cookieMask
+	current, err := self.DumpFlowStats(flowReq)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(ReconcileResult)
+	for _, stats := range current {
+		key := NewFlowKey(stats.TableId, stats.Priority, stats.Match)
+		flowMod, ok := wanted[key]
+		if !ok {
+			self.Send(deleteStrictFlowMod(stats))
+			res.Deleted++
+			continue
+		}
+		delete(wanted, key)
+
+		switch {
+		case flowMod.Cookie != stats.Cookie || flowMod.IdleTimeout != stats.IdleTimeout ||
+			flowMod.HardTimeout != stats.HardTimeout || flowMod.Flags != stats.Flags:
+			// Modify can't change the cookie, timeouts or flags of a flow
+			self.Send(deleteStrictFlowMod(stats))
+			self.Send(flowMod)
+			res.Replaced++
+		case !sameInstructions(flowMod.Instructions, stats.Instructions):
+			modify := *flowMod
+			modify.Command = openflow13.FC_MODIFY_STRICT
+			self.Send(&modify)
+			res.Modified++
+		default:
+			res.Unchanged++
+		}
+	}
+
+	// Whatever is left is missing on the switch
+	for _, flowMod := range wanted {
+		self.Send(flowMod)
+		res.Added++
+	}
+
+	log.Debugf("Reconciled flows on switch %s: %+v", self.dpid, *res)
+	return res, nil
+}
+
+// Build a strict delete for a flow dumped from the switch
+func deleteStrictFlowMod(stats *openflow13.FlowStats) *openflow13.FlowMod {
+	flowMod := openflow13.NewFlowMod()
+	flowMod.Command = openflow13.FC_DELETE_STRICT
+	flowMod.TableId = stats.TableId
+	flowMod.Priority = stats.Priority
+	flowMod.Cookie = stats.Cookie
+	flowMod.CookieMask = 0xffffffffffffffff
+	flowMod.Match = stats.Match
+	flowMod.OutPort = openflow13.P_ANY
+	flowMod.OutGroup = openflow13.OFPG_ANY
+	return flowMod
+}
+
+func sameInstructions(a, b []openflow13.Instruction) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		ab, _ := a[i].MarshalBinary()
+		bb, _ := b[i].MarshalBinary()
+		if !bytes.Equal(ab, bb) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if a flow added by flowMod is selected by a modify or delete
+// request, following the strict and non-strict matching of the spec. The
+// out_port and out_group filters are not checked.
+func FlowModSelects(req, flowMod *openflow13.FlowMod) bool {
+	if req.TableId != openflow13.OFPTT_ALL && req.TableId != flowMod.TableId {
+		return false
+	}
+	if flowMod.Cookie&req.CookieMask != req.Cookie&req.CookieMask {
+		return false
+	}
+
+	switch req.Command {
+	case openflow13.FC_MODIFY_STRICT, openflow13.FC_DELETE_STRICT:
+		return req.Priority == flowMod.Priority &&
+			NewFlowKey(0, 0, req.Match).SameMatch(NewFlowKey(0, 0, flowMod.Match))
+	default:
+		return matchCovers(req.Match, flowMod.Match)
+	}
+}
+
+// Returns true if every packet matching specific also matches general,
+// i.e. every field of general is present in specific with the same or a
+// narrower value
+func matchCovers(general, specific openflow13.Match) bool {
+	for _, g := range general.Fields {
+		found := false
+		for _, s := range specific.Fields {
+			if s.Class == g.Class && s.Field == g.Field {
+				found = fieldCovers(g, s)
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func fieldCovers(general, specific openflow13.MatchField) bool {
+	gValue, _ := general.Value.MarshalBinary()
+	sValue, _ := specific.Value.MarshalBinary()
+	if len(gValue) != len(sValue) {
+		return false
+	}
+	gMask := fieldMask(general, len(gValue))
+	sMask := fieldMask(specific, len(sValue))
+
+	for i := range gValue {
+		// The specific field must match on all bits general matches on,
+		// with the same value
+		if sMask[i]&gMask[i] != gMask[i] || sValue[i]&gMask[i] != gValue[i]&gMask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Mask of a match field, all ones for a field without mask
+func fieldMask(field openflow13.MatchField, n int) []byte {
+	if field.HasMask && field.Mask != nil {
+		if mask, err := field.Mask.MarshalBinary(); err == nil && len(mask) == n {
+			return mask
+		}
+	}
+	mask := make([]byte, n)
+	for i := range mask {
+		mask[i] = 0xff
+	}
+	return mask
+}
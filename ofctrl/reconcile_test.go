@@ -0,0 +1,95 @@
+package ofctrl_test
+
+import (
+	"testing"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Reconciling sends only what differs between the switch and the desired
+// flows
+func TestReconcileFlows(t *testing.T) {
+	app := newTestApp()
+	fake, sw := connectSwitch(t, app, app.connected)
+	defer fake.Close()
+
+	for _, flow := range []string{
+		"table=0,priority=100,tcp,tp_dst=80,actions=output:1",
+		"table=0,priority=100,tcp,tp_dst=443,actions=output:1",
+		"table=0,priority=100,cookie=0x5,tcp,tp_dst=22,actions=output:1",
+		"table=0,priority=100,tcp,tp_dst=8080,actions=output:1",
+	} {
+		if err := fake.AddFlow(flow); err != nil {
+			t.Fatalf("Error adding flow %s. Err: %v", flow, err)
+		}
+	}
+
+	desired := []*ofctrl.Flow{
+		newTCPFlow(0, 100, 80, 1),
+		newTCPFlow(0, 100, 443, 2),
+		newTCPFlow(0, 100, 22, 1),
+		newTCPFlow(0, 100, 53, 1),
+	}
+	res, err := sw.ReconcileFlows(desired, 0, 0)
+	if err != nil {
+		t.Fatalf("Error reconciling flows. Err: %v", err)
+	}
+	want := ofctrl.ReconcileResult{Unchanged: 1, Added: 1, Modified: 1, Replaced: 1, Deleted: 1}
+	if *res != want {
+		t.Errorf("Wrong reconcile result: got %+v, want %+v", *res, want)
+	}
+
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:1")
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=443,actions=output:2")
+	fake.ExpectFlow(t, "table=0,priority=100,cookie=0x0,tcp,tp_dst=22,actions=output:1")
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=53,actions=output:1")
+	fake.ExpectNoFlow(t, "table=0,priority=100,tcp,tp_dst=8080")
+	fake.ExpectFlowCount(t, 4)
+}
+
+func TestFlowModSelects(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     string
+		command uint8
+		flow    string
+		want    bool
+	}{
+		{"wider match", "table=0,ip", openflow13.FC_DELETE, "table=0,priority=10,tcp,tp_dst=80", true},
+		{"narrower match", "table=0,tcp,tp_dst=80", openflow13.FC_DELETE, "table=0,priority=10,tcp", false},
+		{"other value", "table=0,tcp,tp_dst=80", openflow13.FC_MODIFY, "table=0,priority=10,tcp,tp_dst=443", false},
+		{"wider mask", "table=0,ip,nw_dst=10.0.0.0/8", openflow13.FC_DELETE, "table=0,priority=10,ip,nw_dst=10.1.0.0/16", true},
+		{"narrower mask", "table=0,ip,nw_dst=10.1.0.0/16", openflow13.FC_DELETE, "table=0,priority=10,ip,nw_dst=10.0.0.0/8", false},
+		{"other table", "table=1,ip", openflow13.FC_DELETE, "table=0,priority=10,ip", false},
+		{"cookie", "table=0,cookie=0x10/0xf0", openflow13.FC_DELETE, "table=0,priority=10,cookie=0x12,ip", true},
+		{"other cookie", "table=0,cookie=0x10/0xf0", openflow13.FC_DELETE, "table=0,priority=10,cookie=0x22,ip", false},
+		{"strict", "table=0,priority=10,tcp", openflow13.FC_DELETE_STRICT, "table=0,priority=10,tcp", true},
+		{"strict wider match", "table=0,priority=10,ip", openflow13.FC_DELETE_STRICT, "table=0,priority=10,tcp", false},
+		{"strict other priority", "table=0,priority=20,tcp", openflow13.FC_MODIFY_STRICT, "table=0,priority=10,tcp", false},
+	}
+
+	for _, test := range tests {
+		req, err := openflow13.ParseFlowMod(test.req)
+		if err != nil {
+			t.Fatalf("Error parsing %s. Err: %v", test.req, err)
+		}
+		req.Command = test.command
+		flowMod, err := openflow13.ParseFlowMod(test.flow)
+		if err != nil {
+			t.Fatalf("Error parsing %s. Err: %v", test.flow, err)
+		}
+		if got := ofctrl.FlowModSelects(req, flowMod); got != test.want {
+			t.Errorf("Wrong selection for %s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Any table
+	req, _ := openflow13.ParseFlowMod("ip")
+	req.Command = openflow13.FC_DELETE
+	req.TableId = openflow13.OFPTT_ALL
+	flowMod, _ := openflow13.ParseFlowMod("table=3,priority=10,ip")
+	if !ofctrl.FlowModSelects(req, flowMod) {
+		t.Errorf("Wrong selection for all tables: got false, want true")
+	}
+}
@@ -0,0 +1,196 @@
+// Package replay plays a switch session recorded in a capture back to a
+// controller, to reproduce field bugs.
+//
+// The replayer acts as the switch: it sends the recorded messages of the
+// switch in order and waits for each recorded message of the controller
+// before going on. The xids of the replies are rewritten to the xids the
+// controller uses during the replay. Echo messages depend on timing, they
+// are answered live and not replayed.
+//
+// The controller parses and handles the messages of a connection in the
+// order they arrive, so it sees the session in the recorded order. The
+// replay is deterministic as far as the controller is: messages it sends
+// from timers or goroutines of its own, e.g. stats polls, may come in a
+// different order and are reported as a Divergence:
+//
+//	ctrler.SetCapture(capture) // in the field
+//	...
+//	rp, err := replay.Load("session.pcap", dpid)
+//	err = rp.ConnectController(ctrler) // or rp.Connect("127.0.0.1:6633")
+//	defer rp.Close()
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/serngawy/libOpenflow/internal/switchutil"
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// Plays a recorded switch session to a controller
+type Replayer struct {
+	// Wait the recorded time between messages instead of going as fast as
+	// the controller answers
+	Realtime bool
+	// Compare the body of the controller messages too, not only their type
+	Strict bool
+	// How long to wait for each message of the controller
+	Timeout time.Duration
+
+	records []*util.CaptureRecord
+	conn    net.Conn
+	xids    map[uint32]uint32 // Recorded xids of the controller to the replayed ones
+}
+
+// A message of the controller differing from the recording
+type Divergence struct {
+	Record   int    // Index of the record expected
+	Expected []byte // Recorded message
+	Received []byte
+}
+
+func (d *Divergence) Error() string {
+	if d.Expected[1] == d.Received[1] {
+		return fmt.Sprintf("Replay diverged at record %d: message of type %d differs", d.Record, d.Received[1])
+	}
+	return fmt.Sprintf("Replay diverged at record %d: expected message type %d, received type %d",
+		d.Record, d.Expected[1], d.Received[1])
+}
+
+// Create a replayer of a switch session
+func NewReplayer(records []*util.CaptureRecord) *Replayer {
+	return &Replayer{
+		Timeout: 5 * time.Second,
+		records: records,
+		xids:    make(map[uint32]uint32),
+	}
+}
+
+// Load the session of a switch from a capture file. A dpid of 0 replays all
+// records, for captures of a single switch
+func Load(path string, dpid uint64) (*Replayer, error) {
+	records, err := util.ReadCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	if dpid != 0 {
+		session := records[:0]
+		for _, rec := range records {
+			if rec.DPID == dpid {
+				session = append(session, rec)
+			}
+		}
+		records = session
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("No records of dpid %#x in %s", dpid, path)
+	}
+	return NewReplayer(records), nil
+}
+
+// Replay to a controller listening on a TCP address, e.g. "127.0.0.1:6633"
+func (r *Replayer) Connect(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(conn)
+}
+
+// Replay to a controller over a net.Pipe, the controller doesn't need to
+// listen
+func (r *Replayer) ConnectController(ctrler *ofctrl.Controller) error {
+	conn, ctrlerConn := net.Pipe()
+	go ctrler.ServeConn(ctrlerConn)
+	return r.Serve(conn)
+}
+
+// Replay the session to the controller at the other end of conn. Returns
+// once all records are replayed, or on the first divergence. The
+// connection stays open, answering echo requests, until Close
+func (r *Replayer) Serve(conn net.Conn) error {
+	r.conn = conn
+	start := time.Now()
+	first := r.records[0].Time
+
+	for i, rec := range r.records {
+		if len(rec.Data) < 8 || isEcho(rec.Data[1]) {
+			continue
+		}
+		switch rec.Direction {
+		case util.CaptureToController:
+			if r.Realtime {
+				time.Sleep(time.Until(start.Add(rec.Time.Sub(first))))
+			}
+			data := append([]byte(nil), rec.Data...)
+			if xid, ok := r.xids[binary.BigEndian.Uint32(data[4:])]; ok {
+				binary.BigEndian.PutUint32(data[4:], xid)
+			}
+			if _, err := conn.Write(data); err != nil {
+				return err
+			}
+		case util.CaptureToSwitch:
+			data, err := r.receive(r.Timeout)
+			if err != nil {
+				return fmt.Errorf("Error waiting for record %d. Err: %v", i, err)
+			}
+			if data[1] != rec.Data[1] || (r.Strict && !bytes.Equal(data[8:], rec.Data[8:])) {
+				return &Divergence{Record: i, Expected: rec.Data, Received: data}
+			}
+			if xid := binary.BigEndian.Uint32(rec.Data[4:]); xid != 0 {
+				r.xids[xid] = binary.BigEndian.Uint32(data[4:])
+			}
+		}
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	go func() {
+		for {
+			if _, err := r.receive(0); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Disconnect from the controller
+func (r *Replayer) Close() {
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+func isEcho(msgType uint8) bool {
+	return msgType == openflow13.Type_EchoRequest || msgType == openflow13.Type_EchoReply
+}
+
+// Returns the next message of the controller, answering echo requests.
+// A timeout of 0 waits forever
+func (r *Replayer) receive(timeout time.Duration) ([]byte, error) {
+	for {
+		if timeout > 0 {
+			r.conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		data, err := switchutil.ReadMessage(r.conn)
+		if err != nil {
+			return nil, err
+		}
+		switch data[1] {
+		case openflow13.Type_EchoRequest:
+			data[1] = openflow13.Type_EchoReply
+			if _, err := r.conn.Write(data); err != nil {
+				return nil, err
+			}
+		case openflow13.Type_EchoReply:
+		default:
+			return data, nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package replay_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+	"github.com/serngawy/libOpenflow/ofctrl/replay"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+var testDPID = net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 1}
+
+// App installing one flow to a port when its switch connects
+type flowApp struct {
+	port      uint16
+	connected chan *ofctrl.OFSwitch
+}
+
+func newFlowApp(port uint16) *flowApp {
+	return &flowApp{port: port, connected: make(chan *ofctrl.OFSwitch, 1)}
+}
+
+func (app *flowApp) SwitchConnected(sw *ofctrl.OFSwitch) {
+	flow := ofctrl.NewFlow(0)
+	flow.Match.Priority = 100
+	flow.Match.Ethertype = 0x0800
+	flow.Match.IpProto = 6
+	flow.Match.TcpDstPort = app.port
+	flow.SetOutputPortAction(2)
+	sw.InstallFlow(flow)
+	app.connected <- sw
+}
+
+func (app *flowApp) SwitchDisconnected(sw *ofctrl.OFSwitch)                                  {}
+func (app *flowApp) PacketRcvd(sw *ofctrl.OFSwitch, pkt *openflow13.PacketIn)                {}
+func (app *flowApp) MultipartReply(sw *ofctrl.OFSwitch, rep *openflow13.MultipartReply)      {}
+func (app *flowApp) PortStatusChange(sw *ofctrl.OFSwitch, portStatus *openflow13.PortStatus) {}
+func (app *flowApp) FlowRemoved(sw *ofctrl.OFSwitch, flowRemoved *openflow13.FlowRemoved)    {}
+
+func waitConnected(t *testing.T, app *flowApp) *ofctrl.OFSwitch {
+	t.Helper()
+	select {
+	case sw := <-app.connected:
+		return sw
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Switch connected is not notified")
+	}
+	return nil
+}
+
+// Record the session of a fake switch with a controller running app to a
+// capture file, returns its path
+func record(t *testing.T, app *flowApp) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.pcap")
+	capture, err := util.CreateCapture(path)
+	if err != nil {
+		t.Fatalf("Error creating capture. Err: %v", err)
+	}
+	defer capture.Close()
+
+	ctrler := ofctrl.NewController(app)
+	ctrler.SetCapture(capture)
+	fake := ofswitchtest.NewSwitch(testDPID)
+	if err := fake.ConnectController(ctrler); err != nil {
+		t.Fatalf("Error connecting the switch. Err: %v", err)
+	}
+	defer fake.Close()
+	waitConnected(t, app)
+	fake.ExpectFlow(t, "table=0,priority=100,tcp,tp_dst=80,actions=output:2")
+	return path
+}
+
+// A controller running the recorded app replays without divergence
+func TestReplay(t *testing.T) {
+	path := record(t, newFlowApp(80))
+
+	rp, err := replay.Load(path, 1)
+	if err != nil {
+		t.Fatalf("Error loading capture. Err: %v", err)
+	}
+	rp.Strict = true
+	app := newFlowApp(80)
+	if err := rp.ConnectController(ofctrl.NewController(app)); err != nil {
+		t.Fatalf("Error replaying session. Err: %v", err)
+	}
+	defer rp.Close()
+
+	if sw := waitConnected(t, app); sw.DPID().String() != testDPID.String() {
+		t.Errorf("Wrong dpid of replayed switch: got %s, want %s", sw.DPID(), testDPID)
+	}
+}
+
+// A controller sending a different flow diverges in strict mode only
+func TestReplayDivergence(t *testing.T) {
+	path := record(t, newFlowApp(80))
+
+	rp, err := replay.Load(path, 1)
+	if err != nil {
+		t.Fatalf("Error loading capture. Err: %v", err)
+	}
+	if err := rp.ConnectController(ofctrl.NewController(newFlowApp(443))); err != nil {
+		t.Errorf("Error replaying session. Err: %v", err)
+	}
+	rp.Close()
+
+	rp, err = replay.Load(path, 1)
+	if err != nil {
+		t.Fatalf("Error loading capture. Err: %v", err)
+	}
+	rp.Strict = true
+	err = rp.ConnectController(ofctrl.NewController(newFlowApp(443)))
+	defer rp.Close()
+	div, ok := err.(*replay.Divergence)
+	if !ok {
+		t.Fatalf("Wrong replay result: got %v, want a divergence", err)
+	}
+	if div.Expected[1] != openflow13.Type_FlowMod || div.Received[1] != openflow13.Type_FlowMod {
+		t.Errorf("Wrong divergence: %v", div)
+	}
+}
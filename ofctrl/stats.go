@@ -0,0 +1,339 @@
+package ofctrl
+
+// This file implements a periodic statistics poller computing per-port and
+// per-flow rates from the switch counters
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+// Port counter deltas and rates between two polls
+type PortRate struct {
+	PortNo   uint32
+	Interval time.Duration // Time elapsed since the previous sample
+	Reset    bool          // Counters were reset since the previous sample
+
+	// Counter deltas since the previous sample
+	RxPackets uint64
+	TxPackets uint64
+	RxBytes   uint64
+	TxBytes   uint64
+	RxDropped uint64
+	TxDropped uint64
+	RxErrors  uint64
+	TxErrors  uint64
+
+	// Per second rates
+	RxBps         float64
+	TxBps         float64
+	RxPps         float64
+	TxPps         float64
+	RxDropsPerSec float64
+	TxDropsPerSec float64
+
+	Stats *openflow13.PortStats // Latest raw counters
+}
+
+// Flow counter deltas and rates between two polls
+type FlowRate struct {
+	TableId  uint8
+	Priority uint16
+	Cookie   uint64
+	Interval time.Duration // Time elapsed since the previous sample
+	Reset    bool          // Counters were reset since the previous sample
+
+	// Counter deltas since the previous sample
+	Packets uint64
+	Bytes   uint64
+
+	// Per second rates
+	Bps float64
+	Pps float64
+
+	Stats *openflow13.FlowStats // Latest raw counters
+}
+
+// One poll of a switch
+type StatsSample struct {
+	Time  time.Time
+	Ports []PortRate
+	Flows []FlowRate
+}
+
+// Consumers of the stats poller are notified after every poll of a switch
+type StatsConsumer interface {
+	StatsUpdated(sw *OFSwitch, sample *StatsSample)
+}
+
+// Last counters seen for one switch
+type switchStats struct {
+	sw       *OFSwitch
+	lastPoll time.Time
+	ports    map[uint32]*openflow13.PortStats
+	flows    map[string]*openflow13.FlowStats
+	sample   *StatsSample
+}
+
+type StatsPoller struct {
+	Interval  time.Duration // Polling interval
+	PollPorts bool          // Poll port counters
+	PollFlows bool          // Poll flow counters
+
+	consumer StatsConsumer
+	switches map[string]*switchStats // keyed by dpid
+	lock     sync.Mutex
+	stop     chan bool
+	running  bool
+}
+
+// Create a new stats poller. Consumer may be nil if the samples are only
+// read back with LastSample
+func NewStatsPoller(interval time.Duration, consumer StatsConsumer) *StatsPoller {
+	p := new(StatsPoller)
+	p.Interval = interval
+	p.PollPorts = true
+	p.PollFlows = true
+	p.consumer = consumer
+	p.switches = make(map[string]*switchStats)
+	return p
+}
+
+// Start polling all added switches
+func (p *StatsPoller) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.running {
+		return
+	}
+	p.running = true
+	p.stop = make(chan bool)
+	go p.run(p.stop)
+}
+
+// Stop polling
+func (p *StatsPoller) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.running {
+		return
+	}
+	p.running = false
+	close(p.stop)
+}
+
+// Add a switch to be polled. Adding a new connection of an already known
+// switch resets its counters baseline
+func (p *StatsPoller) AddSwitch(sw *OFSwitch) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	dpid := sw.DPID().String()
+	if st, ok := p.switches[dpid]; ok && st.sw == sw {
+		return
+	}
+	p.switches[dpid] = &switchStats{
+		sw:    sw,
+		ports: make(map[uint32]*openflow13.PortStats),
+		flows: make(map[string]*openflow13.FlowStats),
+	}
+}
+
+// Stop polling a switch
+func (p *StatsPoller) RemoveSwitch(sw *OFSwitch) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	dpid := sw.DPID().String()
+	if st, ok := p.switches[dpid]; ok && st.sw == sw {
+		delete(p.switches, dpid)
+	}
+}
+
+// Returns the latest sample of a switch or nil if it was not polled yet
+func (p *StatsPoller) LastSample(sw *OFSwitch) *StatsSample {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if st, ok := p.switches[sw.DPID().String()]; ok {
+		return st.sample
+	}
+	return nil
+}
+
+func (p *StatsPoller) run(stop chan bool) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.pollAll()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Poll all switches in parallel so that a slow switch doesn't delay others
+func (p *StatsPoller) pollAll() {
+	p.lock.Lock()
+	switches := make([]*OFSwitch, 0, len(p.switches))
+	for _, st := range p.switches {
+		switches = append(switches, st.sw)
+	}
+	p.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sw := range switches {
+		wg.Add(1)
+		go func(sw *OFSwitch) {
+			defer wg.Done()
+			p.Poll(sw)
+		}(sw)
+	}
+	wg.Wait()
+}
+
+// Poll a switch once and compute rates against the previous poll
+func (p *StatsPoller) Poll(sw *OFSwitch) {
+	var ports []*openflow13.PortStats
+	var flows []*openflow13.FlowStats
+	var err error
+
+	if p.PollPorts {
+		ports, err = sw.DumpPortStats(openflow13.P_ANY)
+		if err != nil {
+			log.Warnf("Error polling port stats on switch %s. Err: %v", sw.DPID(), err)
+			return
+		}
+	}
+	if p.PollFlows {
+		flowReq := openflow13.NewFlowStatsRequest()
+		flowReq.TableId = openflow13.OFPTT_ALL
+		flows, err = sw.DumpFlowStats(flowReq)
+		if err != nil {
+			log.Warnf("Error polling flow stats on switch %s. Err: %v", sw.DPID(), err)
+			return
+		}
+	}
+
+	p.lock.Lock()
+	st, ok := p.switches[sw.DPID().String()]
+	if !ok || st.sw != sw {
+		// Switch was removed or reconnected while polling
+		p.lock.Unlock()
+		return
+	}
+	sample := st.update(time.Now(), ports, flows)
+	p.lock.Unlock()
+
+	if p.consumer != nil {
+		p.consumer.StatsUpdated(sw, sample)
+	}
+}
+
+// Compute a new sample from the latest counters and keep them as baseline
+func (st *switchStats) update(now time.Time, ports []*openflow13.PortStats, flows []*openflow13.FlowStats) *StatsSample {
+	sample := &StatsSample{Time: now}
+	interval := now.Sub(st.lastPoll)
+	first := st.lastPoll.IsZero()
+
+	newPorts := make(map[uint32]*openflow13.PortStats)
+	for _, ps := range ports {
+		newPorts[ps.PortNo] = ps
+		prev, ok := st.ports[ps.PortNo]
+		if first || !ok {
+			continue
+		}
+		sample.Ports = append(sample.Ports, portRate(prev, ps, interval))
+	}
+
+	newFlows := make(map[string]*openflow13.FlowStats)
+	for _, fs := range flows {
+		key := flowStatsKey(fs)
+		newFlows[key] = fs
+		prev, ok := st.flows[key]
+		if first || !ok {
+			continue
+		}
+		sample.Flows = append(sample.Flows, flowRate(prev, fs, interval))
+	}
+
+	st.ports = newPorts
+	st.flows = newFlows
+	st.lastPoll = now
+	st.sample = sample
+	return sample
+}
+
+// Flows are identified in dumps by table, priority, cookie and match
+func flowStatsKey(fs *openflow13.FlowStats) string {
+	match, _ := fs.Match.MarshalBinary()
+	return fmt.Sprintf("%d/%d/%x/%x", fs.TableId, fs.Priority, fs.Cookie, match)
+}
+
+// Delta of a counter, a counter going backwards means it was reset and
+// everything counted since then is new
+func counterDelta(prev, cur uint64, reset bool) uint64 {
+	if reset || cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func perSec(delta uint64, interval time.Duration) float64 {
+	if interval <= 0 {
+		return 0
+	}
+	return float64(delta) / interval.Seconds()
+}
+
+func portRate(prev, cur *openflow13.PortStats, interval time.Duration) PortRate {
+	// Port uptime going backwards means the port was recreated
+	reset := cur.DurationSec < prev.DurationSec ||
+		cur.RxPackets < prev.RxPackets || cur.TxPackets < prev.TxPackets ||
+		cur.RxBytes < prev.RxBytes || cur.TxBytes < prev.TxBytes
+
+	r := PortRate{PortNo: cur.PortNo, Interval: interval, Reset: reset, Stats: cur}
+	r.RxPackets = counterDelta(prev.RxPackets, cur.RxPackets, reset)
+	r.TxPackets = counterDelta(prev.TxPackets, cur.TxPackets, reset)
+	r.RxBytes = counterDelta(prev.RxBytes, cur.RxBytes, reset)
+	r.TxBytes = counterDelta(prev.TxBytes, cur.TxBytes, reset)
+	r.RxDropped = counterDelta(prev.RxDropped, cur.RxDropped, reset)
+	r.TxDropped = counterDelta(prev.TxDropped, cur.TxDropped, reset)
+	r.RxErrors = counterDelta(prev.RxErrors, cur.RxErrors, reset)
+	r.TxErrors = counterDelta(prev.TxErrors, cur.TxErrors, reset)
+
+	r.RxBps = perSec(r.RxBytes*8, interval)
+	r.TxBps = perSec(r.TxBytes*8, interval)
+	r.RxPps = perSec(r.RxPackets, interval)
+	r.TxPps = perSec(r.TxPackets, interval)
+	r.RxDropsPerSec = perSec(r.RxDropped, interval)
+	r.TxDropsPerSec = perSec(r.TxDropped, interval)
+	return r
+}
+
+func flowRate(prev, cur *openflow13.FlowStats, interval time.Duration) FlowRate {
+	// Flow age going backwards means the flow was re-added
+	reset := cur.DurationSec < prev.DurationSec ||
+		cur.PacketCount < prev.PacketCount || cur.ByteCount < prev.ByteCount
+
+	r := FlowRate{
+		TableId:  cur.TableId,
+		Priority: cur.Priority,
+		Cookie:   cur.Cookie,
+		Interval: interval,
+		Reset:    reset,
+		Stats:    cur,
+	}
+	r.Packets = counterDelta(prev.PacketCount, cur.PacketCount, reset)
+	r.Bytes = counterDelta(prev.ByteCount, cur.ByteCount, reset)
+	r.Bps = perSec(r.Bytes*8, interval)
+	r.Pps = perSec(r.Packets, interval)
+	return r
+}
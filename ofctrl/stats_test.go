@@ -0,0 +1,138 @@
+package ofctrl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+func TestPortRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur openflow13.PortStats
+		interval  time.Duration
+		want      PortRate
+	}{
+		{
+			name:     "counting",
+			prev:     openflow13.PortStats{DurationSec: 10, RxPackets: 100, TxPackets: 50, RxBytes: 1000, TxBytes: 500, RxDropped: 1},
+			cur:      openflow13.PortStats{DurationSec: 12, RxPackets: 300, TxPackets: 50, RxBytes: 3000, TxBytes: 900, RxDropped: 5},
+			interval: 2 * time.Second,
+			want: PortRate{RxPackets: 200, RxBytes: 2000, TxBytes: 400, RxDropped: 4,
+				RxBps: 8000, TxBps: 1600, RxPps: 100, RxDropsPerSec: 2},
+		},
+		{
+			name:     "port recreated",
+			prev:     openflow13.PortStats{DurationSec: 100, RxPackets: 100, RxBytes: 1000},
+			cur:      openflow13.PortStats{DurationSec: 1, RxPackets: 150, RxBytes: 1500},
+			interval: time.Second,
+			want:     PortRate{Reset: true, RxPackets: 150, RxBytes: 1500, RxBps: 12000, RxPps: 150},
+		},
+		{
+			name:     "counter going backwards",
+			prev:     openflow13.PortStats{DurationSec: 10, TxPackets: 100, TxBytes: 1000},
+			cur:      openflow13.PortStats{DurationSec: 11, TxPackets: 10, TxBytes: 100},
+			interval: time.Second,
+			want:     PortRate{Reset: true, TxPackets: 10, TxBytes: 100, TxBps: 800, TxPps: 10},
+		},
+		{
+			name:     "zero interval",
+			prev:     openflow13.PortStats{RxPackets: 1},
+			cur:      openflow13.PortStats{RxPackets: 2},
+			interval: 0,
+			want:     PortRate{RxPackets: 1},
+		},
+	}
+
+	for _, test := range tests {
+		got := portRate(&test.prev, &test.cur, test.interval)
+		if got.Stats != &test.cur || got.Interval != test.interval {
+			t.Errorf("Wrong stats or interval of %s: %+v", test.name, got)
+		}
+		got.Stats = nil
+		got.Interval = 0
+		if got != test.want {
+			t.Errorf("Wrong rate of %s:\n got %+v\nwant %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFlowRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur openflow13.FlowStats
+		want      FlowRate
+	}{
+		{
+			name: "counting",
+			prev: openflow13.FlowStats{DurationSec: 5, PacketCount: 10, ByteCount: 1000},
+			cur:  openflow13.FlowStats{DurationSec: 9, PacketCount: 30, ByteCount: 3000},
+			want: FlowRate{Packets: 20, Bytes: 2000, Bps: 4000, Pps: 5},
+		},
+		{
+			name: "flow re-added",
+			prev: openflow13.FlowStats{DurationSec: 50, PacketCount: 10, ByteCount: 1000},
+			cur:  openflow13.FlowStats{DurationSec: 2, PacketCount: 40, ByteCount: 4000},
+			want: FlowRate{Reset: true, Packets: 40, Bytes: 4000, Bps: 8000, Pps: 10},
+		},
+	}
+
+	for _, test := range tests {
+		test.cur.TableId = 3
+		test.cur.Priority = 100
+		test.cur.Cookie = 0x10
+		got := flowRate(&test.prev, &test.cur, 4*time.Second)
+		if got.TableId != 3 || got.Priority != 100 || got.Cookie != 0x10 || got.Stats != &test.cur {
+			t.Errorf("Wrong flow of %s: %+v", test.name, got)
+		}
+		if got.Reset != test.want.Reset || got.Packets != test.want.Packets || got.Bytes != test.want.Bytes ||
+			got.Bps != test.want.Bps || got.Pps != test.want.Pps {
+			t.Errorf("Wrong rate of %s:\n got %+v\nwant %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// The first poll and new ports or flows only set the baseline, removed ones
+// are forgotten
+func TestStatsUpdate(t *testing.T) {
+	st := &switchStats{
+		ports: make(map[uint32]*openflow13.PortStats),
+		flows: make(map[string]*openflow13.FlowStats),
+	}
+	flow := func(priority uint16, packets uint64) *openflow13.FlowStats {
+		fs := openflow13.NewFlowStats()
+		fs.Priority = priority
+		fs.PacketCount = packets
+		return fs
+	}
+
+	start := time.Unix(1000, 0)
+	sample := st.update(start,
+		[]*openflow13.PortStats{{PortNo: 1, RxPackets: 10}},
+		[]*openflow13.FlowStats{flow(1, 10)})
+	if len(sample.Ports) != 0 || len(sample.Flows) != 0 {
+		t.Errorf("Wrong first sample: %+v", sample)
+	}
+
+	sample = st.update(start.Add(time.Second),
+		[]*openflow13.PortStats{{PortNo: 1, RxPackets: 20}, {PortNo: 2, RxPackets: 5}},
+		[]*openflow13.FlowStats{flow(1, 15), flow(2, 5)})
+	if len(sample.Ports) != 1 || sample.Ports[0].PortNo != 1 || sample.Ports[0].RxPps != 10 {
+		t.Errorf("Wrong port rates: %+v", sample.Ports)
+	}
+	if len(sample.Flows) != 1 || sample.Flows[0].Priority != 1 || sample.Flows[0].Pps != 5 {
+		t.Errorf("Wrong flow rates: %+v", sample.Flows)
+	}
+	if st.sample != sample {
+		t.Errorf("Sample is not kept as the last sample")
+	}
+
+	sample = st.update(start.Add(2*time.Second), []*openflow13.PortStats{{PortNo: 2, RxPackets: 6}}, nil)
+	if len(sample.Ports) != 1 || sample.Ports[0].PortNo != 2 || len(sample.Flows) != 0 {
+		t.Errorf("Wrong sample after removals: %+v", sample)
+	}
+	if _, ok := st.ports[1]; ok || len(st.flows) != 0 {
+		t.Errorf("Removed port or flows are kept: %v %v", st.ports, st.flows)
+	}
+}
@@ -0,0 +1,553 @@
+package ofctrl
+
+// This file implements the desired state of the switches: the flows, groups
+// and meters the controller programmed, kept per DPID across reconnections
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// A flow of the desired state
+type storedFlow struct {
+	flow    *Flow // nil for flows loaded from a snapshot
+	flowMod *openflow13.FlowMod
+}
+
+// Desired state of one switch. It keeps its own copies of the messages it
+// records and hands out copies, so that they are never marshaled by two
+// goroutines at once
+type SwitchState struct {
+	lock   sync.Mutex
+	dpid   string
+	flows  map[FlowKey]*storedFlow
+	groups map[uint32]*openflow13.GroupMod
+	meters map[uint32]*openflow13.MeterMod
+
+	configLoaded bool // the flow config of the controller was recorded
+}
+
+type StateStore struct {
+	lock     sync.Mutex
+	switches map[string]*SwitchState // keyed by dpid
+}
+
+// Create a new empty state store
+func NewStateStore() *StateStore {
+	s := new(StateStore)
+	s.switches = make(map[string]*SwitchState)
+	return s
+}
+
+// Returns the desired state of a switch, creating it on first use
+func (s *StateStore) Switch(dpid net.HardwareAddr) *SwitchState {
+	return s.switchState(dpid.String())
+}
+
+func (s *StateStore) switchState(dpid string) *SwitchState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	st, ok := s.switches[dpid]
+	if !ok {
+		st = &SwitchState{
+			dpid:   dpid,
+			flows:  make(map[FlowKey]*storedFlow),
+			groups: make(map[uint32]*openflow13.GroupMod),
+			meters: make(map[uint32]*openflow13.MeterMod),
+		}
+		s.switches[dpid] = st
+	}
+	return st
+}
+
+// Forget the desired state of a switch
+func (s *StateStore) RemoveSwitch(dpid net.HardwareAddr) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.switches, dpid.String())
+}
+
+// Record a flow, replacing the flow with the same table, priority and match
+func (st *SwitchState) AddFlow(flow *Flow) error {
+	flowMod, err := flow.BuildFlowMod()
+	if err != nil {
+		return err
+	}
+	st.addFlowMod(flow, flowMod)
+	return nil
+}
+
+func (st *SwitchState) addFlowMod(flow *Flow, flowMod *openflow13.FlowMod) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	key := NewFlowKey(flowMod.TableId, flowMod.Priority, flowMod.Match)
+	st.flows[key] = &storedFlow{flow: flow, flowMod: copyFlowMod(flowMod)}
+}
+
+// Forget a flow
+func (st *SwitchState) RemoveFlow(flow *Flow) {
+	key := flow.FlowKey()
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.flows, key)
+}
+
+// Forget the flows selected by a delete request
+func (st *SwitchState) deleteFlows(req *openflow13.FlowMod) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	for key, sf := range st.flows {
+		if FlowModSelects(req, sf.flowMod) {
+			delete(st.flows, key)
+		}
+	}
+}
+
+// Replace the instructions of the flows selected by a modify request.
+// The Flow of the selected flows is replaced too unless flow is nil
+func (st *SwitchState) modifyFlows(req *openflow13.FlowMod, flow *Flow) {
+	instrs := copyFlowMod(req).Instructions
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	for _, sf := range st.flows {
+		if FlowModSelects(req, sf.flowMod) {
+			flowMod := *sf.flowMod
+			flowMod.Instructions = instrs
+			sf.flowMod = &flowMod
+			if flow != nil {
+				sf.flow = flow
+			}
+		}
+	}
+}
+
+// Returns the flows of the desired state. Flows loaded from a snapshot
+// have no Flow object and are only returned by FlowMods
+func (st *SwitchState) Flows() []*Flow {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	flows := make([]*Flow, 0, len(st.flows))
+	for _, key := range sortedKeys(st.flows) {
+		if f := st.flows[key].flow; f != nil {
+			flows = append(flows, f)
+		}
+	}
+	return flows
+}
+
+// Returns new FlowMods adding the flows of the desired state
+func (st *SwitchState) FlowMods() []*openflow13.FlowMod {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	flowMods := make([]*openflow13.FlowMod, 0, len(st.flows))
+	for _, key := range sortedKeys(st.flows) {
+		flowMods = append(flowMods, copyFlowMod(st.flows[key].flowMod))
+	}
+	return flowMods
+}
+
+// Record a group, replacing the group with the same id
+func (st *SwitchState) AddGroup(groupMod *openflow13.GroupMod) {
+	g := copyGroupMod(groupMod)
+	g.Command = openflow13.OFPGC_ADD
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.groups[g.GroupId] = g
+}
+
+// Forget a group
+func (st *SwitchState) RemoveGroup(groupId uint32) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.groups, groupId)
+}
+
+// Returns new GroupMods adding the groups of the desired state
+func (st *SwitchState) Groups() []*openflow13.GroupMod {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	groups := make([]*openflow13.GroupMod, 0, len(st.groups))
+	for _, g := range st.groups {
+		groups = append(groups, copyGroupMod(g))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GroupId < groups[j].GroupId })
+	return groups
+}
+
+// Record a meter, replacing the meter with the same id
+func (st *SwitchState) AddMeter(meterMod *openflow13.MeterMod) {
+	m := copyMeterMod(meterMod)
+	m.Command = openflow13.OFPMC_ADD
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.meters[m.MeterId] = m
+}
+
+// Forget a meter
+func (st *SwitchState) RemoveMeter(meterId uint32) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.meters, meterId)
+}
+
+// Returns new MeterMods adding the meters of the desired state
+func (st *SwitchState) Meters() []*openflow13.MeterMod {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	meters := make([]*openflow13.MeterMod, 0, len(st.meters))
+	for _, m := range st.meters {
+		meters = append(meters, copyMeterMod(m))
+	}
+	sort.Slice(meters, func(i, j int) bool { return meters[i].MeterId < meters[j].MeterId })
+	return meters
+}
+
+// Push the desired state to a switch. Meters and groups are restored first
+// since flows may refer to them, they are never deleted and re-added as
+// that would delete the flows using them. Meters, groups and flows are
+// either re-added or, when reconcile is set, reconciled with the ones found
+// on the switch.
+func (st *SwitchState) Replay(sw *OFSwitch, reconcile bool) error {
+	if !reconcile {
+		st.replayMeters(sw)
+		st.replayGroups(sw)
+		for _, flowMod := range st.FlowMods() {
+			sw.Send(flowMod)
+		}
+		return nil
+	}
+
+	if err := st.reconcileMeters(sw); err != nil {
+		log.Warnf("Error reconciling the meters of switch %s, re-adding them. Err: %v", sw.DPID(), err)
+		st.replayMeters(sw)
+	}
+	if err := st.reconcileGroups(sw); err != nil {
+		log.Warnf("Error reconciling the groups of switch %s, re-adding them. Err: %v", sw.DPID(), err)
+		st.replayGroups(sw)
+	}
+	res, err := sw.ReconcileFlowMods(st.FlowMods(), 0, 0)
+	if err != nil {
+		return err
+	}
+	log.Infof("Reconciled flows of switch %s: %+v", sw.DPID(), *res)
+	return nil
+}
+
+// Add the meters of the desired state, modifying the ones the switch
+// already has
+func (st *SwitchState) replayMeters(sw *OFSwitch) {
+	for _, m := range st.Meters() {
+		m := m
+		add := meterCommand(m, openflow13.OFPMC_ADD)
+		sw.sendHandlingError(add, add.Xid, func(errMsg *openflow13.ErrorMsg) {
+			if errMsg.Type == openflow13.ET_METER_MOD_FAILED && errMsg.Code == openflow13.MMFC_METER_EXISTS {
+				sw.Send(meterCommand(m, openflow13.OFPMC_MODIFY))
+				return
+			}
+			log.Errorf("Error restoring meter %d on switch %s. Err: type %d code %d",
+				m.MeterId, sw.DPID(), errMsg.Type, errMsg.Code)
+		})
+	}
+}
+
+// Add the groups of the desired state, modifying the ones the switch
+// already has
+func (st *SwitchState) replayGroups(sw *OFSwitch) {
+	for _, g := range st.Groups() {
+		g := g
+		add := groupCommand(g, openflow13.OFPGC_ADD)
+		sw.sendHandlingError(add, add.Xid, func(errMsg *openflow13.ErrorMsg) {
+			if errMsg.Type == openflow13.ET_GROUP_MOD_FAILED && errMsg.Code == openflow13.GMFC_GROUP_EXISTS {
+				sw.Send(groupCommand(g, openflow13.OFPGC_MODIFY))
+				return
+			}
+			log.Errorf("Error restoring group %d on switch %s. Err: type %d code %d",
+				g.GroupId, sw.DPID(), errMsg.Type, errMsg.Code)
+		})
+	}
+}
+
+// Add the missing meters, modify the changed ones and delete the meters
+// not in the desired state
+func (st *SwitchState) reconcileMeters(sw *OFSwitch) error {
+	req := openflow13.NewMpRequest(openflow13.MultipartType_MeterConfig)
+	req.Body = openflow13.NewMeterMultipartRequest(openflow13.OFPM_ALL)
+	bodies, err := sw.SendMultipartRequest(req)
+	if err != nil {
+		return err
+	}
+	found := make(map[uint32]*openflow13.MeterConfig)
+	for _, body := range bodies {
+		if config, ok := body.(*openflow13.MeterConfig); ok {
+			found[config.MeterId] = config
+		}
+	}
+
+	var added, modified, deleted int
+	for _, m := range st.Meters() {
+		config, ok := found[m.MeterId]
+		delete(found, m.MeterId)
+		if !ok {
+			sw.Send(meterCommand(m, openflow13.OFPMC_ADD))
+			added++
+		} else if !sameMessage(openflow13.NewMeterConfig(m), config) {
+			sw.Send(meterCommand(m, openflow13.OFPMC_MODIFY))
+			modified++
+		}
+	}
+	for meterId := range found {
+		del := openflow13.NewMeterMod()
+		del.Command = openflow13.OFPMC_DELETE
+		del.MeterId = meterId
+		sw.Send(del)
+		deleted++
+	}
+	log.Infof("Reconciled meters of switch %s: %d added, %d modified, %d deleted",
+		sw.DPID(), added, modified, deleted)
+	return nil
+}
+
+// Add the missing groups, modify the changed ones and delete the groups
+// not in the desired state
+func (st *SwitchState) reconcileGroups(sw *OFSwitch) error {
+	bodies, err := sw.SendMultipartRequest(openflow13.NewMpRequest(openflow13.MultipartType_GroupDesc))
+	if err != nil {
+		return err
+	}
+	found := make(map[uint32]*openflow13.GroupDesc)
+	for _, body := range bodies {
+		if desc, ok := body.(*openflow13.GroupDesc); ok {
+			found[desc.GroupId] = desc
+		}
+	}
+
+	var added, modified, deleted int
+	for _, g := range st.Groups() {
+		desc, ok := found[g.GroupId]
+		delete(found, g.GroupId)
+		if !ok {
+			sw.Send(groupCommand(g, openflow13.OFPGC_ADD))
+			added++
+		} else if !sameMessage(openflow13.NewGroupDesc(g), desc) {
+			sw.Send(groupCommand(g, openflow13.OFPGC_MODIFY))
+			modified++
+		}
+	}
+	for groupId := range found {
+		del := openflow13.NewGroupMod()
+		del.Command = openflow13.OFPGC_DELETE
+		del.GroupId = groupId
+		sw.Send(del)
+		deleted++
+	}
+	log.Infof("Reconciled groups of switch %s: %d added, %d modified, %d deleted",
+		sw.DPID(), added, modified, deleted)
+	return nil
+}
+
+// Returns a copy of a meter mod with another command, in a new message
+func meterCommand(m *openflow13.MeterMod, command uint16) *openflow13.MeterMod {
+	mod := *m
+	mod.Header = openflow13.NewOfp13Header()
+	mod.Header.Type = openflow13.Type_MeterMod
+	mod.Command = command
+	return &mod
+}
+
+// Returns a copy of a group mod with another command, in a new message
+func groupCommand(g *openflow13.GroupMod, command uint16) *openflow13.GroupMod {
+	mod := *g
+	mod.Header = openflow13.NewOfp13Header()
+	mod.Header.Type = openflow13.Type_GroupMod
+	mod.Command = command
+	return &mod
+}
+
+// Decode dst from the wire format of msg so that they share nothing. Sent
+// messages are marshaled by the stream, which writes their lengths, so a
+// message is copied before it is sent and every send gets its own copy
+func copyMessage(dst, msg util.Message) {
+	data, err := msg.MarshalBinary()
+	if err == nil {
+		err = dst.UnmarshalBinary(data)
+	}
+	if err != nil {
+		log.Errorf("Error copying message %+v. Err: %v", msg, err)
+	}
+}
+
+// Returns a copy of a flow mod in a new message
+func copyFlowMod(flowMod *openflow13.FlowMod) *openflow13.FlowMod {
+	mod := openflow13.NewFlowMod()
+	hdr := mod.Header
+	copyMessage(mod, flowMod)
+	mod.Header = hdr
+	return mod
+}
+
+// Returns a copy of a group mod in a new message
+func copyGroupMod(groupMod *openflow13.GroupMod) *openflow13.GroupMod {
+	mod := openflow13.NewGroupMod()
+	hdr := mod.Header
+	copyMessage(mod, groupMod)
+	mod.Header = hdr
+	return mod
+}
+
+// Returns a copy of a meter mod in a new message
+func copyMeterMod(meterMod *openflow13.MeterMod) *openflow13.MeterMod {
+	mod := openflow13.NewMeterMod()
+	hdr := mod.Header
+	copyMessage(mod, meterMod)
+	mod.Header = hdr
+	return mod
+}
+
+// Returns true if both messages have the same wire format
+func sameMessage(a, b util.Message) bool {
+	aData, err := a.MarshalBinary()
+	if err != nil {
+		return false
+	}
+	bData, err := b.MarshalBinary()
+	return err == nil && bytes.Equal(aData, bData)
+}
+
+// On-disk format of the store. Messages are kept in their wire format
+type stateSnapshot struct {
+	Switches []switchSnapshot `json:"switches"`
+}
+
+type switchSnapshot struct {
+	DPID   string   `json:"dpid"`
+	Flows  [][]byte `json:"flows"`
+	Groups [][]byte `json:"groups"`
+	Meters [][]byte `json:"meters"`
+}
+
+// Save a JSON snapshot of the store to a file
+func (s *StateStore) Save(path string) error {
+	s.lock.Lock()
+	dpids := make([]string, 0, len(s.switches))
+	for dpid := range s.switches {
+		dpids = append(dpids, dpid)
+	}
+	sort.Strings(dpids)
+	states := make([]*SwitchState, 0, len(dpids))
+	for _, dpid := range dpids {
+		states = append(states, s.switches[dpid])
+	}
+	s.lock.Unlock()
+
+	snap := stateSnapshot{Switches: make([]switchSnapshot, 0, len(states))}
+	for _, st := range states {
+		ss := switchSnapshot{DPID: st.dpid}
+		for _, flowMod := range st.FlowMods() {
+			b, err := flowMod.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			ss.Flows = append(ss.Flows, b)
+		}
+		for _, g := range st.Groups() {
+			b, err := g.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			ss.Groups = append(ss.Groups, b)
+		}
+		for _, m := range st.Meters() {
+			b, err := m.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			ss.Meters = append(ss.Meters, b)
+		}
+		snap.Switches = append(snap.Switches, ss)
+	}
+
+	data, err := json.MarshalIndent(&snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a
+	// truncated snapshot behind
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load a JSON snapshot saved by Save into the store, replacing the state
+// of the switches it contains
+func (s *StateStore) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var snap stateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("Error decoding state snapshot %s. Err: %v", path, err)
+	}
+
+	for _, ss := range snap.Switches {
+		s.lock.Lock()
+		delete(s.switches, ss.DPID)
+		s.lock.Unlock()
+		st := s.switchState(ss.DPID)
+
+		for _, b := range ss.Flows {
+			flowMod := openflow13.NewFlowMod()
+			if err := flowMod.UnmarshalBinary(b); err != nil {
+				return fmt.Errorf("Error decoding flow of switch %s. Err: %v", ss.DPID, err)
+			}
+			st.addFlowMod(nil, flowMod)
+		}
+		for _, b := range ss.Groups {
+			g := openflow13.NewGroupMod()
+			if err := g.UnmarshalBinary(b); err != nil {
+				return fmt.Errorf("Error decoding group of switch %s. Err: %v", ss.DPID, err)
+			}
+			st.AddGroup(g)
+		}
+		for _, b := range ss.Meters {
+			m := openflow13.NewMeterMod()
+			if err := m.UnmarshalBinary(b); err != nil {
+				return fmt.Errorf("Error decoding meter of switch %s. Err: %v", ss.DPID, err)
+			}
+			st.AddMeter(m)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(flows map[FlowKey]*storedFlow) []FlowKey {
+	keys := make([]FlowKey, 0, len(flows))
+	for k := range flows {
+		keys = append(keys, k)
+	}
+	SortFlowKeys(keys)
+	return keys
+}
@@ -0,0 +1,200 @@
+package ofctrl_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/ofctrl"
+	"github.com/serngawy/libOpenflow/ofctrl/ofswitchtest"
+	"github.com/serngawy/libOpenflow/openflow13"
+)
+
+func newGroup(groupId, port uint32) *openflow13.GroupMod {
+	g := openflow13.NewGroupMod()
+	g.GroupId = groupId
+	bkt := openflow13.NewBucket()
+	bkt.AddAction(openflow13.NewActionOutput(port))
+	g.AddBucket(*bkt)
+	return g
+}
+
+func newMeter(meterId, rate uint32) *openflow13.MeterMod {
+	m := openflow13.NewMeterMod()
+	m.MeterId = meterId
+	m.AddBand(openflow13.NewMeterBandDrop(rate, 0))
+	return m
+}
+
+// Output port of the group installed on a fake switch, 0 if none
+func groupPort(fake *ofswitchtest.Switch, groupId uint32) uint32 {
+	if g := fake.Group(groupId); g != nil && len(g.Buckets) > 0 && len(g.Buckets[0].Actions) > 0 {
+		if output, ok := g.Buckets[0].Actions[0].(*openflow13.ActionOutput); ok {
+			return output.Port
+		}
+	}
+	return 0
+}
+
+// Rate of the meter installed on a fake switch, 0 if none
+func meterRate(fake *ofswitchtest.Switch, meterId uint32) uint32 {
+	if m := fake.Meter(meterId); m != nil && len(m.Bands) > 0 {
+		return m.Bands[0].Header().Rate
+	}
+	return 0
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Returns the group and meter mods a fake switch received, by command
+func receivedMods(fake *ofswitchtest.Switch) (groups, meters map[uint16][]uint32) {
+	groups = make(map[uint16][]uint32)
+	meters = make(map[uint16][]uint32)
+	for _, msg := range fake.Received() {
+		switch m := msg.(type) {
+		case *openflow13.GroupMod:
+			groups[m.Command] = append(groups[m.Command], m.GroupId)
+		case *openflow13.MeterMod:
+			meters[m.Command] = append(meters[m.Command], m.MeterId)
+		}
+	}
+	return
+}
+
+// Program a switch through a controller and disconnect it
+func programSwitch(t *testing.T, ctrler *ofctrl.Controller, app *testApp,
+	groups []*openflow13.GroupMod, meters []*openflow13.MeterMod) {
+	fake := ofswitchtest.NewSwitch(testDPID)
+	sw := connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+
+	for _, m := range meters {
+		sw.InstallMeter(m)
+	}
+	for _, g := range groups {
+		sw.InstallGroup(g)
+	}
+	flow := ofctrl.NewFlow(0)
+	flow.Match.Priority = 100
+	flow.SetMeter(meters[0].MeterId)
+	flow.SetGroupAction(groups[0].GroupId)
+	if err := sw.InstallFlow(flow); err != nil {
+		t.Fatalf("Error installing flow. Err: %v", err)
+	}
+	fake.ExpectFlowCount(t, 1)
+}
+
+// Replaying to a switch that kept its groups and meters modifies them
+// instead of deleting them
+func TestReplayGroupsAndMeters(t *testing.T) {
+	app := newTestApp()
+	ctrler := ofctrl.NewController(app)
+	programSwitch(t, ctrler, app, []*openflow13.GroupMod{newGroup(1, 1)}, []*openflow13.MeterMod{newMeter(1, 1000)})
+
+	fake := ofswitchtest.NewSwitch(testDPID)
+	fake.AddGroup(newGroup(1, 2))
+	fake.AddMeter(newMeter(1, 500))
+	connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+
+	waitFor(t, "group 1 to be modified", func() bool { return groupPort(fake, 1) == 1 })
+	waitFor(t, "meter 1 to be modified", func() bool { return meterRate(fake, 1) == 1000 })
+	fake.ExpectFlowCount(t, 1)
+
+	groups, meters := receivedMods(fake)
+	if len(groups[openflow13.OFPGC_DELETE]) > 0 || len(meters[openflow13.OFPMC_DELETE]) > 0 {
+		t.Errorf("Groups %v and meters %v deleted", groups[openflow13.OFPGC_DELETE], meters[openflow13.OFPMC_DELETE])
+	}
+}
+
+// Reconciling only sends the groups and meters that differ
+func TestReconcileGroupsAndMeters(t *testing.T) {
+	app := newTestApp()
+	ctrler := ofctrl.NewController(app)
+	ctrler.EnableReconciliation()
+	programSwitch(t, ctrler, app,
+		[]*openflow13.GroupMod{newGroup(1, 1), newGroup(2, 2)},
+		[]*openflow13.MeterMod{newMeter(1, 1000), newMeter(2, 2000)})
+
+	fake := ofswitchtest.NewSwitch(testDPID)
+	fake.AddGroup(newGroup(1, 1))
+	fake.AddGroup(newGroup(2, 3))
+	fake.AddGroup(newGroup(9, 9))
+	fake.AddMeter(newMeter(1, 1000))
+	connectController(t, fake, ctrler, app.connected)
+	defer fake.Close()
+
+	waitFor(t, "group 2 to be modified", func() bool { return groupPort(fake, 2) == 2 })
+	fake.ExpectNoGroup(t, 9)
+	fake.ExpectMeter(t, 2)
+	fake.ExpectFlowCount(t, 1)
+
+	groups, meters := receivedMods(fake)
+	want := map[string][]uint32{
+		"group add":    nil,
+		"group modify": {2},
+		"group delete": {9},
+		"meter add":    {2},
+		"meter modify": nil,
+		"meter delete": nil,
+	}
+	got := map[string][]uint32{
+		"group add":    groups[openflow13.OFPGC_ADD],
+		"group modify": groups[openflow13.OFPGC_MODIFY],
+		"group delete": groups[openflow13.OFPGC_DELETE],
+		"meter add":    meters[openflow13.OFPMC_ADD],
+		"meter modify": meters[openflow13.OFPMC_MODIFY],
+		"meter delete": meters[openflow13.OFPMC_DELETE],
+	}
+	for what, ids := range want {
+		if len(got[what]) != len(ids) || (len(ids) > 0 && got[what][0] != ids[0]) {
+			t.Errorf("Wrong %s: got %v, want %v", what, got[what], ids)
+		}
+	}
+}
+
+// The state keeps its own copy of the messages it records and every read
+// returns new messages, so that a replayed message is never the one the
+// app sent or another replay sent
+func TestStateCopies(t *testing.T) {
+	st := ofctrl.NewStateStore().Switch(testDPID)
+	flow := newTCPFlow(0, 100, 80, 1)
+	if err := st.AddFlow(flow); err != nil {
+		t.Fatalf("Error adding flow. Err: %v", err)
+	}
+	group := newGroup(1, 2)
+	st.AddGroup(group)
+	meter := newMeter(1, 500)
+	st.AddMeter(meter)
+
+	first, second := st.FlowMods(), st.FlowMods()
+	if len(first) != 1 || len(second) != 1 || first[0] == second[0] || first[0].Xid == second[0].Xid {
+		t.Errorf("Wrong flow mods: got %+v and %+v, want two copies", first, second)
+	}
+	if first[0].Match.Fields[0].Value == second[0].Match.Fields[0].Value {
+		t.Errorf("Flow mods share their match")
+	}
+
+	groups := st.Groups()
+	if len(groups) != 1 || groups[0] == group || groups[0].Xid == group.Xid {
+		t.Errorf("Wrong groups: got %+v, want a copy of %+v", groups, group)
+	}
+	meters := st.Meters()
+	if len(meters) != 1 || meters[0] == meter || meters[0].Xid == meter.Xid || meters[0].Bands[0] == meter.Bands[0] {
+		t.Errorf("Wrong meters: got %+v, want a copy of %+v", meters, meter)
+	}
+
+	// Changing a sent message doesn't change the state
+	group.Buckets[0].Actions[0].(*openflow13.ActionOutput).Port = 9
+	if port := st.Groups()[0].Buckets[0].Actions[0].(*openflow13.ActionOutput).Port; port != 2 {
+		t.Errorf("Wrong port of stored group: got %d, want 2", port)
+	}
+}
@@ -57,7 +57,7 @@ func (a *ActionHeader) MarshalBinary() (data []byte, err error) {
 }
 
 func (a *ActionHeader) UnmarshalBinary(data []byte) error {
-	if len(data) != 4 {
+	if len(data) < 4 {
 		return errors.New("The []byte the wrong size to unmarshal an " +
 			"ActionHeader message.")
 	}
@@ -66,25 +66,35 @@ func (a *ActionHeader) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
-// Decode Action types.
-func DecodeAction(data []byte) Action {
+// Decode Action types. The action is decoded from the first header length
+// bytes of data, an error is returned if data is shorter than an action or
+// the header length is below the 8 bytes of the smallest action.
+func DecodeAction(data []byte) (Action, error) {
+	if len(data) < 8 {
+		return nil, errors.New("The []byte is too short to decode an Action.")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < 8 || length > len(data) {
+		return nil, errors.New("Wrong action length to decode an Action.")
+	}
+	data = data[:length]
 	t := binary.BigEndian.Uint16(data[:2])
 	var a Action
 	switch t {
 	case ActionType_Output:
 		a = new(ActionOutput)
 	case ActionType_CopyTtlOut:
-		a = new(ActionHeader)
+		a = new(ActionGeneric)
 	case ActionType_CopyTtlIn:
-		a = new(ActionHeader)
+		a = new(ActionGeneric)
 	case ActionType_SetMplsTtl:
 		a = new(ActionMplsTtl)
 	case ActionType_DecMplsTtl:
-		a = new(ActionHeader)
+		a = new(ActionGeneric)
 	case ActionType_PushVlan:
 		a = new(ActionPush)
 	case ActionType_PopVlan:
-		a = new(ActionHeader)
+		a = new(ActionPopVlan)
 	case ActionType_PushMpls:
 		a = new(ActionPush)
 	case ActionType_PopMpls:
@@ -96,16 +106,21 @@ func DecodeAction(data []byte) Action {
 	case ActionType_SetNwTtl:
 		a = new(ActionNwTtl)
 	case ActionType_DecNwTtl:
-		a = new(ActionHeader)
+		a = new(ActionGeneric)
 	case ActionType_SetField:
 		a = new(ActionSetField)
 	case ActionType_PushPbb:
 		a = new(ActionPush)
 	case ActionType_PopPbb:
-		a = new(ActionHeader)
+		a = new(ActionGeneric)
+	default:
+		// Experimenter and unknown actions are kept as raw data
+		a = new(ActionExperimenter)
 	}
-	a.UnmarshalBinary(data)
-	return a
+	if err := a.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
 // Action structure for OFPAT_OUTPUT, which sends packets out ’port’.
@@ -176,6 +191,65 @@ func (a *ActionOutput) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// Action with no argument: copy TTL in/out, decrement MPLS/IP TTL and pop
+// PBB
+type ActionGeneric struct {
+	ActionHeader
+	pad []byte // 4 bytes
+}
+
+func newActionGeneric(actionType uint16) *ActionGeneric {
+	a := new(ActionGeneric)
+	a.Type = actionType
+	a.Length = a.Len()
+	a.pad = make([]byte, 4)
+	return a
+}
+
+// Copy the TTL from the next-to-outermost header to the outermost one
+func NewActionCopyTtlOut() *ActionGeneric {
+	return newActionGeneric(ActionType_CopyTtlOut)
+}
+
+// Copy the TTL from the outermost header to the next-to-outermost one
+func NewActionCopyTtlIn() *ActionGeneric {
+	return newActionGeneric(ActionType_CopyTtlIn)
+}
+
+func NewActionDecMplsTtl() *ActionGeneric {
+	return newActionGeneric(ActionType_DecMplsTtl)
+}
+
+func NewActionDecNwTtl() *ActionGeneric {
+	return newActionGeneric(ActionType_DecNwTtl)
+}
+
+func NewActionPopPbb() *ActionGeneric {
+	return newActionGeneric(ActionType_PopPbb)
+}
+
+func (a *ActionGeneric) Len() (n uint16) {
+	return a.ActionHeader.Len() + 4
+}
+
+func (a *ActionGeneric) MarshalBinary() (data []byte, err error) {
+	data, err = a.ActionHeader.MarshalBinary()
+
+	// Padding
+	bytes := make([]byte, 4)
+
+	data = append(data, bytes...)
+	return
+}
+
+func (a *ActionGeneric) UnmarshalBinary(data []byte) error {
+	if len(data) < int(a.Len()) {
+		return errors.New("The []byte the wrong size to unmarshal an " +
+			"ActionGeneric message.")
+	}
+	return a.ActionHeader.UnmarshalBinary(data[:4])
+}
+
 type ActionSetqueue struct {
 	ActionHeader
 	QueueId uint32
@@ -190,7 +264,7 @@ func NewActionSetQueue(queue uint32) *ActionSetqueue {
 }
 
 func (a *ActionSetqueue) Len() (n uint16) {
-	return a.ActionHeader.Len() + 12
+	return a.ActionHeader.Len() + 4
 }
 
 func (a *ActionSetqueue) MarshalBinary() (data []byte, err error) {
@@ -204,7 +278,7 @@ func (a *ActionSetqueue) MarshalBinary() (data []byte, err error) {
 }
 
 func (a *ActionSetqueue) UnmarshalBinary(data []byte) error {
-	if len(data) != int(a.Len()) {
+	if len(data) < int(a.Len()) {
 		return errors.New("The []byte the wrong size to unmarshal an " +
 			"ActionEnqueue message.")
 	}
@@ -264,12 +338,78 @@ type ActionMplsTtl struct {
 	pad     []byte // 3bytes
 }
 
+func NewActionSetMplsTtl(ttl uint8) *ActionMplsTtl {
+	a := new(ActionMplsTtl)
+	a.Type = ActionType_SetMplsTtl
+	a.Length = 8
+	a.MplsTtl = ttl
+	a.pad = make([]byte, 3)
+	return a
+}
+
+func (a *ActionMplsTtl) Len() (n uint16) {
+	return a.ActionHeader.Len() + 4
+}
+
+func (a *ActionMplsTtl) MarshalBinary() (data []byte, err error) {
+	data, err = a.ActionHeader.MarshalBinary()
+
+	bytes := make([]byte, 4)
+	bytes[0] = a.MplsTtl
+
+	data = append(data, bytes...)
+	return
+}
+
+func (a *ActionMplsTtl) UnmarshalBinary(data []byte) error {
+	if len(data) < int(a.Len()) {
+		return errors.New("The []byte the wrong size to unmarshal an " +
+			"ActionMplsTtl message.")
+	}
+	a.ActionHeader.UnmarshalBinary(data[:4])
+	a.MplsTtl = data[4]
+	return nil
+}
+
 type ActionNwTtl struct {
 	ActionHeader
 	NwTtl uint8
 	pad   []byte // 3bytes
 }
 
+func NewActionSetNwTtl(ttl uint8) *ActionNwTtl {
+	a := new(ActionNwTtl)
+	a.Type = ActionType_SetNwTtl
+	a.Length = 8
+	a.NwTtl = ttl
+	a.pad = make([]byte, 3)
+	return a
+}
+
+func (a *ActionNwTtl) Len() (n uint16) {
+	return a.ActionHeader.Len() + 4
+}
+
+func (a *ActionNwTtl) MarshalBinary() (data []byte, err error) {
+	data, err = a.ActionHeader.MarshalBinary()
+
+	bytes := make([]byte, 4)
+	bytes[0] = a.NwTtl
+
+	data = append(data, bytes...)
+	return
+}
+
+func (a *ActionNwTtl) UnmarshalBinary(data []byte) error {
+	if len(data) < int(a.Len()) {
+		return errors.New("The []byte the wrong size to unmarshal an " +
+			"ActionNwTtl message.")
+	}
+	a.ActionHeader.UnmarshalBinary(data[:4])
+	a.NwTtl = data[4]
+	return nil
+}
+
 type ActionPush struct {
 	ActionHeader
 	EtherType uint16
@@ -292,6 +432,14 @@ func NewActionPushMpls(etherType uint16) *ActionPush {
 	return a
 }
 
+func NewActionPushPbb(etherType uint16) *ActionPush {
+	a := new(ActionPush)
+	a.Type = ActionType_PushPbb
+	a.Length = 8
+	a.EtherType = etherType
+	return a
+}
+
 func (a *ActionPush) Len() (n uint16) {
 	return a.ActionHeader.Len() + 4
 }
@@ -421,4 +569,56 @@ func (a *ActionSetField) UnmarshalBinary(data []byte) error {
 	n += int(a.Field.Len())
 
 	return err
-}
\ No newline at end of file
+}
+
+// Experimenter action, the experimenter defined data is kept as is
+type ActionExperimenter struct {
+	ActionHeader
+	Experimenter uint32 /* Experimenter ID */
+	Data         []byte /* Experimenter defined data, padded to 64 bits */
+}
+
+func NewActionExperimenter(experimenter uint32, data []byte) *ActionExperimenter {
+	a := new(ActionExperimenter)
+	a.Type = ActionType_Experimenter
+	a.Experimenter = experimenter
+	a.Data = data
+	// Pad the action to a multiple of 8 bytes
+	if pad := (8 - (8+len(data))%8) % 8; pad != 0 {
+		a.Data = append(a.Data, make([]byte, pad)...)
+	}
+	a.Length = a.Len()
+	return a
+}
+
+func (a *ActionExperimenter) Len() (n uint16) {
+	return a.ActionHeader.Len() + 4 + uint16(len(a.Data))
+}
+
+func (a *ActionExperimenter) MarshalBinary() (data []byte, err error) {
+	a.Length = a.Len()
+	data, err = a.ActionHeader.MarshalBinary()
+
+	bytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(bytes, a.Experimenter)
+
+	data = append(data, bytes...)
+	data = append(data, a.Data...)
+	return
+}
+
+func (a *ActionExperimenter) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("The []byte the wrong size to unmarshal an " +
+			"ActionExperimenter message.")
+	}
+	a.ActionHeader.UnmarshalBinary(data[:4])
+	if int(a.Length) < 8 || len(data) < int(a.Length) {
+		return errors.New("The []byte the wrong size to unmarshal an " +
+			"ActionExperimenter message.")
+	}
+	a.Experimenter = binary.BigEndian.Uint32(data[4:8])
+	a.Data = make([]byte, int(a.Length)-8)
+	copy(a.Data, data[8:a.Length])
+	return nil
+}
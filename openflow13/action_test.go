@@ -0,0 +1,94 @@
+package openflow13
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Encodings of the actions, as given by the OpenFlow 1.3 spec: type, length
+// and body padded to 64 bits
+func TestActionEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		action Action
+		want   string
+	}{
+		{"output", NewActionOutput(3), "00000010" + "00000003" + "0100" + "000000000000"},
+		{"copy_ttl_out", NewActionCopyTtlOut(), "000b0008" + "00000000"},
+		{"copy_ttl_in", NewActionCopyTtlIn(), "000c0008" + "00000000"},
+		{"set_mpls_ttl", NewActionSetMplsTtl(64), "000f0008" + "40000000"},
+		{"dec_mpls_ttl", NewActionDecMplsTtl(), "00100008" + "00000000"},
+		{"push_vlan", NewActionPushVlan(0x8100), "00110008" + "81000000"},
+		{"pop_vlan", NewActionPopVlan(), "00120008" + "00000000"},
+		{"push_mpls", NewActionPushMpls(0x8847), "00130008" + "88470000"},
+		{"pop_mpls", NewActionPopMpls(0x0800), "00140008" + "08000000"},
+		{"set_queue", NewActionSetQueue(5), "00150008" + "00000005"},
+		{"group", NewActionGroup(7), "00160008" + "00000007"},
+		{"set_nw_ttl", NewActionSetNwTtl(32), "00170008" + "20000000"},
+		{"dec_nw_ttl", NewActionDecNwTtl(), "00180008" + "00000000"},
+		{"set_field", NewActionSetField(*NewVlanPcpField(5)), "00190010" + "80000e0105" + "00000000000000"},
+		{"push_pbb", NewActionPushPbb(0x88e7), "001a0008" + "88e70000"},
+		{"pop_pbb", NewActionPopPbb(), "001b0008" + "00000000"},
+		{"experimenter", NewActionExperimenter(0x2320, []byte{1, 2, 3, 4}), "ffff0010" + "00002320" + "0102030400000000"},
+	}
+
+	for _, test := range tests {
+		data, err := test.action.MarshalBinary()
+		if err != nil {
+			t.Errorf("Error encoding %s. Err: %v", test.name, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.want {
+			t.Errorf("Wrong encoding of %s: got %s, want %s", test.name, got, test.want)
+			continue
+		}
+		if int(test.action.Len()) != len(data) || int(test.action.Header().Length) != len(data) {
+			t.Errorf("Wrong length of %s: got %d and %d, want %d", test.name,
+				test.action.Len(), test.action.Header().Length, len(data))
+		}
+
+		decoded, err := DecodeAction(data)
+		if err != nil {
+			t.Errorf("Error decoding %s. Err: %v", test.name, err)
+			continue
+		}
+		if decoded.Header().Type != test.action.Header().Type || int(decoded.Len()) != len(data) {
+			t.Errorf("Wrong decoded %s: got %+v, want %+v", test.name, decoded, test.action)
+			continue
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil || hex.EncodeToString(again) != test.want {
+			t.Errorf("Wrong re-encoding of %s: got %x, want %s", test.name, again, test.want)
+		}
+	}
+}
+
+// The action following a set_queue is decoded at the right offset
+func TestSetQueueInBucket(t *testing.T) {
+	bkt := NewBucket()
+	bkt.AddAction(NewActionSetQueue(5))
+	bkt.AddAction(NewActionOutput(2))
+	data, err := bkt.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error encoding bucket. Err: %v", err)
+	}
+	if len(data) != 16+8+16 {
+		t.Fatalf("Wrong bucket length: got %d, want %d", len(data), 16+8+16)
+	}
+
+	decoded := new(Bucket)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error decoding bucket. Err: %v", err)
+	}
+	if len(decoded.Actions) != 2 {
+		t.Fatalf("Wrong actions: %+v", decoded.Actions)
+	}
+	queue, ok := decoded.Actions[0].(*ActionSetqueue)
+	if !ok || queue.QueueId != 5 {
+		t.Errorf("Wrong set_queue action: %+v", decoded.Actions[0])
+	}
+	output, ok := decoded.Actions[1].(*ActionOutput)
+	if !ok || output.Port != 2 {
+		t.Errorf("Wrong output action: %+v", decoded.Actions[1])
+	}
+}
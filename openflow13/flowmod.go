@@ -98,7 +98,7 @@ func (f *FlowMod) MarshalBinary() (data []byte, err error) {
 	n += 4
 	binary.BigEndian.PutUint32(bytes[n:], f.OutPort)
 	n += 4
-	binary.BigEndian.PutUint32(bytes[n:], f.OutPort)
+	binary.BigEndian.PutUint32(bytes[n:], f.OutGroup)
 	n += 4
 	binary.BigEndian.PutUint16(bytes[n:], f.Flags)
 	n += 2
@@ -199,6 +199,7 @@ type FlowRemoved struct {
 func NewFlowRemoved() *FlowRemoved {
 	f := new(FlowRemoved)
 	f.Header = NewOfp13Header()
+	f.Header.Type = Type_FlowRemoved
 	f.Match = *NewMatch()
 	return f
 }
@@ -214,6 +215,7 @@ func (f *FlowRemoved) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, int(f.Len()))
 	next := 0
 
+	f.Header.Length = f.Len()
 	bytes, err := f.Header.MarshalBinary()
 	copy(data[next:], bytes)
 	next += int(f.Header.Len())
@@ -4,6 +4,7 @@ package openflow13
 
 import (
 	"encoding/binary"
+	"errors"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/serngawy/libOpenflow/common"
@@ -186,6 +187,9 @@ func (b *Bucket) MarshalBinary() (data []byte, err error) {
 }
 
 func (b *Bucket) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("Wrong size to unmarshal a Bucket.")
+	}
 	n := 0
 	b.Length = binary.BigEndian.Uint16(data[n:])
 	n += 2
@@ -196,12 +200,93 @@ func (b *Bucket) UnmarshalBinary(data []byte) error {
 	b.WatchGroup = binary.BigEndian.Uint32(data[n:])
 	n += 4
 	n += 4 // for padding
+	if int(b.Length) < n || int(b.Length) > len(data) {
+		return errors.New("Wrong size to unmarshal a Bucket.")
+	}
 
 	for n < int(b.Length) {
-		a := DecodeAction(data[n:])
-		b.Actions = append(b.Actions, a)
-		n += int(a.Len())
+		// Each action has a 64 bit aligned header giving its length
+		if n+8 > int(b.Length) {
+			return errors.New("Wrong size to unmarshal an action of a Bucket.")
+		}
+		actLen := int(binary.BigEndian.Uint16(data[n+2:]))
+		if actLen < 8 || n+actLen > int(b.Length) {
+			return errors.New("Wrong size to unmarshal an action of a Bucket.")
+		}
+		act, err := DecodeAction(data[n : n+actLen])
+		if err != nil {
+			return err
+		}
+		b.Actions = append(b.Actions, act)
+		n += actLen
+	}
+
+	return nil
+}
+
+// ofp_group_desc 1.3, a group of a group description reply
+type GroupDesc struct {
+	Length  uint16
+	Type    uint8 /* One of OFPGT_*. */
+	pad     uint8
+	GroupId uint32
+	Buckets []Bucket
+}
+
+// Create the description of the group added by a group mod
+func NewGroupDesc(groupMod *GroupMod) *GroupDesc {
+	g := new(GroupDesc)
+	g.Type = groupMod.Type
+	g.GroupId = groupMod.GroupId
+	g.Buckets = groupMod.Buckets
+	g.Length = g.Len()
+	return g
+}
+
+func (g *GroupDesc) Len() (n uint16) {
+	n = 8
+	for _, b := range g.Buckets {
+		n += b.Len()
+	}
+	return
+}
+
+func (g *GroupDesc) MarshalBinary() (data []byte, err error) {
+	g.Length = g.Len()
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint16(data[0:], g.Length)
+	data[2] = g.Type
+	binary.BigEndian.PutUint32(data[4:], g.GroupId)
+
+	for _, bkt := range g.Buckets {
+		b, err := bkt.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (g *GroupDesc) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("Wrong size to unmarshal a GroupDesc.")
+	}
+	g.Length = binary.BigEndian.Uint16(data[0:])
+	g.Type = data[2]
+	g.GroupId = binary.BigEndian.Uint32(data[4:])
+	if g.Length < 8 || int(g.Length) > len(data) {
+		return errors.New("Wrong size to unmarshal a GroupDesc.")
 	}
 
+	g.Buckets = nil
+	for n := 8; n < int(g.Length); {
+		bkt := new(Bucket)
+		if err := bkt.UnmarshalBinary(data[n:g.Length]); err != nil {
+			return err
+		}
+		g.Buckets = append(g.Buckets, *bkt)
+		n += int(bkt.Length)
+	}
 	return nil
 }
@@ -0,0 +1,100 @@
+package openflow13
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func newTestGroupDesc() *GroupDesc {
+	groupMod := NewGroupMod()
+	groupMod.GroupId = 7
+	groupMod.Type = OFPGT_SELECT
+	for _, port := range []uint32{1, 2} {
+		bkt := NewBucket()
+		bkt.Weight = 50
+		bkt.AddAction(NewActionOutput(port))
+		groupMod.AddBucket(*bkt)
+	}
+	return NewGroupDesc(groupMod)
+}
+
+func TestGroupDescRoundTrip(t *testing.T) {
+	data, err := newTestGroupDesc().MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error encoding group desc. Err: %v", err)
+	}
+	desc := new(GroupDesc)
+	if err := desc.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Error decoding group desc. Err: %v", err)
+	}
+	if desc.GroupId != 7 || desc.Type != OFPGT_SELECT || len(desc.Buckets) != 2 {
+		t.Fatalf("Wrong group desc: %+v", desc)
+	}
+	for i, bkt := range desc.Buckets {
+		output, ok := bkt.Actions[0].(*ActionOutput)
+		if bkt.Weight != 50 || len(bkt.Actions) != 1 || !ok || output.Port != uint32(i+1) {
+			t.Errorf("Wrong bucket %d: %+v", i, bkt)
+		}
+	}
+}
+
+// Malformed group descriptions are errors, not panics
+func TestGroupDescMalformed(t *testing.T) {
+	valid, _ := newTestGroupDesc().MarshalBinary()
+	// The first bucket starts at 8, its first action at 24
+	tests := []struct {
+		name   string
+		modify func(data []byte) []byte
+	}{
+		{"short header", func(data []byte) []byte { return data[:6] }},
+		{"truncated", func(data []byte) []byte { return data[:len(data)-8] }},
+		{"group length below header", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[0:], 4)
+			return data
+		}},
+		{"bucket past group", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[8:], 0x100)
+			return data
+		}},
+		{"bucket below header", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[8:], 8)
+			return data
+		}},
+		{"zero length action", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[26:], 0)
+			return data
+		}},
+		{"action past bucket", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[26:], 24)
+			return data
+		}},
+		{"group shorter than bucket", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[0:], 20)
+			return data
+		}},
+	}
+
+	for _, test := range tests {
+		data := test.modify(append([]byte(nil), valid...))
+		if err := new(GroupDesc).UnmarshalBinary(data); err == nil {
+			t.Errorf("Wrong result of %s: got no error", test.name)
+		}
+	}
+}
+
+// A group desc reply truncated in the middle of a bucket is an error
+func TestGroupDescReplyTruncated(t *testing.T) {
+	rep := NewMpReply(MultipartType_GroupDesc)
+	rep.Body = append(rep.Body, newTestGroupDesc())
+	data, err := rep.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error encoding reply. Err: %v", err)
+	}
+
+	// Cut the last action and fix the message length
+	data = data[:len(data)-8]
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)))
+	if err := new(MultipartReply).UnmarshalBinary(data); err == nil {
+		t.Errorf("Truncated group desc reply decoded without error")
+	}
+}
@@ -198,13 +198,22 @@ func (instr *InstrActions) MarshalBinary() (data []byte, err error) {
 }
 
 func (instr *InstrActions) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("Wrong size to unmarshal an InstrActions message.")
+	}
 	instr.InstrHeader.UnmarshalBinary(data[:4])
+	if int(instr.Length) > len(data) {
+		return errors.New("Wrong size to unmarshal an InstrActions message.")
+	}
 
 	n := 8
 	for n < int(instr.Length) {
-		act := DecodeAction(data[n:])
+		act, err := DecodeAction(data[n:instr.Length])
+		if err != nil {
+			return err
+		}
 		instr.Actions = append(instr.Actions, act)
-		n += int(act.Len())
+		n += int(act.Header().Length)
 	}
 
 	return nil
@@ -232,6 +241,16 @@ func NewInstrWriteActions() *InstrActions {
 	return instr
 }
 
+func NewInstrClearActions() *InstrActions {
+	instr := new(InstrActions)
+	instr.Type = InstrType_CLEAR_ACTIONS
+	instr.pad = make([]byte, 4)
+	instr.Actions = make([]Action, 0)
+	instr.Length = instr.Len()
+
+	return instr
+}
+
 func NewInstrApplyActions() *InstrActions {
 	instr := new(InstrActions)
 	instr.Type = InstrType_APPLY_ACTIONS
@@ -247,6 +266,38 @@ type InstrMeter struct {
 	MeterId uint32
 }
 
+func (instr *InstrMeter) Len() (n uint16) {
+	return 8
+}
+
+func (instr *InstrMeter) MarshalBinary() (data []byte, err error) {
+	data, err = instr.InstrHeader.MarshalBinary()
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, instr.MeterId)
+
+	data = append(data, b...)
+	return
+}
+
+func (instr *InstrMeter) UnmarshalBinary(data []byte) error {
+	if len(data) < int(instr.Len()) {
+		return errors.New("Wrong size to unmarshal an InstrMeter message.")
+	}
+	instr.InstrHeader.UnmarshalBinary(data[:4])
+	instr.MeterId = binary.BigEndian.Uint32(data[4:8])
+	return nil
+}
+
+func NewInstrMeter(meterId uint32) *InstrMeter {
+	instr := new(InstrMeter)
+	instr.Type = InstrType_METER
+	instr.MeterId = meterId
+	instr.Length = instr.Len()
+
+	return instr
+}
+
 func (instr *InstrMeter) AddAction(act Action, prepend bool) error {
 	return errors.New("Not supported on this instrction")
 }
\ No newline at end of file
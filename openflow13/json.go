@@ -0,0 +1,544 @@
+package openflow13
+
+// This file implements the JSON encoding of the messages. The encoding is
+// meant for logs, debug endpoints and test diffs: the fields have fixed
+// names, addresses are decoded and enums are written by name. Objects are
+// written with sorted keys so the same message always gives the same JSON
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+type jsonObject map[string]interface{}
+
+// Adds the type and xid of the message header to its fields
+func msgJSON(h *common.Header, obj jsonObject) ([]byte, error) {
+	obj["version"] = h.Version
+	obj["type"] = h.TypeName()
+	obj["xid"] = h.Xid
+	return json.Marshal(obj)
+}
+
+// A reserved port is written by name, other ports as a number
+func portJSON(port uint32) interface{} {
+	if name, ok := ofctlPortNames[port]; ok {
+		return name
+	}
+	return port
+}
+
+func groupJSON(groupId uint32) interface{} {
+	if groupId == OFPG_ALL || groupId == OFPG_ANY {
+		return formatGroup(groupId)
+	}
+	return groupId
+}
+
+func meterJSON(meterId uint32) interface{} {
+	if meterId > OFPM_MAX {
+		return formatMeter(meterId)
+	}
+	return meterId
+}
+
+func bufferJSON(bufferId uint32) interface{} {
+	if bufferId == OFP_NO_BUFFER {
+		return nil
+	}
+	return bufferId
+}
+
+// Lists are written as [] rather than null when empty
+func actionsJSON(actions []Action) []Action {
+	if actions == nil {
+		return []Action{}
+	}
+	return actions
+}
+
+func instructionsJSON(instrs []Instruction) []Instruction {
+	if instrs == nil {
+		return []Instruction{}
+	}
+	return instrs
+}
+
+func fieldValueJSON(f *ofctlField, b []byte) interface{} {
+	if f == nil {
+		return fmt.Sprintf("0x%x", b)
+	}
+	switch {
+	case f.class == OXM_CLASS_OPENFLOW_BASIC && f.field == OXM_FIELD_ETH_TYPE:
+		return fmt.Sprintf("0x%04x", bytesUint(b))
+	case f.kind == ofctlDec:
+		return bytesUint(b)
+	case f.kind == ofctlPort:
+		return portJSON(uint32(bytesUint(b)))
+	case f.kind == ofctlIPv4 || f.kind == ofctlIPv6:
+		return net.IP(b).String()
+	}
+	return formatFieldValue(f.kind, b, false)
+}
+
+// A match field is written as {"field": "nw_dst", "value": "10.0.0.0",
+// "mask": "255.255.255.0"}
+func (m *MatchField) MarshalJSON() ([]byte, error) {
+	f := lookupOfctlField(m.Class, m.Field)
+	name := OxmFieldName(m.Class, m.Field)
+	if f != nil {
+		name = f.name
+	}
+	obj := jsonObject{
+		"field": name,
+		"value": fieldValueJSON(f, messageBytes(m.Value)),
+	}
+	if m.HasMask {
+		obj["mask"] = fieldValueJSON(f, messageBytes(m.Mask))
+	}
+	return json.Marshal(obj)
+}
+
+// A match is written as the list of its fields
+func (m *Match) MarshalJSON() ([]byte, error) {
+	fields := make([]*MatchField, 0, len(m.Fields))
+	for i := range m.Fields {
+		fields = append(fields, &m.Fields[i])
+	}
+	return json.Marshal(fields)
+}
+
+func actionJSON(a *ActionHeader, obj jsonObject) ([]byte, error) {
+	obj["type"] = enumName(actionTypeNames, uint32(a.Type))
+	return json.Marshal(obj)
+}
+
+func (a *ActionOutput) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"port": portJSON(a.Port), "max_len": a.MaxLen})
+}
+
+func (a *ActionGeneric) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{})
+}
+
+func (a *ActionSetqueue) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"queue_id": a.QueueId})
+}
+
+func (a *ActionGroup) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"group_id": a.GroupId})
+}
+
+func (a *ActionMplsTtl) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"ttl": a.MplsTtl})
+}
+
+func (a *ActionNwTtl) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"ttl": a.NwTtl})
+}
+
+func (a *ActionPush) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"ethertype": fmt.Sprintf("0x%04x", a.EtherType)})
+}
+
+func (a *ActionPopVlan) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{})
+}
+
+func (a *ActionPopMpls) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"ethertype": fmt.Sprintf("0x%04x", a.EtherType)})
+}
+
+func (a *ActionSetField) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{"field": &a.Field})
+}
+
+func (a *ActionExperimenter) MarshalJSON() ([]byte, error) {
+	return actionJSON(&a.ActionHeader, jsonObject{
+		"experimenter": a.Experimenter,
+		"data":         hex.EncodeToString(a.Data),
+	})
+}
+
+func instrJSON(instr *InstrHeader, obj jsonObject) ([]byte, error) {
+	obj["type"] = enumName(instrTypeNames, uint32(instr.Type))
+	return json.Marshal(obj)
+}
+
+func (instr *InstrGotoTable) MarshalJSON() ([]byte, error) {
+	return instrJSON(&instr.InstrHeader, jsonObject{"table_id": instr.TableId})
+}
+
+func (instr *InstrWriteMetadata) MarshalJSON() ([]byte, error) {
+	return instrJSON(&instr.InstrHeader, jsonObject{
+		"metadata":      fmt.Sprintf("0x%x", instr.Metadata),
+		"metadata_mask": fmt.Sprintf("0x%x", instr.MetadataMask),
+	})
+}
+
+func (instr *InstrActions) MarshalJSON() ([]byte, error) {
+	obj := jsonObject{}
+	if instr.Type != InstrType_CLEAR_ACTIONS {
+		obj["actions"] = actionsJSON(instr.Actions)
+	}
+	return instrJSON(&instr.InstrHeader, obj)
+}
+
+func (instr *InstrMeter) MarshalJSON() ([]byte, error) {
+	return instrJSON(&instr.InstrHeader, jsonObject{"meter_id": instr.MeterId})
+}
+
+func (f *FlowMod) MarshalJSON() ([]byte, error) {
+	return msgJSON(&f.Header, jsonObject{
+		"command":      enumName(flowModCommandNames, uint32(f.Command)),
+		"cookie":       fmt.Sprintf("0x%x", f.Cookie),
+		"cookie_mask":  fmt.Sprintf("0x%x", f.CookieMask),
+		"table_id":     f.TableId,
+		"idle_timeout": f.IdleTimeout,
+		"hard_timeout": f.HardTimeout,
+		"priority":     f.Priority,
+		"buffer_id":    bufferJSON(f.BufferId),
+		"out_port":     portJSON(f.OutPort),
+		"out_group":    groupJSON(f.OutGroup),
+		"flags":        formatFlowFlags(f.Flags),
+		"match":        &f.Match,
+		"instructions": instructionsJSON(f.Instructions),
+	})
+}
+
+func (f *FlowRemoved) MarshalJSON() ([]byte, error) {
+	return msgJSON(&f.Header, jsonObject{
+		"cookie":        fmt.Sprintf("0x%x", f.Cookie),
+		"priority":      f.Priority,
+		"reason":        enumName(flowRemovedReasonNames, uint32(f.Reason)),
+		"table_id":      f.TableId,
+		"duration_sec":  f.DurationSec,
+		"duration_nsec": f.DurationNSec,
+		"idle_timeout":  f.IdleTimeout,
+		"hard_timeout":  f.HardTimeout,
+		"packet_count":  f.PacketCount,
+		"byte_count":    f.ByteCount,
+		"match":         &f.Match,
+	})
+}
+
+func (p *PacketIn) MarshalJSON() ([]byte, error) {
+	obj := jsonObject{
+		"buffer_id": bufferJSON(p.BufferId),
+		"total_len": p.TotalLen,
+		"reason":    enumName(packetInReasonNames, uint32(p.Reason)),
+		"table_id":  p.TableId,
+		"cookie":    fmt.Sprintf("0x%x", p.Cookie),
+		"match":     &p.Match,
+	}
+	if len(p.Data.HWDst) != 0 {
+		eth := jsonObject{
+			"dl_src":  p.Data.HWSrc.String(),
+			"dl_dst":  p.Data.HWDst.String(),
+			"dl_type": fmt.Sprintf("0x%04x", p.Data.Ethertype),
+		}
+		if p.Data.VLANID.VID != 0 {
+			eth["dl_vlan"] = p.Data.VLANID.VID
+		}
+		obj["packet"] = eth
+	}
+	return msgJSON(&p.Header, obj)
+}
+
+func (p *PacketOut) MarshalJSON() ([]byte, error) {
+	return msgJSON(&p.Header, jsonObject{
+		"buffer_id": bufferJSON(p.BufferId),
+		"in_port":   portJSON(p.InPort),
+		"actions":   actionsJSON(p.Actions),
+		"data":      hex.EncodeToString(messageBytes(p.Data)),
+	})
+}
+
+func (e *ErrorMsg) MarshalJSON() ([]byte, error) {
+	return msgJSON(&e.Header, jsonObject{
+		"error_type": enumName(errorTypeNames, uint32(e.Type)),
+		"code":       errorCodeName(e.Type, e.Code),
+		"data":       hex.EncodeToString(e.Data.Bytes()),
+	})
+}
+
+func (s *SwitchFeatures) MarshalJSON() ([]byte, error) {
+	ports := s.Ports
+	if ports == nil {
+		ports = []PhyPort{}
+	}
+	return msgJSON(&s.Header, jsonObject{
+		"dpid":         s.DPID.String(),
+		"n_buffers":    s.Buffers,
+		"n_tables":     s.NumTables,
+		"auxiliary_id": s.AuxilaryId,
+		"capabilities": flagNames(capabilityNames, s.Capabilities),
+		"ports":        ports,
+	})
+}
+
+func (c *SwitchConfig) MarshalJSON() ([]byte, error) {
+	return msgJSON(&c.Header, jsonObject{
+		"frag":          enumName(fragNames, uint32(c.Flags&C_FRAG_MASK)),
+		"miss_send_len": c.MissSendLen,
+	})
+}
+
+func (v *VendorHeader) MarshalJSON() ([]byte, error) {
+	return msgJSON(&v.Header, jsonObject{"experimenter": v.Vendor})
+}
+
+func (p *PhyPort) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"port_no":    portJSON(p.PortNo),
+		"hw_addr":    p.HWAddr.String(),
+		"name":       cString(p.Name),
+		"config":     flagNames(portConfigNames, p.Config),
+		"state":      flagNames(portStateNames, p.State),
+		"curr":       p.Curr,
+		"advertised": p.Advertised,
+		"supported":  p.Supported,
+		"peer":       p.Peer,
+		"curr_speed": p.CurrSpeed,
+		"max_speed":  p.MaxSpeed,
+	})
+}
+
+func (p *PortStatus) MarshalJSON() ([]byte, error) {
+	return msgJSON(&p.Header, jsonObject{
+		"reason": enumName(portReasonNames, uint32(p.Reason)),
+		"desc":   &p.Desc,
+	})
+}
+
+func (p *PortMod) MarshalJSON() ([]byte, error) {
+	return msgJSON(&p.Header, jsonObject{
+		"port_no":   portJSON(p.PortNo),
+		"hw_addr":   net.HardwareAddr(p.HWAddr).String(),
+		"config":    flagNames(portConfigNames, p.Config),
+		"mask":      flagNames(portConfigNames, p.Mask),
+		"advertise": p.Advertise,
+	})
+}
+
+func (b *Bucket) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"weight":      b.Weight,
+		"watch_port":  portJSON(b.WatchPort),
+		"watch_group": groupJSON(b.WatchGroup),
+		"actions":     actionsJSON(b.Actions),
+	})
+}
+
+func (g *GroupMod) MarshalJSON() ([]byte, error) {
+	buckets := make([]*Bucket, 0, len(g.Buckets))
+	for i := range g.Buckets {
+		buckets = append(buckets, &g.Buckets[i])
+	}
+	return msgJSON(&g.Header, jsonObject{
+		"command":    enumName(groupCommandNames, uint32(g.Command)),
+		"group_type": enumName(groupTypeNames, uint32(g.Type)),
+		"group_id":   groupJSON(g.GroupId),
+		"buckets":    buckets,
+	})
+}
+
+func (g *GroupDesc) MarshalJSON() ([]byte, error) {
+	buckets := make([]*Bucket, 0, len(g.Buckets))
+	for i := range g.Buckets {
+		buckets = append(buckets, &g.Buckets[i])
+	}
+	return json.Marshal(jsonObject{
+		"group_type": enumName(groupTypeNames, uint32(g.Type)),
+		"group_id":   groupJSON(g.GroupId),
+		"buckets":    buckets,
+	})
+}
+
+func bandJSON(b *MeterBandHeader, obj jsonObject) ([]byte, error) {
+	obj["type"] = enumName(meterBandTypeNames, uint32(b.Type))
+	obj["rate"] = b.Rate
+	obj["burst_size"] = b.BurstSize
+	return json.Marshal(obj)
+}
+
+func (b *MeterBandDrop) MarshalJSON() ([]byte, error) {
+	return bandJSON(&b.MeterBandHeader, jsonObject{})
+}
+
+func (b *MeterBandDSCP) MarshalJSON() ([]byte, error) {
+	return bandJSON(&b.MeterBandHeader, jsonObject{"prec_level": b.PrecLevel})
+}
+
+func (b *MeterBandExperimenter) MarshalJSON() ([]byte, error) {
+	return bandJSON(&b.MeterBandHeader, jsonObject{
+		"experimenter": b.Experimenter,
+		"data":         hex.EncodeToString(b.Data),
+	})
+}
+
+func (m *MeterMod) MarshalJSON() ([]byte, error) {
+	bands := m.Bands
+	if bands == nil {
+		bands = []MeterBand{}
+	}
+	return msgJSON(&m.Header, jsonObject{
+		"command":  enumName(meterCommandNames, uint32(m.Command)),
+		"flags":    flagNames(meterFlagNames, uint32(m.Flags)),
+		"meter_id": m.MeterId,
+		"bands":    bands,
+	})
+}
+
+func (m *MeterConfig) MarshalJSON() ([]byte, error) {
+	bands := m.Bands
+	if bands == nil {
+		bands = []MeterBand{}
+	}
+	return json.Marshal(jsonObject{
+		"flags":    flagNames(meterFlagNames, uint32(m.Flags)),
+		"meter_id": meterJSON(m.MeterId),
+		"bands":    bands,
+	})
+}
+
+func (r *MeterMultipartRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{"meter_id": meterJSON(r.MeterId)})
+}
+
+func (r *RoleRequest) MarshalJSON() ([]byte, error) {
+	return msgJSON(&r.Header, jsonObject{
+		"role":          enumName(roleNames, r.Role),
+		"generation_id": r.GenerationId,
+	})
+}
+
+func (s *MultipartRequest) MarshalJSON() ([]byte, error) {
+	return msgJSON(&s.Header, jsonObject{
+		"multipart_type": enumName(multipartTypeNames, uint32(s.Type)),
+		"flags":          flagNames(multipartFlagNames, uint32(s.Flags)),
+		"body":           s.Body,
+	})
+}
+
+func (s *MultipartReply) MarshalJSON() ([]byte, error) {
+	body := s.Body
+	if body == nil {
+		body = []util.Message{}
+	}
+	return msgJSON(&s.Header, jsonObject{
+		"multipart_type": enumName(multipartTypeNames, uint32(s.Type)),
+		"flags":          flagNames(multipartFlagNames, uint32(s.Flags)),
+		"body":           body,
+	})
+}
+
+func flowFilterJSON(tableId uint8, outPort, outGroup uint32, cookie, cookieMask uint64, match *Match) ([]byte, error) {
+	var table interface{} = tableId
+	if tableId == OFPTT_ALL {
+		table = "all"
+	}
+	return json.Marshal(jsonObject{
+		"table_id":    table,
+		"out_port":    portJSON(outPort),
+		"out_group":   groupJSON(outGroup),
+		"cookie":      fmt.Sprintf("0x%x", cookie),
+		"cookie_mask": fmt.Sprintf("0x%x", cookieMask),
+		"match":       match,
+	})
+}
+
+func (s *FlowStatsRequest) MarshalJSON() ([]byte, error) {
+	return flowFilterJSON(s.TableId, s.OutPort, s.OutGroup, s.Cookie, s.CookieMask, &s.Match)
+}
+
+func (s *AggregateStatsRequest) MarshalJSON() ([]byte, error) {
+	return flowFilterJSON(s.TableId, s.OutPort, s.OutGroup, s.Cookie, s.CookieMask, &s.Match)
+}
+
+func (f *FlowStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"table_id":      f.TableId,
+		"duration_sec":  f.DurationSec,
+		"duration_nsec": f.DurationNSec,
+		"priority":      f.Priority,
+		"idle_timeout":  f.IdleTimeout,
+		"hard_timeout":  f.HardTimeout,
+		"flags":         formatFlowFlags(f.Flags),
+		"cookie":        fmt.Sprintf("0x%x", f.Cookie),
+		"packet_count":  f.PacketCount,
+		"byte_count":    f.ByteCount,
+		"match":         &f.Match,
+		"instructions":  instructionsJSON(f.Instructions),
+	})
+}
+
+func (s *AggregateStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"packet_count": s.PacketCount,
+		"byte_count":   s.ByteCount,
+		"flow_count":   s.FlowCount,
+	})
+}
+
+func (s *DescStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"mfr_desc":   cString(s.MfrDesc),
+		"hw_desc":    cString(s.HWDesc),
+		"sw_desc":    cString(s.SWDesc),
+		"serial_num": cString(s.SerialNum),
+		"dp_desc":    cString(s.DPDesc),
+	})
+}
+
+func (s *TableStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"table_id":      s.TableId,
+		"active_count":  s.ActiveCount,
+		"lookup_count":  s.LookupCount,
+		"matched_count": s.MatchedCount,
+	})
+}
+
+func (s *PortStatsRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{"port_no": portJSON(s.PortNo)})
+}
+
+func (s *PortStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"port_no":       portJSON(s.PortNo),
+		"rx_packets":    s.RxPackets,
+		"tx_packets":    s.TxPackets,
+		"rx_bytes":      s.RxBytes,
+		"tx_bytes":      s.TxBytes,
+		"rx_dropped":    s.RxDropped,
+		"tx_dropped":    s.TxDropped,
+		"rx_errors":     s.RxErrors,
+		"tx_errors":     s.TxErrors,
+		"rx_frame_err":  s.RxFrameErr,
+		"rx_over_err":   s.RxOverErr,
+		"rx_crc_err":    s.RxCRCErr,
+		"collisions":    s.Collisions,
+		"duration_sec":  s.DurationSec,
+		"duration_nsec": s.DurationNSec,
+	})
+}
+
+func (s *QueueStatsRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{"port_no": s.PortNo, "queue_id": s.QueueId})
+}
+
+func (s *QueueStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonObject{
+		"port_no":    s.PortNo,
+		"queue_id":   s.QueueId,
+		"tx_bytes":   s.TxBytes,
+		"tx_packets": s.TxPackets,
+		"tx_errors":  s.TxErrors,
+	})
+}
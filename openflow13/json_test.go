@@ -0,0 +1,61 @@
+package openflow13
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	req := NewMpRequest(MultipartType_Meter)
+	req.Header.Xid = 2
+	req.Body = NewMeterMultipartRequest(OFPM_ALL)
+	rep := NewMpReply(MultipartType_GroupDesc)
+	rep.Header.Xid = 3
+	rep.Body = append(rep.Body, newTestGroupDesc())
+
+	bucket := func(port string) string {
+		return `{"actions":[{"max_len":256,"port":` + port + `,"type":"output"}],` +
+			`"watch_group":"any","watch_port":"ANY","weight":50}`
+	}
+	groupDesc := `{"buckets":[` + bucket("1") + "," + bucket("2") + `],"group_id":7,"group_type":"select"}`
+	tests := []struct {
+		name string
+		msg  json.Marshaler
+		want string
+	}{
+		{"group desc", newTestGroupDesc(), groupDesc},
+		{"meter config", newTestMeterConfig(),
+			`{"bands":[{"burst_size":100,"rate":1000,"type":"drop"},` +
+				`{"burst_size":200,"prec_level":1,"rate":2000,"type":"dscp_remark"}],` +
+				`"flags":["kbps","stats"],"meter_id":5}`},
+		{"empty meter config", new(MeterConfig), `{"bands":[],"flags":[],"meter_id":0}`},
+		{"meter request", NewMeterMultipartRequest(3), `{"meter_id":3}`},
+		{"all meters request", NewMeterMultipartRequest(OFPM_ALL), `{"meter_id":"all"}`},
+		{"multipart request", req,
+			`{"body":{"meter_id":"all"},"flags":[],"multipart_type":"meter","type":"multipart_request","version":4,"xid":2}`},
+		{"multipart reply", rep,
+			`{"body":[` + groupDesc + `],"flags":[],"multipart_type":"group_desc","type":"multipart_reply","version":4,"xid":3}`},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(test.msg)
+		if err != nil {
+			t.Errorf("Error encoding %s. Err: %v", test.name, err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("Wrong JSON of %s:\n got %s\nwant %s", test.name, data, test.want)
+			continue
+		}
+
+		// Keys are sorted, so encoding again gives the same bytes
+		for i := 0; i < 10; i++ {
+			again, err := json.Marshal(test.msg)
+			if err != nil || !bytes.Equal(again, data) {
+				t.Errorf("Unstable JSON of %s: got %s, want %s", test.name, again, data)
+				break
+			}
+		}
+	}
+}
@@ -182,6 +182,7 @@ func DecodeMatchField(class uint16, field uint8, data []byte) (util.Message, err
 		case OXM_FIELD_IN_PORT:
 			val = new(InPortField)
 		case OXM_FIELD_IN_PHY_PORT:
+			val = new(InPhyPortField)
 		case OXM_FIELD_METADATA:
 			val = new(MetadataField)
 		case OXM_FIELD_ETH_DST:
@@ -193,9 +194,11 @@ func DecodeMatchField(class uint16, field uint8, data []byte) (util.Message, err
 		case OXM_FIELD_VLAN_VID:
 			val = new(VlanIdField)
 		case OXM_FIELD_VLAN_PCP:
+			val = new(VlanPcpField)
 		case OXM_FIELD_IP_DSCP:
 			val = new(IpDscpField)
 		case OXM_FIELD_IP_ECN:
+			val = new(IpEcnField)
 		case OXM_FIELD_IP_PROTO:
 			val = new(IpProtoField)
 		case OXM_FIELD_IPV4_SRC:
@@ -211,34 +214,51 @@ func DecodeMatchField(class uint16, field uint8, data []byte) (util.Message, err
 		case OXM_FIELD_UDP_DST:
 			val = new(PortField)
 		case OXM_FIELD_SCTP_SRC:
+			val = new(PortField)
 		case OXM_FIELD_SCTP_DST:
+			val = new(PortField)
 		case OXM_FIELD_ICMPV4_TYPE:
+			val = new(IcmpTypeField)
 		case OXM_FIELD_ICMPV4_CODE:
+			val = new(IcmpCodeField)
 		case OXM_FIELD_ARP_OP:
 			val = new(ArpOperField)
 		case OXM_FIELD_ARP_SPA:
+			val = new(ArpSpaField)
 		case OXM_FIELD_ARP_TPA:
+			val = new(ArpTpaField)
 		case OXM_FIELD_ARP_SHA:
+			val = new(ArpShaField)
 		case OXM_FIELD_ARP_THA:
+			val = new(ArpThaField)
 		case OXM_FIELD_IPV6_SRC:
 			val = new(Ipv6SrcField)
 		case OXM_FIELD_IPV6_DST:
 			val = new(Ipv6DstField)
 		case OXM_FIELD_IPV6_FLABEL:
+			val = new(Ipv6FlowLabelField)
 		case OXM_FIELD_ICMPV6_TYPE:
+			val = new(IcmpTypeField)
 		case OXM_FIELD_ICMPV6_CODE:
+			val = new(IcmpCodeField)
 		case OXM_FIELD_IPV6_ND_TARGET:
+			val = new(Ipv6NdTargetField)
 		case OXM_FIELD_IPV6_ND_SLL:
+			val = new(Ipv6NdSllField)
 		case OXM_FIELD_IPV6_ND_TLL:
+			val = new(Ipv6NdTllField)
 		case OXM_FIELD_MPLS_LABEL:
 			val = new(MplsLabelField)
 		case OXM_FIELD_MPLS_TC:
+			val = new(MplsTcField)
 		case OXM_FIELD_MPLS_BOS:
 			val = new(MplsBosField)
 		case OXM_FIELD_PBB_ISID:
+			val = new(PbbIsidField)
 		case OXM_FIELD_TUNNEL_ID:
 			val = new(TunnelIdField)
 		case OXM_FIELD_IPV6_EXTHDR:
+			val = new(Ipv6ExtHdrField)
 		case OXM_FIELD_TCP_FLAGS:
 			val = new(TcpFlagsField)
 		default:
@@ -266,11 +286,10 @@ func DecodeMatchField(class uint16, field uint8, data []byte) (util.Message, err
 
 		val.UnmarshalBinary(data)
 		return val, nil
-	} else {
-		log.Panic("Unsupported match field: %d in class: %d", field, class)
 	}
 
-	return nil, nil
+	log.Printf("Unsupported match field: %d in class: %d", field, class)
+	return nil, fmt.Errorf("Unsupported match field: %d in class: %d", field, class)
 }
 
 //  ofp_match_type 1.3
@@ -430,6 +449,7 @@ func (m *EthDstField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *EthDstField) UnmarshalBinary(data []byte) error {
+	m.EthDst = make(net.HardwareAddr, 6)
 	copy(m.EthDst, data)
 	return nil
 }
@@ -473,6 +493,7 @@ func (m *EthSrcField) MarshalBinary() (data []byte, err error) {
 }
 
 func (m *EthSrcField) UnmarshalBinary(data []byte) error {
+	m.EthSrc = make(net.HardwareAddr, 6)
 	copy(m.EthSrc, data)
 	return nil
 }
@@ -823,7 +844,7 @@ func NewIpv6DstField(ipDst net.IP, ipDstMask *net.IP) *MatchField {
 
 // IP_PROTO field
 type IpProtoField struct {
-	protocol uint8
+	Protocol uint8
 }
 
 func (m *IpProtoField) Len() uint16 {
@@ -831,12 +852,12 @@ func (m *IpProtoField) Len() uint16 {
 }
 func (m *IpProtoField) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, 1)
-	data[0] = m.protocol
+	data[0] = m.Protocol
 	return
 }
 
 func (m *IpProtoField) UnmarshalBinary(data []byte) error {
-	m.protocol = data[0]
+	m.Protocol = data[0]
 	return nil
 }
 
@@ -848,7 +869,7 @@ func NewIpProtoField(protocol uint8) *MatchField {
 	f.HasMask = false
 
 	ipProtoField := new(IpProtoField)
-	ipProtoField.protocol = protocol
+	ipProtoField.Protocol = protocol
 	f.Value = ipProtoField
 	f.Length = uint8(ipProtoField.Len())
 
@@ -857,7 +878,7 @@ func NewIpProtoField(protocol uint8) *MatchField {
 
 // IP_DSCP field
 type IpDscpField struct {
-	dscp uint8
+	Dscp uint8
 }
 
 func (m *IpDscpField) Len() uint16 {
@@ -865,12 +886,12 @@ func (m *IpDscpField) Len() uint16 {
 }
 func (m *IpDscpField) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, 1)
-	data[0] = m.dscp
+	data[0] = m.Dscp
 	return
 }
 
 func (m *IpDscpField) UnmarshalBinary(data []byte) error {
-	m.dscp = data[0]
+	m.Dscp = data[0]
 	return nil
 }
 
@@ -882,7 +903,7 @@ func NewIpDscpField(dscp uint8) *MatchField {
 	f.HasMask = false
 
 	ipDscpField := new(IpDscpField)
-	ipDscpField.dscp = dscp
+	ipDscpField.Dscp = dscp
 	f.Value = ipDscpField
 	f.Length = uint8(ipDscpField.Len())
 
@@ -968,7 +989,7 @@ func NewMetadataField(metadata uint64, metadataMask *uint64) *MatchField {
 
 // Common struct for all port fields
 type PortField struct {
-	port uint16
+	Port uint16
 }
 
 func (m *PortField) Len() uint16 {
@@ -976,12 +997,12 @@ func (m *PortField) Len() uint16 {
 }
 func (m *PortField) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, m.Len())
-	binary.BigEndian.PutUint16(data, m.port)
+	binary.BigEndian.PutUint16(data, m.Port)
 	return
 }
 
 func (m *PortField) UnmarshalBinary(data []byte) error {
-	m.port = binary.BigEndian.Uint16(data)
+	m.Port = binary.BigEndian.Uint16(data)
 	return nil
 }
 
@@ -993,7 +1014,7 @@ func NewTcpSrcField(port uint16) *MatchField {
 	f.HasMask = false
 
 	tcpSrcField := new(PortField)
-	tcpSrcField.port = port
+	tcpSrcField.Port = port
 	f.Value = tcpSrcField
 	f.Length = uint8(tcpSrcField.Len())
 
@@ -1008,7 +1029,7 @@ func NewTcpDstField(port uint16) *MatchField {
 	f.HasMask = false
 
 	tcpSrcField := new(PortField)
-	tcpSrcField.port = port
+	tcpSrcField.Port = port
 	f.Value = tcpSrcField
 	f.Length = uint8(tcpSrcField.Len())
 
@@ -1023,7 +1044,7 @@ func NewUdpSrcField(port uint16) *MatchField {
 	f.HasMask = false
 
 	tcpSrcField := new(PortField)
-	tcpSrcField.port = port
+	tcpSrcField.Port = port
 	f.Value = tcpSrcField
 	f.Length = uint8(tcpSrcField.Len())
 
@@ -1038,7 +1059,7 @@ func NewUdpDstField(port uint16) *MatchField {
 	f.HasMask = false
 
 	tcpSrcField := new(PortField)
-	tcpSrcField.port = port
+	tcpSrcField.Port = port
 	f.Value = tcpSrcField
 	f.Length = uint8(tcpSrcField.Len())
 
@@ -1205,4 +1226,689 @@ func NewTunnelIpv4DstField(tunnelIpDst net.IP, tunnelIpDstMask *net.IP) *MatchFi
 	}
 
 	return f
-}
\ No newline at end of file
+}
+// IN_PHY_PORT field
+type InPhyPortField struct {
+	InPhyPort uint32
+}
+
+func (m *InPhyPortField) Len() uint16 {
+	return 4
+}
+func (m *InPhyPortField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 4)
+
+	binary.BigEndian.PutUint32(data, m.InPhyPort)
+	return
+}
+func (m *InPhyPortField) UnmarshalBinary(data []byte) error {
+	m.InPhyPort = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+// Return a MatchField for physical input port matching
+func NewInPhyPortField(inPhyPort uint32) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IN_PHY_PORT
+	f.HasMask = false
+
+	inPhyPortField := new(InPhyPortField)
+	inPhyPortField.InPhyPort = inPhyPort
+	f.Value = inPhyPortField
+	f.Length = uint8(inPhyPortField.Len())
+
+	return f
+}
+
+// VLAN_PCP field
+type VlanPcpField struct {
+	VlanPcp uint8
+}
+
+func (m *VlanPcpField) Len() uint16 {
+	return 1
+}
+func (m *VlanPcpField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 1)
+	data[0] = m.VlanPcp
+	return
+}
+func (m *VlanPcpField) UnmarshalBinary(data []byte) error {
+	m.VlanPcp = data[0]
+	return nil
+}
+
+// Return a MatchField for vlan priority matching
+func NewVlanPcpField(vlanPcp uint8) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_VLAN_PCP
+	f.HasMask = false
+
+	vlanPcpField := new(VlanPcpField)
+	vlanPcpField.VlanPcp = vlanPcp
+	f.Value = vlanPcpField
+	f.Length = uint8(vlanPcpField.Len())
+
+	return f
+}
+
+// IP_ECN field
+type IpEcnField struct {
+	Ecn uint8
+}
+
+func (m *IpEcnField) Len() uint16 {
+	return 1
+}
+func (m *IpEcnField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 1)
+	data[0] = m.Ecn
+	return
+}
+func (m *IpEcnField) UnmarshalBinary(data []byte) error {
+	m.Ecn = data[0]
+	return nil
+}
+
+// Return a MatchField for ipv4/ipv6 ecn
+func NewIpEcnField(ecn uint8) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IP_ECN
+	f.HasMask = false
+
+	ipEcnField := new(IpEcnField)
+	ipEcnField.Ecn = ecn
+	f.Value = ipEcnField
+	f.Length = uint8(ipEcnField.Len())
+
+	return f
+}
+
+// SCTP_SRC field
+func NewSctpSrcField(port uint16) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_SCTP_SRC
+	f.HasMask = false
+
+	sctpSrcField := new(PortField)
+	sctpSrcField.Port = port
+	f.Value = sctpSrcField
+	f.Length = uint8(sctpSrcField.Len())
+
+	return f
+}
+
+// SCTP_DST field
+func NewSctpDstField(port uint16) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_SCTP_DST
+	f.HasMask = false
+
+	sctpDstField := new(PortField)
+	sctpDstField.Port = port
+	f.Value = sctpDstField
+	f.Length = uint8(sctpDstField.Len())
+
+	return f
+}
+
+// Common struct for the ICMPv4 and ICMPv6 type fields
+type IcmpTypeField struct {
+	Type uint8
+}
+
+func (m *IcmpTypeField) Len() uint16 {
+	return 1
+}
+func (m *IcmpTypeField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 1)
+	data[0] = m.Type
+	return
+}
+func (m *IcmpTypeField) UnmarshalBinary(data []byte) error {
+	m.Type = data[0]
+	return nil
+}
+
+// Common struct for the ICMPv4 and ICMPv6 code fields
+type IcmpCodeField struct {
+	Code uint8
+}
+
+func (m *IcmpCodeField) Len() uint16 {
+	return 1
+}
+func (m *IcmpCodeField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 1)
+	data[0] = m.Code
+	return
+}
+func (m *IcmpCodeField) UnmarshalBinary(data []byte) error {
+	m.Code = data[0]
+	return nil
+}
+
+func newIcmpTypeField(field uint8, icmpType uint8) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = field
+	f.HasMask = false
+
+	icmpTypeField := new(IcmpTypeField)
+	icmpTypeField.Type = icmpType
+	f.Value = icmpTypeField
+	f.Length = uint8(icmpTypeField.Len())
+
+	return f
+}
+
+func newIcmpCodeField(field uint8, icmpCode uint8) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = field
+	f.HasMask = false
+
+	icmpCodeField := new(IcmpCodeField)
+	icmpCodeField.Code = icmpCode
+	f.Value = icmpCodeField
+	f.Length = uint8(icmpCodeField.Len())
+
+	return f
+}
+
+// ICMPV4_TYPE field
+func NewIcmpv4TypeField(icmpType uint8) *MatchField {
+	return newIcmpTypeField(OXM_FIELD_ICMPV4_TYPE, icmpType)
+}
+
+// ICMPV4_CODE field
+func NewIcmpv4CodeField(icmpCode uint8) *MatchField {
+	return newIcmpCodeField(OXM_FIELD_ICMPV4_CODE, icmpCode)
+}
+
+// ICMPV6_TYPE field
+func NewIcmpv6TypeField(icmpType uint8) *MatchField {
+	return newIcmpTypeField(OXM_FIELD_ICMPV6_TYPE, icmpType)
+}
+
+// ICMPV6_CODE field
+func NewIcmpv6CodeField(icmpCode uint8) *MatchField {
+	return newIcmpCodeField(OXM_FIELD_ICMPV6_CODE, icmpCode)
+}
+
+// ARP source IPv4 address field
+type ArpSpaField struct {
+	ArpSpa net.IP
+}
+
+func (m *ArpSpaField) Len() uint16 {
+	return 4
+}
+func (m *ArpSpaField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 4)
+	copy(data, m.ArpSpa.To4())
+	return
+}
+
+func (m *ArpSpaField) UnmarshalBinary(data []byte) error {
+	m.ArpSpa = net.IPv4(data[0], data[1], data[2], data[3])
+	return nil
+}
+
+// Return a MatchField for arp source ipv4 addr
+func NewArpSpaField(arpSpa net.IP, arpSpaMask *net.IP) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_ARP_SPA
+	f.HasMask = false
+
+	arpSpaField := new(ArpSpaField)
+	arpSpaField.ArpSpa = arpSpa
+	f.Value = arpSpaField
+	f.Length = uint8(arpSpaField.Len())
+
+	// Add the mask
+	if arpSpaMask != nil {
+		mask := new(ArpSpaField)
+		mask.ArpSpa = *arpSpaMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// ARP target IPv4 address field
+type ArpTpaField struct {
+	ArpTpa net.IP
+}
+
+func (m *ArpTpaField) Len() uint16 {
+	return 4
+}
+func (m *ArpTpaField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 4)
+	copy(data, m.ArpTpa.To4())
+	return
+}
+
+func (m *ArpTpaField) UnmarshalBinary(data []byte) error {
+	m.ArpTpa = net.IPv4(data[0], data[1], data[2], data[3])
+	return nil
+}
+
+// Return a MatchField for arp target ipv4 addr
+func NewArpTpaField(arpTpa net.IP, arpTpaMask *net.IP) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_ARP_TPA
+	f.HasMask = false
+
+	arpTpaField := new(ArpTpaField)
+	arpTpaField.ArpTpa = arpTpa
+	f.Value = arpTpaField
+	f.Length = uint8(arpTpaField.Len())
+
+	// Add the mask
+	if arpTpaMask != nil {
+		mask := new(ArpTpaField)
+		mask.ArpTpa = *arpTpaMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// ARP source hardware address field
+type ArpShaField struct {
+	ArpSha net.HardwareAddr
+}
+
+func (m *ArpShaField) Len() uint16 {
+	return 6
+}
+func (m *ArpShaField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 6)
+	copy(data, m.ArpSha)
+	return
+}
+
+func (m *ArpShaField) UnmarshalBinary(data []byte) error {
+	m.ArpSha = make(net.HardwareAddr, 6)
+	copy(m.ArpSha, data)
+	return nil
+}
+
+// Return a MatchField for arp source hardware addr
+func NewArpShaField(arpSha net.HardwareAddr, arpShaMask *net.HardwareAddr) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_ARP_SHA
+	f.HasMask = false
+
+	arpShaField := new(ArpShaField)
+	arpShaField.ArpSha = arpSha
+	f.Value = arpShaField
+	f.Length = uint8(arpShaField.Len())
+
+	// Add the mask
+	if arpShaMask != nil {
+		mask := new(ArpShaField)
+		mask.ArpSha = *arpShaMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// ARP target hardware address field
+type ArpThaField struct {
+	ArpTha net.HardwareAddr
+}
+
+func (m *ArpThaField) Len() uint16 {
+	return 6
+}
+func (m *ArpThaField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 6)
+	copy(data, m.ArpTha)
+	return
+}
+
+func (m *ArpThaField) UnmarshalBinary(data []byte) error {
+	m.ArpTha = make(net.HardwareAddr, 6)
+	copy(m.ArpTha, data)
+	return nil
+}
+
+// Return a MatchField for arp target hardware addr
+func NewArpThaField(arpTha net.HardwareAddr, arpThaMask *net.HardwareAddr) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_ARP_THA
+	f.HasMask = false
+
+	arpThaField := new(ArpThaField)
+	arpThaField.ArpTha = arpTha
+	f.Value = arpThaField
+	f.Length = uint8(arpThaField.Len())
+
+	// Add the mask
+	if arpThaMask != nil {
+		mask := new(ArpThaField)
+		mask.ArpTha = *arpThaMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// IPV6_FLABEL field
+type Ipv6FlowLabelField struct {
+	FlowLabel uint32
+}
+
+func (m *Ipv6FlowLabelField) Len() uint16 {
+	return 4
+}
+func (m *Ipv6FlowLabelField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 4)
+
+	binary.BigEndian.PutUint32(data, m.FlowLabel)
+	return
+}
+func (m *Ipv6FlowLabelField) UnmarshalBinary(data []byte) error {
+	m.FlowLabel = binary.BigEndian.Uint32(data)
+	return nil
+}
+
+// Return a MatchField for ipv6 flow label matching
+func NewIpv6FlowLabelField(flowLabel uint32, flowLabelMask *uint32) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IPV6_FLABEL
+	f.HasMask = false
+
+	flowLabelField := new(Ipv6FlowLabelField)
+	flowLabelField.FlowLabel = flowLabel
+	f.Value = flowLabelField
+	f.Length = uint8(flowLabelField.Len())
+
+	// Add the mask
+	if flowLabelMask != nil {
+		mask := new(Ipv6FlowLabelField)
+		mask.FlowLabel = *flowLabelMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// IPV6_ND_TARGET field
+type Ipv6NdTargetField struct {
+	NdTarget net.IP
+}
+
+func (m *Ipv6NdTargetField) Len() uint16 {
+	return 16
+}
+func (m *Ipv6NdTargetField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 16)
+	copy(data, m.NdTarget)
+	return
+}
+
+func (m *Ipv6NdTargetField) UnmarshalBinary(data []byte) error {
+	m.NdTarget = make([]byte, 16)
+	copy(m.NdTarget, data)
+	return nil
+}
+
+// Return a MatchField for ipv6 neighbor discovery target addr
+func NewIpv6NdTargetField(ndTarget net.IP) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IPV6_ND_TARGET
+	f.HasMask = false
+
+	ndTargetField := new(Ipv6NdTargetField)
+	ndTargetField.NdTarget = ndTarget
+	f.Value = ndTargetField
+	f.Length = uint8(ndTargetField.Len())
+
+	return f
+}
+
+// IPV6_ND_SLL field
+type Ipv6NdSllField struct {
+	NdSll net.HardwareAddr
+}
+
+func (m *Ipv6NdSllField) Len() uint16 {
+	return 6
+}
+func (m *Ipv6NdSllField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 6)
+	copy(data, m.NdSll)
+	return
+}
+
+func (m *Ipv6NdSllField) UnmarshalBinary(data []byte) error {
+	m.NdSll = make(net.HardwareAddr, 6)
+	copy(m.NdSll, data)
+	return nil
+}
+
+// Return a MatchField for ipv6 neighbor discovery source link-layer addr
+func NewIpv6NdSllField(ndSll net.HardwareAddr) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IPV6_ND_SLL
+	f.HasMask = false
+
+	ndSllField := new(Ipv6NdSllField)
+	ndSllField.NdSll = ndSll
+	f.Value = ndSllField
+	f.Length = uint8(ndSllField.Len())
+
+	return f
+}
+
+// IPV6_ND_TLL field
+type Ipv6NdTllField struct {
+	NdTll net.HardwareAddr
+}
+
+func (m *Ipv6NdTllField) Len() uint16 {
+	return 6
+}
+func (m *Ipv6NdTllField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 6)
+	copy(data, m.NdTll)
+	return
+}
+
+func (m *Ipv6NdTllField) UnmarshalBinary(data []byte) error {
+	m.NdTll = make(net.HardwareAddr, 6)
+	copy(m.NdTll, data)
+	return nil
+}
+
+// Return a MatchField for ipv6 neighbor discovery target link-layer addr
+func NewIpv6NdTllField(ndTll net.HardwareAddr) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IPV6_ND_TLL
+	f.HasMask = false
+
+	ndTllField := new(Ipv6NdTllField)
+	ndTllField.NdTll = ndTll
+	f.Value = ndTllField
+	f.Length = uint8(ndTllField.Len())
+
+	return f
+}
+
+// MPLS_TC field
+type MplsTcField struct {
+	MplsTc uint8
+}
+
+func (m *MplsTcField) Len() uint16 {
+	return 1
+}
+
+func (m *MplsTcField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 1)
+	data[0] = m.MplsTc
+	return
+}
+func (m *MplsTcField) UnmarshalBinary(data []byte) error {
+	m.MplsTc = data[0]
+	return nil
+}
+
+// Return a MatchField for mpls traffic class matching
+func NewMplsTcField(mplsTc uint8) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_MPLS_TC
+	f.HasMask = false
+
+	mplsTcField := new(MplsTcField)
+	mplsTcField.MplsTc = mplsTc
+	f.Value = mplsTcField
+	f.Length = uint8(mplsTcField.Len())
+	return f
+}
+
+// PBB_ISID field, 24 bits on the wire
+type PbbIsidField struct {
+	PbbIsid uint32
+}
+
+func (m *PbbIsidField) Len() uint16 {
+	return 3
+}
+
+func (m *PbbIsidField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 3)
+	data[0] = uint8(m.PbbIsid >> 16)
+	data[1] = uint8(m.PbbIsid >> 8)
+	data[2] = uint8(m.PbbIsid)
+	return
+}
+func (m *PbbIsidField) UnmarshalBinary(data []byte) error {
+	m.PbbIsid = uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+	return nil
+}
+
+// Return a MatchField for pbb service instance id matching
+func NewPbbIsidField(pbbIsid uint32, pbbIsidMask *uint32) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_PBB_ISID
+	f.HasMask = false
+
+	pbbIsidField := new(PbbIsidField)
+	pbbIsidField.PbbIsid = pbbIsid
+	f.Value = pbbIsidField
+	f.Length = uint8(pbbIsidField.Len())
+
+	// Add the mask
+	if pbbIsidMask != nil {
+		mask := new(PbbIsidField)
+		mask.PbbIsid = *pbbIsidMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// ofp_ipv6exthdr_flags 1.3
+const (
+	OFPIEH_NONEXT = 1 << 0 /* "No next header" encountered. */
+	OFPIEH_ESP    = 1 << 1 /* Encrypted Sec Payload header present. */
+	OFPIEH_AUTH   = 1 << 2 /* Authentication header present. */
+	OFPIEH_DEST   = 1 << 3 /* 1 or 2 dest headers present. */
+	OFPIEH_FRAG   = 1 << 4 /* Fragment header present. */
+	OFPIEH_ROUTER = 1 << 5 /* Router header present. */
+	OFPIEH_HOP    = 1 << 6 /* Hop-by-hop header present. */
+	OFPIEH_UNREP  = 1 << 7 /* Unexpected repeats encountered. */
+	OFPIEH_UNSEQ  = 1 << 8 /* Unexpected sequencing encountered. */
+)
+
+// IPV6_EXTHDR field
+type Ipv6ExtHdrField struct {
+	ExtHdr uint16
+}
+
+func (m *Ipv6ExtHdrField) Len() uint16 {
+	return 2
+}
+func (m *Ipv6ExtHdrField) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 2)
+
+	binary.BigEndian.PutUint16(data, m.ExtHdr)
+	return
+}
+func (m *Ipv6ExtHdrField) UnmarshalBinary(data []byte) error {
+	m.ExtHdr = binary.BigEndian.Uint16(data)
+	return nil
+}
+
+// Return a MatchField for ipv6 extension header pseudo-field matching
+func NewIpv6ExtHdrField(extHdr uint16, extHdrMask *uint16) *MatchField {
+	f := new(MatchField)
+	f.Class = OXM_CLASS_OPENFLOW_BASIC
+	f.Field = OXM_FIELD_IPV6_EXTHDR
+	f.HasMask = false
+
+	extHdrField := new(Ipv6ExtHdrField)
+	extHdrField.ExtHdr = extHdr
+	f.Value = extHdrField
+	f.Length = uint8(extHdrField.Len())
+
+	// Add the mask
+	if extHdrMask != nil {
+		mask := new(Ipv6ExtHdrField)
+		mask.ExtHdr = *extHdrMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
+
+// Return a MatchField for masked tunnel id matching
+func NewTunnelIdMaskField(tunnelId uint64, tunnelIdMask *uint64) *MatchField {
+	f := NewTunnelIdField(tunnelId)
+
+	// Add the mask
+	if tunnelIdMask != nil {
+		mask := new(TunnelIdField)
+		mask.TunnelId = *tunnelIdMask
+		f.Mask = mask
+		f.HasMask = true
+		f.Length += uint8(mask.Len())
+	}
+
+	return f
+}
@@ -0,0 +1,76 @@
+package openflow13
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+// OXM encodings of the match fields, as given by the OpenFlow 1.3 spec:
+// class, field << 1 | hasmask, length, value and mask
+func TestMatchFieldEncoding(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	macMask := net.HardwareAddr{0xff, 0xff, 0xff, 0x00, 0x00, 0x00}
+	ipMask := net.ParseIP("255.255.255.0").To4()
+	flowLabelMask := uint32(0xfffff)
+	isidMask := uint32(0xffff00)
+	extHdrMask := uint16(0x1ff)
+	tunnelIdMask := uint64(0xff)
+
+	tests := []struct {
+		name  string
+		field *MatchField
+		want  string
+	}{
+		{"in_phy_port", NewInPhyPortField(3), "8000020400000003"},
+		{"vlan_pcp", NewVlanPcpField(5), "80000e0105"},
+		{"ip_ecn", NewIpEcnField(2), "8000120102"},
+		{"sctp_src", NewSctpSrcField(80), "80002202" + "0050"},
+		{"sctp_dst", NewSctpDstField(443), "80002402" + "01bb"},
+		{"icmpv4_type", NewIcmpv4TypeField(8), "8000260108"},
+		{"icmpv4_code", NewIcmpv4CodeField(1), "8000280101"},
+		{"arp_spa", NewArpSpaField(net.ParseIP("10.0.0.1"), nil), "80002c04" + "0a000001"},
+		{"masked arp_tpa", NewArpTpaField(net.ParseIP("10.0.0.1"), &ipMask), "80002f08" + "0a000001" + "ffffff00"},
+		{"arp_sha", NewArpShaField(mac, nil), "80003006" + "001122334455"},
+		{"masked arp_tha", NewArpThaField(mac, &macMask), "8000330c" + "001122334455" + "ffffff000000"},
+		{"ipv6_flabel", NewIpv6FlowLabelField(0x12345, nil), "80003804" + "00012345"},
+		{"masked ipv6_flabel", NewIpv6FlowLabelField(0x12345, &flowLabelMask), "80003908" + "00012345" + "000fffff"},
+		{"icmpv6_type", NewIcmpv6TypeField(135), "80003a0187"},
+		{"icmpv6_code", NewIcmpv6CodeField(0), "80003c0100"},
+		{"ipv6_nd_target", NewIpv6NdTargetField(net.ParseIP("fe80::1")), "80003e10" + "fe800000000000000000000000000001"},
+		{"ipv6_nd_sll", NewIpv6NdSllField(mac), "80004006" + "001122334455"},
+		{"ipv6_nd_tll", NewIpv6NdTllField(mac), "80004206" + "001122334455"},
+		{"mpls_tc", NewMplsTcField(3), "8000460103"},
+		{"pbb_isid", NewPbbIsidField(0x123456, nil), "80004a03" + "123456"},
+		{"masked pbb_isid", NewPbbIsidField(0x123456, &isidMask), "80004b06" + "123456" + "ffff00"},
+		{"masked tunnel_id", NewTunnelIdMaskField(100, &tunnelIdMask), "80004d10" + "0000000000000064" + "00000000000000ff"},
+		{"ipv6_exthdr", NewIpv6ExtHdrField(0x41, nil), "80004e02" + "0041"},
+		{"masked ipv6_exthdr", NewIpv6ExtHdrField(0x41, &extHdrMask), "80004f04" + "0041" + "01ff"},
+	}
+
+	for _, test := range tests {
+		data, err := test.field.MarshalBinary()
+		if err != nil {
+			t.Errorf("Error encoding %s. Err: %v", test.name, err)
+			continue
+		}
+		if got := hex.EncodeToString(data); got != test.want {
+			t.Errorf("Wrong encoding of %s: got %s, want %s", test.name, got, test.want)
+			continue
+		}
+
+		decoded := new(MatchField)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Errorf("Error decoding %s. Err: %v", test.name, err)
+			continue
+		}
+		if decoded.Field != test.field.Field || decoded.HasMask != test.field.HasMask || int(decoded.Len()) != len(data) {
+			t.Errorf("Wrong decoded %s: got %+v, want %+v", test.name, decoded, test.field)
+			continue
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil || hex.EncodeToString(again) != test.want {
+			t.Errorf("Wrong re-encoding of %s: got %x, want %s", test.name, again, test.want)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package openflow13
+
+// This file has all meter related defs
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+const (
+	OFPM_MAX = 0xffff0000 /* Last usable meter. */
+	/* Virtual meters. */
+	OFPM_SLOWPATH   = 0xfffffffd /* Meter for slow datapath. */
+	OFPM_CONTROLLER = 0xfffffffe /* Meter for controller connection. */
+	OFPM_ALL        = 0xffffffff /* Represents all meters for stat requests commands. */
+)
+
+const (
+	OFPMC_ADD    = 0 /* New meter. */
+	OFPMC_MODIFY = 1 /* Modify specified meter. */
+	OFPMC_DELETE = 2 /* Delete specified meter. */
+)
+
+const (
+	OFPMF_KBPS  = 1 << 0 /* Rate value in kb/s (kilo-bit per second). */
+	OFPMF_PKTPS = 1 << 1 /* Rate value in packet/sec. */
+	OFPMF_BURST = 1 << 2 /* Do burst size. */
+	OFPMF_STATS = 1 << 3 /* Collect statistics. */
+)
+
+const (
+	OFPMBT_DROP         = 1      /* Drop packet. */
+	OFPMBT_DSCP_REMARK  = 2      /* Remark DSCP in the IP header. */
+	OFPMBT_EXPERIMENTER = 0xFFFF /* Experimenter meter band. */
+)
+
+// MeterMod message
+type MeterMod struct {
+	common.Header
+	Command uint16      /* One of OFPMC_*. */
+	Flags   uint16      /* Bitmap of OFPMF_* flags. */
+	MeterId uint32      /* Meter instance. */
+	Bands   []MeterBand /* List of bands */
+}
+
+// Create a new meter mod message
+func NewMeterMod() *MeterMod {
+	m := new(MeterMod)
+	m.Header = NewOfp13Header()
+	m.Header.Type = Type_MeterMod
+
+	m.Command = OFPMC_ADD
+	m.Flags = OFPMF_KBPS
+	m.MeterId = 0
+	m.Bands = make([]MeterBand, 0)
+	return m
+}
+
+// Add a band to meter mod
+func (m *MeterMod) AddBand(band MeterBand) {
+	m.Bands = append(m.Bands, band)
+}
+
+func (m *MeterMod) Len() (n uint16) {
+	n = m.Header.Len()
+	n += 8
+	if m.Command == OFPMC_DELETE {
+		return
+	}
+
+	for _, b := range m.Bands {
+		n += b.Len()
+	}
+
+	return
+}
+
+func (m *MeterMod) MarshalBinary() (data []byte, err error) {
+	m.Header.Length = m.Len()
+	data, err = m.Header.MarshalBinary()
+
+	bytes := make([]byte, 8)
+	n := 0
+	binary.BigEndian.PutUint16(bytes[n:], m.Command)
+	n += 2
+	binary.BigEndian.PutUint16(bytes[n:], m.Flags)
+	n += 2
+	binary.BigEndian.PutUint32(bytes[n:], m.MeterId)
+	n += 4
+	data = append(data, bytes...)
+
+	if m.Command == OFPMC_DELETE {
+		return
+	}
+
+	for _, band := range m.Bands {
+		bytes, err = band.MarshalBinary()
+		data = append(data, bytes...)
+	}
+
+	return
+}
+
+func (m *MeterMod) UnmarshalBinary(data []byte) error {
+	n := 0
+	m.Header.UnmarshalBinary(data[n:])
+	n += int(m.Header.Len())
+
+	if len(data) < n+8 {
+		return errors.New("Wrong size to unmarshal a MeterMod message.")
+	}
+	m.Command = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	m.Flags = binary.BigEndian.Uint16(data[n:])
+	n += 2
+	m.MeterId = binary.BigEndian.Uint32(data[n:])
+	n += 4
+
+	m.Bands = make([]MeterBand, 0)
+	for n < int(m.Header.Length) {
+		band, err := DecodeMeterBand(data[n:])
+		if err != nil {
+			return err
+		}
+		m.Bands = append(m.Bands, band)
+		n += int(band.Len())
+	}
+
+	return nil
+}
+
+type MeterBand interface {
+	util.Message
+	Header() *MeterBandHeader
+}
+
+// Common header of all meter bands
+type MeterBandHeader struct {
+	Type      uint16 /* One of OFPMBT_*. */
+	Length    uint16 /* Length in bytes of this band. */
+	Rate      uint32 /* Rate for this band. */
+	BurstSize uint32 /* Size of bursts. */
+}
+
+func (b *MeterBandHeader) Header() *MeterBandHeader {
+	return b
+}
+
+func (b *MeterBandHeader) Len() (n uint16) {
+	return 12
+}
+
+func (b *MeterBandHeader) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, b.Len())
+	binary.BigEndian.PutUint16(data[0:], b.Type)
+	binary.BigEndian.PutUint16(data[2:], b.Length)
+	binary.BigEndian.PutUint32(data[4:], b.Rate)
+	binary.BigEndian.PutUint32(data[8:], b.BurstSize)
+	return
+}
+
+func (b *MeterBandHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < int(b.Len()) {
+		return errors.New("Wrong size to unmarshal a MeterBandHeader.")
+	}
+	b.Type = binary.BigEndian.Uint16(data[0:])
+	b.Length = binary.BigEndian.Uint16(data[2:])
+	b.Rate = binary.BigEndian.Uint32(data[4:])
+	b.BurstSize = binary.BigEndian.Uint32(data[8:])
+	return nil
+}
+
+// Decode a meter band from its wire format
+func DecodeMeterBand(data []byte) (MeterBand, error) {
+	if len(data) < 2 {
+		return nil, errors.New("Wrong size to decode a meter band.")
+	}
+	var b MeterBand
+	switch binary.BigEndian.Uint16(data[:2]) {
+	case OFPMBT_DROP:
+		b = new(MeterBandDrop)
+	case OFPMBT_DSCP_REMARK:
+		b = new(MeterBandDSCP)
+	case OFPMBT_EXPERIMENTER:
+		b = new(MeterBandExperimenter)
+	default:
+		return nil, errors.New("Unknown meter band type.")
+	}
+	if err := b.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Drop packets exceeding the band rate
+type MeterBandDrop struct {
+	MeterBandHeader
+	pad []byte // 4 bytes
+}
+
+func NewMeterBandDrop(rate, burstSize uint32) *MeterBandDrop {
+	b := new(MeterBandDrop)
+	b.Type = OFPMBT_DROP
+	b.Rate = rate
+	b.BurstSize = burstSize
+	b.pad = make([]byte, 4)
+	b.Length = b.Len()
+	return b
+}
+
+func (b *MeterBandDrop) Len() (n uint16) {
+	return 16
+}
+
+func (b *MeterBandDrop) MarshalBinary() (data []byte, err error) {
+	b.Length = b.Len()
+	data, err = b.MeterBandHeader.MarshalBinary()
+	data = append(data, make([]byte, 4)...)
+	return
+}
+
+func (b *MeterBandDrop) UnmarshalBinary(data []byte) error {
+	if len(data) < int(b.Len()) {
+		return errors.New("Wrong size to unmarshal a MeterBandDrop.")
+	}
+	return b.MeterBandHeader.UnmarshalBinary(data)
+}
+
+// Remark the DSCP of packets exceeding the band rate
+type MeterBandDSCP struct {
+	MeterBandHeader
+	PrecLevel uint8  /* Number of drop precedence level to add. */
+	pad       []byte // 3 bytes
+}
+
+func NewMeterBandDSCP(rate, burstSize uint32, precLevel uint8) *MeterBandDSCP {
+	b := new(MeterBandDSCP)
+	b.Type = OFPMBT_DSCP_REMARK
+	b.Rate = rate
+	b.BurstSize = burstSize
+	b.PrecLevel = precLevel
+	b.pad = make([]byte, 3)
+	b.Length = b.Len()
+	return b
+}
+
+func (b *MeterBandDSCP) Len() (n uint16) {
+	return 16
+}
+
+func (b *MeterBandDSCP) MarshalBinary() (data []byte, err error) {
+	b.Length = b.Len()
+	data, err = b.MeterBandHeader.MarshalBinary()
+	bytes := make([]byte, 4)
+	bytes[0] = b.PrecLevel
+	data = append(data, bytes...)
+	return
+}
+
+func (b *MeterBandDSCP) UnmarshalBinary(data []byte) error {
+	if len(data) < int(b.Len()) {
+		return errors.New("Wrong size to unmarshal a MeterBandDSCP.")
+	}
+	b.MeterBandHeader.UnmarshalBinary(data)
+	b.PrecLevel = data[12]
+	return nil
+}
+
+// Experimenter meter band
+type MeterBandExperimenter struct {
+	MeterBandHeader
+	Experimenter uint32 /* Experimenter ID */
+	Data         []byte /* Experimenter defined data */
+}
+
+func (b *MeterBandExperimenter) Len() (n uint16) {
+	return 16 + uint16(len(b.Data))
+}
+
+func (b *MeterBandExperimenter) MarshalBinary() (data []byte, err error) {
+	b.Length = b.Len()
+	data, err = b.MeterBandHeader.MarshalBinary()
+	bytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(bytes, b.Experimenter)
+	data = append(data, bytes...)
+	data = append(data, b.Data...)
+	return
+}
+
+func (b *MeterBandExperimenter) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errors.New("Wrong size to unmarshal a MeterBandExperimenter.")
+	}
+	b.MeterBandHeader.UnmarshalBinary(data)
+	if int(b.Length) < 16 || len(data) < int(b.Length) {
+		return errors.New("Wrong size to unmarshal a MeterBandExperimenter.")
+	}
+	b.Experimenter = binary.BigEndian.Uint32(data[12:])
+	b.Data = make([]byte, int(b.Length)-16)
+	copy(b.Data, data[16:b.Length])
+	return nil
+}
+
+// ofp_meter_multipart_request 1.3, body of the meter stats and meter
+// config requests
+type MeterMultipartRequest struct {
+	MeterId uint32 /* Meter instance, or OFPM_ALL. */
+	pad     []byte // 4 bytes
+}
+
+func NewMeterMultipartRequest(meterId uint32) *MeterMultipartRequest {
+	r := new(MeterMultipartRequest)
+	r.MeterId = meterId
+	r.pad = make([]byte, 4)
+	return r
+}
+
+func (r *MeterMultipartRequest) Len() (n uint16) {
+	return 8
+}
+
+func (r *MeterMultipartRequest) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, r.Len())
+	binary.BigEndian.PutUint32(data[0:], r.MeterId)
+	return
+}
+
+func (r *MeterMultipartRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < int(r.Len()) {
+		return errors.New("Wrong size to unmarshal a MeterMultipartRequest.")
+	}
+	r.MeterId = binary.BigEndian.Uint32(data[0:])
+	return nil
+}
+
+// ofp_meter_config 1.3, a meter of a meter config reply
+type MeterConfig struct {
+	Length  uint16
+	Flags   uint16 /* Bitmap of OFPMF_* flags. */
+	MeterId uint32
+	Bands   []MeterBand
+}
+
+// Create the config of the meter added by a meter mod
+func NewMeterConfig(meterMod *MeterMod) *MeterConfig {
+	m := new(MeterConfig)
+	m.Flags = meterMod.Flags
+	m.MeterId = meterMod.MeterId
+	m.Bands = meterMod.Bands
+	m.Length = m.Len()
+	return m
+}
+
+func (m *MeterConfig) Len() (n uint16) {
+	n = 8
+	for _, b := range m.Bands {
+		n += b.Len()
+	}
+	return
+}
+
+func (m *MeterConfig) MarshalBinary() (data []byte, err error) {
+	m.Length = m.Len()
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint16(data[0:], m.Length)
+	binary.BigEndian.PutUint16(data[2:], m.Flags)
+	binary.BigEndian.PutUint32(data[4:], m.MeterId)
+
+	for _, band := range m.Bands {
+		b, err := band.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+func (m *MeterConfig) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("Wrong size to unmarshal a MeterConfig.")
+	}
+	m.Length = binary.BigEndian.Uint16(data[0:])
+	m.Flags = binary.BigEndian.Uint16(data[2:])
+	m.MeterId = binary.BigEndian.Uint32(data[4:])
+	if int(m.Length) > len(data) {
+		return errors.New("Wrong size to unmarshal a MeterConfig.")
+	}
+
+	m.Bands = nil
+	for n := 8; n < int(m.Length); {
+		band, err := DecodeMeterBand(data[n:])
+		if err != nil {
+			return err
+		}
+		m.Bands = append(m.Bands, band)
+		n += int(band.Len())
+	}
+	return nil
+}
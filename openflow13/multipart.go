@@ -18,8 +18,23 @@ type MultipartRequest struct {
 	Body  util.Message
 }
 
+// Create a new multipart request of the given type. Body is left empty
+// for the request types that don't carry one (desc, table, port desc ..)
+func NewMpRequest(mpType uint16) *MultipartRequest {
+	s := new(MultipartRequest)
+	s.Header = NewOfp13Header()
+	s.Header.Type = Type_MultiPartRequest
+	s.Type = mpType
+	s.pad = make([]byte, 4)
+	return s
+}
+
 func (s *MultipartRequest) Len() (n uint16) {
-	return s.Header.Len() + 8 + s.Body.Len()
+	n = s.Header.Len() + 8
+	if s.Body != nil {
+		n += s.Body.Len()
+	}
+	return
 }
 
 func (s *MultipartRequest) MarshalBinary() (data []byte, err error) {
@@ -35,8 +50,10 @@ func (s *MultipartRequest) MarshalBinary() (data []byte, err error) {
 	n += 4 // for padding
 	data = append(data, b...)
 
-	b, err = s.Body.MarshalBinary()
-	data = append(data, b...)
+	if s.Body != nil {
+		b, err = s.Body.MarshalBinary()
+		data = append(data, b...)
+	}
 
 	log.Debugf("Sending MultipartRequest (%d): %v", len(data), data)
 
@@ -56,24 +73,28 @@ func (s *MultipartRequest) UnmarshalBinary(data []byte) error {
 	var req util.Message
 	switch s.Type {
 	case MultipartType_Aggregate:
-		req = s.Body.(*AggregateStatsRequest)
-		err = req.UnmarshalBinary(data[n:])
+		req = NewAggregateStatsRequest()
 	case MultipartType_Desc:
 		break
 	case MultipartType_Flow:
-		req = s.Body.(*FlowStatsRequest)
-		err = req.UnmarshalBinary(data[n:])
+		req = NewFlowStatsRequest()
 	case MultipartType_Port:
-		req = s.Body.(*PortStatsRequest)
-		err = req.UnmarshalBinary(data[n:])
+		req = NewPortStatsRequest()
 	case MultipartType_Table:
 		break
 	case MultipartType_Queue:
-		req = s.Body.(*QueueStatsRequest)
-		err = req.UnmarshalBinary(data[n:])
+		req = NewQueueStatsRequest()
+	case MultipartType_GroupDesc:
+		break
+	case MultipartType_Meter, MultipartType_MeterConfig:
+		req = NewMeterMultipartRequest(OFPM_ALL)
 	case MultipartType_Experimenter:
 		break
 	}
+	if req != nil {
+		err = req.UnmarshalBinary(data[n:])
+		s.Body = req
+	}
 	return err
 }
 
@@ -86,6 +107,16 @@ type MultipartReply struct {
 	Body  []util.Message
 }
 
+// Create a new multipart reply of the given type, without any body
+func NewMpReply(mpType uint16) *MultipartReply {
+	s := new(MultipartReply)
+	s.Header = NewOfp13Header()
+	s.Header.Type = Type_MultiPartReply
+	s.Type = mpType
+	s.pad = make([]byte, 4)
+	return s
+}
+
 func (s *MultipartReply) Len() (n uint16) {
 	n = s.Header.Len()
 	n += 8
@@ -132,7 +163,7 @@ func (s *MultipartReply) UnmarshalBinary(data []byte) error {
 		case MultipartType_Aggregate:
 			repl = new(AggregateStats)
 		case MultipartType_Desc:
-			repl = new(DescStats)
+			repl = NewDescStats()
 		case MultipartType_Flow:
 			repl = new(FlowStats)
 		case MultipartType_Port:
@@ -141,14 +172,25 @@ func (s *MultipartReply) UnmarshalBinary(data []byte) error {
 			repl = new(TableStats)
 		case MultipartType_Queue:
 			repl = new(QueueStats)
+		case MultipartType_GroupDesc:
+			repl = new(GroupDesc)
+		case MultipartType_MeterConfig:
+			repl = new(MeterConfig)
 		// FIXME: Support all types
 		case MultipartType_Experimenter:
 			break
 		}
 
+		if repl == nil {
+			log.Warnf("Unsupported multipart reply type: %d", s.Type)
+			break
+		}
+
 		err = repl.UnmarshalBinary(data[n:])
 		if err != nil {
+			// The length of a malformed body can't be trusted
 			log.Printf("Error parsing stats reply")
+			break
 		}
 		n += repl.Len()
 		req = append(req, repl)
@@ -688,15 +730,16 @@ const (
 	MAX_TABLE_NAME_LEN = 32
 )
 
-// ofp_port_stats_request 1.0
+// ofp_port_stats_request 1.3
 type PortStatsRequest struct {
-	PortNo uint16
-	pad    []uint8 // Size 6
+	PortNo uint32
+	pad    []uint8 // Size 4
 }
 
 func NewPortStatsRequest() *PortStatsRequest {
 	p := new(PortStatsRequest)
-	p.pad = make([]byte, 6)
+	p.PortNo = P_ANY
+	p.pad = make([]byte, 4)
 	return p
 }
 
@@ -707,8 +750,8 @@ func (s *PortStatsRequest) Len() (n uint16) {
 func (s *PortStatsRequest) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, int(s.Len()))
 	n := 0
-	binary.BigEndian.PutUint16(data[n:], s.PortNo)
-	n += 2
+	binary.BigEndian.PutUint32(data[n:], s.PortNo)
+	n += 4
 	copy(data[n:], s.pad)
 	n += len(s.pad)
 	return
@@ -716,48 +759,50 @@ func (s *PortStatsRequest) MarshalBinary() (data []byte, err error) {
 
 func (s *PortStatsRequest) UnmarshalBinary(data []byte) error {
 	n := 0
-	s.PortNo = binary.BigEndian.Uint16(data[n:])
-	n += 2
+	s.PortNo = binary.BigEndian.Uint32(data[n:])
+	n += 4
 	copy(s.pad, data[n:])
 	n += len(s.pad)
 	return nil
 }
 
-// ofp_port_stats 1.0
+// ofp_port_stats 1.3
 type PortStats struct {
-	PortNo     uint16
-	pad        []uint8 // Size 6
-	RxPackets  uint64
-	TxPackets  uint64
-	RxBytes    uint64
-	TxBytes    uint64
-	RxDropped  uint64
-	TxDropped  uint64
-	RxErrors   uint64
-	TxErrors   uint64
-	RxFrameErr uint64
-	RxOverErr  uint64
-	RxCRCErr   uint64
-	Collisions uint64
+	PortNo       uint32
+	pad          []uint8 // Size 4
+	RxPackets    uint64
+	TxPackets    uint64
+	RxBytes      uint64
+	TxBytes      uint64
+	RxDropped    uint64
+	TxDropped    uint64
+	RxErrors     uint64
+	TxErrors     uint64
+	RxFrameErr   uint64
+	RxOverErr    uint64
+	RxCRCErr     uint64
+	Collisions   uint64
+	DurationSec  uint32 /* Time port has been alive in seconds. */
+	DurationNSec uint32 /* Time port has been alive in nanoseconds beyond duration_sec. */
 }
 
 func NewPortStats() *PortStats {
 	p := new(PortStats)
-	p.pad = make([]byte, 6)
+	p.pad = make([]byte, 4)
 	return p
 }
 
 func (s *PortStats) Len() (n uint16) {
-	return 104
+	return 112
 }
 
 func (s *PortStats) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, int(s.Len()))
 	n := 0
-	binary.BigEndian.PutUint16(data[n:], s.PortNo)
-	n += 2
+	binary.BigEndian.PutUint32(data[n:], s.PortNo)
+	n += 4
 	copy(data[n:], s.pad)
-	n += len(s.pad)
+	n += 4
 	binary.BigEndian.PutUint64(data[n:], s.RxPackets)
 	n += 8
 	binary.BigEndian.PutUint64(data[n:], s.TxPackets)
@@ -782,15 +827,19 @@ func (s *PortStats) MarshalBinary() (data []byte, err error) {
 	n += 8
 	binary.BigEndian.PutUint64(data[n:], s.Collisions)
 	n += 8
+	binary.BigEndian.PutUint32(data[n:], s.DurationSec)
+	n += 4
+	binary.BigEndian.PutUint32(data[n:], s.DurationNSec)
+	n += 4
 	return
 }
 
 func (s *PortStats) UnmarshalBinary(data []byte) error {
 	n := 0
-	s.PortNo = binary.BigEndian.Uint16(data[n:])
-	n += 2
-	copy(s.pad, data[n:])
-	n += len(s.pad)
+	s.PortNo = binary.BigEndian.Uint32(data[n:])
+	n += 4
+	copy(s.pad, data[n:n+4])
+	n += 4
 	s.RxPackets = binary.BigEndian.Uint64(data[n:])
 	n += 8
 	s.TxPackets = binary.BigEndian.Uint64(data[n:])
@@ -815,6 +864,10 @@ func (s *PortStats) UnmarshalBinary(data []byte) error {
 	n += 8
 	s.Collisions = binary.BigEndian.Uint64(data[n:])
 	n += 8
+	s.DurationSec = binary.BigEndian.Uint32(data[n:])
+	n += 4
+	s.DurationNSec = binary.BigEndian.Uint32(data[n:])
+	n += 4
 	return nil
 }
 
@@ -918,7 +971,9 @@ type PortStatus struct {
 func NewPortStatus() *PortStatus {
 	p := new(PortStatus)
 	p.Header = NewOfp13Header()
+	p.Header.Type = Type_PortStatus
 	p.pad = make([]byte, 7)
+	p.Desc = *NewPhyPort()
 	return p
 }
 
@@ -0,0 +1,487 @@
+package openflow13
+
+// This file implements formatting flows in the ovs-ofctl syntax, e.g.
+// "table=0,priority=100,tcp,nw_dst=10.0.0.0/24,tp_dst=80,actions=output:2"
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/serngawy/libOpenflow/util"
+)
+
+// How the value of a match field is written
+type ofctlKind int
+
+const (
+	ofctlDec  ofctlKind = iota // Decimal integer
+	ofctlHex                   // Hexadecimal integer
+	ofctlMac                   // Ethernet address
+	ofctlIPv4                  // IPv4 address, masks as prefix lengths when possible
+	ofctlIPv6                  // IPv6 address, masks as prefix lengths when possible
+	ofctlPort                  // Port number or name of a reserved port
+)
+
+// A match field in the ovs-ofctl syntax
+type ofctlField struct {
+	name  string
+	class uint16
+	field uint8
+	size  int // Size of the value in bytes
+	kind  ofctlKind
+}
+
+var ofctlFields = []ofctlField{
+	{"in_port", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IN_PORT, 4, ofctlPort},
+	{"in_phy_port", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IN_PHY_PORT, 4, ofctlPort},
+	{"metadata", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_METADATA, 8, ofctlHex},
+	{"dl_dst", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ETH_DST, 6, ofctlMac},
+	{"dl_src", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ETH_SRC, 6, ofctlMac},
+	{"dl_type", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ETH_TYPE, 2, ofctlHex},
+	{"vlan_vid", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_VLAN_VID, 2, ofctlHex},
+	{"dl_vlan_pcp", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_VLAN_PCP, 1, ofctlDec},
+	{"ip_dscp", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IP_DSCP, 1, ofctlDec},
+	{"nw_ecn", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IP_ECN, 1, ofctlDec},
+	{"nw_proto", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IP_PROTO, 1, ofctlDec},
+	{"nw_src", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV4_SRC, 4, ofctlIPv4},
+	{"nw_dst", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV4_DST, 4, ofctlIPv4},
+	{"tcp_src", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TCP_SRC, 2, ofctlDec},
+	{"tcp_dst", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TCP_DST, 2, ofctlDec},
+	{"udp_src", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_UDP_SRC, 2, ofctlDec},
+	{"udp_dst", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_UDP_DST, 2, ofctlDec},
+	{"sctp_src", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_SCTP_SRC, 2, ofctlDec},
+	{"sctp_dst", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_SCTP_DST, 2, ofctlDec},
+	{"icmp_type", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ICMPV4_TYPE, 1, ofctlDec},
+	{"icmp_code", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ICMPV4_CODE, 1, ofctlDec},
+	{"arp_op", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ARP_OP, 2, ofctlDec},
+	{"arp_spa", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ARP_SPA, 4, ofctlIPv4},
+	{"arp_tpa", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ARP_TPA, 4, ofctlIPv4},
+	{"arp_sha", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ARP_SHA, 6, ofctlMac},
+	{"arp_tha", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ARP_THA, 6, ofctlMac},
+	{"ipv6_src", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_SRC, 16, ofctlIPv6},
+	{"ipv6_dst", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_DST, 16, ofctlIPv6},
+	{"ipv6_label", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_FLABEL, 4, ofctlHex},
+	{"icmpv6_type", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ICMPV6_TYPE, 1, ofctlDec},
+	{"icmpv6_code", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_ICMPV6_CODE, 1, ofctlDec},
+	{"nd_target", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_ND_TARGET, 16, ofctlIPv6},
+	{"nd_sll", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_ND_SLL, 6, ofctlMac},
+	{"nd_tll", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_ND_TLL, 6, ofctlMac},
+	{"mpls_label", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_MPLS_LABEL, 4, ofctlDec},
+	{"mpls_tc", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_MPLS_TC, 1, ofctlDec},
+	{"mpls_bos", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_MPLS_BOS, 1, ofctlDec},
+	{"pbb_isid", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_PBB_ISID, 3, ofctlHex},
+	{"tun_id", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TUNNEL_ID, 8, ofctlHex},
+	{"ipv6_exthdr", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_IPV6_EXTHDR, 2, ofctlHex},
+	{"tcp_flags", OXM_CLASS_OPENFLOW_BASIC, OXM_FIELD_TCP_FLAGS, 2, ofctlHex},
+	{"tun_src", OXM_CLASS_NXM_1, NXM_NX_TUN_IPV4_SRC, 4, ofctlIPv4},
+	{"tun_dst", OXM_CLASS_NXM_1, NXM_NX_TUN_IPV4_DST, 4, ofctlIPv4},
+}
+
+// Shorthands for eth_type and ip_proto, e.g. "tcp"
+var ofctlProtocols = []struct {
+	name    string
+	ethType uint16
+	ipProto uint8
+}{
+	{"ip", ETH_TYPE_IPV4, 0},
+	{"ipv6", ETH_TYPE_IPV6, 0},
+	{"arp", ETH_TYPE_ARP, 0},
+	{"mpls", ETH_TYPE_MPLS, 0},
+	{"mplsm", ETH_TYPE_MPLS_MCAST, 0},
+	{"icmp", ETH_TYPE_IPV4, IP_PROTO_ICMP},
+	{"tcp", ETH_TYPE_IPV4, IP_PROTO_TCP},
+	{"udp", ETH_TYPE_IPV4, IP_PROTO_UDP},
+	{"sctp", ETH_TYPE_IPV4, IP_PROTO_SCTP},
+	{"icmp6", ETH_TYPE_IPV6, IP_PROTO_ICMPV6},
+	{"tcp6", ETH_TYPE_IPV6, IP_PROTO_TCP},
+	{"udp6", ETH_TYPE_IPV6, IP_PROTO_UDP},
+	{"sctp6", ETH_TYPE_IPV6, IP_PROTO_SCTP},
+}
+
+// Names of the reserved ports
+var ofctlPortNames = map[uint32]string{
+	P_IN_PORT:    "IN_PORT",
+	P_TABLE:      "TABLE",
+	P_NORMAL:     "NORMAL",
+	P_FLOOD:      "FLOOD",
+	P_ALL:        "ALL",
+	P_CONTROLLER: "CONTROLLER",
+	P_LOCAL:      "LOCAL",
+	P_ANY:        "ANY",
+}
+
+func lookupOfctlField(class uint16, field uint8) *ofctlField {
+	for i := range ofctlFields {
+		if ofctlFields[i].class == class && ofctlFields[i].field == field {
+			return &ofctlFields[i]
+		}
+	}
+	return nil
+}
+
+func formatPort(port uint32) string {
+	if name, ok := ofctlPortNames[port]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", port)
+}
+
+// Value of a match field as big endian bytes
+func messageBytes(m util.Message) []byte {
+	if m == nil {
+		return nil
+	}
+	b, _ := m.MarshalBinary()
+	return b
+}
+
+func bytesUint(b []byte) uint64 {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+// Length of the prefix a mask is made of, -1 if it isn't a prefix
+func maskPrefixLen(mask []byte) int {
+	ones, bits := net.IPMask(mask).Size()
+	if bits == 0 {
+		return -1
+	}
+	return ones
+}
+
+func formatFieldValue(kind ofctlKind, b []byte, isMask bool) string {
+	switch kind {
+	case ofctlHex:
+		return fmt.Sprintf("0x%x", bytesUint(b))
+	case ofctlMac:
+		return net.HardwareAddr(b).String()
+	case ofctlIPv4, ofctlIPv6:
+		if isMask {
+			if n := maskPrefixLen(b); n >= 0 {
+				return fmt.Sprintf("%d", n)
+			}
+		}
+		return net.IP(b).String()
+	case ofctlPort:
+		if !isMask {
+			return formatPort(uint32(bytesUint(b)))
+		}
+	}
+	return fmt.Sprintf("%d", bytesUint(b))
+}
+
+// Name and value of a match field in the ovs-ofctl syntax, e.g.
+// ("nw_dst", "10.0.0.0/24")
+func (m *MatchField) ofctl() (string, string) {
+	value := messageBytes(m.Value)
+	var mask []byte
+	if m.HasMask {
+		mask = messageBytes(m.Mask)
+	}
+
+	// A present vlan id is written as the vid
+	if m.Class == OXM_CLASS_OPENFLOW_BASIC && m.Field == OXM_FIELD_VLAN_VID &&
+		!m.HasMask && len(value) == 2 {
+		if vid := uint16(bytesUint(value)); vid&OFPVID_PRESENT != 0 {
+			return "dl_vlan", fmt.Sprintf("%d", vid&^OFPVID_PRESENT)
+		}
+	}
+
+	f := lookupOfctlField(m.Class, m.Field)
+	if f == nil {
+		// Fields without a name are written as raw bytes
+		s := fmt.Sprintf("0x%x", value)
+		if mask != nil {
+			s += fmt.Sprintf("/0x%x", mask)
+		}
+		return OxmFieldName(m.Class, m.Field), s
+	}
+	if f.field == OXM_FIELD_ETH_TYPE && f.class == OXM_CLASS_OPENFLOW_BASIC {
+		return f.name, fmt.Sprintf("0x%04x", bytesUint(value))
+	}
+
+	s := formatFieldValue(f.kind, value, false)
+	if mask != nil {
+		s += "/" + formatFieldValue(f.kind, mask, true)
+	}
+	return f.name, s
+}
+
+// Returns the field in the ovs-ofctl syntax, e.g. "nw_dst=10.0.0.0/24"
+func (m *MatchField) String() string {
+	name, value := m.ofctl()
+	return name + "=" + value
+}
+
+// Returns the match in the ovs-ofctl syntax. The eth_type and ip_proto
+// fields are written as a shorthand, e.g. "tcp", and the transport ports
+// as tp_src and tp_dst
+func (m *Match) String() string {
+	ethType := m.basicField(OXM_FIELD_ETH_TYPE)
+	ipProto := m.basicField(OXM_FIELD_IP_PROTO)
+	if ethType != nil && ethType.HasMask {
+		ethType = nil
+	}
+	if ipProto != nil && ipProto.HasMask {
+		ipProto = nil
+	}
+
+	// Pick the shorthand covering the most fields
+	proto := ""
+	skipProto := false
+	if ethType != nil {
+		for _, p := range ofctlProtocols {
+			if uint64(p.ethType) != fieldUint(ethType) {
+				continue
+			}
+			if p.ipProto == 0 && proto == "" {
+				proto = p.name
+			} else if ipProto != nil && uint64(p.ipProto) == fieldUint(ipProto) {
+				proto = p.name
+				skipProto = true
+			}
+		}
+	}
+
+	parts := make([]string, 0, len(m.Fields))
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if f.Class == OXM_CLASS_OPENFLOW_BASIC {
+			switch {
+			case f == ethType && proto != "":
+				parts = append(parts, proto)
+				continue
+			case f == ipProto && skipProto:
+				continue
+			case ipProto != nil && uint64(transportProto(f.Field)) == fieldUint(ipProto):
+				name, value := f.ofctl()
+				parts = append(parts, "tp"+name[strings.Index(name, "_"):]+"="+value)
+				continue
+			}
+		}
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// IP protocol of a transport port field, 0 for other fields
+func transportProto(field uint8) uint8 {
+	switch field {
+	case OXM_FIELD_TCP_SRC, OXM_FIELD_TCP_DST:
+		return IP_PROTO_TCP
+	case OXM_FIELD_UDP_SRC, OXM_FIELD_UDP_DST:
+		return IP_PROTO_UDP
+	case OXM_FIELD_SCTP_SRC, OXM_FIELD_SCTP_DST:
+		return IP_PROTO_SCTP
+	}
+	return 0
+}
+
+func (a *ActionOutput) String() string {
+	switch a.Port {
+	case P_CONTROLLER:
+		return fmt.Sprintf("CONTROLLER:%d", a.MaxLen)
+	case P_IN_PORT, P_TABLE, P_NORMAL, P_FLOOD, P_ALL, P_LOCAL:
+		return ofctlPortNames[a.Port]
+	}
+	return "output:" + formatPort(a.Port)
+}
+
+func (a *ActionGeneric) String() string {
+	switch a.Type {
+	case ActionType_CopyTtlOut:
+		return "copy_ttl_out"
+	case ActionType_CopyTtlIn:
+		return "copy_ttl_in"
+	case ActionType_DecMplsTtl:
+		return "dec_mpls_ttl"
+	case ActionType_DecNwTtl:
+		return "dec_ttl"
+	case ActionType_PopPbb:
+		return "pop_pbb"
+	}
+	return fmt.Sprintf("action_%d", a.Type)
+}
+
+func (a *ActionSetqueue) String() string {
+	return fmt.Sprintf("set_queue:%d", a.QueueId)
+}
+
+func (a *ActionGroup) String() string {
+	return fmt.Sprintf("group:%d", a.GroupId)
+}
+
+func (a *ActionMplsTtl) String() string {
+	return fmt.Sprintf("set_mpls_ttl:%d", a.MplsTtl)
+}
+
+func (a *ActionNwTtl) String() string {
+	return fmt.Sprintf("mod_nw_ttl:%d", a.NwTtl)
+}
+
+func (a *ActionPush) String() string {
+	switch a.Type {
+	case ActionType_PushVlan:
+		return fmt.Sprintf("push_vlan:0x%04x", a.EtherType)
+	case ActionType_PushMpls:
+		return fmt.Sprintf("push_mpls:0x%04x", a.EtherType)
+	}
+	return fmt.Sprintf("push_pbb:0x%04x", a.EtherType)
+}
+
+func (a *ActionPopVlan) String() string {
+	return "pop_vlan"
+}
+
+func (a *ActionPopMpls) String() string {
+	return fmt.Sprintf("pop_mpls:0x%04x", a.EtherType)
+}
+
+func (a *ActionSetField) String() string {
+	name, value := a.Field.ofctl()
+	return "set_field:" + value + "->" + name
+}
+
+func (a *ActionExperimenter) String() string {
+	return fmt.Sprintf("experimenter(id=0x%x,data=%s)", a.Experimenter, hex.EncodeToString(a.Data))
+}
+
+// Returns the actions in the ovs-ofctl syntax
+func FormatActions(actions []Action) string {
+	strs := make([]string, 0, len(actions))
+	for _, act := range actions {
+		if s, ok := act.(fmt.Stringer); ok {
+			strs = append(strs, s.String())
+		} else {
+			strs = append(strs, fmt.Sprintf("action_%d", act.Header().Type))
+		}
+	}
+	return strings.Join(strs, ",")
+}
+
+func (instr *InstrGotoTable) String() string {
+	return fmt.Sprintf("goto_table:%d", instr.TableId)
+}
+
+func (instr *InstrWriteMetadata) String() string {
+	if instr.MetadataMask == ^uint64(0) {
+		return fmt.Sprintf("write_metadata:0x%x", instr.Metadata)
+	}
+	return fmt.Sprintf("write_metadata:0x%x/0x%x", instr.Metadata, instr.MetadataMask)
+}
+
+// Apply actions are written as the list of actions
+func (instr *InstrActions) String() string {
+	switch instr.Type {
+	case InstrType_WRITE_ACTIONS:
+		return "write_actions(" + FormatActions(instr.Actions) + ")"
+	case InstrType_CLEAR_ACTIONS:
+		return "clear_actions"
+	}
+	return FormatActions(instr.Actions)
+}
+
+func (instr *InstrMeter) String() string {
+	return fmt.Sprintf("meter:%d", instr.MeterId)
+}
+
+// Returns the instructions in the ovs-ofctl syntax, "drop" if they do
+// nothing
+func FormatInstructions(instrs []Instruction) string {
+	strs := make([]string, 0, len(instrs))
+	for _, instr := range instrs {
+		var s string
+		if str, ok := instr.(fmt.Stringer); ok {
+			s = str.String()
+		}
+		if s != "" {
+			strs = append(strs, s)
+		}
+	}
+	if len(strs) == 0 {
+		return "drop"
+	}
+	return strings.Join(strs, ",")
+}
+
+var ofctlFlowFlags = []struct {
+	flag uint16
+	name string
+}{
+	{FF_SEND_FLOW_REM, "send_flow_rem"},
+	{FF_CHECK_OVERLAP, "check_overlap"},
+	{FF_RESET_COUNTS, "reset_counts"},
+	{FF_NO_PKT_COUNTS, "no_packet_counts"},
+	{FF_NO_BYT_COUNTS, "no_byte_counts"},
+}
+
+func formatFlowFlags(flags uint16) []string {
+	names := []string{}
+	for _, f := range ofctlFlowFlags {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// Returns the flow mod in the ovs-ofctl add-flow syntax. The command isn't
+// part of the syntax, delete requests are written without actions
+func (f *FlowMod) String() string {
+	parts := []string{fmt.Sprintf("table=%d", f.TableId), fmt.Sprintf("priority=%d", f.Priority)}
+	if f.CookieMask != 0 {
+		parts = append(parts, fmt.Sprintf("cookie=0x%x/0x%x", f.Cookie, f.CookieMask))
+	} else if f.Cookie != 0 {
+		parts = append(parts, fmt.Sprintf("cookie=0x%x", f.Cookie))
+	}
+	if f.IdleTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("idle_timeout=%d", f.IdleTimeout))
+	}
+	if f.HardTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("hard_timeout=%d", f.HardTimeout))
+	}
+	parts = append(parts, formatFlowFlags(f.Flags)...)
+	if f.OutPort != P_ANY {
+		parts = append(parts, "out_port="+formatPort(f.OutPort))
+	}
+	if f.OutGroup != OFPG_ANY {
+		parts = append(parts, fmt.Sprintf("out_group=%d", f.OutGroup))
+	}
+	if match := f.Match.String(); match != "" {
+		parts = append(parts, match)
+	}
+	if f.Command != FC_DELETE && f.Command != FC_DELETE_STRICT {
+		parts = append(parts, "actions="+FormatInstructions(f.Instructions))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Returns the flow in the ovs-ofctl dump-flows syntax
+func (f *FlowStats) String() string {
+	parts := []string{
+		fmt.Sprintf("cookie=0x%x", f.Cookie),
+		fmt.Sprintf("duration=%d.%03ds", f.DurationSec, f.DurationNSec/1000000),
+		fmt.Sprintf("table=%d", f.TableId),
+		fmt.Sprintf("n_packets=%d", f.PacketCount),
+		fmt.Sprintf("n_bytes=%d", f.ByteCount),
+	}
+	if f.IdleTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("idle_timeout=%d", f.IdleTimeout))
+	}
+	if f.HardTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("hard_timeout=%d", f.HardTimeout))
+	}
+	parts = append(parts, formatFlowFlags(f.Flags)...)
+
+	flow := fmt.Sprintf("priority=%d", f.Priority)
+	if match := f.Match.String(); match != "" {
+		flow += "," + match
+	}
+	parts = append(parts, flow)
+	return strings.Join(parts, ", ") + " actions=" + FormatInstructions(f.Instructions)
+}
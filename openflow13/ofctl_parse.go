@@ -0,0 +1,766 @@
+package openflow13
+
+// This file implements parsing flows in the ovs-ofctl syntax, as written
+// for ovs-ofctl add-flow or printed by ovs-ofctl dump-flows
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Other names of the match fields
+var ofctlFieldAliases = map[string]string{
+	"eth_dst":        "dl_dst",
+	"eth_src":        "dl_src",
+	"eth_type":       "dl_type",
+	"vlan_pcp":       "dl_vlan_pcp",
+	"ip_ecn":         "nw_ecn",
+	"ip_proto":       "nw_proto",
+	"ip_src":         "nw_src",
+	"ip_dst":         "nw_dst",
+	"ipv4_src":       "nw_src",
+	"ipv4_dst":       "nw_dst",
+	"icmpv4_type":    "icmp_type",
+	"icmpv4_code":    "icmp_code",
+	"ipv6_flabel":    "ipv6_label",
+	"ipv6_nd_target": "nd_target",
+	"ipv6_nd_sll":    "nd_sll",
+	"ipv6_nd_tll":    "nd_tll",
+	"tunnel_id":      "tun_id",
+	"tun_ipv4_src":   "tun_src",
+	"tun_ipv4_dst":   "tun_dst",
+}
+
+// Fields printed by ovs-ofctl dump-flows that aren't part of a flow mod
+var ofctlDumpFields = map[string]bool{
+	"duration":   true,
+	"n_packets":  true,
+	"n_bytes":    true,
+	"idle_age":   true,
+	"hard_age":   true,
+	"importance": true,
+}
+
+// set_field shorthands, e.g. "mod_dl_dst:00:11:22:33:44:55"
+var ofctlModActions = map[string]string{
+	"mod_dl_dst":   "dl_dst",
+	"mod_dl_src":   "dl_src",
+	"mod_nw_src":   "nw_src",
+	"mod_nw_dst":   "nw_dst",
+	"mod_nw_ecn":   "nw_ecn",
+	"mod_vlan_pcp": "dl_vlan_pcp",
+	"mod_vlan_vid": "dl_vlan",
+	"mod_tp_src":   "tp_src",
+	"mod_tp_dst":   "tp_dst",
+	"set_tunnel":   "tun_id",
+	"set_tunnel64": "tun_id",
+}
+
+func findOfctlField(name string) *ofctlField {
+	if alias, ok := ofctlFieldAliases[name]; ok {
+		name = alias
+	}
+	for i := range ofctlFields {
+		if ofctlFields[i].name == name {
+			return &ofctlFields[i]
+		}
+	}
+	return nil
+}
+
+// Split s at the commas and, if spaces is set, the white spaces that aren't
+// within parentheses
+func splitOfctl(s string, spaces bool) ([]string, error) {
+	var tokens []string
+	depth := 0
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("Unbalanced parentheses in %s", s)
+			}
+		case depth == 0 && (c == ',' || (spaces && (c == ' ' || c == '\t'))):
+			if tok := strings.TrimSpace(s[start:i]); tok != "" {
+				tokens = append(tokens, tok)
+			}
+			start = i + 1
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("Unbalanced parentheses in %s", s)
+	}
+	if tok := strings.TrimSpace(s[start:]); tok != "" {
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+func parsePort(s string) (uint32, error) {
+	for port, name := range ofctlPortNames {
+		if strings.EqualFold(s, name) {
+			return port, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid port %s", s)
+	}
+	return uint32(v), nil
+}
+
+func parseUint(s string, bits int) (uint64, error) {
+	v, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %d bits value %s", bits, s)
+	}
+	return v, nil
+}
+
+func uintBytes(v uint64, size int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b[8-size:]
+}
+
+// Parse a value or mask of a field into its big endian bytes
+func parseFieldBytes(f *ofctlField, s string, isMask bool) ([]byte, error) {
+	switch f.kind {
+	case ofctlMac:
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("Invalid %s %s", f.name, s)
+		}
+		return mac, nil
+	case ofctlIPv4, ofctlIPv6:
+		bits := 8 * f.size
+		if isMask && !strings.ContainsAny(s, ".:") {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n > bits {
+				return nil, fmt.Errorf("Invalid %s prefix length %s", f.name, s)
+			}
+			return net.CIDRMask(n, bits), nil
+		}
+		ip := net.ParseIP(s)
+		if ip != nil && f.kind == ofctlIPv4 {
+			ip = ip.To4()
+		} else if ip != nil && ip.To4() != nil && !strings.Contains(s, ":") {
+			ip = nil
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid %s %s", f.name, s)
+		}
+		return []byte(ip), nil
+	case ofctlPort:
+		if !isMask {
+			port, err := parsePort(s)
+			if err != nil {
+				return nil, err
+			}
+			return uintBytes(uint64(port), f.size), nil
+		}
+	}
+	v, err := parseUint(s, 8*f.size)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s %s", f.name, s)
+	}
+	return uintBytes(v, f.size), nil
+}
+
+func newMatchFieldBytes(f *ofctlField, value, mask []byte) (*MatchField, error) {
+	field := &MatchField{Class: f.class, Field: f.field, Length: uint8(len(value))}
+	var err error
+	if field.Value, err = DecodeMatchField(f.class, f.field, value); err != nil {
+		return nil, err
+	}
+	if mask != nil {
+		if field.Mask, err = DecodeMatchField(f.class, f.field, mask); err != nil {
+			return nil, err
+		}
+		field.HasMask = true
+		field.Length += uint8(len(mask))
+	}
+	return field, nil
+}
+
+// Parse a match field from its name and value, e.g. ("nw_dst",
+// "10.0.0.0/24"). tp_src and tp_dst are parsed as TCP ports, the caller
+// sets their protocol
+func parseMatchField(name, value string) (*MatchField, error) {
+	switch name {
+	case "dl_vlan":
+		vid, err := parseUint(value, 12)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid dl_vlan %s", value)
+		}
+		return NewVlanIdField(uint16(vid), nil), nil
+	case "nw_tos":
+		// The DSCP is the 6 high bits of the ToS, the ECN bits are ignored
+		tos, err := parseUint(value, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid nw_tos %s", value)
+		}
+		return NewIpDscpField(uint8(tos >> 2)), nil
+	case "tp_src":
+		name = "tcp_src"
+	case "tp_dst":
+		name = "tcp_dst"
+	}
+
+	f := findOfctlField(name)
+	if f == nil {
+		return nil, fmt.Errorf("Unknown match field %s", name)
+	}
+
+	var mask []byte
+	valueStr := value
+	if i := strings.Index(value, "/"); i >= 0 {
+		valueStr = value[:i]
+		var err error
+		if mask, err = parseFieldBytes(f, value[i+1:], true); err != nil {
+			return nil, err
+		}
+	}
+	b, err := parseFieldBytes(f, valueStr, false)
+	if err != nil {
+		return nil, err
+	}
+	if mask != nil {
+		// ovs-ofctl accepts address bits outside of the mask
+		for i := range b {
+			b[i] &= mask[i]
+		}
+	}
+	return newMatchFieldBytes(f, b, mask)
+}
+
+// Parse a vlan_tci match, e.g. "0x1064/0x1fff", into its vlan_vid and
+// vlan_pcp fields. The CFI bit of the TCI stands for OFPVID_PRESENT, a
+// vlan_tci of 0 matches the packets without vlan. The pcp bits can only be
+// matched all together, along with the CFI bit
+func parseVlanTci(value string) ([]*MatchField, error) {
+	tciStr, maskStr := value, ""
+	if i := strings.Index(value, "/"); i >= 0 {
+		tciStr, maskStr = value[:i], value[i+1:]
+	}
+	tci, err := parseUint(tciStr, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid vlan_tci %s", value)
+	}
+	mask := uint64(0xffff)
+	if maskStr != "" {
+		if mask, err = parseUint(maskStr, 16); err != nil {
+			return nil, fmt.Errorf("Invalid vlan_tci %s", value)
+		}
+	}
+	tci &= mask
+
+	vidMask := uint16(mask & 0x1fff)
+	pcpMask := uint16(mask & 0xe000)
+	present := tci&OFPVID_PRESENT != 0
+	if pcpMask != 0 && (pcpMask != 0xe000 || vidMask&OFPVID_PRESENT == 0) {
+		return nil, fmt.Errorf("vlan_tci %s: the pcp bits can only be matched together with the CFI bit", value)
+	}
+
+	var fields []*MatchField
+	if vidMask != 0 {
+		field := &MatchField{Class: OXM_CLASS_OPENFLOW_BASIC, Field: OXM_FIELD_VLAN_VID, Length: 2}
+		field.Value = &VlanIdField{VlanId: uint16(tci & 0x1fff)}
+		if vidMask != 0x1fff {
+			field.Mask = &VlanIdField{VlanId: vidMask}
+			field.HasMask = true
+			field.Length += 2
+		}
+		fields = append(fields, field)
+	}
+	if pcpMask != 0 && present {
+		fields = append(fields, NewVlanPcpField(uint8(tci>>13)))
+	} else if pcpMask != 0 && tci>>13 != 0 {
+		return nil, fmt.Errorf("vlan_tci %s: pcp of a packet without vlan", value)
+	}
+	return fields, nil
+}
+
+// Set the protocol of the tp_src and tp_dst fields of a match from its
+// ip_proto
+func setTransportProto(m *Match, tpFields []int, name string) error {
+	if len(tpFields) == 0 {
+		return nil
+	}
+	ipProto := m.basicField(OXM_FIELD_IP_PROTO)
+	if ipProto == nil {
+		return fmt.Errorf("%s requires tcp, udp or sctp", name)
+	}
+	var src, dst uint8
+	switch fieldUint(ipProto) {
+	case IP_PROTO_TCP:
+		src, dst = OXM_FIELD_TCP_SRC, OXM_FIELD_TCP_DST
+	case IP_PROTO_UDP:
+		src, dst = OXM_FIELD_UDP_SRC, OXM_FIELD_UDP_DST
+	case IP_PROTO_SCTP:
+		src, dst = OXM_FIELD_SCTP_SRC, OXM_FIELD_SCTP_DST
+	default:
+		return fmt.Errorf("%s requires tcp, udp or sctp", name)
+	}
+	for _, i := range tpFields {
+		if m.Fields[i].Field == OXM_FIELD_TCP_SRC {
+			m.Fields[i].Field = src
+		} else {
+			m.Fields[i].Field = dst
+		}
+	}
+	return nil
+}
+
+// Parse a flow in the ovs-ofctl syntax into an add flow mod, e.g.
+// "table=0,priority=100,ip,nw_dst=10.0.0.0/24,tcp,tp_dst=80,actions=output:2".
+// The output of ovs-ofctl dump-flows is accepted too, its statistics are
+// ignored. A flow without actions drops the packets, the command of the
+// flow mod can be changed by the caller
+func ParseFlowMod(s string) (*FlowMod, error) {
+	flowMod := NewFlowMod()
+	match := &flowMod.Match
+
+	// Everything after actions= are the actions
+	flowStr, actionStr := s, ""
+	if i := strings.Index(s, "actions="); i >= 0 {
+		flowStr, actionStr = s[:i], s[i+len("actions="):]
+	}
+	tokens, err := splitOfctl(flowStr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var tpFields []int
+	for _, tok := range tokens {
+		key, value := tok, ""
+		hasValue := false
+		if i := strings.Index(tok, "="); i >= 0 {
+			key, value, hasValue = tok[:i], tok[i+1:], true
+		}
+
+		if !hasValue {
+			if err := parseFlowKeyword(flowMod, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if ofctlDumpFields[key] {
+			continue
+		}
+
+		handled, err := parseFlowModField(flowMod, key, value)
+		if err != nil {
+			return nil, err
+		}
+		if handled {
+			continue
+		}
+		if key == "vlan_tci" {
+			fields, err := parseVlanTci(value)
+			if err != nil {
+				return nil, err
+			}
+			for _, field := range fields {
+				match.AddField(*field)
+			}
+			continue
+		}
+
+		field, err := parseMatchField(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if key == "tp_src" || key == "tp_dst" {
+			tpFields = append(tpFields, len(match.Fields))
+		}
+		match.AddField(*field)
+	}
+	if err := setTransportProto(match, tpFields, "tp_src and tp_dst"); err != nil {
+		return nil, err
+	}
+
+	if flowMod.Instructions, err = parseInstructions(actionStr, match); err != nil {
+		return nil, err
+	}
+	return flowMod, nil
+}
+
+// Parse a keyword without value: flow mod flag or protocol shorthand
+func parseFlowKeyword(flowMod *FlowMod, key string) error {
+	for _, f := range ofctlFlowFlags {
+		if key == f.name {
+			flowMod.Flags |= f.flag
+			return nil
+		}
+	}
+	for _, p := range ofctlProtocols {
+		if key != p.name {
+			continue
+		}
+		// Shorthands may be repeated, e.g. "ip,...,tcp"
+		if err := addShorthandField(&flowMod.Match, *NewEthTypeField(p.ethType)); err != nil {
+			return err
+		}
+		if p.ipProto != 0 {
+			return addShorthandField(&flowMod.Match, *NewIpProtoField(p.ipProto))
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown flow keyword %s", key)
+}
+
+// Add a field of a protocol shorthand unless the match already has it
+func addShorthandField(m *Match, f MatchField) error {
+	existing := m.basicField(f.Field)
+	if existing == nil {
+		m.AddField(f)
+		return nil
+	}
+	if existing.HasMask || fieldUint(existing) != fieldUint(&f) {
+		return fmt.Errorf("Conflicting %s in protocol shorthands", OxmFieldName(f.Class, f.Field))
+	}
+	return nil
+}
+
+// Parse a field of the flow mod that isn't a match field. Returns false if
+// key isn't one
+func parseFlowModField(flowMod *FlowMod, key, value string) (bool, error) {
+	var err error
+	var v uint64
+	switch key {
+	case "table":
+		if v, err = parseUint(value, 8); err == nil {
+			flowMod.TableId = uint8(v)
+		}
+	case "priority":
+		if v, err = parseUint(value, 16); err == nil {
+			flowMod.Priority = uint16(v)
+		}
+	case "idle_timeout":
+		if v, err = parseUint(value, 16); err == nil {
+			flowMod.IdleTimeout = uint16(v)
+		}
+	case "hard_timeout":
+		if v, err = parseUint(value, 16); err == nil {
+			flowMod.HardTimeout = uint16(v)
+		}
+	case "cookie":
+		cookie, mask := value, ""
+		if i := strings.Index(value, "/"); i >= 0 {
+			cookie, mask = value[:i], value[i+1:]
+		}
+		if flowMod.Cookie, err = parseUint(cookie, 64); err == nil && mask != "" {
+			if mask == "-1" {
+				flowMod.CookieMask = ^uint64(0)
+			} else {
+				flowMod.CookieMask, err = parseUint(mask, 64)
+			}
+		}
+	case "out_port":
+		flowMod.OutPort, err = parsePort(value)
+	case "out_group":
+		if v, err = parseUint(value, 32); err == nil {
+			flowMod.OutGroup = uint32(v)
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("Invalid %s %s", key, value)
+	}
+	return true, nil
+}
+
+// Parse the actions of a flow into its instructions, in the order of the
+// specification. match is used to resolve mod_tp_src and mod_tp_dst
+func parseInstructions(s string, match *Match) ([]Instruction, error) {
+	tokens, err := splitOfctl(s, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var meter, clear, write, metadata, gotoTable Instruction
+	apply := NewInstrApplyActions()
+	setOnce := func(instr *Instruction, value Instruction, name string) error {
+		if *instr != nil {
+			return fmt.Errorf("Instruction %s is present more than once", name)
+		}
+		*instr = value
+		return nil
+	}
+
+	for _, tok := range tokens {
+		name, arg := tok, ""
+		if i := strings.IndexAny(tok, ":("); i >= 0 {
+			name, arg = tok[:i], tok[i+1:]
+		}
+
+		switch name {
+		case "drop":
+			if len(tokens) != 1 {
+				return nil, errors.New("drop can't be combined with other actions")
+			}
+		case "meter":
+			v, err := parseUint(arg, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid meter %s", arg)
+			}
+			err = setOnce(&meter, NewInstrMeter(uint32(v)), name)
+			if err != nil {
+				return nil, err
+			}
+		case "clear_actions":
+			if err := setOnce(&clear, NewInstrClearActions(), name); err != nil {
+				return nil, err
+			}
+		case "write_actions":
+			if !strings.HasSuffix(arg, ")") {
+				return nil, fmt.Errorf("Invalid write_actions %s", tok)
+			}
+			actions, err := parseActionList(strings.TrimSuffix(arg, ")"), match)
+			if err != nil {
+				return nil, err
+			}
+			instr := NewInstrWriteActions()
+			for _, act := range actions {
+				instr.AddAction(act, false)
+			}
+			if err := setOnce(&write, instr, name); err != nil {
+				return nil, err
+			}
+		case "write_metadata":
+			value, mask := arg, ""
+			if i := strings.Index(arg, "/"); i >= 0 {
+				value, mask = arg[:i], arg[i+1:]
+			}
+			v, err := parseUint(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid write_metadata %s", arg)
+			}
+			m := ^uint64(0)
+			if mask != "" {
+				if m, err = parseUint(mask, 64); err != nil {
+					return nil, fmt.Errorf("Invalid write_metadata %s", arg)
+				}
+			}
+			if err := setOnce(&metadata, NewInstrWriteMetadata(v, m), name); err != nil {
+				return nil, err
+			}
+		case "goto_table":
+			v, err := parseUint(arg, 8)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid goto_table %s", arg)
+			}
+			if err := setOnce(&gotoTable, NewInstrGotoTable(uint8(v)), name); err != nil {
+				return nil, err
+			}
+		default:
+			act, err := parseAction(tok, match)
+			if err != nil {
+				return nil, err
+			}
+			apply.AddAction(act, false)
+		}
+	}
+
+	var instrs []Instruction
+	if meter != nil {
+		instrs = append(instrs, meter)
+	}
+	if len(apply.Actions) > 0 {
+		instrs = append(instrs, apply)
+	}
+	for _, instr := range []Instruction{clear, write, metadata, gotoTable} {
+		if instr != nil {
+			instrs = append(instrs, instr)
+		}
+	}
+	return instrs, nil
+}
+
+// Parse a list of actions in the ovs-ofctl syntax, e.g. the actions of a
+// group bucket "set_field:10.0.0.1->nw_dst,output:2"
+func ParseActions(s string) ([]Action, error) {
+	return parseActionList(s, nil)
+}
+
+func parseActionList(s string, match *Match) ([]Action, error) {
+	tokens, err := splitOfctl(s, false)
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]Action, 0, len(tokens))
+	for _, tok := range tokens {
+		act, err := parseAction(tok, match)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, act)
+	}
+	return actions, nil
+}
+
+// Parse an action, e.g. "output:2" or "set_field:10.0.0.1->nw_dst"
+func parseAction(s string, match *Match) (Action, error) {
+	name, arg := s, ""
+	if i := strings.IndexAny(s, ":("); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+
+	uintArg := func(bits int) (uint64, error) {
+		v, err := parseUint(arg, bits)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid action %s", s)
+		}
+		return v, nil
+	}
+
+	// Reserved ports are written in upper case
+	name = strings.ToLower(name)
+
+	switch name {
+	case "output":
+		port, err := parsePort(arg)
+		if err != nil {
+			return nil, err
+		}
+		act := NewActionOutput(port)
+		if port == P_CONTROLLER {
+			act.MaxLen = OFPCML_NO_BUFFER
+		}
+		return act, nil
+	case "controller":
+		act := NewActionOutput(P_CONTROLLER)
+		act.MaxLen = OFPCML_NO_BUFFER
+		if arg != "" {
+			v, err := uintArg(16)
+			if err != nil {
+				return nil, err
+			}
+			act.MaxLen = uint16(v)
+		}
+		return act, nil
+	case "in_port", "table", "normal", "flood", "all", "local":
+		port, _ := parsePort(name)
+		return NewActionOutput(port), nil
+	case "group":
+		v, err := uintArg(32)
+		if err != nil {
+			return nil, err
+		}
+		return NewActionGroup(uint32(v)), nil
+	case "set_queue":
+		v, err := uintArg(32)
+		if err != nil {
+			return nil, err
+		}
+		return NewActionSetQueue(uint32(v)), nil
+	case "push_vlan", "push_mpls", "push_pbb", "pop_mpls":
+		v, err := uintArg(16)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "push_vlan":
+			return NewActionPushVlan(uint16(v)), nil
+		case "push_mpls":
+			return NewActionPushMpls(uint16(v)), nil
+		case "push_pbb":
+			return NewActionPushPbb(uint16(v)), nil
+		}
+		return NewActionPopMpls(uint16(v)), nil
+	case "pop_vlan", "strip_vlan":
+		return NewActionPopVlan(), nil
+	case "pop_pbb":
+		return NewActionPopPbb(), nil
+	case "copy_ttl_out":
+		return NewActionCopyTtlOut(), nil
+	case "copy_ttl_in":
+		return NewActionCopyTtlIn(), nil
+	case "dec_mpls_ttl":
+		return NewActionDecMplsTtl(), nil
+	case "dec_ttl":
+		return NewActionDecNwTtl(), nil
+	case "set_mpls_ttl", "mod_nw_ttl":
+		v, err := uintArg(8)
+		if err != nil {
+			return nil, err
+		}
+		if name == "set_mpls_ttl" {
+			return NewActionSetMplsTtl(uint8(v)), nil
+		}
+		return NewActionSetNwTtl(uint8(v)), nil
+	case "set_field":
+		i := strings.Index(arg, "->")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid action %s", s)
+		}
+		return parseSetField(arg[i+2:], arg[:i], match)
+	case "experimenter":
+		return parseExperimenter(s)
+	}
+
+	if field, ok := ofctlModActions[name]; ok {
+		return parseSetField(field, arg, match)
+	}
+
+	// A bare port number outputs to the port
+	if port, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return NewActionOutput(uint32(port)), nil
+	}
+	return nil, fmt.Errorf("Unknown action %s", s)
+}
+
+func parseSetField(name, value string, match *Match) (Action, error) {
+	field, err := parseMatchField(name, value)
+	if err != nil {
+		return nil, err
+	}
+	if name == "tp_src" || name == "tp_dst" {
+		m := &Match{Fields: []MatchField{*field}}
+		if match != nil {
+			if ipProto := match.basicField(OXM_FIELD_IP_PROTO); ipProto != nil {
+				m.Fields = append(m.Fields, *ipProto)
+			}
+		}
+		if err := setTransportProto(m, []int{0}, "mod_"+name); err != nil {
+			return nil, err
+		}
+		field = &m.Fields[0]
+	}
+	return NewActionSetField(*field), nil
+}
+
+// Parse "experimenter(id=0x2320,data=0102)"
+func parseExperimenter(s string) (Action, error) {
+	arg := strings.TrimSuffix(strings.TrimPrefix(s, "experimenter("), ")")
+	if arg == s {
+		return nil, fmt.Errorf("Invalid action %s", s)
+	}
+	var id uint64
+	var data []byte
+	var err error
+	for _, kv := range strings.Split(arg, ",") {
+		switch {
+		case strings.HasPrefix(kv, "id="):
+			id, err = parseUint(kv[len("id="):], 32)
+		case strings.HasPrefix(kv, "data="):
+			data, err = hex.DecodeString(kv[len("data="):])
+		default:
+			err = errors.New(kv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid action %s", s)
+		}
+	}
+	return NewActionExperimenter(uint32(id), data), nil
+}
@@ -0,0 +1,219 @@
+package openflow13
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// Flows written the way String() writes them, parsing then formatting them
+// must give back the same text
+var ofctlFlows = []string{
+	"table=0,priority=100,tcp,nw_dst=10.0.0.0/24,tp_dst=80,actions=mod_dl_dst:00:11:22:33:44:55,output:2",
+	"table=0,priority=100,tcp,nw_dst=10.0.0.0/24,tp_dst=80,actions=set_field:00:11:22:33:44:55->dl_dst,output:2",
+	"table=1,priority=10,cookie=0x1f,idle_timeout=30,hard_timeout=60,send_flow_rem,in_port=3,dl_vlan=100,actions=pop_vlan,NORMAL",
+	"table=2,priority=1000,ip,nw_src=10.0.1.0/255.0.255.0,actions=drop",
+	"table=2,priority=1000,udp6,ipv6_dst=2001:db8::/32,tp_src=53,actions=CONTROLLER:65535",
+	"table=3,priority=5,arp,arp_op=1,arp_tpa=10.0.0.1,actions=push_vlan:0x8100,set_field:10->dl_vlan,group:7",
+	"table=3,priority=5,dl_dst=01:00:00:00:00:00/01:00:00:00:00:00,actions=FLOOD",
+	"table=4,priority=7,metadata=0x10/0xf0,tun_id=0x64,actions=meter:2,dec_ttl,clear_actions,write_actions(set_queue:1,LOCAL),write_metadata:0x1/0xff,goto_table:9",
+	"table=5,priority=1,mpls,mpls_label=16,mpls_bos=1,actions=pop_mpls:0x0800,set_mpls_ttl:10,copy_ttl_in,output:1",
+	"table=5,priority=1,icmp6,icmpv6_type=135,nd_target=fe80::1,actions=experimenter(id=0x2320,data=0102030405060708)",
+	"table=6,priority=2,sctp,tp_src=5000,tcp_flags=0x2/0x12,actions=IN_PORT",
+}
+
+func TestOfctlRoundTrip(t *testing.T) {
+	for _, s := range ofctlFlows {
+		flowMod, err := ParseFlowMod(s)
+		if err != nil {
+			t.Errorf("Error parsing %s. Err: %v", s, err)
+			continue
+		}
+
+		// mod_* actions are written as set_field
+		want := s
+		if s == ofctlFlows[0] {
+			want = ofctlFlows[1]
+		}
+		if got := flowMod.String(); got != want {
+			t.Errorf("Wrong format of %s:\n got %s\nwant %s", s, got, want)
+		}
+
+		// The binary encoding survives the text
+		data, err := flowMod.MarshalBinary()
+		if err != nil {
+			t.Errorf("Error marshaling %s. Err: %v", s, err)
+			continue
+		}
+		decoded := new(FlowMod)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Errorf("Error unmarshaling %s. Err: %v", s, err)
+			continue
+		}
+		if got := decoded.String(); got != want {
+			t.Errorf("Wrong format of decoded %s:\n got %s\nwant %s", s, got, want)
+		}
+		reparsed, err := ParseFlowMod(decoded.String())
+		if err != nil {
+			t.Errorf("Error parsing %s. Err: %v", decoded.String(), err)
+			continue
+		}
+		reparsed.Xid = flowMod.Xid
+		again, _ := reparsed.MarshalBinary()
+		if !bytes.Equal(data, again) {
+			t.Errorf("Binary of %s changed after a round trip", s)
+		}
+	}
+}
+
+func TestOfctlFormatFlowMod(t *testing.T) {
+	flowMod := NewFlowMod()
+	flowMod.TableId = 1
+	flowMod.Priority = 100
+	flowMod.Match.AddField(*NewInPortField(2))
+	flowMod.Match.AddField(*NewEthTypeField(ETH_TYPE_IPV4))
+	flowMod.Match.AddField(*NewIpProtoField(IP_PROTO_UDP))
+	mask := net.ParseIP("255.255.0.0")
+	flowMod.Match.AddField(*NewIpv4DstField(net.ParseIP("192.168.0.0"), &mask))
+	flowMod.Match.AddField(*NewUdpDstField(4789))
+
+	apply := NewInstrApplyActions()
+	apply.AddAction(NewActionSetField(*NewTunnelIdField(5)), false)
+	apply.AddAction(NewActionOutput(P_TABLE), false)
+	flowMod.AddInstruction(apply)
+
+	want := "table=1,priority=100,in_port=2,udp,nw_dst=192.168.0.0/16,tp_dst=4789,actions=set_field:0x5->tun_id,TABLE"
+	if got := flowMod.String(); got != want {
+		t.Fatalf("Wrong format:\n got %s\nwant %s", got, want)
+	}
+
+	parsed, err := ParseFlowMod(want)
+	if err != nil {
+		t.Fatalf("Error parsing %s. Err: %v", want, err)
+	}
+	parsed.Xid = flowMod.Xid
+	data, _ := flowMod.MarshalBinary()
+	parsedData, _ := parsed.MarshalBinary()
+	if !bytes.Equal(data, parsedData) {
+		t.Errorf("Parsed flow mod differs:\n got %x\nwant %x", parsedData, data)
+	}
+
+	flowMod.Command = FC_DELETE
+	flowMod.OutPort = 4
+	want = "table=1,priority=100,out_port=4,in_port=2,udp,nw_dst=192.168.0.0/16,tp_dst=4789"
+	if got := flowMod.String(); got != want {
+		t.Errorf("Wrong format of delete:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestOfctlParseDumpFlows(t *testing.T) {
+	stats := NewFlowStats()
+	stats.TableId = 3
+	stats.Priority = 200
+	stats.Cookie = 0xabc
+	stats.DurationSec = 12
+	stats.DurationNSec = 345000000
+	stats.PacketCount = 10
+	stats.ByteCount = 980
+	stats.Match.AddField(*NewEthTypeField(ETH_TYPE_ARP))
+	apply := NewInstrApplyActions()
+	apply.AddAction(NewActionOutput(P_NORMAL), false)
+	stats.Instructions = append(stats.Instructions, apply)
+
+	want := "cookie=0xabc, duration=12.345s, table=3, n_packets=10, n_bytes=980, priority=200,arp actions=NORMAL"
+	if got := stats.String(); got != want {
+		t.Fatalf("Wrong format:\n got %s\nwant %s", got, want)
+	}
+
+	flowMod, err := ParseFlowMod(want)
+	if err != nil {
+		t.Fatalf("Error parsing %s. Err: %v", want, err)
+	}
+	if got := flowMod.String(); got != "table=3,priority=200,cookie=0xabc,arp,actions=NORMAL" {
+		t.Errorf("Wrong flow mod parsed from dump-flows: %s", got)
+	}
+}
+
+func TestOfctlParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"priority=70000,actions=drop",
+		"ip,tp_dst=80,actions=drop",
+		"ip,arp,actions=drop",
+		"nw_dst=10.0.0.1/33,actions=drop",
+		"ipv6,ipv6_src=10.0.0.1,actions=drop",
+		"foo=1,actions=drop",
+		"actions=output:2,drop",
+		"actions=goto_table:1,goto_table:2",
+		"actions=write_actions(output:1",
+		"actions=bogus:1",
+		"actions=set_field:1->foo",
+	} {
+		if _, err := ParseFlowMod(s); err == nil {
+			t.Errorf("Parsing %s didn't fail", s)
+		}
+	}
+}
+
+func TestOfctlParseShorthands(t *testing.T) {
+	s := "table=0,priority=100,ip,nw_dst=10.0.0.0/24,tcp,tp_dst=80,actions=mod_dl_dst:00:11:22:33:44:55,output:2"
+	flowMod, err := ParseFlowMod(s)
+	if err != nil {
+		t.Fatalf("Error parsing %s. Err: %v", s, err)
+	}
+	want := "table=0,priority=100,tcp,nw_dst=10.0.0.0/24,tp_dst=80,actions=set_field:00:11:22:33:44:55->dl_dst,output:2"
+	if got := flowMod.String(); got != want {
+		t.Errorf("Wrong format:\n got %s\nwant %s", got, want)
+	}
+	if err := flowMod.Match.Validate(); err != nil {
+		t.Errorf("Invalid match parsed from %s. Err: %v", s, err)
+	}
+}
+
+// vlan_tci and nw_tos are parsed into their OpenFlow 1.3 fields
+func TestOfctlParseVlanTciAndTos(t *testing.T) {
+	tests := []struct {
+		flow string
+		want string // Same flow with the OpenFlow 1.3 fields
+	}{
+		{"vlan_tci=0x1064,actions=drop", "dl_vlan=100,dl_vlan_pcp=0,actions=drop"},
+		{"vlan_tci=0x1064/0x1fff,actions=drop", "dl_vlan=100,actions=drop"},
+		{"vlan_tci=0xb064,actions=drop", "dl_vlan=100,dl_vlan_pcp=5,actions=drop"},
+		{"vlan_tci=0x1000/0x1000,actions=drop", "vlan_vid=0x1000/0x1000,actions=drop"},
+		{"vlan_tci=0x0064/0x0fff,actions=drop", "vlan_vid=0x64/0xfff,actions=drop"},
+		{"vlan_tci=0,actions=drop", "vlan_vid=0,actions=drop"},
+		{"vlan_tci=0/0,actions=drop", "actions=drop"},
+		{"ip,nw_tos=0x28,actions=drop", "ip,ip_dscp=10,actions=drop"},
+		{"ip,nw_tos=0x2b,actions=drop", "ip,ip_dscp=10,actions=drop"},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFlowMod(test.flow)
+		if err != nil {
+			t.Errorf("Error parsing %s. Err: %v", test.flow, err)
+			continue
+		}
+		want, err := ParseFlowMod(test.want)
+		if err != nil {
+			t.Fatalf("Error parsing %s. Err: %v", test.want, err)
+		}
+		want.Xid = got.Xid
+		gotData, _ := got.MarshalBinary()
+		wantData, _ := want.MarshalBinary()
+		if !bytes.Equal(gotData, wantData) {
+			t.Errorf("Wrong flow parsed from %s:\n got %s\nwant %s", test.flow, got, want)
+		}
+	}
+
+	for _, s := range []string{
+		"vlan_tci=0x2000/0xe000,actions=drop",
+		"vlan_tci=0x3064/0x3fff,actions=drop",
+		"vlan_tci=0x2000,actions=drop",
+		"vlan_tci=0x10000,actions=drop",
+		"vlan_tci=0x1000/bogus,actions=drop",
+		"ip,nw_tos=256,actions=drop",
+	} {
+		if _, err := ParseFlowMod(s); err == nil {
+			t.Errorf("Parsing %s didn't fail", s)
+		}
+	}
+}
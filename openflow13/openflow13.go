@@ -11,6 +11,7 @@ package openflow13
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 
 	"github.com/serngawy/libOpenflow/common"
@@ -98,6 +99,51 @@ const (
 	Type_MeterMod = 29
 )
 
+var messageTypeNames = map[uint8]string{
+	Type_Hello:                 "hello",
+	Type_Error:                 "error",
+	Type_EchoRequest:           "echo_request",
+	Type_EchoReply:             "echo_reply",
+	Type_Experimenter:          "experimenter",
+	Type_FeaturesRequest:       "features_request",
+	Type_FeaturesReply:         "features_reply",
+	Type_GetConfigRequest:      "get_config_request",
+	Type_GetConfigReply:        "get_config_reply",
+	Type_SetConfig:             "set_config",
+	Type_PacketIn:              "packet_in",
+	Type_FlowRemoved:           "flow_removed",
+	Type_PortStatus:            "port_status",
+	Type_PacketOut:             "packet_out",
+	Type_FlowMod:               "flow_mod",
+	Type_GroupMod:              "group_mod",
+	Type_PortMod:               "port_mod",
+	Type_TableMod:              "table_mod",
+	Type_MultiPartRequest:      "multipart_request",
+	Type_MultiPartReply:        "multipart_reply",
+	Type_BarrierRequest:        "barrier_request",
+	Type_BarrierReply:          "barrier_reply",
+	Type_QueueGetConfigRequest: "queue_get_config_request",
+	Type_QueueGetConfigReply:   "queue_get_config_reply",
+	Type_RoleRequest:           "role_request",
+	Type_RoleReply:             "role_reply",
+	Type_GetAsyncRequest:       "get_async_request",
+	Type_GetAsyncReply:         "get_async_reply",
+	Type_SetAsync:              "set_async",
+	Type_MeterMod:              "meter_mod",
+}
+
+// Returns the name of an ofp_type, e.g. "packet_in"
+func MessageTypeName(t uint8) string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type_%d", t)
+}
+
+func init() {
+	common.RegisterTypeNames(VERSION, MessageTypeName)
+}
+
 func Parse(b []byte) (message util.Message, err error) {
 	switch b[1] {
 	case Type_Hello:
@@ -137,15 +183,17 @@ func Parse(b []byte) (message util.Message, err error) {
 		message = NewFlowRemoved()
 		err = message.UnmarshalBinary(b)
 	case Type_PortStatus:
-		message = new(PortStatus)
+		message = NewPortStatus()
 		err = message.UnmarshalBinary(b)
 	case Type_PacketOut:
-		break
+		message = NewPacketOut()
+		err = message.UnmarshalBinary(b)
 	case Type_FlowMod:
 		message = NewFlowMod()
 		err = message.UnmarshalBinary(b)
 	case Type_GroupMod:
-		break
+		message = NewGroupMod()
+		err = message.UnmarshalBinary(b)
 	case Type_PortMod:
 		break
 	case Type_TableMod:
@@ -166,12 +214,24 @@ func Parse(b []byte) (message util.Message, err error) {
 	case Type_MultiPartReply:
 		message = new(MultipartReply)
 		err = message.UnmarshalBinary(b)
+	case Type_MeterMod:
+		message = NewMeterMod()
+		err = message.UnmarshalBinary(b)
+	case Type_RoleRequest:
+		message = NewRoleRequest(OFPCR_ROLE_NOCHANGE, 0)
+		err = message.UnmarshalBinary(b)
+	case Type_RoleReply:
+		message = NewRoleReply(OFPCR_ROLE_NOCHANGE, 0)
+		err = message.UnmarshalBinary(b)
 	default:
 		err = errors.New("An unknown v1.0 packet type was received. Parse function will discard data.")
 	}
 	return
 }
 
+// Buffer id of a packet that isn't buffered on the switch
+const OFP_NO_BUFFER = 0xffffffff
+
 // When the controller wishes to send a packet out through the
 // datapath, it uses the OFPT_PACKET_OUT message: The buffer_id
 // is the same given in the ofp_packet_in message. If the
@@ -193,7 +253,7 @@ func NewPacketOut() *PacketOut {
 	p := new(PacketOut)
 	p.Header = NewOfp13Header()
 	p.Header.Type = Type_PacketOut
-	p.BufferId = 0xffffffff
+	p.BufferId = OFP_NO_BUFFER
 	p.InPort = P_ANY
 	p.ActionsLen = 0
 	p.pad = make([]byte, 6)
@@ -212,7 +272,10 @@ func (p *PacketOut) Len() (n uint16) {
 	for _, a := range p.Actions {
 		n += a.Len()
 	}
-	n += p.Data.Len()
+	// A packet buffered on the switch has no data
+	if p.Data != nil {
+		n += p.Data.Len()
+	}
 	//if n < 72 { return 72 }
 	return
 }
@@ -227,6 +290,11 @@ func (p *PacketOut) MarshalBinary() (data []byte, err error) {
 	copy(data[n:], b)
 	n += len(b)
 
+	p.ActionsLen = 0
+	for _, a := range p.Actions {
+		p.ActionsLen += a.Len()
+	}
+
 	binary.BigEndian.PutUint32(data[n:], p.BufferId)
 	n += 4
 	binary.BigEndian.PutUint32(data[n:], p.InPort)
@@ -241,15 +309,23 @@ func (p *PacketOut) MarshalBinary() (data []byte, err error) {
 		n += len(b)
 	}
 
-	b, err = p.Data.MarshalBinary()
-	copy(data[n:], b)
-	n += len(b)
+	if p.Data != nil {
+		b, err = p.Data.MarshalBinary()
+		copy(data[n:], b)
+		n += len(b)
+	}
 	return
 }
 
 func (p *PacketOut) UnmarshalBinary(data []byte) error {
 	err := p.Header.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
 	n := p.Header.Len()
+	if len(data) < int(n)+16 {
+		return errors.New("The []byte the wrong size to unmarshal a PacketOut message.")
+	}
 
 	p.BufferId = binary.BigEndian.Uint32(data[n:])
 	n += 4
@@ -260,14 +336,32 @@ func (p *PacketOut) UnmarshalBinary(data []byte) error {
 
 	n += 6 // for pad
 
-	for n < (n + p.ActionsLen) {
-		a := DecodeAction(data[n:])
+	end := n + p.ActionsLen
+	if len(data) < int(end) {
+		return errors.New("The []byte the wrong size to unmarshal a PacketOut message.")
+	}
+	p.Actions = make([]Action, 0)
+	for n < end {
+		a, err := DecodeAction(data[n:end])
+		if err != nil {
+			return err
+		}
 		p.Actions = append(p.Actions, a)
-		n += a.Len()
+		n += a.Header().Length
 	}
 
-	err = p.Data.UnmarshalBinary(data[n:])
-	return err
+	// Packet data is only present for unbuffered packets. Data that isn't
+	// an ethernet frame is kept as raw bytes
+	p.Data = nil
+	if int(n) < len(data) {
+		eth := new(protocol.Ethernet)
+		if eth.UnmarshalBinary(data[n:]) == nil {
+			p.Data = eth
+		} else {
+			p.Data = util.NewBuffer(data[n:])
+		}
+	}
+	return nil
 }
 
 // ofp_packet_in 1.3
@@ -305,6 +399,7 @@ func (p *PacketIn) Len() (n uint16) {
 }
 
 func (p *PacketIn) MarshalBinary() (data []byte, err error) {
+	p.Header.Length = p.Len()
 	data, err = p.Header.MarshalBinary()
 
 	b := make([]byte, 16)
@@ -317,7 +412,7 @@ func (p *PacketIn) MarshalBinary() (data []byte, err error) {
 	n += 1
 	b[n] = p.TableId
 	n += 1
-	binary.BigEndian.PutUint64(b, p.Cookie)
+	binary.BigEndian.PutUint64(b[n:], p.Cookie)
 	n += 8
 	data = append(data, b...)
 
@@ -443,6 +538,8 @@ type ErrorMsg struct {
 
 func NewErrorMsg() *ErrorMsg {
 	e := new(ErrorMsg)
+	e.Header = NewOfp13Header()
+	e.Header.Type = Type_Error
 	e.Data = *util.NewBuffer(make([]byte, 0))
 	return e
 }
@@ -459,6 +556,7 @@ func (e *ErrorMsg) MarshalBinary() (data []byte, err error) {
 	data = make([]byte, int(e.Len()))
 	next := 0
 
+	e.Header.Length = e.Len()
 	bytes, err := e.Header.MarshalBinary()
 	copy(data[next:], bytes)
 	next += len(bytes)
@@ -497,6 +595,7 @@ const (
 	ET_PORT_MOD_FAILED       = 7      /* Port mod request failed. */
 	ET_TABLE_MOD_FAILED      = 8      /* Table mod request failed. */
 	ET_QUEUE_OP_FAILED       = 9      /* Queue operation failed. */
+	ET_SWITCH_CONFIG_FAILED  = 10     /* Switch Config request failed. */
 	ET_ROLE_REQUEST_FAILED   = 11     /* Controller Role request failed. */
 	ET_METER_MOD_FAILED      = 12     /* Error in meter. */
 	ET_TABLE_FEATURES_FAILED = 13     /* Setting table features failed. */
@@ -608,6 +707,22 @@ const (
 	GMFC_EPERM                = 14 /* Permissions error. */
 )
 
+// ofp_meter_mod_failed_code 1.3
+const (
+	MMFC_UNKNOWN        = 0  /* Unspecified error. */
+	MMFC_METER_EXISTS   = 1  /* Meter not added because a Meter ADD attempted to replace an existing Meter. */
+	MMFC_INVALID_METER  = 2  /* Meter not added because Meter specified is invalid. */
+	MMFC_UNKNOWN_METER  = 3  /* Meter not modified because a Meter MODIFY attempted to modify a non-existent Meter. */
+	MMFC_BAD_COMMAND    = 4  /* Unsupported or unknown command. */
+	MMFC_BAD_FLAGS      = 5  /* Flag configuration unsupported. */
+	MMFC_BAD_RATE       = 6  /* Rate unsupported. */
+	MMFC_BAD_BURST      = 7  /* Burst size unsupported. */
+	MMFC_BAD_BAND       = 8  /* Band unsupported. */
+	MMFC_BAD_BAND_VALUE = 9  /* Band value unsupported. */
+	MMFC_OUT_OF_METERS  = 10 /* No more meters available. */
+	MMFC_OUT_OF_BANDS   = 11 /* The maximum number of properties for a meter has been exceeded. */
+)
+
 // ofp_port_mod_failed_code 1.0
 const (
 	PMFC_BAD_PORT = iota
@@ -684,6 +799,8 @@ func (s *SwitchFeatures) MarshalBinary() (data []byte, err error) {
 	bytes, err = s.Header.MarshalBinary()
 	copy(data[next:], bytes)
 	next += len(bytes)
+	copy(data[next:], s.DPID)
+	next += len(s.DPID)
 	binary.BigEndian.PutUint32(data[next:], s.Buffers)
 	next += 4
 	data[next] = s.NumTables
@@ -733,6 +850,7 @@ func (s *SwitchFeatures) UnmarshalBinary(data []byte) error {
 		p := NewPhyPort()
 		err = p.UnmarshalBinary(data[next:])
 		next += int(p.Len())
+		s.Ports = append(s.Ports, *p)
 	}
 	return err
 }
@@ -0,0 +1,112 @@
+package openflow13
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/serngawy/libOpenflow/protocol"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+func newTestPacketOut(data util.Message) *PacketOut {
+	pktOut := NewPacketOut()
+	pktOut.InPort = 3
+	pktOut.AddAction(NewActionSetQueue(1))
+	pktOut.AddAction(NewActionOutput(P_TABLE))
+	pktOut.Data = data
+	return pktOut
+}
+
+func TestPacketOutRoundTrip(t *testing.T) {
+	eth := protocol.NewEthernet()
+	eth.HWSrc = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	eth.HWDst = net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	eth.Ethertype = 0x0806
+	eth.Data, _ = protocol.NewARP(protocol.Type_Request)
+	buffered := newTestPacketOut(nil)
+	buffered.BufferId = 42
+
+	tests := []struct {
+		name   string
+		pktOut *PacketOut
+	}{
+		{"ethernet data", newTestPacketOut(eth)},
+		{"raw data", newTestPacketOut(util.NewBuffer([]byte{1, 2, 3, 4}))},
+		{"buffered", buffered},
+		{"no action", NewPacketOut()},
+	}
+
+	for _, test := range tests {
+		data, err := test.pktOut.MarshalBinary()
+		if err != nil {
+			t.Errorf("Error encoding %s. Err: %v", test.name, err)
+			continue
+		}
+		decoded := new(PacketOut)
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Errorf("Error decoding %s. Err: %v", test.name, err)
+			continue
+		}
+		if decoded.BufferId != test.pktOut.BufferId || decoded.InPort != test.pktOut.InPort ||
+			decoded.ActionsLen != test.pktOut.ActionsLen || len(decoded.Actions) != len(test.pktOut.Actions) {
+			t.Errorf("Wrong decoded %s: got %+v, want %+v", test.name, decoded, test.pktOut)
+			continue
+		}
+		if (decoded.Data == nil) != (test.pktOut.Data == nil) {
+			t.Errorf("Wrong data of %s: got %v, want %v", test.name, decoded.Data, test.pktOut.Data)
+			continue
+		}
+		if _, ok := test.pktOut.Data.(*protocol.Ethernet); ok {
+			if _, ok := decoded.Data.(*protocol.Ethernet); !ok {
+				t.Errorf("Wrong data type of %s: got %T", test.name, decoded.Data)
+			}
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil || !bytes.Equal(again, data) {
+			t.Errorf("Wrong re-encoding of %s: got %x, want %x", test.name, again, data)
+		}
+	}
+}
+
+// Malformed packet outs are errors, not panics
+func TestPacketOutMalformed(t *testing.T) {
+	valid, _ := newTestPacketOut(nil).MarshalBinary()
+	// The actions length is at 16, the first action starts at 24 and the
+	// second at 32
+	tests := []struct {
+		name   string
+		modify func(data []byte) []byte
+	}{
+		{"short header", func(data []byte) []byte { return data[:20] }},
+		{"actions past message", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[16:], 0x100)
+			return data
+		}},
+		{"actions shorter than an action", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[16:], 4)
+			return data
+		}},
+		{"zero length action", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[26:], 0)
+			return data
+		}},
+		{"action below header", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[26:], 4)
+			return data
+		}},
+		{"action past actions", func(data []byte) []byte {
+			binary.BigEndian.PutUint16(data[34:], 24)
+			return data
+		}},
+		{"truncated action", func(data []byte) []byte { return data[:36] }},
+	}
+
+	for _, test := range tests {
+		data := test.modify(append([]byte(nil), valid...))
+		if err := new(PacketOut).UnmarshalBinary(data); err == nil {
+			t.Errorf("Wrong result of %s: got no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package openflow13
+
+// This file implements the validation of matches: duplicate fields, masks
+// and the prerequisites of the OXM basic fields (OpenFlow 1.3 - 7.2.3.6)
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ETH_TYPE_IPV4       = 0x0800
+	ETH_TYPE_ARP        = 0x0806
+	ETH_TYPE_IPV6       = 0x86dd
+	ETH_TYPE_MPLS       = 0x8847
+	ETH_TYPE_MPLS_MCAST = 0x8848
+	ETH_TYPE_PBB        = 0x88e7
+
+	IP_PROTO_ICMP   = 1
+	IP_PROTO_TCP    = 6
+	IP_PROTO_UDP    = 17
+	IP_PROTO_ICMPV6 = 58
+	IP_PROTO_SCTP   = 132
+
+	ICMPV6_TYPE_NS = 135 /* Neighbor solicitation */
+	ICMPV6_TYPE_NA = 136 /* Neighbor advertisement */
+)
+
+var oxmFieldNames = map[uint8]string{
+	OXM_FIELD_IN_PORT:        "in_port",
+	OXM_FIELD_IN_PHY_PORT:    "in_phy_port",
+	OXM_FIELD_METADATA:       "metadata",
+	OXM_FIELD_ETH_DST:        "eth_dst",
+	OXM_FIELD_ETH_SRC:        "eth_src",
+	OXM_FIELD_ETH_TYPE:       "eth_type",
+	OXM_FIELD_VLAN_VID:       "vlan_vid",
+	OXM_FIELD_VLAN_PCP:       "vlan_pcp",
+	OXM_FIELD_IP_DSCP:        "ip_dscp",
+	OXM_FIELD_IP_ECN:         "ip_ecn",
+	OXM_FIELD_IP_PROTO:       "ip_proto",
+	OXM_FIELD_IPV4_SRC:       "ipv4_src",
+	OXM_FIELD_IPV4_DST:       "ipv4_dst",
+	OXM_FIELD_TCP_SRC:        "tcp_src",
+	OXM_FIELD_TCP_DST:        "tcp_dst",
+	OXM_FIELD_UDP_SRC:        "udp_src",
+	OXM_FIELD_UDP_DST:        "udp_dst",
+	OXM_FIELD_SCTP_SRC:       "sctp_src",
+	OXM_FIELD_SCTP_DST:       "sctp_dst",
+	OXM_FIELD_ICMPV4_TYPE:    "icmpv4_type",
+	OXM_FIELD_ICMPV4_CODE:    "icmpv4_code",
+	OXM_FIELD_ARP_OP:         "arp_op",
+	OXM_FIELD_ARP_SPA:        "arp_spa",
+	OXM_FIELD_ARP_TPA:        "arp_tpa",
+	OXM_FIELD_ARP_SHA:        "arp_sha",
+	OXM_FIELD_ARP_THA:        "arp_tha",
+	OXM_FIELD_IPV6_SRC:       "ipv6_src",
+	OXM_FIELD_IPV6_DST:       "ipv6_dst",
+	OXM_FIELD_IPV6_FLABEL:    "ipv6_flabel",
+	OXM_FIELD_ICMPV6_TYPE:    "icmpv6_type",
+	OXM_FIELD_ICMPV6_CODE:    "icmpv6_code",
+	OXM_FIELD_IPV6_ND_TARGET: "ipv6_nd_target",
+	OXM_FIELD_IPV6_ND_SLL:    "ipv6_nd_sll",
+	OXM_FIELD_IPV6_ND_TLL:    "ipv6_nd_tll",
+	OXM_FIELD_MPLS_LABEL:     "mpls_label",
+	OXM_FIELD_MPLS_TC:        "mpls_tc",
+	OXM_FIELD_MPLS_BOS:       "mpls_bos",
+	OXM_FIELD_PBB_ISID:       "pbb_isid",
+	OXM_FIELD_TUNNEL_ID:      "tunnel_id",
+	OXM_FIELD_IPV6_EXTHDR:    "ipv6_exthdr",
+	OXM_FIELD_PBB_UCA:        "pbb_uca",
+	OXM_FIELD_TCP_FLAGS:      "tcp_flags",
+}
+
+var nxmFieldNames = map[uint8]string{
+	NXM_NX_TUN_IPV4_SRC: "tun_ipv4_src",
+	NXM_NX_TUN_IPV4_DST: "tun_ipv4_dst",
+}
+
+// Returns the name of a match field, e.g. "eth_type"
+func OxmFieldName(class uint16, field uint8) string {
+	var name string
+	switch class {
+	case OXM_CLASS_OPENFLOW_BASIC:
+		name = oxmFieldNames[field]
+	case OXM_CLASS_NXM_1:
+		name = nxmFieldNames[field]
+	}
+	if name == "" {
+		return fmt.Sprintf("field_%d_%d", class, field)
+	}
+	return name
+}
+
+// OXM basic fields that may be masked
+var maskableFields = map[uint8]bool{
+	OXM_FIELD_METADATA:    true,
+	OXM_FIELD_ETH_DST:     true,
+	OXM_FIELD_ETH_SRC:     true,
+	OXM_FIELD_VLAN_VID:    true,
+	OXM_FIELD_IPV4_SRC:    true,
+	OXM_FIELD_IPV4_DST:    true,
+	OXM_FIELD_ARP_SPA:     true,
+	OXM_FIELD_ARP_TPA:     true,
+	OXM_FIELD_ARP_SHA:     true,
+	OXM_FIELD_ARP_THA:     true,
+	OXM_FIELD_IPV6_SRC:    true,
+	OXM_FIELD_IPV6_DST:    true,
+	OXM_FIELD_IPV6_FLABEL: true,
+	OXM_FIELD_PBB_ISID:    true,
+	OXM_FIELD_TUNNEL_ID:   true,
+	OXM_FIELD_IPV6_EXTHDR: true,
+	OXM_FIELD_TCP_FLAGS:   true,
+}
+
+// Prerequisite of an OXM basic field: another field exactly matching one
+// of the values
+type matchPrereq struct {
+	field  uint8
+	values []uint64
+}
+
+var matchPrereqs = map[uint8]matchPrereq{
+	OXM_FIELD_IP_DSCP:        {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV4, ETH_TYPE_IPV6}},
+	OXM_FIELD_IP_ECN:         {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV4, ETH_TYPE_IPV6}},
+	OXM_FIELD_IP_PROTO:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV4, ETH_TYPE_IPV6}},
+	OXM_FIELD_IPV4_SRC:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV4}},
+	OXM_FIELD_IPV4_DST:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV4}},
+	OXM_FIELD_TCP_SRC:        {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_TCP}},
+	OXM_FIELD_TCP_DST:        {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_TCP}},
+	OXM_FIELD_TCP_FLAGS:      {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_TCP}},
+	OXM_FIELD_UDP_SRC:        {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_UDP}},
+	OXM_FIELD_UDP_DST:        {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_UDP}},
+	OXM_FIELD_SCTP_SRC:       {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_SCTP}},
+	OXM_FIELD_SCTP_DST:       {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_SCTP}},
+	OXM_FIELD_ICMPV4_TYPE:    {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_ICMP}},
+	OXM_FIELD_ICMPV4_CODE:    {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_ICMP}},
+	OXM_FIELD_ARP_OP:         {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_ARP}},
+	OXM_FIELD_ARP_SPA:        {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_ARP}},
+	OXM_FIELD_ARP_TPA:        {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_ARP}},
+	OXM_FIELD_ARP_SHA:        {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_ARP}},
+	OXM_FIELD_ARP_THA:        {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_ARP}},
+	OXM_FIELD_IPV6_SRC:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV6}},
+	OXM_FIELD_IPV6_DST:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV6}},
+	OXM_FIELD_IPV6_FLABEL:    {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV6}},
+	OXM_FIELD_IPV6_EXTHDR:    {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_IPV6}},
+	OXM_FIELD_ICMPV6_TYPE:    {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_ICMPV6}},
+	OXM_FIELD_ICMPV6_CODE:    {OXM_FIELD_IP_PROTO, []uint64{IP_PROTO_ICMPV6}},
+	OXM_FIELD_IPV6_ND_TARGET: {OXM_FIELD_ICMPV6_TYPE, []uint64{ICMPV6_TYPE_NS, ICMPV6_TYPE_NA}},
+	OXM_FIELD_IPV6_ND_SLL:    {OXM_FIELD_ICMPV6_TYPE, []uint64{ICMPV6_TYPE_NS}},
+	OXM_FIELD_IPV6_ND_TLL:    {OXM_FIELD_ICMPV6_TYPE, []uint64{ICMPV6_TYPE_NA}},
+	OXM_FIELD_MPLS_LABEL:     {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_MPLS, ETH_TYPE_MPLS_MCAST}},
+	OXM_FIELD_MPLS_TC:        {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_MPLS, ETH_TYPE_MPLS_MCAST}},
+	OXM_FIELD_MPLS_BOS:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_MPLS, ETH_TYPE_MPLS_MCAST}},
+	OXM_FIELD_PBB_ISID:       {OXM_FIELD_ETH_TYPE, []uint64{ETH_TYPE_PBB}},
+}
+
+// Fields implying the IP version when ip_proto lacks its eth_type
+var ipv4Fields = []uint8{OXM_FIELD_IPV4_SRC, OXM_FIELD_IPV4_DST, OXM_FIELD_ICMPV4_TYPE, OXM_FIELD_ICMPV4_CODE}
+var ipv6Fields = []uint8{OXM_FIELD_IPV6_SRC, OXM_FIELD_IPV6_DST, OXM_FIELD_IPV6_FLABEL, OXM_FIELD_IPV6_EXTHDR,
+	OXM_FIELD_ICMPV6_TYPE, OXM_FIELD_ICMPV6_CODE, OXM_FIELD_IPV6_ND_TARGET, OXM_FIELD_IPV6_ND_SLL, OXM_FIELD_IPV6_ND_TLL}
+
+// Returns the OXM basic field of a match, nil if absent
+func (m *Match) basicField(field uint8) *MatchField {
+	for i := range m.Fields {
+		if m.Fields[i].Class == OXM_CLASS_OPENFLOW_BASIC && m.Fields[i].Field == field {
+			return &m.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Value of a match field as an unsigned integer
+func fieldUint(f *MatchField) uint64 {
+	b, _ := f.Value.MarshalBinary()
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+func prereqString(p matchPrereq) string {
+	alts := make([]string, 0, len(p.values))
+	for _, v := range p.values {
+		if p.field == OXM_FIELD_ETH_TYPE {
+			alts = append(alts, fmt.Sprintf("%s=0x%04x", oxmFieldNames[p.field], v))
+		} else {
+			alts = append(alts, fmt.Sprintf("%s=%d", oxmFieldNames[p.field], v))
+		}
+	}
+	return strings.Join(alts, " or ")
+}
+
+// Check the match: no duplicate fields, masks only on maskable fields with
+// no value bits outside of the mask and all prerequisites present
+func (m *Match) Validate() error {
+	for i, f := range m.Fields {
+		name := OxmFieldName(f.Class, f.Field)
+		if f.Value == nil {
+			return fmt.Errorf("Match field %s has no value", name)
+		}
+		for _, other := range m.Fields[:i] {
+			if other.Class == f.Class && other.Field == f.Field {
+				return fmt.Errorf("Match field %s is present more than once", name)
+			}
+		}
+		if err := f.validateMask(); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range m.Fields {
+		if f.Class != OXM_CLASS_OPENFLOW_BASIC {
+			continue
+		}
+		if err := m.checkPrereq(f.Field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *MatchField) validateMask() error {
+	name := OxmFieldName(f.Class, f.Field)
+	if !f.HasMask {
+		return nil
+	}
+	if f.Mask == nil {
+		return fmt.Errorf("Match field %s has no mask value", name)
+	}
+	if f.Class == OXM_CLASS_OPENFLOW_BASIC && !maskableFields[f.Field] {
+		return fmt.Errorf("Match field %s can't be masked", name)
+	}
+
+	value, _ := f.Value.MarshalBinary()
+	mask, _ := f.Mask.MarshalBinary()
+	if len(value) != len(mask) {
+		return fmt.Errorf("Match field %s mask has a wrong length", name)
+	}
+	for i := range value {
+		if value[i]&^mask[i] != 0 {
+			return fmt.Errorf("Match field %s value has bits set outside of its mask", name)
+		}
+	}
+	return nil
+}
+
+// Check the prerequisite of a field, and the prerequisites of vlan_pcp and
+// in_phy_port which only require another field to be present
+func (m *Match) checkPrereq(field uint8) error {
+	name := oxmFieldNames[field]
+	switch field {
+	case OXM_FIELD_IN_PHY_PORT:
+		if m.basicField(OXM_FIELD_IN_PORT) == nil {
+			return fmt.Errorf("Match field %s requires in_port", name)
+		}
+		return nil
+	case OXM_FIELD_VLAN_PCP:
+		vid := m.basicField(OXM_FIELD_VLAN_VID)
+		if vid == nil || fieldUint(vid)&OFPVID_PRESENT == 0 {
+			return fmt.Errorf("Match field %s requires vlan_vid with OFPVID_PRESENT", name)
+		}
+		return nil
+	}
+
+	p, ok := matchPrereqs[field]
+	if !ok {
+		return nil
+	}
+	pf := m.basicField(p.field)
+	if pf == nil {
+		return fmt.Errorf("Match field %s requires %s", name, prereqString(p))
+	}
+	if pf.HasMask {
+		return fmt.Errorf("Match field %s requires an exact match on %s", name, oxmFieldNames[p.field])
+	}
+	v := fieldUint(pf)
+	for _, want := range p.values {
+		if v == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("Match field %s requires %s, found %s=%d", name, prereqString(p), oxmFieldNames[p.field], v)
+}
+
+// Insert the missing prerequisites of the match fields that can be inferred
+// in front of the fields, then validate the match. An error is returned if a
+// missing prerequisite is ambiguous or a prerequisite has a conflicting value
+func (m *Match) AddPrerequisites() error {
+	for {
+		added, err := m.addMissingPrereq()
+		if err != nil {
+			return err
+		}
+		if !added {
+			break
+		}
+	}
+	return m.Validate()
+}
+
+// Insert the first missing prerequisite found, returns false if none is
+// missing
+func (m *Match) addMissingPrereq() (bool, error) {
+	for _, f := range m.Fields {
+		if f.Class != OXM_CLASS_OPENFLOW_BASIC {
+			continue
+		}
+		name := oxmFieldNames[f.Field]
+
+		if f.Field == OXM_FIELD_VLAN_PCP {
+			if m.basicField(OXM_FIELD_VLAN_VID) == nil {
+				// Match any tagged packet
+				mask := uint16(OFPVID_PRESENT)
+				m.insertField(*NewVlanIdField(0, &mask))
+				return true, nil
+			}
+			continue
+		}
+
+		p, ok := matchPrereqs[f.Field]
+		if !ok || m.basicField(p.field) != nil {
+			continue
+		}
+
+		value := p.values[0]
+		if len(p.values) > 1 {
+			v, ok := m.inferPrereq(p)
+			if !ok {
+				return false, fmt.Errorf("Match field %s requires %s", name, prereqString(p))
+			}
+			value = v
+		}
+
+		switch p.field {
+		case OXM_FIELD_ETH_TYPE:
+			m.insertField(*NewEthTypeField(uint16(value)))
+		case OXM_FIELD_IP_PROTO:
+			m.insertField(*NewIpProtoField(uint8(value)))
+		case OXM_FIELD_ICMPV6_TYPE:
+			m.insertField(*NewIcmpv6TypeField(uint8(value)))
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Pick the value of an ambiguous prerequisite from the other fields
+func (m *Match) inferPrereq(p matchPrereq) (uint64, bool) {
+	if p.field != OXM_FIELD_ETH_TYPE || p.values[0] != ETH_TYPE_IPV4 {
+		return 0, false
+	}
+
+	hasAny := func(fields []uint8) bool {
+		for _, f := range fields {
+			if m.basicField(f) != nil {
+				return true
+			}
+		}
+		return false
+	}
+	v4 := hasAny(ipv4Fields)
+	v6 := hasAny(ipv6Fields)
+	if ipProto := m.basicField(OXM_FIELD_IP_PROTO); ipProto != nil {
+		switch fieldUint(ipProto) {
+		case IP_PROTO_ICMP:
+			v4 = true
+		case IP_PROTO_ICMPV6:
+			v6 = true
+		}
+	}
+
+	switch {
+	case v4 && !v6:
+		return ETH_TYPE_IPV4, true
+	case v6 && !v4:
+		return ETH_TYPE_IPV6, true
+	}
+	return 0, false
+}
+
+// Insert a field in front of the match
+func (m *Match) insertField(f MatchField) {
+	m.Fields = append([]MatchField{f}, m.Fields...)
+	m.Length += f.Len()
+}
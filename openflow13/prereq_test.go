@@ -0,0 +1,119 @@
+package openflow13
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func newTestMatch(fields ...*MatchField) *Match {
+	m := NewMatch()
+	for _, f := range fields {
+		m.AddField(*f)
+	}
+	return m
+}
+
+// Names of the fields of a match, in order
+func matchFieldNames(m *Match) string {
+	names := make([]string, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		names = append(names, OxmFieldName(f.Class, f.Field))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestMatchValidate(t *testing.T) {
+	ipMask := net.ParseIP("255.255.255.0").To4()
+	dscpField := NewIpDscpField(10)
+	dscpField.HasMask = true
+	dscpField.Mask = dscpField.Value
+	vidMask := uint16(OFPVID_PRESENT)
+	untagged := NewVlanIdField(0, nil)
+	untagged.Value.(*VlanIdField).VlanId = 0
+
+	tests := []struct {
+		name  string
+		match *Match
+		err   string // Part of the error, empty if the match is valid
+	}{
+		{"tcp", newTestMatch(NewEthTypeField(ETH_TYPE_IPV4), NewIpProtoField(IP_PROTO_TCP), NewTcpDstField(80)), ""},
+		{"tcp over ipv6", newTestMatch(NewEthTypeField(ETH_TYPE_IPV6), NewIpProtoField(IP_PROTO_TCP), NewTcpDstField(80)), ""},
+		{"duplicate", newTestMatch(NewEthTypeField(ETH_TYPE_IPV4), NewEthTypeField(ETH_TYPE_IPV4)), "more than once"},
+		{"missing eth_type", newTestMatch(NewIpv4SrcField(net.ParseIP("10.0.0.1"), nil)), "requires eth_type=0x0800"},
+		{"wrong eth_type", newTestMatch(NewEthTypeField(ETH_TYPE_ARP), NewIpv4SrcField(net.ParseIP("10.0.0.1"), nil)),
+			"found eth_type=2054"},
+		{"missing ip_proto", newTestMatch(NewEthTypeField(ETH_TYPE_IPV4), NewTcpDstField(80)), "requires ip_proto=6"},
+		{"unmaskable field", newTestMatch(NewEthTypeField(ETH_TYPE_IPV4), dscpField), "can't be masked"},
+		{"value outside mask", newTestMatch(NewEthTypeField(ETH_TYPE_IPV4), NewIpv4SrcField(net.ParseIP("10.0.0.1"), &ipMask)),
+			"bits set outside of its mask"},
+		{"in_phy_port", newTestMatch(NewInPhyPortField(1)), "requires in_port"},
+		{"in_phy_port with in_port", newTestMatch(NewInPortField(1), NewInPhyPortField(1)), ""},
+		{"untagged vlan_pcp", newTestMatch(untagged, NewVlanPcpField(3)), "OFPVID_PRESENT"},
+		{"tagged vlan_pcp", newTestMatch(NewVlanIdField(0, &vidMask), NewVlanPcpField(3)), ""},
+		{"nd_sll", newTestMatch(NewEthTypeField(ETH_TYPE_IPV6), NewIpProtoField(IP_PROTO_ICMPV6), NewIcmpv6TypeField(ICMPV6_TYPE_NA),
+			NewIpv6NdSllField(net.HardwareAddr{0, 0, 0, 0, 0, 1})), "requires icmpv6_type=135"},
+		{"mpls", newTestMatch(NewEthTypeField(ETH_TYPE_MPLS_MCAST), NewMplsLabelField(16)), ""},
+	}
+
+	for _, test := range tests {
+		err := test.match.Validate()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("Error validating %s. Err: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Wrong error of %s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestMatchAddPrerequisites(t *testing.T) {
+	tests := []struct {
+		name  string
+		match *Match
+		want  string // Fields of the completed match, empty if it fails
+		err   string
+	}{
+		{"tcp", newTestMatch(NewTcpDstField(80), NewIpv4DstField(net.ParseIP("10.0.0.1"), nil)),
+			"eth_type,ip_proto,tcp_dst,ipv4_dst", ""},
+		{"ipv4 implied by ipv4_src", newTestMatch(NewIpProtoField(IP_PROTO_UDP), NewIpv4SrcField(net.ParseIP("10.0.0.1"), nil)),
+			"eth_type,ip_proto,ipv4_src", ""},
+		{"ipv6 implied by icmpv6", newTestMatch(NewIcmpv6TypeField(135)), "eth_type,ip_proto,icmpv6_type", ""},
+		{"nd_target", newTestMatch(NewIpv6NdTargetField(net.ParseIP("fe80::1"))), "", "requires icmpv6_type=135 or icmpv6_type=136"},
+		{"nd_tll", newTestMatch(NewIpv6NdTllField(net.HardwareAddr{0, 0, 0, 0, 0, 1})),
+			"eth_type,ip_proto,icmpv6_type,ipv6_nd_tll", ""},
+		{"vlan_pcp", newTestMatch(NewVlanPcpField(3)), "vlan_vid,vlan_pcp", ""},
+		{"ambiguous ip version", newTestMatch(NewIpProtoField(IP_PROTO_TCP)), "", "requires eth_type=0x0800 or eth_type=0x86dd"},
+		{"conflicting ip version", newTestMatch(NewIpProtoField(IP_PROTO_TCP), NewIpv4SrcField(net.ParseIP("10.0.0.1"), nil),
+			NewIpv6DstField(net.ParseIP("2001:db8::1"), nil)), "", "requires eth_type=0x0800"},
+		{"conflicting prerequisite", newTestMatch(NewEthTypeField(ETH_TYPE_ARP), NewIpProtoField(IP_PROTO_TCP), NewTcpDstField(80)),
+			"", "found eth_type=2054"},
+	}
+
+	for _, test := range tests {
+		err := test.match.AddPrerequisites()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Wrong error of %s: got %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error completing %s. Err: %v", test.name, err)
+			continue
+		}
+		if got := matchFieldNames(test.match); got != test.want {
+			t.Errorf("Wrong fields of %s: got %s, want %s", test.name, got, test.want)
+		}
+		var length uint16
+		for _, f := range test.match.Fields {
+			length += f.Len()
+		}
+		if test.match.Length != 4+length {
+			t.Errorf("Wrong length of %s: got %d, want %d", test.name, test.match.Length, 4+length)
+		}
+	}
+}
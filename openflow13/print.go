@@ -0,0 +1,584 @@
+package openflow13
+
+// This file implements the compact String() of the messages, e.g.
+// "packet_in xid=0 table=0 cookie=0x0 reason=no_match buffer=none total_len=60 in_port=1 eth=00:00:00:00:00:01->ff:ff:ff:ff:ff:ff,type=0x0806"
+// The flows, matches, actions and instructions are written in the
+// ovs-ofctl syntax, see ofctl.go
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/protocol"
+)
+
+var packetInReasonNames = map[uint32]string{
+	R_NO_MATCH:    "no_match",
+	R_ACTION:      "action",
+	R_INVALID_TTL: "invalid_ttl",
+}
+
+var flowRemovedReasonNames = map[uint32]string{
+	RR_IDLE_TIMEOUT: "idle_timeout",
+	RR_HARD_TIMEOUT: "hard_timeout",
+	RR_DELETE:       "delete",
+	RR_GROUP_DELETE: "group_delete",
+}
+
+var portReasonNames = map[uint32]string{
+	PR_ADD:    "add",
+	PR_DELETE: "delete",
+	PR_MODIFY: "modify",
+}
+
+var flowModCommandNames = map[uint32]string{
+	FC_ADD:           "add",
+	FC_MODIFY:        "modify",
+	FC_MODIFY_STRICT: "modify_strict",
+	FC_DELETE:        "delete",
+	FC_DELETE_STRICT: "delete_strict",
+}
+
+var groupCommandNames = map[uint32]string{
+	OFPGC_ADD:    "add",
+	OFPGC_MODIFY: "modify",
+	OFPGC_DELETE: "delete",
+}
+
+var groupTypeNames = map[uint32]string{
+	OFPGT_ALL:      "all",
+	OFPGT_SELECT:   "select",
+	OFPGT_INDIRECT: "indirect",
+	OFPGT_FF:       "ff",
+}
+
+var meterCommandNames = map[uint32]string{
+	OFPMC_ADD:    "add",
+	OFPMC_MODIFY: "modify",
+	OFPMC_DELETE: "delete",
+}
+
+var meterBandTypeNames = map[uint32]string{
+	OFPMBT_DROP:         "drop",
+	OFPMBT_DSCP_REMARK:  "dscp_remark",
+	OFPMBT_EXPERIMENTER: "experimenter",
+}
+
+var multipartTypeNames = map[uint32]string{
+	MultipartType_Desc:          "desc",
+	MultipartType_Flow:          "flow",
+	MultipartType_Aggregate:     "aggregate",
+	MultipartType_Table:         "table",
+	MultipartType_Port:          "port_stats",
+	MultipartType_Queue:         "queue",
+	MultipartType_Group:         "group",
+	MultipartType_GroupDesc:     "group_desc",
+	MultipartType_GroupFeatures: "group_features",
+	MultipartType_Meter:         "meter",
+	MultipartType_MeterConfig:   "meter_config",
+	MultipartType_MeterFeatures: "meter_features",
+	MultipartType_TableFeatures: "table_features",
+	MultipartType_PortDesc:      "port_desc",
+	MultipartType_Experimenter:  "experimenter",
+}
+
+var roleNames = map[uint32]string{
+	OFPCR_ROLE_NOCHANGE: "nochange",
+	OFPCR_ROLE_EQUAL:    "equal",
+	OFPCR_ROLE_MASTER:   "master",
+	OFPCR_ROLE_SLAVE:    "slave",
+}
+
+var actionTypeNames = map[uint32]string{
+	ActionType_Output:       "output",
+	ActionType_CopyTtlOut:   "copy_ttl_out",
+	ActionType_CopyTtlIn:    "copy_ttl_in",
+	ActionType_SetMplsTtl:   "set_mpls_ttl",
+	ActionType_DecMplsTtl:   "dec_mpls_ttl",
+	ActionType_PushVlan:     "push_vlan",
+	ActionType_PopVlan:      "pop_vlan",
+	ActionType_PushMpls:     "push_mpls",
+	ActionType_PopMpls:      "pop_mpls",
+	ActionType_SetQueue:     "set_queue",
+	ActionType_Group:        "group",
+	ActionType_SetNwTtl:     "set_nw_ttl",
+	ActionType_DecNwTtl:     "dec_nw_ttl",
+	ActionType_SetField:     "set_field",
+	ActionType_PushPbb:      "push_pbb",
+	ActionType_PopPbb:       "pop_pbb",
+	ActionType_Experimenter: "experimenter",
+}
+
+var instrTypeNames = map[uint32]string{
+	InstrType_GOTO_TABLE:     "goto_table",
+	InstrType_WRITE_METADATA: "write_metadata",
+	InstrType_WRITE_ACTIONS:  "write_actions",
+	InstrType_APPLY_ACTIONS:  "apply_actions",
+	InstrType_CLEAR_ACTIONS:  "clear_actions",
+	InstrType_METER:          "meter",
+	InstrType_EXPERIMENTER:   "experimenter",
+}
+
+var errorTypeNames = map[uint32]string{
+	ET_HELLO_FAILED:          "hello_failed",
+	ET_BAD_REQUEST:           "bad_request",
+	ET_BAD_ACTION:            "bad_action",
+	ET_BAD_INSTRUCTION:       "bad_instruction",
+	PET_BAD_MATCH:            "bad_match",
+	ET_FLOW_MOD_FAILED:       "flow_mod_failed",
+	ET_GROUP_MOD_FAILED:      "group_mod_failed",
+	ET_PORT_MOD_FAILED:       "port_mod_failed",
+	ET_TABLE_MOD_FAILED:      "table_mod_failed",
+	ET_QUEUE_OP_FAILED:       "queue_op_failed",
+	ET_SWITCH_CONFIG_FAILED:  "switch_config_failed",
+	ET_ROLE_REQUEST_FAILED:   "role_request_failed",
+	ET_METER_MOD_FAILED:      "meter_mod_failed",
+	ET_TABLE_FEATURES_FAILED: "table_features_failed",
+	ET_EXPERIMENTER:          "experimenter",
+}
+
+// Names of the error codes by error type, indexed by code
+var errorCodeNames = map[uint16][]string{
+	ET_HELLO_FAILED: {"incompatible", "eperm"},
+	ET_BAD_REQUEST: {"bad_version", "bad_type", "bad_multipart", "bad_experimenter",
+		"bad_exp_type", "eperm", "bad_len", "buffer_empty", "buffer_unknown",
+		"bad_table_id", "is_slave", "bad_port", "bad_packet", "multipart_buffer_overflow"},
+	ET_BAD_ACTION: {"bad_type", "bad_len", "bad_experimenter", "bad_exp_type",
+		"bad_out_port", "bad_argument", "eperm", "too_many", "bad_queue",
+		"bad_out_group", "match_inconsistent", "unsupported_order", "bad_tag",
+		"bad_set_type", "bad_set_len", "bad_set_argument"},
+	ET_BAD_INSTRUCTION: {"unknown_inst", "unsup_inst", "bad_table_id", "unsup_metadata",
+		"unsup_metadata_mask", "bad_experimenter", "bad_exp_type", "bad_len", "eperm"},
+	PET_BAD_MATCH: {"bad_type", "bad_len", "bad_tag", "bad_dl_addr_mask",
+		"bad_nw_addr_mask", "bad_wildcards", "bad_field", "bad_value", "bad_mask",
+		"bad_prereq", "dup_field", "eperm"},
+	ET_FLOW_MOD_FAILED: {"unknown", "table_full", "bad_table_id", "overlap", "eperm",
+		"bad_timeout", "bad_command", "bad_flags"},
+	ET_GROUP_MOD_FAILED: {"group_exists", "invalid_group", "weight_unsupported",
+		"out_of_groups", "out_of_buckets", "chaining_unsupported", "watch_unsupported",
+		"loop", "unknown_group", "chained_group", "bad_type", "bad_command",
+		"bad_bucket", "bad_watch", "eperm"},
+	ET_PORT_MOD_FAILED:     {"bad_port", "bad_hw_addr", "bad_config", "bad_advertise", "eperm"},
+	ET_TABLE_MOD_FAILED:    {"bad_table", "bad_config", "eperm"},
+	ET_QUEUE_OP_FAILED:     {"bad_port", "bad_queue", "eperm"},
+	ET_ROLE_REQUEST_FAILED: {"stale", "unsup", "bad_role"},
+	ET_METER_MOD_FAILED: {"unknown", "meter_exists", "invalid_meter", "unknown_meter",
+		"bad_command", "bad_flags", "bad_rate", "bad_burst", "bad_band",
+		"bad_band_value", "out_of_meters", "out_of_bands"},
+}
+
+type flagName struct {
+	flag uint32
+	name string
+}
+
+var portConfigNames = []flagName{
+	{PC_PORT_DOWN, "port_down"},
+	{PC_NO_RECV, "no_recv"},
+	{PC_NO_FWD, "no_fwd"},
+	{PC_NO_PACKET_IN, "no_packet_in"},
+}
+
+var portStateNames = []flagName{
+	{PS_LINK_DOWN, "link_down"},
+	{PS_BLOCKED, "blocked"},
+	{PS_LIVE, "live"},
+}
+
+var capabilityNames = []flagName{
+	{C_FLOW_STATS, "flow_stats"},
+	{C_TABLE_STATS, "table_stats"},
+	{C_PORT_STATS, "port_stats"},
+	{C_GROUP_STATS, "group_stats"},
+	{C_IP_REASM, "ip_reasm"},
+	{C_QUEUE_STATS, "queue_stats"},
+	{C_PORT_BLOCKED, "port_blocked"},
+}
+
+var meterFlagNames = []flagName{
+	{OFPMF_KBPS, "kbps"},
+	{OFPMF_PKTPS, "pktps"},
+	{OFPMF_BURST, "burst"},
+	{OFPMF_STATS, "stats"},
+}
+
+var multipartFlagNames = []flagName{
+	{OFPMPF_REQ_MORE, "more"},
+}
+
+var fragNames = map[uint32]string{
+	C_FRAG_NORMAL: "normal",
+	C_FRAG_DROP:   "drop",
+	C_FRAG_REASM:  "reasm",
+}
+
+// Returns the name of an enum value, the number if it has no name
+func enumName(names map[uint32]string, v uint32) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+// Returns the names of the flags set, the unknown bits as one hex number
+func flagNames(names []flagName, flags uint32) []string {
+	strs := []string{}
+	for _, f := range names {
+		if flags&f.flag != 0 {
+			strs = append(strs, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		strs = append(strs, fmt.Sprintf("0x%x", flags))
+	}
+	return strs
+}
+
+// Returns the name of an error code, the number if it has no name
+func errorCodeName(errType, code uint16) string {
+	if names := errorCodeNames[errType]; int(code) < len(names) {
+		return names[code]
+	}
+	return fmt.Sprintf("%d", code)
+}
+
+func formatBufferId(bufferId uint32) string {
+	if bufferId == OFP_NO_BUFFER {
+		return "none"
+	}
+	return fmt.Sprintf("0x%x", bufferId)
+}
+
+func formatGroup(groupId uint32) string {
+	switch groupId {
+	case OFPG_ALL:
+		return "all"
+	case OFPG_ANY:
+		return "any"
+	}
+	return fmt.Sprintf("%d", groupId)
+}
+
+func formatMeter(meterId uint32) string {
+	switch meterId {
+	case OFPM_ALL:
+		return "all"
+	case OFPM_CONTROLLER:
+		return "controller"
+	case OFPM_SLOWPATH:
+		return "slowpath"
+	}
+	return fmt.Sprintf("%d", meterId)
+}
+
+func formatTable(tableId uint8) string {
+	if tableId == OFPTT_ALL {
+		return "all"
+	}
+	return fmt.Sprintf("%d", tableId)
+}
+
+// Returns a string of a fixed size field padded with zeros
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func formatEthernet(eth *protocol.Ethernet) string {
+	s := fmt.Sprintf("eth=%s->%s", eth.HWSrc, eth.HWDst)
+	if eth.VLANID.VID != 0 {
+		s += fmt.Sprintf(",vlan=%d", eth.VLANID.VID)
+	}
+	return s + fmt.Sprintf(",type=0x%04x", eth.Ethertype)
+}
+
+// Start of the message strings, e.g. "packet_in xid=5"
+func msgString(h *common.Header, parts ...string) string {
+	return strings.Join(append([]string{h.String()}, parts...), " ")
+}
+
+func (p *PacketIn) String() string {
+	parts := []string{
+		fmt.Sprintf("table=%d", p.TableId),
+		fmt.Sprintf("cookie=0x%x", p.Cookie),
+		"reason=" + enumName(packetInReasonNames, uint32(p.Reason)),
+		"buffer=" + formatBufferId(p.BufferId),
+		fmt.Sprintf("total_len=%d", p.TotalLen),
+	}
+	if match := p.Match.String(); match != "" {
+		parts = append(parts, match)
+	}
+	if len(p.Data.HWDst) != 0 {
+		parts = append(parts, formatEthernet(&p.Data))
+	}
+	return msgString(&p.Header, parts...)
+}
+
+func (p *PacketOut) String() string {
+	return msgString(&p.Header,
+		"in_port="+formatPort(p.InPort),
+		"buffer="+formatBufferId(p.BufferId),
+		"actions="+FormatActions(p.Actions),
+		fmt.Sprintf("data_len=%d", len(messageBytes(p.Data))))
+}
+
+func (f *FlowRemoved) String() string {
+	parts := []string{
+		fmt.Sprintf("table=%d", f.TableId),
+		fmt.Sprintf("priority=%d", f.Priority),
+		fmt.Sprintf("cookie=0x%x", f.Cookie),
+		"reason=" + enumName(flowRemovedReasonNames, uint32(f.Reason)),
+		fmt.Sprintf("duration=%d.%03ds", f.DurationSec, f.DurationNSec/1000000),
+		fmt.Sprintf("n_packets=%d", f.PacketCount),
+		fmt.Sprintf("n_bytes=%d", f.ByteCount),
+	}
+	if f.IdleTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("idle_timeout=%d", f.IdleTimeout))
+	}
+	if f.HardTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("hard_timeout=%d", f.HardTimeout))
+	}
+	if match := f.Match.String(); match != "" {
+		parts = append(parts, match)
+	}
+	return msgString(&f.Header, parts...)
+}
+
+func (e *ErrorMsg) String() string {
+	return msgString(&e.Header,
+		"type="+enumName(errorTypeNames, uint32(e.Type)),
+		"code="+errorCodeName(e.Type, e.Code),
+		fmt.Sprintf("data_len=%d", len(e.Data.Bytes())))
+}
+
+func (s *SwitchFeatures) String() string {
+	return msgString(&s.Header,
+		"dpid="+s.DPID.String(),
+		fmt.Sprintf("buffers=%d", s.Buffers),
+		fmt.Sprintf("tables=%d", s.NumTables),
+		fmt.Sprintf("auxiliary_id=%d", s.AuxilaryId),
+		"capabilities="+strings.Join(flagNames(capabilityNames, s.Capabilities), ","),
+		fmt.Sprintf("ports=%d", len(s.Ports)))
+}
+
+func (c *SwitchConfig) String() string {
+	return msgString(&c.Header,
+		"frag="+enumName(fragNames, uint32(c.Flags&C_FRAG_MASK)),
+		fmt.Sprintf("miss_send_len=%d", c.MissSendLen))
+}
+
+func (v *VendorHeader) String() string {
+	return msgString(&v.Header, fmt.Sprintf("experimenter=0x%x", v.Vendor))
+}
+
+// Returns the port, e.g. "1(veth1): addr=00:00:00:00:00:01 config=0 state=live"
+func (p *PhyPort) String() string {
+	config := strings.Join(flagNames(portConfigNames, p.Config), ",")
+	if config == "" {
+		config = "0"
+	}
+	state := strings.Join(flagNames(portStateNames, p.State), ",")
+	if state == "" {
+		state = "0"
+	}
+	return fmt.Sprintf("%s(%s): addr=%s config=%s state=%s speed=%d",
+		formatPort(p.PortNo), cString(p.Name), p.HWAddr, config, state, p.CurrSpeed)
+}
+
+func (p *PortStatus) String() string {
+	return msgString(&p.Header, "reason="+enumName(portReasonNames, uint32(p.Reason)), p.Desc.String())
+}
+
+func (p *PortMod) String() string {
+	return msgString(&p.Header,
+		"port="+formatPort(p.PortNo),
+		"addr="+net.HardwareAddr(p.HWAddr).String(),
+		fmt.Sprintf("config=0x%x", p.Config),
+		fmt.Sprintf("mask=0x%x", p.Mask),
+		fmt.Sprintf("advertise=0x%x", p.Advertise))
+}
+
+func (b *Bucket) String() string {
+	parts := []string{}
+	if b.Weight != 0 {
+		parts = append(parts, fmt.Sprintf("weight=%d", b.Weight))
+	}
+	if b.WatchPort != P_ANY {
+		parts = append(parts, "watch_port="+formatPort(b.WatchPort))
+	}
+	if b.WatchGroup != OFPG_ANY {
+		parts = append(parts, "watch_group="+formatGroup(b.WatchGroup))
+	}
+	parts = append(parts, "actions="+FormatActions(b.Actions))
+	return "bucket=" + strings.Join(parts, ",")
+}
+
+func (g *GroupMod) String() string {
+	parts := []string{
+		enumName(groupCommandNames, uint32(g.Command)),
+		"group_id=" + formatGroup(g.GroupId),
+		"type=" + enumName(groupTypeNames, uint32(g.Type)),
+	}
+	for i := range g.Buckets {
+		parts = append(parts, g.Buckets[i].String())
+	}
+	return msgString(&g.Header, parts...)
+}
+
+func (g *GroupDesc) String() string {
+	parts := []string{
+		"group_id=" + formatGroup(g.GroupId),
+		"type=" + enumName(groupTypeNames, uint32(g.Type)),
+	}
+	for i := range g.Buckets {
+		parts = append(parts, g.Buckets[i].String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (b *MeterBandHeader) bandString() string {
+	return fmt.Sprintf("type=%s,rate=%d,burst_size=%d",
+		enumName(meterBandTypeNames, uint32(b.Type)), b.Rate, b.BurstSize)
+}
+
+func (b *MeterBandDrop) String() string {
+	return b.bandString()
+}
+
+func (b *MeterBandDSCP) String() string {
+	return b.bandString() + fmt.Sprintf(",prec_level=%d", b.PrecLevel)
+}
+
+func (b *MeterBandExperimenter) String() string {
+	return b.bandString() + fmt.Sprintf(",experimenter=0x%x", b.Experimenter)
+}
+
+func (m *MeterMod) String() string {
+	parts := []string{
+		enumName(meterCommandNames, uint32(m.Command)),
+		fmt.Sprintf("meter=%d", m.MeterId),
+		"flags=" + strings.Join(flagNames(meterFlagNames, uint32(m.Flags)), ","),
+	}
+	for _, band := range m.Bands {
+		if s, ok := band.(fmt.Stringer); ok {
+			parts = append(parts, "band="+s.String())
+		}
+	}
+	return msgString(&m.Header, parts...)
+}
+
+func (m *MeterConfig) String() string {
+	parts := []string{
+		"meter=" + formatMeter(m.MeterId),
+		"flags=" + strings.Join(flagNames(meterFlagNames, uint32(m.Flags)), ","),
+	}
+	for _, band := range m.Bands {
+		if s, ok := band.(fmt.Stringer); ok {
+			parts = append(parts, "band="+s.String())
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *MeterMultipartRequest) String() string {
+	return "meter=" + formatMeter(r.MeterId)
+}
+
+func (r *RoleRequest) String() string {
+	return msgString(&r.Header,
+		"role="+enumName(roleNames, r.Role),
+		fmt.Sprintf("generation_id=%d", r.GenerationId))
+}
+
+func (s *MultipartRequest) String() string {
+	parts := []string{"type=" + enumName(multipartTypeNames, uint32(s.Type))}
+	if s.Flags != 0 {
+		parts = append(parts, "flags="+strings.Join(flagNames(multipartFlagNames, uint32(s.Flags)), ","))
+	}
+	if str, ok := s.Body.(fmt.Stringer); ok {
+		if body := str.String(); body != "" {
+			parts = append(parts, body)
+		}
+	}
+	return msgString(&s.Header, parts...)
+}
+
+// The bodies of the replies are written one per line
+func (s *MultipartReply) String() string {
+	parts := []string{"type=" + enumName(multipartTypeNames, uint32(s.Type))}
+	if s.Flags != 0 {
+		parts = append(parts, "flags="+strings.Join(flagNames(multipartFlagNames, uint32(s.Flags)), ","))
+	}
+	str := msgString(&s.Header, parts...)
+	for _, body := range s.Body {
+		if b, ok := body.(fmt.Stringer); ok {
+			str += "\n " + b.String()
+		}
+	}
+	return str
+}
+
+// Fields of the flow stats and aggregate requests
+func flowFilterString(tableId uint8, outPort, outGroup uint32, cookie, cookieMask uint64, match *Match) string {
+	parts := []string{"table=" + formatTable(tableId)}
+	if outPort != P_ANY {
+		parts = append(parts, "out_port="+formatPort(outPort))
+	}
+	if outGroup != OFPG_ANY {
+		parts = append(parts, "out_group="+formatGroup(outGroup))
+	}
+	if cookieMask != 0 {
+		parts = append(parts, fmt.Sprintf("cookie=0x%x/0x%x", cookie, cookieMask))
+	}
+	if s := match.String(); s != "" {
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *FlowStatsRequest) String() string {
+	return flowFilterString(s.TableId, s.OutPort, s.OutGroup, s.Cookie, s.CookieMask, &s.Match)
+}
+
+func (s *AggregateStatsRequest) String() string {
+	return flowFilterString(s.TableId, s.OutPort, s.OutGroup, s.Cookie, s.CookieMask, &s.Match)
+}
+
+func (s *AggregateStats) String() string {
+	return fmt.Sprintf("packet_count=%d byte_count=%d flow_count=%d", s.PacketCount, s.ByteCount, s.FlowCount)
+}
+
+func (s *DescStats) String() string {
+	return fmt.Sprintf("mfr=%q hw=%q sw=%q serial=%q dp=%q", cString(s.MfrDesc), cString(s.HWDesc),
+		cString(s.SWDesc), cString(s.SerialNum), cString(s.DPDesc))
+}
+
+func (s *TableStats) String() string {
+	return fmt.Sprintf("table=%d active=%d lookup=%d matched=%d",
+		s.TableId, s.ActiveCount, s.LookupCount, s.MatchedCount)
+}
+
+func (s *PortStatsRequest) String() string {
+	return "port=" + formatPort(s.PortNo)
+}
+
+func (s *PortStats) String() string {
+	return fmt.Sprintf("port=%s rx_packets=%d rx_bytes=%d rx_dropped=%d rx_errors=%d tx_packets=%d tx_bytes=%d tx_dropped=%d tx_errors=%d duration=%d.%03ds",
+		formatPort(s.PortNo), s.RxPackets, s.RxBytes, s.RxDropped, s.RxErrors,
+		s.TxPackets, s.TxBytes, s.TxDropped, s.TxErrors, s.DurationSec, s.DurationNSec/1000000)
+}
+
+func (s *QueueStatsRequest) String() string {
+	return fmt.Sprintf("port=%d queue=%d", s.PortNo, s.QueueId)
+}
+
+func (s *QueueStats) String() string {
+	return fmt.Sprintf("port=%d queue=%d tx_packets=%d tx_bytes=%d tx_errors=%d",
+		s.PortNo, s.QueueId, s.TxPackets, s.TxBytes, s.TxErrors)
+}
@@ -0,0 +1,47 @@
+package openflow13
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestMeterConfig() *MeterConfig {
+	meterMod := NewMeterMod()
+	meterMod.MeterId = 5
+	meterMod.Flags = OFPMF_KBPS | OFPMF_STATS
+	meterMod.AddBand(NewMeterBandDrop(1000, 100))
+	meterMod.AddBand(NewMeterBandDSCP(2000, 200, 1))
+	return NewMeterConfig(meterMod)
+}
+
+func TestString(t *testing.T) {
+	req := NewMpRequest(MultipartType_Meter)
+	req.Header.Xid = 2
+	req.Body = NewMeterMultipartRequest(OFPM_ALL)
+	rep := NewMpReply(MultipartType_MeterConfig)
+	rep.Header.Xid = 3
+	rep.Body = append(rep.Body, newTestMeterConfig())
+
+	groupDesc := "group_id=7 type=select bucket=weight=50,actions=output:1 bucket=weight=50,actions=output:2"
+	meterConfig := "meter=5 flags=kbps,stats band=type=drop,rate=1000,burst_size=100 " +
+		"band=type=dscp_remark,rate=2000,burst_size=200,prec_level=1"
+	tests := []struct {
+		name string
+		msg  fmt.Stringer
+		want string
+	}{
+		{"group desc", newTestGroupDesc(), groupDesc},
+		{"meter config", newTestMeterConfig(), meterConfig},
+		{"empty meter config", new(MeterConfig), "meter=0 flags="},
+		{"meter request", NewMeterMultipartRequest(3), "meter=3"},
+		{"all meters request", NewMeterMultipartRequest(OFPM_ALL), "meter=all"},
+		{"multipart request", req, "multipart_request xid=2 type=meter meter=all"},
+		{"multipart reply", rep, "multipart_reply xid=3 type=meter_config\n " + meterConfig},
+	}
+
+	for _, test := range tests {
+		if got := test.msg.String(); got != test.want {
+			t.Errorf("Wrong string of %s:\n got %s\nwant %s", test.name, got, test.want)
+		}
+	}
+}
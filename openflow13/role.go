@@ -0,0 +1,73 @@
+package openflow13
+
+// This file has the controller role related defs
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/serngawy/libOpenflow/common"
+)
+
+// ofp_controller_role 1.3
+const (
+	OFPCR_ROLE_NOCHANGE = 0 /* Don't change current role. */
+	OFPCR_ROLE_EQUAL    = 1 /* Default role, full access. */
+	OFPCR_ROLE_MASTER   = 2 /* Full access, at most one master. */
+	OFPCR_ROLE_SLAVE    = 3 /* Read-only access. */
+)
+
+// Role request and reply message
+type RoleRequest struct {
+	common.Header
+	Role         uint32 /* One of OFPCR_ROLE_*. */
+	pad          []byte // 4 bytes
+	GenerationId uint64 /* Master Election Generation Id */
+}
+
+// Create a new role request message
+func NewRoleRequest(role uint32, generationId uint64) *RoleRequest {
+	r := new(RoleRequest)
+	r.Header = NewOfp13Header()
+	r.Header.Type = Type_RoleRequest
+	r.Role = role
+	r.pad = make([]byte, 4)
+	r.GenerationId = generationId
+	return r
+}
+
+// Create a new role reply message
+func NewRoleReply(role uint32, generationId uint64) *RoleRequest {
+	r := NewRoleRequest(role, generationId)
+	r.Header.Type = Type_RoleReply
+	return r
+}
+
+func (r *RoleRequest) Len() (n uint16) {
+	return r.Header.Len() + 16
+}
+
+func (r *RoleRequest) MarshalBinary() (data []byte, err error) {
+	r.Header.Length = r.Len()
+	data, err = r.Header.MarshalBinary()
+
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b[0:], r.Role)
+	binary.BigEndian.PutUint64(b[8:], r.GenerationId)
+
+	data = append(data, b...)
+	return
+}
+
+func (r *RoleRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < int(r.Len()) {
+		return errors.New("Wrong size to unmarshal a RoleRequest message.")
+	}
+	if err := r.Header.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	n := r.Header.Len()
+	r.Role = binary.BigEndian.Uint32(data[n:])
+	r.GenerationId = binary.BigEndian.Uint64(data[n+8:])
+	return nil
+}
@@ -0,0 +1,220 @@
+package util
+
+// This file implements capture files of OpenFlow channels. They are pcap
+// files with the DLT_OPENFLOW link type, which Wireshark and tcpdump read:
+// each message is prefixed with its direction and the datapath id
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Link type of the OpenFlow messages with their pseudo header
+	CaptureLinkType = 267
+
+	// Directions of the captured messages
+	CaptureToSwitch     = 1
+	CaptureToController = 2
+
+	pcapMagicNanos   = 0xa1b23c4d
+	pcapMagicMicros  = 0xa1b2c3d4
+	pcapSnapLen      = 0xffff + captureHeaderLen
+	captureHeaderLen = 12
+)
+
+// A message of a capture
+type CaptureRecord struct {
+	Time      time.Time
+	Direction uint32 // CaptureToSwitch or CaptureToController
+	DPID      uint64 // 0 if unknown
+	Data      []byte // The OpenFlow message
+}
+
+// Writes OpenFlow messages to a capture file. Safe for concurrent use
+type CaptureWriter struct {
+	lock   sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// Create a capture writing to w
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	c := &CaptureWriter{w: bufio.NewWriter(w)}
+	if closer, ok := w.(io.Closer); ok {
+		c.closer = closer
+	}
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagicNanos)
+	binary.LittleEndian.PutUint16(hdr[4:], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], CaptureLinkType)
+	if _, err := c.w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return c, c.w.Flush()
+}
+
+// Create a capture file
+func CreateCapture(path string) (*CaptureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCaptureWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Append a message to the capture
+func (c *CaptureWriter) Write(rec *CaptureRecord) error {
+	length := captureHeaderLen + len(rec.Data)
+	hdr := make([]byte, 16+captureHeaderLen)
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(rec.Time.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(rec.Time.Nanosecond()))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(length))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(length))
+	// The pseudo header is in network byte order
+	binary.BigEndian.PutUint32(hdr[16:], rec.Direction)
+	binary.BigEndian.PutUint64(hdr[20:], rec.DPID)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, err := c.w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(rec.Data); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// Close the capture and the file it writes to
+func (c *CaptureWriter) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.w.Flush()
+	if c.closer != nil {
+		if cerr := c.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reads the messages of a capture file
+type CaptureReader struct {
+	r      *bufio.Reader
+	order  binary.ByteOrder
+	nanos  bool
+	closer io.Closer
+}
+
+// Create a reader of the capture in r
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	c := &CaptureReader{r: bufio.NewReader(r)}
+	if closer, ok := r.(io.Closer); ok {
+		c.closer = closer
+	}
+
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return nil, err
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr) {
+		case pcapMagicNanos:
+			c.order, c.nanos = order, true
+		case pcapMagicMicros:
+			c.order = order
+		}
+	}
+	if c.order == nil {
+		return nil, errors.New("Not a pcap file")
+	}
+	if linkType := c.order.Uint32(hdr[20:]); linkType != CaptureLinkType {
+		return nil, fmt.Errorf("Invalid capture link type %d", linkType)
+	}
+	return c, nil
+}
+
+// Open a capture file
+func OpenCapture(path string) (*CaptureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCaptureReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Returns the next message of the capture, io.EOF at the end
+func (c *CaptureReader) Read() (*CaptureRecord, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return nil, err
+	}
+	length := c.order.Uint32(hdr[8:])
+	if length < captureHeaderLen || length > pcapSnapLen {
+		return nil, fmt.Errorf("Invalid capture record length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	frac := time.Duration(c.order.Uint32(hdr[4:]))
+	if !c.nanos {
+		frac *= time.Microsecond
+	}
+	return &CaptureRecord{
+		Time:      time.Unix(int64(c.order.Uint32(hdr[0:])), int64(frac)),
+		Direction: binary.BigEndian.Uint32(data[0:]),
+		DPID:      binary.BigEndian.Uint64(data[4:]),
+		Data:      data[captureHeaderLen:],
+	}, nil
+}
+
+// Close the file of the capture
+func (c *CaptureReader) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// Read all messages of a capture file
+func ReadCapture(path string) ([]*CaptureRecord, error) {
+	c, err := OpenCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var records []*CaptureRecord
+	for {
+		rec, err := c.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
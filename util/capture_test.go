@@ -0,0 +1,211 @@
+package util_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serngawy/libOpenflow/common"
+	"github.com/serngawy/libOpenflow/openflow13"
+	"github.com/serngawy/libOpenflow/util"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	hello, _ := common.NewHello(4)
+	helloData, _ := hello.MarshalBinary()
+	features := openflow13.NewFeaturesReply()
+	featuresData, _ := features.MarshalBinary()
+
+	records := []*util.CaptureRecord{
+		{Time: time.Unix(1600000000, 123456789), Direction: util.CaptureToSwitch, DPID: 0x1, Data: helloData},
+		{Time: time.Unix(1600000000, 987654321), Direction: util.CaptureToController, DPID: 0x1, Data: helloData},
+		{Time: time.Unix(1600000001, 1), Direction: util.CaptureToController, DPID: 0xfedcba9876543210, Data: featuresData},
+	}
+
+	var buf bytes.Buffer
+	w, err := util.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatalf("Error creating capture. Err: %v", err)
+	}
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Error writing capture. Err: %v", err)
+		}
+	}
+
+	r, err := util.NewCaptureReader(&buf)
+	if err != nil {
+		t.Fatalf("Error reading capture. Err: %v", err)
+	}
+	for i, want := range records {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Error reading record %d. Err: %v", i, err)
+		}
+		if !got.Time.Equal(want.Time) || got.Direction != want.Direction || got.DPID != want.DPID ||
+			!bytes.Equal(got.Data, want.Data) {
+			t.Errorf("Wrong record %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Wrong end of capture: got %v, want %v", err, io.EOF)
+	}
+}
+
+// Messages exchanged before the features reply are written once it gives
+// their datapath id
+func TestCapturePendingRecords(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := util.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatalf("Error creating capture. Err: %v", err)
+	}
+	headerLen := buf.Len()
+
+	ctrlConn, swConn := net.Pipe()
+	defer swConn.Close()
+	stream := util.NewCaptureMessageStream(ctrlConn, parserIntf{}, w)
+	defer func() { stream.Shutdown <- true }()
+
+	hello, _ := common.NewHello(4)
+	helloData, _ := hello.MarshalBinary()
+	if _, err := swConn.Write(helloData); err != nil {
+		t.Fatalf("Error sending hello. Err: %v", err)
+	}
+	<-stream.Inbound
+	stream.Outbound <- hello
+	if _, err := io.ReadFull(swConn, make([]byte, len(helloData))); err != nil {
+		t.Fatalf("Error receiving hello. Err: %v", err)
+	}
+	if buf.Len() != headerLen {
+		t.Errorf("Records written before the features reply")
+	}
+
+	features := openflow13.NewFeaturesReply()
+	features.DPID = net.HardwareAddr{0, 0, 0, 0, 0, 0, 0, 7}
+	featuresData, _ := features.MarshalBinary()
+	if _, err := swConn.Write(featuresData); err != nil {
+		t.Fatalf("Error sending features reply. Err: %v", err)
+	}
+	<-stream.Inbound
+
+	r, err := util.NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Error reading capture. Err: %v", err)
+	}
+	want := []struct {
+		direction uint32
+		data      []byte
+	}{
+		{util.CaptureToController, helloData},
+		{util.CaptureToSwitch, helloData},
+		{util.CaptureToController, featuresData},
+	}
+	for i, rec := range want {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Error reading record %d. Err: %v", i, err)
+		}
+		if got.Direction != rec.direction || got.DPID != 7 || !bytes.Equal(got.Data, rec.data) {
+			t.Errorf("Wrong record %d: got direction %d dpid %d, want direction %d dpid 7",
+				i, got.Direction, got.DPID, rec.direction)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Wrong end of capture: got %v, want %v", err, io.EOF)
+	}
+}
+
+// Buffer a capture is written to while the test reads it
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// Returns the records written so far
+func (b *lockedBuffer) records(t *testing.T) []*util.CaptureRecord {
+	b.lock.Lock()
+	data := append([]byte(nil), b.buf.Bytes()...)
+	b.lock.Unlock()
+
+	r, err := util.NewCaptureReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error reading capture. Err: %v", err)
+	}
+	var records []*util.CaptureRecord
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Error reading record %d. Err: %v", len(records), err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// Messages held for the features reply are written without datapath id
+// when too many are held or the stream shuts down before it
+func TestCapturePendingFlush(t *testing.T) {
+	const maxPending = 64 // Messages a capture holds, see the stream
+	buf := new(lockedBuffer)
+	w, err := util.NewCaptureWriter(buf)
+	if err != nil {
+		t.Fatalf("Error creating capture. Err: %v", err)
+	}
+	ctrlConn, swConn := net.Pipe()
+	defer swConn.Close()
+	stream := util.NewCaptureMessageStream(ctrlConn, parserIntf{}, w)
+
+	hello, _ := common.NewHello(4)
+	helloData, _ := hello.MarshalBinary()
+	send := func(count int) {
+		for i := 0; i < count; i++ {
+			if _, err := swConn.Write(helloData); err != nil {
+				t.Fatalf("Error sending hello. Err: %v", err)
+			}
+			<-stream.Inbound
+		}
+	}
+
+	send(maxPending - 1)
+	if records := buf.records(t); len(records) != 0 {
+		t.Errorf("Wrong records below the limit: got %d, want 0", len(records))
+	}
+	send(1)
+	records := buf.records(t)
+	if len(records) != maxPending {
+		t.Fatalf("Wrong records at the limit: got %d, want %d", len(records), maxPending)
+	}
+	for i, rec := range records {
+		if rec.DPID != 0 || !bytes.Equal(rec.Data, helloData) {
+			t.Errorf("Wrong record %d: got dpid %d data %x", i, rec.DPID, rec.Data)
+		}
+	}
+
+	send(2)
+	stream.Shutdown <- true
+	deadline := time.Now().Add(2 * time.Second)
+	for len(records) != maxPending+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Wrong records after shutdown: got %d, want %d", len(records), maxPending+2)
+		}
+		time.Sleep(10 * time.Millisecond)
+		records = buf.records(t)
+	}
+	for i, rec := range records[maxPending:] {
+		if rec.DPID != 0 || rec.Direction != util.CaptureToController {
+			t.Errorf("Wrong record %d after shutdown: got dpid %d direction %d", maxPending+i, rec.DPID, rec.Direction)
+		}
+	}
+}
@@ -5,11 +5,18 @@ import (
 	"encoding/binary"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
 
-const numParserGoroutines = 25
+// The messages of a connection are parsed by a single goroutine so that
+// they are delivered on Inbound in the order they were received
+const numParserGoroutines = 1
+
+// Type of the features reply in all OpenFlow versions
+const typeFeaturesReply = 6
 
 type BufferPool struct {
 	Empty chan *bytes.Buffer
@@ -49,11 +56,36 @@ type MessageStream struct {
 	Outbound chan Message
 	// Channel on which to receive a shutdown command
 	Shutdown chan bool
+	// Capture the messages are copied to, nil if none
+	captureLock sync.Mutex
+	capture     *streamCapture
+}
+
+// The capture of a stream. Messages are held until the features reply
+// gives the datapath id of the records. They are written with a datapath id
+// of 0 when the stream shuts down before it or too many are held
+type streamCapture struct {
+	w       *CaptureWriter
+	dpid    uint64
+	pending []*CaptureRecord
 }
 
+// Number of messages a capture holds until the features reply
+const maxPendingCaptureRecords = 64
+
 // Returns a pointer to a new MessageStream. Used to parse
 // OpenFlow messages from conn.
 func NewMessageStream(conn net.Conn, parser Parser) *MessageStream {
+	return newMessageStream(conn, parser, nil)
+}
+
+// Returns a new MessageStream copying every message, from the first one,
+// to a capture
+func NewCaptureMessageStream(conn net.Conn, parser Parser, w *CaptureWriter) *MessageStream {
+	return newMessageStream(conn, parser, w)
+}
+
+func newMessageStream(conn net.Conn, parser Parser, w *CaptureWriter) *MessageStream {
 	m := &MessageStream{
 		conn,
 		NewBufferPool(),
@@ -64,6 +96,11 @@ func NewMessageStream(conn net.Conn, parser Parser) *MessageStream {
 		make(chan Message, 1), // Inbound
 		make(chan Message, 1), // Outbound
 		make(chan bool, 1),    // Shutdown
+		sync.Mutex{},          // captureLock
+		nil,                   // capture
+	}
+	if w != nil {
+		m.capture = &streamCapture{w: w}
 	}
 
 	go m.outbound()
@@ -79,6 +116,86 @@ func (m *MessageStream) GetAddr() net.Addr {
 	return m.conn.RemoteAddr()
 }
 
+// Start copying the messages sent and received to a capture, nil to stop.
+// The stream is the controller end of the channel: outbound messages go to
+// the switch
+func (m *MessageStream) SetCapture(w *CaptureWriter) {
+	m.captureLock.Lock()
+	defer m.captureLock.Unlock()
+	m.flushCapture()
+	if w == nil {
+		m.capture = nil
+		return
+	}
+	m.capture = &streamCapture{w: w}
+}
+
+// Copy a message to the capture, if any
+func (m *MessageStream) captureMessage(direction uint32, data []byte) {
+	m.captureLock.Lock()
+	defer m.captureLock.Unlock()
+	c := m.capture
+	if c == nil || len(data) < 8 {
+		return
+	}
+
+	rec := &CaptureRecord{
+		Time:      time.Now(),
+		Direction: direction,
+		DPID:      c.dpid,
+		Data:      append([]byte(nil), data...),
+	}
+	// The datapath id is at the same offset in the features reply of all
+	// OpenFlow versions
+	if c.dpid == 0 && direction == CaptureToController && data[1] == typeFeaturesReply && len(data) >= 16 {
+		c.dpid = binary.BigEndian.Uint64(data[8:])
+		records := append(c.pending, rec)
+		c.pending = nil
+		for _, pending := range records {
+			pending.DPID = c.dpid
+			if !m.writeCapture(pending) {
+				return
+			}
+		}
+		return
+	}
+	if c.dpid == 0 {
+		c.pending = append(c.pending, rec)
+		if len(c.pending) >= maxPendingCaptureRecords {
+			m.flushCapture()
+		}
+		return
+	}
+	m.writeCapture(rec)
+}
+
+// Write the held messages without datapath id. Must be called with the
+// capture lock held
+func (m *MessageStream) flushCapture() {
+	c := m.capture
+	if c == nil {
+		return
+	}
+	records := c.pending
+	c.pending = nil
+	for _, rec := range records {
+		if !m.writeCapture(rec) {
+			return
+		}
+	}
+}
+
+// Write a record, the capture stops on error. Must be called with the
+// capture lock held
+func (m *MessageStream) writeCapture(rec *CaptureRecord) bool {
+	if err := m.capture.w.Write(rec); err != nil {
+		log.Warnf("Error writing capture, stopping it. Err: %v", err)
+		m.capture = nil
+		return false
+	}
+	return true
+}
+
 // Listen for a Shutdown signal or Outbound messages.
 func (m *MessageStream) outbound() {
 	for {
@@ -86,6 +203,9 @@ func (m *MessageStream) outbound() {
 		case <-m.Shutdown:
 			log.Infof("Closing OpenFlow message stream.")
 			m.conn.Close()
+			m.captureLock.Lock()
+			m.flushCapture()
+			m.captureLock.Unlock()
 			for i := 0; i < numParserGoroutines; i++ {
 				m.parserShutdown <- true
 			}
@@ -93,6 +213,8 @@ func (m *MessageStream) outbound() {
 		case msg := <-m.Outbound:
 			// Forward outbound messages to conn
 			data, _ := msg.MarshalBinary()
+			// Captured before the reply can be
+			m.captureMessage(CaptureToSwitch, data)
 			if _, err := m.conn.Write(data); err != nil {
 				log.Warnln("OutboundError:", err)
 				m.Error <- err
@@ -140,6 +262,7 @@ func (m *MessageStream) inbound() {
 				msg = msg - 1
 				if msg == 0 {
 					hdr = 0
+					m.captureMessage(CaptureToController, buf.Bytes())
 					m.pool.Full <- buf
 					buf = <-m.pool.Empty
 				}
@@ -151,21 +274,33 @@ func (m *MessageStream) inbound() {
 
 // Parse incoming message
 func (m *MessageStream) parse() {
-	errMessage := "received: %v and encountered error: %v"
 	for {
 		select {
 		case b := <-m.pool.Full:
-			msg, err := m.parser.Parse(b.Bytes())
-			// Log all message parsing errors.
-			if err != nil {
-				log.Errorf(errMessage, b.Bytes(), err)
-			}
-
-			m.Inbound <- msg
-			b.Reset()
-			m.pool.Empty <- b
+			m.parseBuffer(b)
 		case <-m.parserShutdown:
-			return
+			// Deliver the messages received before the shutdown
+			for {
+				select {
+				case b := <-m.pool.Full:
+					m.parseBuffer(b)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
+
+// Parse a received message, deliver it and give the buffer back
+func (m *MessageStream) parseBuffer(b *bytes.Buffer) {
+	msg, err := m.parser.Parse(b.Bytes())
+	// Log all message parsing errors.
+	if err != nil {
+		log.Errorf("received: %v and encountered error: %v", b.Bytes(), err)
+	}
+
+	m.Inbound <- msg
+	b.Reset()
+	m.pool.Empty <- b
+}
@@ -1,4 +1,4 @@
-package util
+package util_test
 
 import (
 	"io"
@@ -101,3 +101,51 @@ func TestMessageStream(t *testing.T) {
 		t.Fatalf("found more goroutines: %v before, %v after", goroutineCountStart, goroutineCountEnd)
 	}
 }
+
+// Connection reading a fixed stream, then blocking until closed
+type streamConn struct {
+	fakeConn
+	data   []byte
+	closed chan bool
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	if len(c.data) == 0 {
+		<-c.closed
+		return 0, io.EOF
+	}
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *streamConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// Messages are delivered in the order they were received
+func TestMessageStreamOrder(t *testing.T) {
+	const numMessages = 1000
+	c := &streamConn{closed: make(chan bool)}
+	for i := 0; i < numMessages; i++ {
+		echo := openflow13.NewEchoRequest()
+		echo.Xid = uint32(i)
+		b, _ := echo.MarshalBinary()
+		c.data = append(c.data, b...)
+	}
+
+	stream := util.NewMessageStream(c, parserIntf{})
+	defer func() { stream.Shutdown <- true }()
+	for i := 0; i < numMessages; i++ {
+		select {
+		case msg := <-stream.Inbound:
+			echo, ok := msg.(*common.Header)
+			if !ok || echo.Xid != uint32(i) {
+				t.Fatalf("Wrong message %d: got %+v", i, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Message %d is not received", i)
+		}
+	}
+}